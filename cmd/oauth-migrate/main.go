@@ -0,0 +1,36 @@
+// Command oauth-migrate converts the OAuth client secrets and tokens stored in
+// plaintext into bcrypt hashes and SHA-256 digests. Run it from the project
+// root, where the .env file is, after applying
+// migrations/domain/10-oauth-hashed-credentials.sql.
+package main
+
+import (
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/infras"
+	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/evermos/boilerplate-go/shared/oauth"
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	logger.InitLogger()
+	config := configs.Get()
+	logger.SetLogLevel(config)
+
+	db := infras.CreateMySQLWriteConn(*config)
+	defer db.Close()
+
+	result, err := oauth.MigrateCredentials(db)
+	if err != nil {
+		log.
+			Fatal().
+			Err(err).
+			Msg("Failed migrating OAuth credentials")
+	}
+
+	log.Info().
+		Int64("clientSecrets", result.ClientSecrets).
+		Int64("accessTokens", result.AccessTokens).
+		Int64("refreshTokens", result.RefreshTokens).
+		Msg("OAuth credentials migrated.")
+}
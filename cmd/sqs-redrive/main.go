@@ -0,0 +1,47 @@
+// Command sqs-redrive moves the messages in the SQS dead-letter queue back to
+// the queues they failed in, once the cause of the failures is fixed. Run it
+// from the project root, where the .env file is:
+//
+//	go run ./cmd/sqs-redrive [-dlq URL] [-queue URL] [-max N]
+//
+// The dead-letter queue defaults to EVENT.CONSUMER.SQS.DEAD_LETTER_QUEUE_URL.
+// With -queue every message is sent to that queue instead.
+package main
+
+import (
+	"flag"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/event/consumer"
+	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/rs/zerolog/log"
+)
+
+func main() {
+	logger.InitLogger()
+	config := configs.Get()
+	logger.SetLogLevel(config)
+
+	deadLetterURL := flag.String("dlq", config.Event.Consumer.SQS.DeadLetterQueueURL, "URL of the dead-letter queue")
+	targetURL := flag.String("queue", "", "URL of the queue to send every message to, instead of the queue it failed in")
+	max := flag.Int("max", 0, "maximum number of messages to redrive, 0 for all")
+	flag.Parse()
+
+	if *deadLetterURL == "" {
+		log.Fatal().Msg("No dead-letter queue given with -dlq or EVENT.CONSUMER.SQS.DEAD_LETTER_QUEUE_URL")
+	}
+
+	redriven, err := consumer.NewSQSConsumer(config).Redrive(*deadLetterURL, *targetURL, *max)
+	if err != nil {
+		log.
+			Fatal().
+			Err(err).
+			Int("redriven", redriven).
+			Msg("Failed redriving messages")
+	}
+
+	log.Info().
+		Int("redriven", redriven).
+		Str("dlq", *deadLetterURL).
+		Msg("Messages redriven.")
+}
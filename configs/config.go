@@ -24,6 +24,42 @@ type Config struct {
 		URL      string `mapstructure:"URL"`
 		AuthServiceBaseURL 		string `mapstructure:"AUTH_SERVICE_BASE_URL"`
 		AuthServiceValidatePath string `mapstructure:"AUTH_SERVICE_VALIDATE_URL"`
+		AuthService struct {
+			TimeoutMillis          int    `mapstructure:"TIMEOUT_MILLIS"`
+			MaxRetries             int    `mapstructure:"MAX_RETRIES"`
+			BreakerThreshold       int    `mapstructure:"BREAKER_THRESHOLD"`
+			BreakerCooldownSeconds int    `mapstructure:"BREAKER_COOLDOWN_SECONDS"`
+			CacheStore             string `mapstructure:"CACHE_STORE"`
+			CacheSize              int    `mapstructure:"CACHE_SIZE"`
+			CacheTTLSeconds        int    `mapstructure:"CACHE_TTL_SECONDS"`
+		} `mapstructure:"AUTH_SERVICE"`
+		Idempotency struct {
+			Store                  string `mapstructure:"STORE"`
+			TTLSeconds             int    `mapstructure:"TTL_SECONDS"`
+			LeaseSeconds           int    `mapstructure:"LEASE_SECONDS"`
+			JanitorIntervalSeconds int64  `mapstructure:"JANITOR_INTERVAL_SECONDS"`
+			JanitorBatchSize       int    `mapstructure:"JANITOR_BATCH_SIZE"`
+		} `mapstructure:"IDEMPOTENCY"`
+		JWT struct {
+			HS256Secrets       []string `mapstructure:"HS256_SECRETS"`
+			JWKSFile           string   `mapstructure:"JWKS_FILE"`
+			JWKSURL            string   `mapstructure:"JWKS_URL"`
+			JWKSRefreshSeconds int      `mapstructure:"JWKS_REFRESH_SECONDS"`
+			Issuer             string   `mapstructure:"ISSUER"`
+			Audience           string   `mapstructure:"AUDIENCE"`
+			LeewaySeconds      int      `mapstructure:"LEEWAY_SECONDS"`
+			RemoteFallback     bool     `mapstructure:"REMOTE_FALLBACK"`
+		} `mapstructure:"JWT"`
+		Policy struct {
+			Roles map[string][]string `mapstructure:"ROLES"`
+		} `mapstructure:"POLICY"`
+		RateLimit struct {
+			Store  string `mapstructure:"STORE"`
+			Groups map[string]struct {
+				Requests      int `mapstructure:"REQUESTS"`
+				WindowSeconds int `mapstructure:"WINDOW_SECONDS"`
+			} `mapstructure:"GROUPS"`
+		} `mapstructure:"RATE_LIMIT"`
 	}
 
 	Cache struct {
@@ -58,6 +94,36 @@ type Config struct {
 	}
 
 	Event struct {
+		// Driver selects the transport of events: sns (the default) for
+		// SNS and SQS, memory or file for local development.
+		Driver string `mapstructure:"DRIVER"`
+
+		Local struct {
+			Dir                string `mapstructure:"DIR"`
+			MaxFlight          int    `mapstructure:"MAX_FLIGHT"`
+			MaxAttempts        int    `mapstructure:"MAX_ATTEMPTS"`
+			RetryDelayMillis   int    `mapstructure:"RETRY_DELAY_MILLIS"`
+			PollIntervalMillis int    `mapstructure:"POLL_INTERVAL_MILLIS"`
+		} `mapstructure:"LOCAL"`
+
+		Outbox struct {
+			IntervalMillis int `mapstructure:"INTERVAL_MILLIS"`
+			BatchSize      int `mapstructure:"BATCH_SIZE"`
+			MaxAttempts    int `mapstructure:"MAX_ATTEMPTS"`
+			BackoffSeconds int `mapstructure:"BACKOFF_SECONDS"`
+			// Published and failed rows are deleted RETENTION_HOURS after
+			// they were published or written.
+			RetentionHours         int   `mapstructure:"RETENTION_HOURS"`
+			JanitorIntervalSeconds int64 `mapstructure:"JANITOR_INTERVAL_SECONDS"`
+			JanitorBatchSize       int   `mapstructure:"JANITOR_BATCH_SIZE"`
+		} `mapstructure:"OUTBOX"`
+
+		CartAbandonment struct {
+			IntervalSeconds int64 `mapstructure:"INTERVAL_SECONDS"`
+			AfterSeconds    int64 `mapstructure:"AFTER_SECONDS"`
+			BatchSize       int   `mapstructure:"BATCH_SIZE"`
+		} `mapstructure:"CART_ABANDONMENT"`
+
 		Consumer struct {
 			SQS struct {
 				AccessKeyID       string `mapstructure:"ACCESS_KEY_ID"`
@@ -69,6 +135,19 @@ type Config struct {
 				SecretAccessKey   string `mapstructure:"SECRET_ACCESS_KEY"`
 				WaitTimeSeconds   int64  `mapstructure:"WAIT_TIME_SECONDS"`
 
+				// Failed messages are received again after a visibility
+				// timeout doubling from VisibilityBackoffSeconds, and are
+				// moved to DeadLetterQueueURL after MaxReceiveCount receives.
+				MaxReceiveCount          int64  `mapstructure:"MAX_RECEIVE_COUNT"`
+				VisibilityBackoffSeconds int64  `mapstructure:"VISIBILITY_BACKOFF_SECONDS"`
+				DeadLetterQueueURL       string `mapstructure:"DEAD_LETTER_QUEUE_URL"`
+
+				// Messages are processed by up to Workers goroutines per
+				// queue, extending their visibility timeout to
+				// VisibilityTimeoutSeconds for as long as they are processed.
+				Workers                  int   `mapstructure:"WORKERS"`
+				VisibilityTimeoutSeconds int64 `mapstructure:"VISIBILITY_TIMEOUT_SECONDS"`
+
 				Topics struct {
 					FooBarBaz struct {
 						Enabled bool   `mapstructure:"ENABLED"`
@@ -89,11 +168,44 @@ type Config struct {
 						ARN     string `mapstructure:"ARN"`
 						Enabled bool   `mapstructure:"ENABLED"`
 					} `mapstructure:"FOO_CREATED"`
+					OrderCancelled struct {
+						ARN     string `mapstructure:"ARN"`
+						Enabled bool   `mapstructure:"ENABLED"`
+					} `mapstructure:"ORDER_CANCELLED"`
+					OrderCreated struct {
+						ARN     string `mapstructure:"ARN"`
+						Enabled bool   `mapstructure:"ENABLED"`
+					} `mapstructure:"ORDER_CREATED"`
+					OrderStatusChanged struct {
+						ARN     string `mapstructure:"ARN"`
+						Enabled bool   `mapstructure:"ENABLED"`
+					} `mapstructure:"ORDER_STATUS_CHANGED"`
+					ProductChanged struct {
+						ARN     string `mapstructure:"ARN"`
+						Enabled bool   `mapstructure:"ENABLED"`
+					} `mapstructure:"PRODUCT_CHANGED"`
+					ProductStockChanged struct {
+						ARN     string `mapstructure:"ARN"`
+						Enabled bool   `mapstructure:"ENABLED"`
+					} `mapstructure:"PRODUCT_STOCK_CHANGED"`
+					CartAbandoned struct {
+						ARN     string `mapstructure:"ARN"`
+						Enabled bool   `mapstructure:"ENABLED"`
+					} `mapstructure:"CART_ABANDONED"`
 				}
 			}
 		}
 	}
 
+	OAuth struct {
+		ExpirationSeconds        int64    `mapstructure:"EXPIRATION_SECONDS"`
+		RefreshExpirationSeconds int64    `mapstructure:"REFRESH_EXPIRATION_SECONDS"`
+		CodeExpirationSeconds    int64    `mapstructure:"CODE_EXPIRATION_SECONDS"`
+		JanitorIntervalSeconds   int64    `mapstructure:"JANITOR_INTERVAL_SECONDS"`
+		JanitorBatchSize         int      `mapstructure:"JANITOR_BATCH_SIZE"`
+		ClientScope              []string `mapstructure:"CLIENT_SCOPE"`
+	} `mapstructure:"OAUTH"`
+
 	Server struct {
 		Env      string `mapstructure:"ENV"`
 		LogLevel string `mapstructure:"LOG_LEVEL"`
@@ -20,3 +20,9 @@ func ProvideConsumers(fooBarBaz foobarbaz.ConsumerImpl) Consumers {
 func (c *Consumers) Start() {
 	c.FooBarBaz.Start()
 }
+
+// Stop stops all domains event consumer, waiting for the messages being
+// processed.
+func (c *Consumers) Stop() {
+	c.FooBarBaz.Stop()
+}
@@ -2,4 +2,6 @@ package consumer
 
 type Consumer interface {
 	Listen(url string)
+	// Stop stops listening, and waits for the messages being processed.
+	Stop()
 }
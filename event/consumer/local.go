@@ -0,0 +1,145 @@
+package consumer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/event/local"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultPollInterval is how often a FileConsumer looks for new messages
+// when none is configured.
+const defaultPollInterval = time.Second
+
+// New creates the Consumer of the driver selected with EVENT.DRIVER, which
+// has process receive its messages. It consumes from SQS unless memory or
+// file is selected.
+func New(config *configs.Config, broker *local.MemoryBroker, process Process) Consumer {
+	switch config.Event.Driver {
+	case local.DriverMemory:
+		return &MemoryConsumer{Process: process, broker: broker}
+	case local.DriverFile:
+		return NewFileConsumer(config, process)
+	default:
+		sqsConsumer := NewSQSConsumer(config)
+		sqsConsumer.Process = process
+		return sqsConsumer
+	}
+}
+
+// MemoryConsumer consumes from a local.MemoryBroker. Retries are made by the
+// broker.
+type MemoryConsumer struct {
+	Process Process
+	broker  *local.MemoryBroker
+	mu      sync.Mutex
+	queues  []string
+}
+
+// Listen subscribes to the queue named url and returns; messages are
+// processed by the broker's goroutines.
+func (c *MemoryConsumer) Listen(url string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	log.Info().Str("queue", url).Msg("Memory Consumer subscribed.")
+	c.broker.Subscribe(url, local.Process(c.Process))
+	c.queues = append(c.queues, url)
+}
+
+// Stop unsubscribes from the queues listened to. Messages already handed to
+// the broker's goroutines are still processed.
+func (c *MemoryConsumer) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, queue := range c.queues {
+		c.broker.Unsubscribe(queue)
+	}
+	c.queues = nil
+}
+
+// FileConsumer consumes from a local.FileQueue.
+type FileConsumer struct {
+	Process      Process
+	queue        *local.FileQueue
+	pollInterval time.Duration
+	maxAttempts  int
+	retryDelay   time.Duration
+	stop         chan struct{}
+	stopOnce     sync.Once
+	listening    sync.WaitGroup
+}
+
+// NewFileConsumer creates a FileConsumer configured with EVENT.LOCAL.*.
+func NewFileConsumer(config *configs.Config, process Process) *FileConsumer {
+	pollInterval := time.Duration(config.Event.Local.PollIntervalMillis) * time.Millisecond
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	return &FileConsumer{
+		Process:      process,
+		queue:        local.NewFileQueue(config.Event.Local.Dir),
+		pollInterval: pollInterval,
+		maxAttempts:  config.Event.Local.MaxAttempts,
+		retryDelay:   time.Duration(config.Event.Local.RetryDelayMillis) * time.Millisecond,
+		stop:         make(chan struct{}),
+	}
+}
+
+// Listen polls the queue named url for new messages until Stop is called.
+func (c *FileConsumer) Listen(url string) {
+	c.listening.Add(1)
+	defer c.listening.Done()
+
+	log.Info().Str("queue", url).Dur("pollInterval", c.pollInterval).Msg("File Consumer will start polling.")
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		default:
+		}
+
+		consumed, err := c.queue.Consume(url, c.process)
+		if err != nil {
+			log.Error().Err(err).Str("queue", url).Msg("failed consuming messages, will retry")
+		}
+
+		if consumed == 0 {
+			select {
+			case <-c.stop:
+				return
+			case <-time.After(c.pollInterval):
+			}
+		}
+	}
+}
+
+// Stop stops polling, and waits for the messages being consumed.
+func (c *FileConsumer) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.listening.Wait()
+}
+
+// process tries message up to maxAttempts times, then gives it up so that it
+// does not hold back the queue.
+func (c *FileConsumer) process(message []byte) error {
+	for attempt := 1; ; attempt++ {
+		err := c.Process(message)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= c.maxAttempts {
+			log.Error().Err(err).Int("attempts", attempt).Msg("failed processing message, giving up")
+			return nil
+		}
+
+		log.Error().Err(err).Int("attempts", attempt).Msg("failed processing message, will retry")
+		time.Sleep(c.retryDelay)
+	}
+}
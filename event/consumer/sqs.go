@@ -1,16 +1,49 @@
 package consumer
 
 import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 	"github.com/evermos/boilerplate-go/configs"
 	"github.com/rs/zerolog/log"
 )
 
+// Attributes of the messages moved to the dead-letter queue.
+const (
+	// AttributeSourceQueueURL is the URL of the queue the message failed in.
+	AttributeSourceQueueURL = "SourceQueueUrl"
+	// AttributeError is the error of the last failed attempt.
+	AttributeError = "ErrorMessage"
+	// AttributeReceiveCount is how many times the message was received.
+	AttributeReceiveCount = "ReceiveCount"
+	// AttributeFailedAt is when the message was moved, in RFC 3339.
+	AttributeFailedAt = "FailedAt"
+)
+
+const (
+	// maxVisibilityTimeoutSeconds is the longest visibility timeout SQS allows.
+	maxVisibilityTimeoutSeconds = 12 * 60 * 60
+	// maxMessageAttributes is the most message attributes SQS allows.
+	maxMessageAttributes = 10
+	// maxErrorLength is the longest error attached to a dead-lettered message.
+	maxErrorLength = 1024
+	// maxReceiveMessages is the most messages SQS returns per receive.
+	maxReceiveMessages = 10
+	// maxDeleteBatch is the most messages SQS deletes per batch.
+	maxDeleteBatch = 10
+	// defaultDeleteInterval is the longest a processed message waits for its
+	// batch to be deleted.
+	defaultDeleteInterval = time.Second
+)
+
 // Process represents the processing function of the message consumer.
 type Process func(e []byte) error
 
@@ -41,11 +74,21 @@ func createSQSConfig(config *configs.Config) (*session.Session, error) {
 	})
 }
 
-// SQSConsumer represents an SQS consumer.
+// SQSConsumer represents an SQS consumer. Each queue it listens to is
+// processed by a pool of workers, and messages are deleted in batches.
 type SQSConsumer struct {
 	Process Process
 	config  *configs.Config
-	sqs     *sqs.SQS
+	sqs     sqsiface.SQSAPI
+
+	workers           int
+	visibilityTimeout int64
+	heartbeatInterval time.Duration
+	deleteInterval    time.Duration
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	listening sync.WaitGroup
 }
 
 // NewSQSConsumer create object Consumer
@@ -54,21 +97,79 @@ func NewSQSConsumer(config *configs.Config) *SQSConsumer {
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed creating sqs config")
 	}
-	return &SQSConsumer{config: config, sqs: sqs.New(sess)}
+	return NewSQSConsumerWithClient(config, sqs.New(sess))
+}
+
+// NewSQSConsumerWithClient creates an SQSConsumer using the given SQS client.
+func NewSQSConsumerWithClient(config *configs.Config, client sqsiface.SQSAPI) *SQSConsumer {
+	workers := config.Event.Consumer.SQS.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	visibilityTimeout := config.Event.Consumer.SQS.VisibilityTimeoutSeconds
+	ctx, cancel := context.WithCancel(context.Background())
+	return &SQSConsumer{
+		config:            config,
+		sqs:               client,
+		workers:           workers,
+		visibilityTimeout: visibilityTimeout,
+		heartbeatInterval: time.Duration(visibilityTimeout) * time.Second / 2,
+		deleteInterval:    defaultDeleteInterval,
+		ctx:               ctx,
+		cancel:            cancel,
+	}
 }
 
-// Listen is a function to listen new message from sqs queue
+// Listen is a function to listen new message from sqs queue. It only
+// receives as many messages as it has idle workers, and returns once Stop is
+// called and the messages in flight are processed.
 func (p *SQSConsumer) Listen(url string) {
-	log.Info().Str("url", url).Msg("SQS Consumer will start polling.")
+	p.listening.Add(1)
+	defer p.listening.Done()
+
+	log.Info().Str("url", url).Int("workers", p.workers).Msg("SQS Consumer will start polling.")
+
+	deletes := make(chan *sqs.Message)
+	deleted := make(chan struct{})
+	go func() {
+		defer close(deleted)
+		p.deleteBatches(url, deletes)
+	}()
+
+	var inFlight sync.WaitGroup
+	idle := make(chan struct{}, p.workers)
+	for i := 0; i < p.workers; i++ {
+		idle <- struct{}{}
+	}
+
+	defer func() {
+		inFlight.Wait()
+		close(deletes)
+		<-deleted
+		log.Info().Str("url", url).Msg("SQS Consumer stopped.")
+	}()
 
 	retries := 0
 	for {
-		receiveResp, err := p.sqs.ReceiveMessage(&sqs.ReceiveMessageInput{
-			QueueUrl:            aws.String(url),
-			MaxNumberOfMessages: aws.Int64(p.config.Event.Consumer.SQS.MaxMessage),
-			WaitTimeSeconds:     aws.Int64(p.config.Event.Consumer.SQS.WaitTimeSeconds),
+		workers := p.acquire(idle)
+		if workers == 0 {
+			return
+		}
+
+		receiveResp, err := p.sqs.ReceiveMessageWithContext(p.ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(url),
+			MaxNumberOfMessages:   aws.Int64(int64(workers)),
+			WaitTimeSeconds:       aws.Int64(p.config.Event.Consumer.SQS.WaitTimeSeconds),
+			AttributeNames:        aws.StringSlice([]string{sqs.MessageSystemAttributeNameApproximateReceiveCount, sqs.MessageSystemAttributeNameMessageGroupId}),
+			MessageAttributeNames: aws.StringSlice([]string{"All"}),
 		})
 		if err != nil {
+			release(idle, workers)
+			if p.ctx.Err() != nil {
+				return
+			}
+
 			if retries == p.config.Event.Consumer.SQS.MaxRetriesConsume {
 				log.Error().Err(err).Int("retries", retries).Msg("failed receiving message after maximum retries, failing permanently")
 				return
@@ -82,24 +183,367 @@ func (p *SQSConsumer) Listen(url string) {
 				Int("backoffSeconds", p.config.Event.Consumer.SQS.BackoffSeconds).
 				Msg("failed receiving message, will retry")
 			retries++
-			time.Sleep(time.Duration(p.config.Event.Consumer.SQS.BackoffSeconds) * time.Second)
+			select {
+			case <-p.ctx.Done():
+				return
+			case <-time.After(time.Duration(p.config.Event.Consumer.SQS.BackoffSeconds) * time.Second):
+			}
 			continue
 		} else {
 			retries = 0
 		}
 
+		release(idle, workers-len(receiveResp.Messages))
 		for _, message := range receiveResp.Messages {
-			err := p.Process([]byte(*message.Body))
-			if err != nil {
-				log.Error().Err(err).Msg("failed processing message")
+			inFlight.Add(1)
+			go func(message *sqs.Message) {
+				defer inFlight.Done()
+				defer release(idle, 1)
+
+				if p.handle(message, url) {
+					deletes <- message
+				}
+			}(message)
+		}
+	}
+}
+
+// Stop stops receiving messages, and waits for the messages in flight to be
+// processed and deleted.
+func (p *SQSConsumer) Stop() {
+	p.cancel()
+	p.listening.Wait()
+}
+
+// acquire waits for an idle worker and takes up to MaxMessage of them,
+// returning how many it took, or 0 once the consumer is stopped.
+func (p *SQSConsumer) acquire(idle chan struct{}) int {
+	select {
+	case <-p.ctx.Done():
+		return 0
+	case <-idle:
+	}
+
+	max := int(p.config.Event.Consumer.SQS.MaxMessage)
+	if max <= 0 || max > maxReceiveMessages {
+		max = maxReceiveMessages
+	}
+
+	workers := 1
+	for workers < max {
+		select {
+		case <-idle:
+			workers++
+		default:
+			return workers
+		}
+	}
+	return workers
+}
+
+func release(idle chan struct{}, workers int) {
+	for i := 0; i < workers; i++ {
+		idle <- struct{}{}
+	}
+}
+
+// handle processes message and reports whether it can be deleted, which it
+// can once processed. A failed message is left in the queue to be received
+// again after a backoff, until it has been received MaxReceiveCount times and
+// is moved to the dead-letter queue.
+func (p *SQSConsumer) handle(message *sqs.Message, url string) bool {
+	stopHeartbeat := p.heartbeat(message, url)
+	err := p.Process([]byte(*message.Body))
+	stopHeartbeat()
+	if err == nil {
+		return true
+	}
+
+	receiveCount := receiveCount(message)
+	log.Error().Err(err).Str("messageId", aws.StringValue(message.MessageId)).Int64("receiveCount", receiveCount).Msg("failed processing message")
+
+	conf := p.config.Event.Consumer.SQS
+	if conf.MaxReceiveCount <= 0 || receiveCount < conf.MaxReceiveCount {
+		p.backoff(message, url, receiveCount)
+		return false
+	}
+
+	if conf.DeadLetterQueueURL == "" {
+		log.Warn().Str("messageId", aws.StringValue(message.MessageId)).Msg("message reached the maximum receive count but no dead-letter queue is configured")
+		p.backoff(message, url, receiveCount)
+		return false
+	}
+
+	err = p.deadLetter(message, url, err, receiveCount)
+	if err != nil {
+		log.Error().Err(err).Str("messageId", aws.StringValue(message.MessageId)).Msg("failed moving message to the dead-letter queue")
+		return false
+	}
+
+	return true
+}
+
+// heartbeat keeps message invisible to other consumers while it is being
+// processed, by extending its visibility timeout every half timeout until
+// the returned function is called.
+func (p *SQSConsumer) heartbeat(message *sqs.Message, url string) (stop func()) {
+	if p.visibilityTimeout <= 0 || p.heartbeatInterval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(p.heartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				_, err := p.sqs.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+					QueueUrl:          &url,
+					ReceiptHandle:     message.ReceiptHandle,
+					VisibilityTimeout: aws.Int64(p.visibilityTimeout),
+				})
+				if err != nil {
+					log.Error().Err(err).Str("messageId", aws.StringValue(message.MessageId)).Msg("failed extending message visibility")
+				}
 			}
+		}
+	}()
 
-			err = p.deleteMessage(message, url)
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// deleteBatches deletes the messages sent to deletes in batches of up to ten,
+// at least every deleteInterval, until deletes is closed.
+func (p *SQSConsumer) deleteBatches(url string, deletes <-chan *sqs.Message) {
+	ticker := time.NewTicker(p.deleteInterval)
+	defer ticker.Stop()
+
+	batch := make([]*sqs.Message, 0, maxDeleteBatch)
+	for {
+		select {
+		case message, ok := <-deletes:
+			if !ok {
+				p.deleteMessageBatch(url, batch)
+				return
+			}
+
+			batch = append(batch, message)
+			if len(batch) == maxDeleteBatch {
+				p.deleteMessageBatch(url, batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			p.deleteMessageBatch(url, batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// deleteMessageBatch deletes messages with one call. Messages that fail to
+// be deleted are received again and processed twice.
+func (p *SQSConsumer) deleteMessageBatch(url string, messages []*sqs.Message) {
+	if len(messages) == 0 {
+		return
+	}
+
+	entries := make([]*sqs.DeleteMessageBatchRequestEntry, 0, len(messages))
+	for i, message := range messages {
+		entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: message.ReceiptHandle,
+		})
+	}
+
+	output, err := p.sqs.DeleteMessageBatch(&sqs.DeleteMessageBatchInput{
+		QueueUrl: &url,
+		Entries:  entries,
+	})
+	if err != nil {
+		log.Err(err).Int("messages", len(messages)).Msg("failed deleting messages")
+		return
+	}
+
+	for _, failed := range output.Failed {
+		log.Error().
+			Str("id", aws.StringValue(failed.Id)).
+			Str("code", aws.StringValue(failed.Code)).
+			Str("message", aws.StringValue(failed.Message)).
+			Msg("failed deleting message")
+	}
+}
+
+// backoff hides message for VisibilityBackoffSeconds, doubled for each
+// receive after the first. The queue's visibility timeout applies when no
+// backoff is configured.
+func (p *SQSConsumer) backoff(message *sqs.Message, url string, receiveCount int64) {
+	base := p.config.Event.Consumer.SQS.VisibilityBackoffSeconds
+	if base <= 0 {
+		return
+	}
+
+	timeout := float64(base) * math.Pow(2, float64(receiveCount-1))
+	if timeout > maxVisibilityTimeoutSeconds {
+		timeout = maxVisibilityTimeoutSeconds
+	}
+
+	_, err := p.sqs.ChangeMessageVisibility(&sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          &url,
+		ReceiptHandle:     message.ReceiptHandle,
+		VisibilityTimeout: aws.Int64(int64(timeout)),
+	})
+	if err != nil {
+		log.Error().Err(err).Str("messageId", aws.StringValue(message.MessageId)).Msg("failed changing message visibility")
+	}
+}
+
+// deadLetter sends a copy of message to the dead-letter queue, with the queue
+// it failed in, the error and its receive count attached.
+func (p *SQSConsumer) deadLetter(message *sqs.Message, url string, cause error, receiveCount int64) error {
+	reason := cause.Error()
+	if reason == "" {
+		reason = "unknown error"
+	}
+	if len(reason) > maxErrorLength {
+		reason = reason[:maxErrorLength]
+	}
+
+	attributes := map[string]*sqs.MessageAttributeValue{
+		AttributeSourceQueueURL: stringAttribute(url),
+		AttributeError:          stringAttribute(reason),
+		AttributeReceiveCount:   {DataType: aws.String("Number"), StringValue: aws.String(strconv.FormatInt(receiveCount, 10))},
+		AttributeFailedAt:       stringAttribute(time.Now().UTC().Format(time.RFC3339)),
+	}
+	for name, value := range message.MessageAttributes {
+		if _, ok := attributes[name]; !ok && len(attributes) < maxMessageAttributes {
+			attributes[name] = value
+		}
+	}
+
+	_, err := p.sqs.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:               aws.String(p.config.Event.Consumer.SQS.DeadLetterQueueURL),
+		MessageBody:            message.Body,
+		MessageAttributes:      attributes,
+		MessageGroupId:         message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId],
+		MessageDeduplicationId: deduplicationID(message),
+	})
+	return err
+}
+
+// Redrive moves up to max messages (all of them when max is not positive)
+// from the dead-letter queue back to the queue they failed in, or to
+// targetURL when it is not empty, and returns how many were moved. Messages
+// that do not say which queue they failed in are left in the dead-letter
+// queue.
+func (p *SQSConsumer) Redrive(deadLetterURL, targetURL string, max int) (redriven int, err error) {
+	for max <= 0 || redriven < max {
+		batch := int64(p.config.Event.Consumer.SQS.MaxMessage)
+		if batch <= 0 || batch > maxReceiveMessages {
+			batch = maxReceiveMessages
+		}
+		if max > 0 && int64(max-redriven) < batch {
+			batch = int64(max - redriven)
+		}
+
+		receiveResp, err := p.sqs.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(deadLetterURL),
+			MaxNumberOfMessages:   aws.Int64(batch),
+			WaitTimeSeconds:       aws.Int64(1),
+			AttributeNames:        aws.StringSlice([]string{sqs.MessageSystemAttributeNameMessageGroupId}),
+			MessageAttributeNames: aws.StringSlice([]string{"All"}),
+		})
+		if err != nil {
+			return redriven, err
+		}
+		if len(receiveResp.Messages) == 0 {
+			return redriven, nil
+		}
+
+		for _, message := range receiveResp.Messages {
+			ok, err := p.redrive(message, deadLetterURL, targetURL)
 			if err != nil {
-				log.Error().Err(err).Msg("failed deleting message")
+				return redriven, err
+			}
+			if ok {
+				redriven++
 			}
 		}
 	}
+
+	return
+}
+
+func (p *SQSConsumer) redrive(message *sqs.Message, deadLetterURL, targetURL string) (bool, error) {
+	target := targetURL
+	if target == "" {
+		if source, ok := message.MessageAttributes[AttributeSourceQueueURL]; ok {
+			target = aws.StringValue(source.StringValue)
+		}
+	}
+	if target == "" {
+		log.Warn().Str("messageId", aws.StringValue(message.MessageId)).Msg("message does not say which queue it failed in, leaving it")
+		return false, nil
+	}
+
+	attributes := make(map[string]*sqs.MessageAttributeValue)
+	for name, value := range message.MessageAttributes {
+		switch name {
+		case AttributeSourceQueueURL, AttributeError, AttributeReceiveCount, AttributeFailedAt:
+		default:
+			attributes[name] = value
+		}
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:               aws.String(target),
+		MessageBody:            message.Body,
+		MessageGroupId:         message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId],
+		MessageDeduplicationId: deduplicationID(message),
+	}
+	if len(attributes) > 0 {
+		input.MessageAttributes = attributes
+	}
+
+	_, err := p.sqs.SendMessage(input)
+	if err != nil {
+		return false, err
+	}
+
+	return true, p.deleteMessage(message, deadLetterURL)
+}
+
+// deduplicationID returns the deduplication ID for sending a copy of message.
+// Messages with a group ID come from a FIFO queue and are copied to one, which
+// requires a deduplication ID unless content-based deduplication is enabled,
+// so the ID of message is used. Other messages get none.
+func deduplicationID(message *sqs.Message) *string {
+	if aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]) == "" {
+		return nil
+	}
+	return message.MessageId
+}
+
+// receiveCount returns how many times message has been received, including
+// this time.
+func receiveCount(message *sqs.Message) int64 {
+	count, err := strconv.ParseInt(aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]), 10, 64)
+	if err != nil {
+		return 1
+	}
+	return count
+}
+
+func stringAttribute(value string) *sqs.MessageAttributeValue {
+	return &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
 }
 
 func (p *SQSConsumer) deleteMessage(msg *sqs.Message, url string) error {
@@ -0,0 +1,475 @@
+package consumer
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	queueURL      = "https://sqs.local/000000000000/orders"
+	deadLetterURL = "https://sqs.local/000000000000/orders-dlq"
+)
+
+// fakeSQS records the calls made by an SQSConsumer, and serves the messages
+// in queued to ReceiveMessage.
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	queued      map[string][]*sqs.Message
+	deleted     []string
+	sent        []*sqs.SendMessageInput
+	visibility  map[string]int64
+	failSending bool
+}
+
+func newFakeSQS() *fakeSQS {
+	return &fakeSQS{queued: make(map[string][]*sqs.Message), visibility: make(map[string]int64)}
+}
+
+func (f *fakeSQS) ReceiveMessage(input *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	url := aws.StringValue(input.QueueUrl)
+	n := int(aws.Int64Value(input.MaxNumberOfMessages))
+	if n > len(f.queued[url]) {
+		n = len(f.queued[url])
+	}
+
+	messages := f.queued[url][:n]
+	f.queued[url] = f.queued[url][n:]
+	return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+}
+
+func (f *fakeSQS) DeleteMessage(input *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	f.deleted = append(f.deleted, aws.StringValue(input.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibility(input *sqs.ChangeMessageVisibilityInput) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.visibility[aws.StringValue(input.ReceiptHandle)] = aws.Int64Value(input.VisibilityTimeout)
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (f *fakeSQS) SendMessage(input *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	if f.failSending {
+		return nil, errors.New("send failed")
+	}
+	f.sent = append(f.sent, input)
+	return &sqs.SendMessageOutput{MessageId: aws.String("sent")}, nil
+}
+
+func newMessage(handle string, receiveCount int) *sqs.Message {
+	return &sqs.Message{
+		MessageId:     aws.String("id-" + handle),
+		ReceiptHandle: aws.String(handle),
+		Body:          aws.String(`{"Message":"{}"}`),
+		Attributes: map[string]*string{
+			sqs.MessageSystemAttributeNameApproximateReceiveCount: aws.String(strconv.Itoa(receiveCount)),
+		},
+	}
+}
+
+func newTestConsumer(client *fakeSQS, process Process) *SQSConsumer {
+	config := &configs.Config{}
+	config.Event.Consumer.SQS.MaxMessage = 10
+	config.Event.Consumer.SQS.MaxReceiveCount = 3
+	config.Event.Consumer.SQS.VisibilityBackoffSeconds = 10
+	config.Event.Consumer.SQS.DeadLetterQueueURL = deadLetterURL
+
+	c := NewSQSConsumerWithClient(config, client)
+	c.Process = process
+	return c
+}
+
+func failing(message []byte) error {
+	return errors.New("stock service unavailable")
+}
+
+func TestSQSConsumerHandle(t *testing.T) {
+	t.Run("deletes processed messages", func(t *testing.T) {
+		client := newFakeSQS()
+		c := newTestConsumer(client, func(message []byte) error { return nil })
+
+		assert.True(t, c.handle(newMessage("a", 1), queueURL))
+		assert.Empty(t, client.visibility)
+	})
+
+	t.Run("backs failed messages off exponentially", func(t *testing.T) {
+		client := newFakeSQS()
+		c := newTestConsumer(client, failing)
+
+		assert.False(t, c.handle(newMessage("a", 1), queueURL))
+		assert.False(t, c.handle(newMessage("b", 2), queueURL))
+
+		assert.Empty(t, client.sent)
+		assert.Equal(t, int64(10), client.visibility["a"])
+		assert.Equal(t, int64(20), client.visibility["b"])
+	})
+
+	t.Run("caps the backoff at the SQS maximum", func(t *testing.T) {
+		client := newFakeSQS()
+		c := newTestConsumer(client, failing)
+		c.config.Event.Consumer.SQS.MaxReceiveCount = 0
+
+		assert.False(t, c.handle(newMessage("a", 40), queueURL))
+
+		assert.Equal(t, int64(maxVisibilityTimeoutSeconds), client.visibility["a"])
+	})
+
+	t.Run("moves messages to the dead-letter queue after the maximum receive count", func(t *testing.T) {
+		client := newFakeSQS()
+		c := newTestConsumer(client, failing)
+		message := newMessage("a", 3)
+		message.MessageAttributes = map[string]*sqs.MessageAttributeValue{"TraceId": stringAttribute("trace")}
+
+		assert.True(t, c.handle(message, queueURL))
+
+		require.Len(t, client.sent, 1)
+		sent := client.sent[0]
+		assert.Equal(t, deadLetterURL, aws.StringValue(sent.QueueUrl))
+		assert.Equal(t, aws.StringValue(message.Body), aws.StringValue(sent.MessageBody))
+		assert.Equal(t, queueURL, aws.StringValue(sent.MessageAttributes[AttributeSourceQueueURL].StringValue))
+		assert.Equal(t, "stock service unavailable", aws.StringValue(sent.MessageAttributes[AttributeError].StringValue))
+		assert.Equal(t, "3", aws.StringValue(sent.MessageAttributes[AttributeReceiveCount].StringValue))
+		assert.Equal(t, "trace", aws.StringValue(sent.MessageAttributes["TraceId"].StringValue))
+	})
+
+	t.Run("dead-letters FIFO messages with their group and a deduplication ID", func(t *testing.T) {
+		client := newFakeSQS()
+		c := newTestConsumer(client, failing)
+		message := newMessage("a", 3)
+		message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId] = aws.String("order-1")
+
+		assert.True(t, c.handle(message, queueURL))
+
+		require.Len(t, client.sent, 1)
+		assert.Equal(t, "order-1", aws.StringValue(client.sent[0].MessageGroupId))
+		assert.Equal(t, "id-a", aws.StringValue(client.sent[0].MessageDeduplicationId))
+	})
+
+	t.Run("dead-letters standard messages without a deduplication ID", func(t *testing.T) {
+		client := newFakeSQS()
+		c := newTestConsumer(client, failing)
+
+		assert.True(t, c.handle(newMessage("a", 3), queueURL))
+
+		require.Len(t, client.sent, 1)
+		assert.Nil(t, client.sent[0].MessageGroupId)
+		assert.Nil(t, client.sent[0].MessageDeduplicationId)
+	})
+
+	t.Run("keeps messages that cannot be dead-lettered", func(t *testing.T) {
+		client := newFakeSQS()
+		client.failSending = true
+		c := newTestConsumer(client, failing)
+
+		assert.False(t, c.handle(newMessage("a", 3), queueURL))
+	})
+
+	t.Run("keeps retrying without a dead-letter queue", func(t *testing.T) {
+		client := newFakeSQS()
+		c := newTestConsumer(client, failing)
+		c.config.Event.Consumer.SQS.DeadLetterQueueURL = ""
+
+		assert.False(t, c.handle(newMessage("a", 3), queueURL))
+
+		assert.Empty(t, client.sent)
+		assert.Equal(t, int64(40), client.visibility["a"])
+	})
+}
+
+func TestSQSConsumerRedrive(t *testing.T) {
+	deadLettered := func(handle, source string) *sqs.Message {
+		message := newMessage(handle, 1)
+		message.MessageAttributes = map[string]*sqs.MessageAttributeValue{
+			AttributeError:    stringAttribute("failed"),
+			AttributeFailedAt: stringAttribute("2026-10-18T00:00:00Z"),
+			"TraceId":         stringAttribute("trace-" + handle),
+		}
+		if source != "" {
+			message.MessageAttributes[AttributeSourceQueueURL] = stringAttribute(source)
+		}
+		return message
+	}
+
+	t.Run("sends messages back to the queue they failed in", func(t *testing.T) {
+		client := newFakeSQS()
+		client.queued[deadLetterURL] = []*sqs.Message{deadLettered("a", queueURL), deadLettered("b", ""), deadLettered("c", queueURL)}
+		c := newTestConsumer(client, nil)
+
+		redriven, err := c.Redrive(deadLetterURL, "", 0)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, redriven)
+		assert.Equal(t, []string{"a", "c"}, client.deleted)
+		require.Len(t, client.sent, 2)
+		assert.Equal(t, queueURL, aws.StringValue(client.sent[0].QueueUrl))
+		assert.Equal(t, map[string]*sqs.MessageAttributeValue{"TraceId": stringAttribute("trace-a")}, client.sent[0].MessageAttributes)
+	})
+
+	t.Run("sends every message to the given queue", func(t *testing.T) {
+		client := newFakeSQS()
+		client.queued[deadLetterURL] = []*sqs.Message{deadLettered("a", queueURL), deadLettered("b", "")}
+		c := newTestConsumer(client, nil)
+
+		redriven, err := c.Redrive(deadLetterURL, "https://sqs.local/000000000000/replay", 0)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, redriven)
+		for _, sent := range client.sent {
+			assert.Equal(t, "https://sqs.local/000000000000/replay", aws.StringValue(sent.QueueUrl))
+		}
+	})
+
+	t.Run("redrives FIFO messages with their group and a deduplication ID", func(t *testing.T) {
+		client := newFakeSQS()
+		message := deadLettered("a", queueURL)
+		message.Attributes[sqs.MessageSystemAttributeNameMessageGroupId] = aws.String("order-1")
+		client.queued[deadLetterURL] = []*sqs.Message{message}
+		c := newTestConsumer(client, nil)
+
+		_, err := c.Redrive(deadLetterURL, "", 0)
+
+		assert.NoError(t, err)
+		require.Len(t, client.sent, 1)
+		assert.Equal(t, "order-1", aws.StringValue(client.sent[0].MessageGroupId))
+		assert.Equal(t, "id-a", aws.StringValue(client.sent[0].MessageDeduplicationId))
+	})
+
+	t.Run("stops at max", func(t *testing.T) {
+		client := newFakeSQS()
+		client.queued[deadLetterURL] = []*sqs.Message{deadLettered("a", queueURL), deadLettered("b", queueURL), deadLettered("c", queueURL)}
+		c := newTestConsumer(client, nil)
+
+		redriven, err := c.Redrive(deadLetterURL, "", 2)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, redriven)
+		assert.Len(t, client.queued[deadLetterURL], 1)
+	})
+}
+
+func newListeningConsumer(t *testing.T, server *sqsServer, workers int, process Process) *SQSConsumer {
+	config := &configs.Config{}
+	config.Event.Consumer.SQS.MaxMessage = 10
+	config.Event.Consumer.SQS.WaitTimeSeconds = 1
+	config.Event.Consumer.SQS.Workers = workers
+
+	c := NewSQSConsumerWithClient(config, server.client())
+	c.Process = process
+	return c
+}
+
+// listen starts c listening to the queue of server, and returns a channel
+// closed once Listen returns.
+func listen(c *SQSConsumer, server *sqsServer) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Listen(server.URL + "/000000000000/orders")
+	}()
+	return done
+}
+
+func eventually(t *testing.T, condition func() bool) {
+	require.Eventually(t, condition, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestSQSConsumerListen(t *testing.T) {
+	t.Run("processes messages concurrently", func(t *testing.T) {
+		server := newSQSServer(t)
+		server.send("a", "b", "c", "d")
+
+		// Every message waits for the others, so they only complete when
+		// processed at the same time.
+		var mu sync.Mutex
+		running := 0
+		all := make(chan struct{})
+		c := newListeningConsumer(t, server, 4, func(message []byte) error {
+			mu.Lock()
+			running++
+			if running == 4 {
+				close(all)
+			}
+			mu.Unlock()
+
+			select {
+			case <-all:
+				return nil
+			case <-time.After(5 * time.Second):
+				return errors.New("messages were not processed concurrently")
+			}
+		})
+		done := listen(c, server)
+
+		eventually(t, func() bool { return server.queued() == 0 })
+		c.Stop()
+		<-done
+	})
+
+	t.Run("receives no more messages than it has idle workers", func(t *testing.T) {
+		server := newSQSServer(t)
+		server.send("a", "b", "c")
+
+		release := make(chan struct{})
+		var mu sync.Mutex
+		var received []string
+		c := newListeningConsumer(t, server, 2, func(message []byte) error {
+			mu.Lock()
+			received = append(received, string(message))
+			mu.Unlock()
+			<-release
+			return nil
+		})
+		done := listen(c, server)
+
+		eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(received) == 2
+		})
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		assert.Len(t, received, 2)
+		mu.Unlock()
+
+		close(release)
+		eventually(t, func() bool { return server.queued() == 0 })
+		c.Stop()
+		<-done
+	})
+
+	t.Run("extends the visibility of messages being processed", func(t *testing.T) {
+		server := newSQSServer(t)
+		server.send("slow", "fast")
+
+		c := newListeningConsumer(t, server, 2, func(message []byte) error {
+			if string(message) == "slow" {
+				time.Sleep(100 * time.Millisecond)
+			}
+			return nil
+		})
+		c.visibilityTimeout = 1
+		c.heartbeatInterval = 20 * time.Millisecond
+		done := listen(c, server)
+
+		eventually(t, func() bool { return server.queued() == 0 })
+		c.Stop()
+		<-done
+
+		assert.GreaterOrEqual(t, server.heartbeats("slow"), 2)
+		assert.Zero(t, server.heartbeats("fast"))
+	})
+
+	t.Run("deletes processed messages in batches", func(t *testing.T) {
+		server := newSQSServer(t)
+		bodies := make([]string, 12)
+		for i := range bodies {
+			bodies[i] = strconv.Itoa(i)
+		}
+		server.send(bodies...)
+
+		var processed int32
+		c := newListeningConsumer(t, server, 10, func(message []byte) error {
+			atomic.AddInt32(&processed, 1)
+			return nil
+		})
+		c.deleteInterval = time.Hour
+		done := listen(c, server)
+
+		eventually(t, func() bool { return atomic.LoadInt32(&processed) == int32(len(bodies)) })
+		eventually(t, func() bool { return len(server.batches()) == 1 })
+		assert.Len(t, server.batches()[0], maxDeleteBatch)
+
+		// The rest of the messages are deleted on Stop.
+		c.Stop()
+		<-done
+		batches := server.batches()
+		require.Len(t, batches, 2)
+		assert.Len(t, batches[1], 2)
+		assert.Zero(t, server.queued())
+	})
+
+	t.Run("leaves failed messages in the queue", func(t *testing.T) {
+		server := newSQSServer(t)
+		server.send("a")
+
+		processed := make(chan struct{})
+		c := newListeningConsumer(t, server, 1, func(message []byte) error {
+			close(processed)
+			return errors.New("failed")
+		})
+		done := listen(c, server)
+
+		<-processed
+		c.Stop()
+		<-done
+
+		assert.Empty(t, server.batches())
+		assert.Equal(t, 1, server.queued())
+	})
+
+	t.Run("drains messages in flight on stop", func(t *testing.T) {
+		server := newSQSServer(t)
+		server.send("a", "b")
+
+		started := make(chan struct{}, 2)
+		release := make(chan struct{})
+		c := newListeningConsumer(t, server, 2, func(message []byte) error {
+			started <- struct{}{}
+			<-release
+			return nil
+		})
+		done := listen(c, server)
+		<-started
+		<-started
+
+		stopped := make(chan struct{})
+		go func() {
+			c.Stop()
+			close(stopped)
+		}()
+
+		select {
+		case <-stopped:
+			t.Fatal("stopped before the messages in flight were processed")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(release)
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("did not stop")
+		}
+		<-done
+
+		assert.Zero(t, server.queued())
+	})
+
+	t.Run("stops while waiting for messages", func(t *testing.T) {
+		server := newSQSServer(t)
+		c := newListeningConsumer(t, server, 1, func(message []byte) error { return nil })
+		c.config.Event.Consumer.SQS.WaitTimeSeconds = 20
+		done := listen(c, server)
+		eventually(t, func() bool {
+			server.mu.Lock()
+			defer server.mu.Unlock()
+			return server.receives > 0
+		})
+
+		start := time.Now()
+		c.Stop()
+		<-done
+
+		assert.Less(t, int64(time.Since(start)), int64(time.Second))
+	})
+}
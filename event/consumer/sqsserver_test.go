@@ -0,0 +1,270 @@
+package consumer
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// sqsServer is a single SQS queue served over the SQS query protocol, so the
+// real client can be tested against it. It supports ReceiveMessage with long
+// polling, ChangeMessageVisibility, DeleteMessage and DeleteMessageBatch.
+type sqsServer struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// visibilityTimeout hides received messages until they are deleted.
+	visibilityTimeout time.Duration
+	messages          []*sqsServerMessage
+	sequence          int
+	receives          int
+	// deleteBatches holds the receipt handles of each DeleteMessageBatch call.
+	deleteBatches [][]string
+	// visibilityChanges counts the ChangeMessageVisibility calls per message.
+	visibilityChanges map[string]int
+}
+
+type sqsServerMessage struct {
+	id           string
+	body         string
+	receipt      string
+	receiveCount int
+	visibleAt    time.Time
+}
+
+func newSQSServer(t *testing.T) *sqsServer {
+	s := &sqsServer{visibilityTimeout: time.Minute, visibilityChanges: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// client returns an SQS client sending its requests to s.
+func (s *sqsServer) client() *sqs.SQS {
+	return sqs.New(session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("ap-southeast-1"),
+		Endpoint:    aws.String(s.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		DisableSSL:  aws.Bool(true),
+		MaxRetries:  aws.Int(0),
+	})))
+}
+
+// send queues a message for each of bodies.
+func (s *sqsServer) send(bodies ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, body := range bodies {
+		s.sequence++
+		s.messages = append(s.messages, &sqsServerMessage{id: strconv.Itoa(s.sequence), body: body})
+	}
+}
+
+// queued returns how many messages have not been deleted.
+func (s *sqsServer) queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.messages)
+}
+
+func (s *sqsServer) batches() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.deleteBatches...)
+}
+
+func (s *sqsServer) heartbeats(body string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.visibilityChanges[body]
+}
+
+func (s *sqsServer) serve(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.fail(w, "MalformedQueryString", err.Error())
+		return
+	}
+
+	action := r.PostForm.Get("Action")
+	var result interface{}
+	switch action {
+	case "ReceiveMessage":
+		result = s.receiveMessage(r)
+	case "ChangeMessageVisibility":
+		timeout, _ := strconv.Atoi(r.PostForm.Get("VisibilityTimeout"))
+		if !s.changeVisibility(r.PostForm.Get("ReceiptHandle"), time.Duration(timeout)*time.Second) {
+			s.fail(w, "ReceiptHandleIsInvalid", "receipt handle is invalid")
+			return
+		}
+	case "DeleteMessage":
+		s.delete(r.PostForm.Get("ReceiptHandle"))
+	case "DeleteMessageBatch":
+		result = s.deleteMessageBatch(r)
+	default:
+		s.fail(w, "InvalidAction", fmt.Sprintf("action %s is not supported", action))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName   xml.Name
+		Result    interface{} `xml:",omitempty"`
+		RequestID string      `xml:"ResponseMetadata>RequestId"`
+	}{XMLName: xml.Name{Local: action + "Response"}, Result: result, RequestID: "request"})
+}
+
+type receiveMessageResult struct {
+	XMLName  xml.Name `xml:"ReceiveMessageResult"`
+	Messages []receivedMessage
+}
+
+type receivedMessage struct {
+	XMLName       xml.Name `xml:"Message"`
+	MessageID     string   `xml:"MessageId"`
+	ReceiptHandle string
+	MD5OfBody     string
+	Body          string
+	Attributes    []receivedAttribute
+}
+
+type receivedAttribute struct {
+	XMLName xml.Name `xml:"Attribute"`
+	Name    string
+	Value   string
+}
+
+// receiveMessage waits up to WaitTimeSeconds for a visible message, then
+// returns up to MaxNumberOfMessages of them.
+func (s *sqsServer) receiveMessage(r *http.Request) receiveMessageResult {
+	max, _ := strconv.Atoi(r.PostForm.Get("MaxNumberOfMessages"))
+	wait, _ := strconv.Atoi(r.PostForm.Get("WaitTimeSeconds"))
+	deadline := time.Now().Add(time.Duration(wait) * time.Second)
+
+	for {
+		if messages := s.receive(max); len(messages) > 0 || !time.Now().Before(deadline) {
+			return receiveMessageResult{Messages: messages}
+		}
+
+		select {
+		case <-r.Context().Done():
+			return receiveMessageResult{}
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func (s *sqsServer) receive(max int) []receivedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.receives++
+	now := time.Now()
+	var received []receivedMessage
+	for _, message := range s.messages {
+		if len(received) == max {
+			break
+		}
+		if now.Before(message.visibleAt) {
+			continue
+		}
+
+		message.receiveCount++
+		message.receipt = fmt.Sprintf("%s-%d", message.id, message.receiveCount)
+		message.visibleAt = now.Add(s.visibilityTimeout)
+
+		sum := md5.Sum([]byte(message.body))
+		received = append(received, receivedMessage{
+			MessageID:     message.id,
+			ReceiptHandle: message.receipt,
+			MD5OfBody:     hex.EncodeToString(sum[:]),
+			Body:          message.body,
+			Attributes: []receivedAttribute{
+				{Name: sqs.MessageSystemAttributeNameApproximateReceiveCount, Value: strconv.Itoa(message.receiveCount)},
+			},
+		})
+	}
+	return received
+}
+
+func (s *sqsServer) changeVisibility(receipt string, timeout time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, message := range s.messages {
+		if message.receipt == receipt {
+			message.visibleAt = time.Now().Add(timeout)
+			s.visibilityChanges[message.body]++
+			return true
+		}
+	}
+	return false
+}
+
+func (s *sqsServer) delete(receipt string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, message := range s.messages {
+		if message.receipt == receipt {
+			s.messages = append(s.messages[:i], s.messages[i+1:]...)
+			return
+		}
+	}
+}
+
+type deleteMessageBatchResult struct {
+	XMLName xml.Name `xml:"DeleteMessageBatchResult"`
+	Entries []deleteMessageBatchResultEntry
+}
+
+type deleteMessageBatchResultEntry struct {
+	XMLName xml.Name `xml:"DeleteMessageBatchResultEntry"`
+	ID      string   `xml:"Id"`
+}
+
+func (s *sqsServer) deleteMessageBatch(r *http.Request) deleteMessageBatchResult {
+	var result deleteMessageBatchResult
+	var receipts []string
+	for i := 1; ; i++ {
+		prefix := fmt.Sprintf("DeleteMessageBatchRequestEntry.%d.", i)
+		id := r.PostForm.Get(prefix + "Id")
+		if id == "" {
+			break
+		}
+
+		receipt := r.PostForm.Get(prefix + "ReceiptHandle")
+		s.delete(receipt)
+		receipts = append(receipts, receipt)
+		result.Entries = append(result.Entries, deleteMessageBatchResultEntry{ID: id})
+	}
+
+	s.mu.Lock()
+	s.deleteBatches = append(s.deleteBatches, receipts)
+	s.mu.Unlock()
+	return result
+}
+
+func (s *sqsServer) fail(w http.ResponseWriter, code, message string) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusBadRequest)
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName   xml.Name `xml:"ErrorResponse"`
+		Type      string   `xml:"Error>Type"`
+		Code      string   `xml:"Error>Code"`
+		Message   string   `xml:"Error>Message"`
+		RequestID string   `xml:"RequestId"`
+	}{Type: "Sender", Code: code, Message: message, RequestID: "request"})
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/evermos/boilerplate-go/configs"
 	"github.com/evermos/boilerplate-go/event/consumer"
+	"github.com/evermos/boilerplate-go/event/local"
 	"github.com/evermos/boilerplate-go/event/model"
 	"github.com/evermos/boilerplate-go/internal/domain/foobarbaz"
 	"github.com/evermos/boilerplate-go/shared/failure"
@@ -13,7 +14,7 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// ConsumerImpl is the SQS consumer implementation for this domain.
+// ConsumerImpl is the event consumer implementation for this domain.
 type ConsumerImpl struct {
 	Config   *configs.Config
 	Service  foobarbaz.FooService
@@ -21,25 +22,27 @@ type ConsumerImpl struct {
 }
 
 // ProvideConsumerImpl is the provider for this consumer.
-func ProvideConsumerImpl(config *configs.Config, service foobarbaz.FooService) ConsumerImpl {
+func ProvideConsumerImpl(config *configs.Config, service foobarbaz.FooService, broker *local.MemoryBroker) ConsumerImpl {
 	c := ConsumerImpl{}
 	c.Config = config
 	c.Service = service
-
-	sqsConsumer := consumer.NewSQSConsumer(config)
-	sqsConsumer.Process = c.processEvent
-	c.Consumer = sqsConsumer
+	c.Consumer = consumer.New(config, broker, c.processEvent)
 
 	return c
 }
 
-// Start starts up the SQS subscriber
+// Start starts up the subscriber
 func (c *ConsumerImpl) Start() {
 	if c.Config.Event.Consumer.SQS.Topics.FooBarBaz.Enabled {
 		go c.Consumer.Listen(c.Config.Event.Consumer.SQS.Topics.FooBarBaz.URL)
 	}
 }
 
+// Stop stops the subscriber, waiting for the messages being processed.
+func (c *ConsumerImpl) Stop() {
+	c.Consumer.Stop()
+}
+
 func (c *ConsumerImpl) processEvent(value []byte) (err error) {
 	snsMessage := model.SNSMessage{}
 	err = json.Unmarshal(value, &snsMessage)
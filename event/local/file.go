@@ -0,0 +1,127 @@
+package local
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FileQueue keeps the messages of each topic in an append-only file,
+// <dir>/<topic>.log, one message per line. Each queue remembers how far it
+// has read in <dir>/<queue>.offset, so messages survive restarts and are
+// delivered at least once.
+type FileQueue struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFileQueue creates a FileQueue keeping its files in dir.
+func NewFileQueue(dir string) *FileQueue {
+	return &FileQueue{dir: dir}
+}
+
+// Publish appends message to the file of topic.
+func (q *FileQueue) Publish(topic string, message []byte) error {
+	payload, err := envelope(topic, message)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(q.path(topic, ".log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	// A single write keeps lines whole when several processes append.
+	_, err = file.Write(append(payload, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Consume has process receive the messages published to the topic named
+// queue since the last call, in order, and returns how many it received. It
+// stops at the first message process fails, which is received again by the
+// next call.
+func (q *FileQueue) Consume(queue string, process Process) (consumed int, err error) {
+	offset, err := q.offset(queue)
+	if err != nil {
+		return
+	}
+
+	file, err := os.Open(q.path(queue, ".log"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A line without its newline is still being written.
+			return consumed, nil
+		}
+		if err != nil {
+			return consumed, err
+		}
+
+		if err := process(line[:len(line)-1]); err != nil {
+			return consumed, err
+		}
+
+		offset += int64(len(line))
+		if err := q.saveOffset(queue, offset); err != nil {
+			return consumed, err
+		}
+		consumed++
+	}
+}
+
+func (q *FileQueue) path(name, ext string) string {
+	return filepath.Join(q.dir, fileName(name)+ext)
+}
+
+func (q *FileQueue) offset(queue string) (int64, error) {
+	raw, err := ioutil.ReadFile(q.path(queue, ".offset"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseInt(strings.TrimSpace(string(raw)), 10, 64)
+}
+
+// saveOffset replaces the offset file of queue in one rename, so a crash
+// leaves either the old or the new offset.
+func (q *FileQueue) saveOffset(queue string, offset int64) error {
+	path := q.path(queue, ".offset")
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,49 @@
+// Package local implements event transports that need no cloud services, for
+// running the event pipeline in development and tests. A queue receives the
+// messages published to the topic of the same name, wrapped in the same SNS
+// envelope as messages SQS receives from SNS, so consumers work unchanged.
+package local
+
+import (
+	"encoding/json"
+	"regexp"
+	"time"
+
+	"github.com/evermos/boilerplate-go/event/model"
+	"github.com/gofrs/uuid"
+)
+
+const (
+	// DriverSNS publishes to SNS and consumes from SQS.
+	DriverSNS = "sns"
+	// DriverMemory publishes and consumes within the process.
+	DriverMemory = "memory"
+	// DriverFile publishes to and consumes from append-only files.
+	DriverFile = "file"
+)
+
+// Process processes a message received from a queue.
+type Process func(message []byte) error
+
+// envelope wraps message published to topic the way SNS delivers it to SQS.
+func envelope(topic string, message []byte) ([]byte, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(model.SNSMessage{
+		Type:      "Notification",
+		MessageID: id,
+		TopicARN:  topic,
+		Message:   string(message),
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+	})
+}
+
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// fileName converts a topic, which may be an ARN or a URL, to a file name.
+func fileName(topic string) string {
+	return unsafeName.ReplaceAllString(topic, "_")
+}
@@ -0,0 +1,142 @@
+package local_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evermos/boilerplate-go/event/local"
+	"github.com/evermos/boilerplate-go/event/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decode(t *testing.T, payload []byte) model.SNSMessage {
+	var message model.SNSMessage
+	require.NoError(t, json.Unmarshal(payload, &message))
+	return message
+}
+
+func TestMemoryBroker(t *testing.T) {
+	t.Run("delivers to the queue of the topic", func(t *testing.T) {
+		b := local.NewMemoryBroker(1, 1, 0)
+		received := make(chan []byte, 2)
+		b.Subscribe("orders", func(message []byte) error {
+			received <- message
+			return nil
+		})
+
+		require.NoError(t, b.Publish("products", []byte(`{"id":1}`)))
+		require.NoError(t, b.Publish("orders", []byte(`{"id":2}`)))
+
+		select {
+		case payload := <-received:
+			message := decode(t, payload)
+			assert.Equal(t, "orders", message.TopicARN)
+			assert.Equal(t, `{"id":2}`, message.Message)
+			assert.Equal(t, "Notification", message.Type)
+		case <-time.After(time.Second):
+			t.Fatal("message was not delivered")
+		}
+
+		select {
+		case <-received:
+			t.Fatal("message to a topic with no queue was delivered")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("retries failed messages", func(t *testing.T) {
+		b := local.NewMemoryBroker(1, 3, time.Millisecond)
+		var mu sync.Mutex
+		attempts := 0
+		done := make(chan struct{})
+		b.Subscribe("orders", func(message []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts++
+			if attempts == 3 {
+				close(done)
+			}
+			return errors.New("failed")
+		})
+
+		require.NoError(t, b.Publish("orders", []byte(`{}`)))
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("message was not retried")
+		}
+	})
+}
+
+func TestFileQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	topic := "arn:aws:sns:ap-southeast-1:000000000000:orders"
+	q := local.NewFileQueue(dir)
+
+	consumed, err := q.Consume(topic, func(message []byte) error { return nil })
+	assert.NoError(t, err)
+	assert.Equal(t, 0, consumed)
+
+	for _, message := range []string{`{"id":1}`, `{"id":2}`, `{"id":3}`} {
+		require.NoError(t, q.Publish(topic, []byte(message)))
+	}
+
+	t.Run("stops at a failed message", func(t *testing.T) {
+		var received []string
+		consumed, err := q.Consume(topic, func(payload []byte) error {
+			message := decode(t, payload)
+			if message.Message == `{"id":2}` {
+				return errors.New("failed")
+			}
+			received = append(received, message.Message)
+			return nil
+		})
+
+		assert.Error(t, err)
+		assert.Equal(t, 1, consumed)
+		assert.Equal(t, []string{`{"id":1}`}, received)
+	})
+
+	t.Run("resumes from the saved offset", func(t *testing.T) {
+		var received []string
+		consumed, err := local.NewFileQueue(dir).Consume(topic, func(payload []byte) error {
+			received = append(received, decode(t, payload).Message)
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, consumed)
+		assert.Equal(t, []string{`{"id":2}`, `{"id":3}`}, received)
+	})
+
+	t.Run("skips a line still being written", func(t *testing.T) {
+		files, err := filepath.Glob(filepath.Join(dir, "*.log"))
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+
+		file, err := os.OpenFile(files[0], os.O_APPEND|os.O_WRONLY, 0644)
+		require.NoError(t, err)
+		_, err = file.WriteString(`{"Type":"Notif`)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		consumed, err := q.Consume(topic, func(payload []byte) error {
+			t.Fatalf("received partial line %s", payload)
+			return nil
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 0, consumed)
+	})
+}
@@ -0,0 +1,111 @@
+package local
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/event/model"
+	"github.com/evermos/boilerplate-go/shared"
+	"github.com/rs/zerolog/log"
+)
+
+// memoryTopic is the only shared.PubSub topic. The broker routes messages to
+// queues itself, since a PubSub cannot register subscribers once started.
+const memoryTopic = "events"
+
+// memoryBuffer is the number of messages published ahead of the consumers
+// before Publish blocks.
+const memoryBuffer = 1024
+
+// MemoryBroker delivers messages within the process on top of shared.PubSub.
+// Messages are lost when the process exits, and those published to a topic
+// with no queue are dropped.
+type MemoryBroker struct {
+	pubsub shared.PubSub
+	start  sync.Once
+	mu     sync.RWMutex
+	queues map[string]Process
+}
+
+var (
+	memoryBroker     *MemoryBroker
+	memoryBrokerOnce sync.Once
+)
+
+// ProvideMemoryBroker returns the MemoryBroker of the process, configured
+// with EVENT.LOCAL.*. It is shared so that consumers wired separately from
+// the producer receive its messages.
+func ProvideMemoryBroker(conf *configs.Config) *MemoryBroker {
+	memoryBrokerOnce.Do(func() {
+		local := conf.Event.Local
+		memoryBroker = NewMemoryBroker(local.MaxFlight, local.MaxAttempts, time.Duration(local.RetryDelayMillis)*time.Millisecond)
+	})
+	return memoryBroker
+}
+
+// NewMemoryBroker creates a MemoryBroker processing up to maxFlight messages
+// at a time, and trying each up to maxAttempts times retryDelay apart.
+// Messages are only processed in order when maxFlight is one.
+func NewMemoryBroker(maxFlight, maxAttempts int, retryDelay time.Duration) *MemoryBroker {
+	if maxFlight <= 0 {
+		maxFlight = 1
+	}
+
+	b := &MemoryBroker{
+		pubsub: shared.New(maxFlight, shared.SetMessageBuffer(memoryBuffer)),
+		queues: make(map[string]Process),
+	}
+	b.pubsub.SubscriberRegistry(memoryTopic, b.deliver, shared.SetMaxRetry(maxAttempts), shared.SetMaxDelayRetry(retryDelay))
+	return b
+}
+
+// Publish publishes message to topic.
+func (b *MemoryBroker) Publish(topic string, message []byte) error {
+	payload, err := envelope(topic, message)
+	if err != nil {
+		return err
+	}
+
+	b.start.Do(b.pubsub.Start)
+	b.pubsub.Publish(memoryTopic, payload)
+	return nil
+}
+
+// Subscribe has process receive the messages published to the topic named
+// queue from now on, replacing the previous process of queue.
+func (b *MemoryBroker) Subscribe(queue string, process Process) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queues[queue] = process
+}
+
+// Unsubscribe stops delivering to queue. Messages published to its topic
+// afterwards are dropped.
+func (b *MemoryBroker) Unsubscribe(queue string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.queues, queue)
+}
+
+func (b *MemoryBroker) deliver(payload []byte) error {
+	var message model.SNSMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		return err
+	}
+
+	b.mu.RLock()
+	process, ok := b.queues[message.TopicARN]
+	b.mu.RUnlock()
+	if !ok {
+		log.Debug().Str("topic", message.TopicARN).Msg("Dropped message published to a topic with no queue.")
+		return nil
+	}
+
+	err := process(payload)
+	if err != nil {
+		log.Error().Err(err).Str("queue", message.TopicARN).Msg("failed processing message")
+	}
+	return err
+}
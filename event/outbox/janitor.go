@@ -0,0 +1,117 @@
+package outbox
+
+import (
+	"time"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultJanitorBatchSize is the number of rows the janitor deletes per
+	// statement when none is configured.
+	defaultJanitorBatchSize = 1000
+	// defaultRetention is how long published and failed messages are kept
+	// when no retention is configured.
+	defaultRetention = 7 * 24 * time.Hour
+)
+
+// Janitor periodically deletes the messages the relay is done with, in
+// batches so as not to hold long locks, so that the outbox only grows with
+// the pending messages.
+type Janitor struct {
+	store     Store
+	interval  time.Duration
+	retention time.Duration
+	batchSize int
+	now       func() time.Time
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewJanitor creates a Janitor purging every interval the messages published
+// or failed more than retention ago. A non positive interval disables it.
+func NewJanitor(store Store, interval time.Duration, retention time.Duration, batchSize int) *Janitor {
+	if retention <= 0 {
+		retention = defaultRetention
+	}
+	if batchSize <= 0 {
+		batchSize = defaultJanitorBatchSize
+	}
+
+	return &Janitor{
+		store:     store,
+		interval:  interval,
+		retention: retention,
+		batchSize: batchSize,
+		now:       time.Now,
+	}
+}
+
+// ProvideJanitor creates a Janitor from EVENT.OUTBOX.* configuration.
+func ProvideJanitor(store Store, config *configs.Config) *Janitor {
+	conf := config.Event.Outbox
+	return NewJanitor(
+		store,
+		time.Duration(conf.JanitorIntervalSeconds)*time.Second,
+		time.Duration(conf.RetentionHours)*time.Hour,
+		conf.JanitorBatchSize)
+}
+
+// Start purges in the background until Stop is called.
+func (j *Janitor) Start() {
+	if j.interval <= 0 {
+		log.Info().Msg("Outbox janitor is disabled.")
+		return
+	}
+
+	j.stop = make(chan struct{})
+	j.done = make(chan struct{})
+	log.Info().Str("interval", j.interval.String()).Str("retention", j.retention.String()).Msg("Outbox janitor started.")
+
+	go func() {
+		defer close(j.done)
+
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-ticker.C:
+				purged, err := j.Purge()
+				if err != nil {
+					logger.ErrorWithStack(err)
+				}
+				log.Debug().Int64("purged", purged).Msg("Outbox janitor run completed.")
+			}
+		}
+	}()
+}
+
+// Stop stops purging and waits for a running purge to complete.
+func (j *Janitor) Stop() {
+	if j == nil || j.stop == nil {
+		return
+	}
+
+	close(j.stop)
+	<-j.done
+	j.stop = nil
+}
+
+// Purge deletes every message past its retention, one batch at a time, and
+// returns how many were deleted.
+func (j *Janitor) Purge() (int64, error) {
+	var purged int64
+	before := j.now().Add(-j.retention)
+	for {
+		rows, err := j.store.Purge(before, j.batchSize)
+		purged += rows
+		if err != nil || rows == 0 {
+			return purged, err
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package outbox
+
+import (
+	"time"
+
+	"github.com/evermos/boilerplate-go/infras"
+	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	outboxQueries = struct {
+		insertMessage        string
+		selectPendingMessage string
+		claimMessage         string
+		updateMessage        string
+		purgeMessages        string
+	}{
+		insertMessage: `
+			INSERT INTO event_outbox (
+				entity_id,
+				aggregate_type,
+				aggregate_id,
+				topic,
+				event_type,
+				payload,
+				message_group_id,
+				status,
+				attempts,
+				last_error,
+				occurred_at,
+				available_at,
+				published_at
+			) VALUES (
+				:entity_id,
+				:aggregate_type,
+				:aggregate_id,
+				:topic,
+				:event_type,
+				:payload,
+				:message_group_id,
+				:status,
+				:attempts,
+				:last_error,
+				:occurred_at,
+				:available_at,
+				:published_at)`,
+
+		selectPendingMessage: `
+			SELECT
+				o.id,
+				o.entity_id,
+				o.aggregate_type,
+				o.aggregate_id,
+				o.topic,
+				o.event_type,
+				o.payload,
+				o.message_group_id,
+				o.status,
+				o.attempts,
+				o.last_error,
+				o.occurred_at,
+				o.available_at,
+				o.published_at
+			FROM event_outbox o
+			WHERE o.status = 'pending'
+				AND o.available_at <= ?
+				AND NOT EXISTS (
+					SELECT 1 FROM event_outbox p
+					WHERE p.aggregate_type = o.aggregate_type
+						AND p.aggregate_id = o.aggregate_id
+						AND p.status = 'pending'
+						AND p.id < o.id)
+			ORDER BY o.id
+			LIMIT ?`,
+
+		claimMessage: `
+			UPDATE event_outbox
+			SET available_at = ?
+			WHERE id = ? AND status = 'pending' AND available_at <= ?`,
+
+		updateMessage: `
+			UPDATE event_outbox
+			SET
+				status = :status,
+				attempts = :attempts,
+				last_error = :last_error,
+				available_at = :available_at,
+				published_at = :published_at
+			WHERE id = :id`,
+
+		purgeMessages: `
+			DELETE FROM event_outbox
+			WHERE status <> 'pending' AND COALESCE(published_at, occurred_at) < ?
+			LIMIT ?`,
+	}
+)
+
+// MySQLStore is a Store keeping messages in the event_outbox table.
+type MySQLStore struct {
+	DB *infras.MySQLConn
+}
+
+// ProvideMySQLStore is the provider for MySQLStore.
+func ProvideMySQLStore(db *infras.MySQLConn) *MySQLStore {
+	return &MySQLStore{DB: db}
+}
+
+// TxCreate writes message as part of a caller-owned transaction.
+func (s *MySQLStore) TxCreate(tx *sqlx.Tx, message Message) (err error) {
+	stmt, err := tx.PrepareNamed(outboxQueries.insertMessage)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(message)
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+
+	return
+}
+
+// ResolvePending resolves the oldest pending message of up to limit
+// aggregates. It reads from the primary, since a lagging replica would hand
+// out messages that have already been published.
+func (s *MySQLStore) ResolvePending(now time.Time, limit int) (messages []Message, err error) {
+	err = s.DB.Write.Select(&messages, outboxQueries.selectPendingMessage, now, limit)
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+
+	return
+}
+
+// Claim pushes the availability of message to until, which only succeeds
+// while it is still available at now.
+func (s *MySQLStore) Claim(message Message, now time.Time, until time.Time) (claimed bool, err error) {
+	result, err := s.DB.Write.Exec(outboxQueries.claimMessage, until, message.ID, now)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	return affected == 1, nil
+}
+
+// Update saves the publish status of message.
+func (s *MySQLStore) Update(message Message) (err error) {
+	_, err = s.DB.Write.NamedExec(outboxQueries.updateMessage, message)
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+
+	return
+}
+
+// Purge deletes up to limit published or failed messages older than before.
+func (s *MySQLStore) Purge(before time.Time, limit int) (purged int64, err error) {
+	result, err := s.DB.Write.Exec(outboxQueries.purgeMessages, before, limit)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	purged, err = result.RowsAffected()
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+
+	return
+}
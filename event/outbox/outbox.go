@@ -0,0 +1,100 @@
+package outbox
+
+import (
+	"time"
+
+	"github.com/evermos/boilerplate-go/event/model"
+	"github.com/gofrs/uuid"
+	"github.com/guregu/null"
+	"github.com/jmoiron/sqlx"
+)
+
+// Status is the publish status of an outbox Message.
+type Status string
+
+const (
+	// StatusPending messages are waiting to be published.
+	StatusPending Status = "pending"
+	// StatusPublished messages have been published.
+	StatusPublished Status = "published"
+	// StatusFailed messages could not be published after MaxAttempts and
+	// are no longer retried.
+	StatusFailed Status = "failed"
+)
+
+// Message is an event waiting in the outbox. Messages are written in the
+// transaction of the domain change they describe, so an event is published if
+// and only if the change is committed.
+type Message struct {
+	ID             int64       `db:"id"`
+	EntityID       uuid.UUID   `db:"entity_id"`
+	AggregateType  string      `db:"aggregate_type"`
+	AggregateID    string      `db:"aggregate_id"`
+	Topic          string      `db:"topic"`
+	EventType      string      `db:"event_type"`
+	Payload        []byte      `db:"payload"`
+	MessageGroupID null.String `db:"message_group_id"`
+	Status         Status      `db:"status"`
+	Attempts       int         `db:"attempts"`
+	LastError      null.String `db:"last_error"`
+	OccurredAt     time.Time   `db:"occurred_at"`
+	AvailableAt    time.Time   `db:"available_at"`
+	PublishedAt    null.Time   `db:"published_at"`
+}
+
+// NewMessage creates a pending Message publishing request. Messages of the
+// same aggregate are published in the order they were written.
+func NewMessage(aggregateType string, aggregateID string, request model.PublishRequest) Message {
+	id, _ := uuid.NewV4()
+	message := Message{
+		EntityID:      id,
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		Topic:         request.Topic,
+		EventType:     request.Event.EventType,
+		Payload:       request.Event.Data.Value,
+		Status:        StatusPending,
+		OccurredAt:    request.Event.Data.Timestamp,
+		AvailableAt:   request.Event.Data.Timestamp,
+	}
+	if request.MessageGroupID != nil {
+		message.MessageGroupID = null.StringFrom(*request.MessageGroupID)
+	}
+
+	return message
+}
+
+// PublishRequest returns the request publishing m.
+func (m Message) PublishRequest() model.PublishRequest {
+	return model.PublishRequest{
+		Event: model.EventWrapper{
+			EventType: m.EventType,
+			Data: model.Data{
+				Timestamp: m.OccurredAt,
+				Value:     m.Payload,
+			},
+		},
+		MessageGroupID: m.MessageGroupID.Ptr(),
+		Topic:          m.Topic,
+	}
+}
+
+// Store persists outbox messages.
+type Store interface {
+	// TxCreate writes message as part of a caller-owned transaction.
+	TxCreate(tx *sqlx.Tx, message Message) (err error)
+	// ResolvePending resolves up to limit pending messages available at
+	// now, in the order they were written. Only the oldest pending message
+	// of each aggregate is resolved, so that the messages of an aggregate
+	// are published one at a time.
+	ResolvePending(now time.Time, limit int) (messages []Message, err error)
+	// Claim makes message unavailable to other relays until until. It
+	// reports false when another relay claimed it first.
+	Claim(message Message, now time.Time, until time.Time) (claimed bool, err error)
+	// Update saves the publish status of message.
+	Update(message Message) (err error)
+	// Purge deletes up to limit published or failed messages that were
+	// published, or written when they failed, before before, and returns how
+	// many were deleted.
+	Purge(before time.Time, limit int) (purged int64, err error)
+}
@@ -0,0 +1,215 @@
+package outbox
+
+import (
+	"time"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/event/producer"
+	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/guregu/null"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultRelayBatchSize   = 100
+	defaultRelayMaxAttempts = 10
+	defaultRelayBackoff     = 5 * time.Second
+	maxRelayBackoff         = time.Hour
+
+	// claimTimeout is how long a claimed message is kept from other
+	// relays. A message whose relay died while publishing it is published
+	// again once it has passed, so delivery is at least once.
+	claimTimeout = time.Minute
+)
+
+// Relay publishes the pending messages of the outbox through a Producer.
+// Messages that fail to publish are retried with exponential backoff until
+// MaxAttempts, after which they are marked failed and stop holding back the
+// later messages of their aggregate.
+type Relay struct {
+	store       Store
+	producer    producer.Producer
+	interval    time.Duration
+	batchSize   int
+	maxAttempts int
+	backoff     time.Duration
+	now         func() time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// RelayOptions configures a Relay. Zero values are replaced by defaults.
+type RelayOptions struct {
+	Interval    time.Duration
+	BatchSize   int
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+// NewRelay creates a new Relay publishing the messages of store.
+func NewRelay(store Store, producer producer.Producer, options RelayOptions) *Relay {
+	if options.BatchSize <= 0 {
+		options.BatchSize = defaultRelayBatchSize
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaultRelayMaxAttempts
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = defaultRelayBackoff
+	}
+
+	return &Relay{
+		store:       store,
+		producer:    producer,
+		interval:    options.Interval,
+		batchSize:   options.BatchSize,
+		maxAttempts: options.MaxAttempts,
+		backoff:     options.Backoff,
+		now:         time.Now,
+	}
+}
+
+// ProvideRelay is the provider for Relay.
+func ProvideRelay(store Store, producer producer.Producer, config *configs.Config) *Relay {
+	conf := config.Event.Outbox
+	return NewRelay(store, producer, RelayOptions{
+		Interval:    time.Duration(conf.IntervalMillis) * time.Millisecond,
+		BatchSize:   conf.BatchSize,
+		MaxAttempts: conf.MaxAttempts,
+		Backoff:     time.Duration(conf.BackoffSeconds) * time.Second,
+	})
+}
+
+// Start relays pending messages every interval in the background until Stop
+// is called. A relay without an interval does nothing.
+func (r *Relay) Start() {
+	if r.interval <= 0 {
+		log.Info().Msg("Outbox relay disabled.")
+		return
+	}
+
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				_, err := r.Relay()
+				if err != nil {
+					logger.ErrorWithStack(err)
+				}
+			}
+		}
+	}()
+
+	log.Info().Dur("interval", r.interval).Msg("Outbox relay started.")
+}
+
+// Stop stops the relay started by Start and waits for the message being
+// published to finish.
+func (r *Relay) Stop() {
+	if r == nil || r.stop == nil {
+		return
+	}
+
+	close(r.stop)
+	<-r.done
+	r.stop = nil
+}
+
+// Relay publishes batches of pending messages until none is left or Stop is
+// called, and returns how many were published. Every message resolved is
+// either published, backed off, failed or claimed by another relay, so no
+// message is resolved twice.
+func (r *Relay) Relay() (published int, err error) {
+	for {
+		if r.stopping() {
+			return published, nil
+		}
+
+		messages, err := r.store.ResolvePending(r.now(), r.batchSize)
+		if err != nil {
+			return published, err
+		}
+
+		if len(messages) == 0 {
+			return published, nil
+		}
+
+		for _, message := range messages {
+			ok, err := r.publish(message)
+			if err != nil {
+				return published, err
+			}
+			if ok {
+				published++
+			}
+		}
+	}
+}
+
+// stopping reports whether Stop has been called, so that a large backlog does
+// not hold up the shutdown. The rest of it is published after the restart.
+func (r *Relay) stopping() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// publish claims and publishes message, and saves whether it was published.
+// It reports false when the message was claimed by another relay or failed
+// to publish.
+func (r *Relay) publish(message Message) (published bool, err error) {
+	now := r.now()
+	claimed, err := r.store.Claim(message, now, now.Add(claimTimeout))
+	if err != nil || !claimed {
+		return
+	}
+
+	message.Attempts++
+	publishErr := r.producer.Publish(message.PublishRequest())
+	if publishErr == nil {
+		message.Status = StatusPublished
+		message.PublishedAt = null.TimeFrom(r.now())
+		message.LastError = null.String{}
+		return true, r.store.Update(message)
+	}
+
+	message.LastError = null.StringFrom(publishErr.Error())
+	if message.Attempts >= r.maxAttempts {
+		message.Status = StatusFailed
+		log.Error().
+			Err(publishErr).
+			Str("id", message.EntityID.String()).
+			Str("eventType", message.EventType).
+			Int("attempts", message.Attempts).
+			Msg("Giving up publishing outbox message")
+	} else {
+		message.AvailableAt = r.now().Add(r.backoffFor(message.Attempts))
+	}
+
+	return false, r.store.Update(message)
+}
+
+// backoffFor returns how long to wait before the next attempt after attempts
+// failed ones.
+func (r *Relay) backoffFor(attempts int) time.Duration {
+	backoff := r.backoff
+	for i := 1; i < attempts && backoff < maxRelayBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRelayBackoff {
+		backoff = maxRelayBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,287 @@
+package outbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/evermos/boilerplate-go/event/model"
+	"github.com/guregu/null"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeStore struct {
+	messages []Message
+	// claimedElsewhere are the IDs of messages another relay claims first.
+	claimedElsewhere map[int64]bool
+	purged           map[int64]bool
+}
+
+func (s *fakeStore) TxCreate(tx *sqlx.Tx, message Message) error {
+	message.ID = int64(len(s.messages) + 1)
+	s.messages = append(s.messages, message)
+	return nil
+}
+
+func (s *fakeStore) ResolvePending(now time.Time, limit int) ([]Message, error) {
+	blocked := make(map[string]bool)
+	messages := make([]Message, 0)
+	for _, message := range s.messages {
+		if message.Status != StatusPending {
+			continue
+		}
+		key := message.AggregateType + ":" + message.AggregateID
+		if !blocked[key] && !message.AvailableAt.After(now) && len(messages) < limit {
+			messages = append(messages, message)
+		}
+		blocked[key] = true
+	}
+	return messages, nil
+}
+
+func (s *fakeStore) Claim(message Message, now time.Time, until time.Time) (bool, error) {
+	stored := &s.messages[message.ID-1]
+	if s.claimedElsewhere[message.ID] {
+		stored.AvailableAt = until
+	}
+	if stored.Status != StatusPending || stored.AvailableAt.After(now) {
+		return false, nil
+	}
+	stored.AvailableAt = until
+	return true, nil
+}
+
+func (s *fakeStore) Update(message Message) error {
+	s.messages[message.ID-1] = message
+	return nil
+}
+
+func (s *fakeStore) Purge(before time.Time, limit int) (int64, error) {
+	if s.purged == nil {
+		s.purged = make(map[int64]bool)
+	}
+
+	var purged int64
+	for _, message := range s.messages {
+		if int(purged) == limit {
+			break
+		}
+		if s.purged[message.ID] || message.Status == StatusPending {
+			continue
+		}
+
+		at := message.OccurredAt
+		if message.PublishedAt.Valid {
+			at = message.PublishedAt.Time
+		}
+		if at.Before(before) {
+			s.purged[message.ID] = true
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+type fakeProducer struct {
+	published []model.PublishRequest
+	fail      map[string]bool
+	onPublish func()
+}
+
+func (p *fakeProducer) Publish(request model.PublishRequest) error {
+	if p.fail[string(request.Event.Data.Value)] {
+		return errors.New("publish failed")
+	}
+	p.published = append(p.published, request)
+	if p.onPublish != nil {
+		p.onPublish()
+	}
+	return nil
+}
+
+func newTestMessage(aggregateID string, value string, at time.Time) Message {
+	return NewMessage("order", aggregateID, model.PublishRequest{
+		Event: model.EventWrapper{
+			EventType: "order.cancelled",
+			Data:      model.Data{Timestamp: at, Value: []byte(value)},
+		},
+		Topic: "arn:order-cancelled",
+	})
+}
+
+func newTestRelay(store *fakeStore, producer *fakeProducer, now *time.Time) *Relay {
+	relay := NewRelay(store, producer, RelayOptions{BatchSize: 2, MaxAttempts: 3, Backoff: time.Second})
+	relay.now = func() time.Time { return *now }
+	return relay
+}
+
+func values(requests []model.PublishRequest) []string {
+	result := make([]string, 0, len(requests))
+	for _, request := range requests {
+		result = append(result, string(request.Event.Data.Value))
+	}
+	return result
+}
+
+func TestRelay(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("publishes pending messages in order per aggregate", func(t *testing.T) {
+		now := start
+		store := &fakeStore{}
+		producer := &fakeProducer{}
+		for _, m := range []Message{
+			newTestMessage("a", "a1", start),
+			newTestMessage("b", "b1", start),
+			newTestMessage("a", "a2", start),
+			newTestMessage("c", "c1", start),
+			newTestMessage("a", "a3", start),
+		} {
+			_ = store.TxCreate(nil, m)
+		}
+
+		published, err := newTestRelay(store, producer, &now).Relay()
+
+		assert.NoError(t, err)
+		assert.Equal(t, 5, published)
+		assert.Equal(t, []string{"a1", "b1", "a2", "c1", "a3"}, values(producer.published))
+		for _, m := range store.messages {
+			assert.Equal(t, StatusPublished, m.Status)
+			assert.Equal(t, 1, m.Attempts)
+			assert.True(t, m.PublishedAt.Valid)
+		}
+		assert.Equal(t, "arn:order-cancelled", producer.published[0].Topic)
+		assert.Equal(t, "order.cancelled", producer.published[0].Event.EventType)
+		assert.Equal(t, start, producer.published[0].Event.Data.Timestamp)
+	})
+
+	t.Run("retries failed messages with backoff and holds back their aggregate", func(t *testing.T) {
+		now := start
+		store := &fakeStore{}
+		producer := &fakeProducer{fail: map[string]bool{"a1": true}}
+		_ = store.TxCreate(nil, newTestMessage("a", "a1", start))
+		_ = store.TxCreate(nil, newTestMessage("a", "a2", start))
+		_ = store.TxCreate(nil, newTestMessage("b", "b1", start))
+		relay := newTestRelay(store, producer, &now)
+
+		published, err := relay.Relay()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, published)
+		assert.Equal(t, []string{"b1"}, values(producer.published))
+		assert.Equal(t, StatusPending, store.messages[0].Status)
+		assert.Equal(t, "publish failed", store.messages[0].LastError.String)
+		assert.Equal(t, start.Add(time.Second), store.messages[0].AvailableAt)
+
+		now = start.Add(500 * time.Millisecond)
+		published, _ = relay.Relay()
+		assert.Equal(t, 0, published, "a1 is backing off and a2 waits for it")
+
+		now = start.Add(time.Second)
+		_, _ = relay.Relay()
+		assert.Equal(t, 2, store.messages[0].Attempts)
+		assert.Equal(t, now.Add(2*time.Second), store.messages[0].AvailableAt)
+
+		producer.fail = nil
+		now = now.Add(2 * time.Second)
+		published, _ = relay.Relay()
+		assert.Equal(t, 2, published)
+		assert.Equal(t, []string{"b1", "a1", "a2"}, values(producer.published))
+		assert.False(t, store.messages[0].LastError.Valid)
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		now := start
+		store := &fakeStore{}
+		producer := &fakeProducer{fail: map[string]bool{"a1": true}}
+		_ = store.TxCreate(nil, newTestMessage("a", "a1", start))
+		_ = store.TxCreate(nil, newTestMessage("a", "a2", start))
+		relay := newTestRelay(store, producer, &now)
+
+		for i := 0; i < 3; i++ {
+			_, _ = relay.Relay()
+			now = now.Add(time.Hour)
+		}
+
+		assert.Equal(t, StatusFailed, store.messages[0].Status)
+		assert.Equal(t, 3, store.messages[0].Attempts)
+		assert.Equal(t, []string{"a2"}, values(producer.published), "a failed message no longer holds back its aggregate")
+	})
+
+	t.Run("skips messages claimed by another relay", func(t *testing.T) {
+		now := start
+		store := &fakeStore{claimedElsewhere: map[int64]bool{1: true}}
+		producer := &fakeProducer{}
+		_ = store.TxCreate(nil, newTestMessage("a", "a1", start))
+		_ = store.TxCreate(nil, newTestMessage("b", "b1", start))
+
+		published, err := newTestRelay(store, producer, &now).Relay()
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, published)
+		assert.Equal(t, []string{"b1"}, values(producer.published))
+		assert.Equal(t, StatusPending, store.messages[0].Status)
+	})
+}
+
+func TestRelayStop(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &fakeStore{}
+	producer := &fakeProducer{}
+	for _, aggregateID := range []string{"a", "b", "c", "d", "e"} {
+		_ = store.TxCreate(nil, newTestMessage(aggregateID, aggregateID+"1", now))
+	}
+	relay := newTestRelay(store, producer, &now)
+	relay.stop = make(chan struct{})
+	producer.onPublish = func() {
+		if len(producer.published) == 1 {
+			close(relay.stop)
+		}
+	}
+
+	published, err := relay.Relay()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, published, "the batch being published is finished")
+	assert.Equal(t, StatusPending, store.messages[2].Status)
+}
+
+func TestJanitor(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &fakeStore{}
+	for _, m := range []struct {
+		status      Status
+		occurredAt  time.Time
+		publishedAt null.Time
+	}{
+		{StatusPublished, start, null.TimeFrom(start)},
+		{StatusPublished, start, null.TimeFrom(start.Add(2 * time.Hour))},
+		{StatusFailed, start, null.Time{}},
+		{StatusFailed, start.Add(2 * time.Hour), null.Time{}},
+		{StatusPending, start, null.Time{}},
+	} {
+		message := newTestMessage("a", "a", start)
+		message.Status = m.status
+		message.OccurredAt = m.occurredAt
+		message.PublishedAt = m.publishedAt
+		_ = store.TxCreate(nil, message)
+	}
+
+	janitor := NewJanitor(store, time.Minute, time.Hour, 1)
+	janitor.now = func() time.Time { return start.Add(150 * time.Minute) }
+
+	purged, err := janitor.Purge()
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+	assert.Equal(t, map[int64]bool{1: true, 3: true}, store.purged)
+}
+
+func TestRelayBackoff(t *testing.T) {
+	relay := NewRelay(&fakeStore{}, &fakeProducer{}, RelayOptions{Backoff: 5 * time.Second})
+
+	assert.Equal(t, 5*time.Second, relay.backoffFor(1))
+	assert.Equal(t, 10*time.Second, relay.backoffFor(2))
+	assert.Equal(t, 40*time.Second, relay.backoffFor(4))
+	assert.Equal(t, time.Hour, relay.backoffFor(20))
+}
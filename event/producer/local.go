@@ -0,0 +1,48 @@
+package producer
+
+import (
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/event/local"
+	"github.com/evermos/boilerplate-go/event/model"
+	"github.com/rs/zerolog/log"
+)
+
+// ProvideProducer creates the Producer of the driver selected with
+// EVENT.DRIVER, publishing to SNS unless memory or file is selected.
+func ProvideProducer(config *configs.Config, broker *local.MemoryBroker) Producer {
+	switch config.Event.Driver {
+	case local.DriverMemory:
+		log.Info().Msg("Memory Producer ready to publish messages.")
+		return &LocalProducer{queue: broker}
+	case local.DriverFile:
+		log.Info().Str("dir", config.Event.Local.Dir).Msg("File Producer ready to publish messages.")
+		return &LocalProducer{queue: local.NewFileQueue(config.Event.Local.Dir)}
+	default:
+		return NewSNSProducer(config)
+	}
+}
+
+// localQueue is implemented by the queues of package local.
+type localQueue interface {
+	Publish(topic string, message []byte) error
+}
+
+// LocalProducer publishes to a MemoryBroker or a FileQueue.
+type LocalProducer struct {
+	queue localQueue
+}
+
+// Publish publishes a message to the topic of the request.
+func (p *LocalProducer) Publish(request model.PublishRequest) error {
+	err := p.queue.Publish(request.Topic, request.Event.Data.Value)
+	if err != nil {
+		log.Err(err).Str("topic", request.Topic).Msg("failed publishing message")
+		return err
+	}
+
+	log.Debug().
+		Str("topic", request.Topic).
+		Str("eventType", request.Event.EventType).
+		Msg("Published local message")
+	return nil
+}
@@ -0,0 +1,5 @@
+// Package schemas holds the JSON Schemas of the domain event payloads
+// published through the outbox, one file per event type and version in
+// <event type>/v<version>.json. The payload is the decoded data.value of the
+// event wrapper, and its version field names the schema it conforms to.
+package schemas
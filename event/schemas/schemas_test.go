@@ -0,0 +1,260 @@
+package schemas_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evermos/boilerplate-go/event/model"
+	"github.com/evermos/boilerplate-go/internal/domain/cart"
+	"github.com/evermos/boilerplate-go/internal/domain/order"
+	"github.com/evermos/boilerplate-go/internal/domain/product"
+	"github.com/gofrs/uuid"
+	"github.com/guregu/null"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// schema is the subset of JSON Schema used by the schemas in this package.
+type schema struct {
+	Type       interface{}        `json:"type"`
+	Required   []string           `json:"required"`
+	Properties map[string]*schema `json:"properties"`
+	Items      *schema            `json:"items"`
+	Enum       []interface{}      `json:"enum"`
+}
+
+func loadSchema(t *testing.T, path string) *schema {
+	raw, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	var s schema
+	require.NoError(t, json.Unmarshal(raw, &s), path)
+	return &s
+}
+
+// validate reports the places where value does not conform to s.
+func validate(s *schema, value interface{}, path string) (errs []string) {
+	if !hasType(s, value) {
+		return []string{fmt.Sprintf("%s: %v is not of type %v", path, value, s.Type)}
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		errs = append(errs, fmt.Sprintf("%s: %v is not one of %v", path, value, s.Enum))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: %s is required", path, name))
+			}
+		}
+
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				errs = append(errs, fmt.Sprintf("%s: %s is not in the schema", path, name))
+				continue
+			}
+			errs = append(errs, validate(property, v[name], path+"."+name)...)
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				errs = append(errs, validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+
+	return
+}
+
+func hasType(s *schema, value interface{}) bool {
+	var types []string
+	switch t := s.Type.(type) {
+	case nil:
+		return true
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, name := range t {
+			types = append(types, name.(string))
+		}
+	}
+
+	for _, name := range types {
+		switch v := value.(type) {
+		case nil:
+			if name == "null" {
+				return true
+			}
+		case bool:
+			if name == "boolean" {
+				return true
+			}
+		case float64:
+			if name == "number" || (name == "integer" && v == math.Trunc(v)) {
+				return true
+			}
+		case string:
+			if name == "string" {
+				return true
+			}
+		case []interface{}:
+			if name == "array" {
+				return true
+			}
+		case map[string]interface{}:
+			if name == "object" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if allowed == value {
+			return true
+		}
+	}
+	return false
+}
+
+func getRandomUUID() uuid.UUID {
+	id, _ := uuid.NewV4()
+	return id
+}
+
+// samples returns a payload built by the domain packages for every schema.
+func samples(t *testing.T) map[string][]model.EventWrapper {
+	userID := getRandomUUID()
+	adminID := getRandomUUID()
+	productID := getRandomUUID()
+
+	o, err := order.Order{}.NewOrder(userID, "Jl. Sudirman 1")
+	require.NoError(t, err)
+	o.AttachItems([]order.OrderItem{
+		{OrderID: o.ID, ProductID: productID, Quantity: 2, UnitPrice: 15000, CreatedAt: o.CreatedAt, CreatedBy: userID},
+	})
+	o.Recalculate()
+
+	shipped := o
+	shippedHistory, err := shipped.UpdateStatus(order.OrderStatusShipping, adminID)
+	require.NoError(t, err)
+
+	cancelled := o
+	cancelledHistory, err := cancelled.UpdateStatus(order.OrderStatusCancelled, userID)
+	require.NoError(t, err)
+
+	p, err := product.Product{}.NewFromRequestFormat(product.ProductRequestFormat{
+		Name: "Kopi", Description: "Kopi arabika", Category: "Minuman", Brand: "Kapal", Stock: 10, Price: 15000,
+	}, adminID)
+	require.NoError(t, err)
+	deleted := p
+	require.NoError(t, deleted.SoftDelete(adminID))
+
+	c, err := cart.Cart{}.NewCart(userID)
+	require.NoError(t, err)
+	c.UpdatedAt = null.TimeFrom(time.Now().Add(-48 * time.Hour))
+	c.AttachItems([]cart.CartItem{
+		{CartID: c.ID, ProductID: productID, Quantity: 3, UnitPrice: 15000, CreatedAt: c.CreatedAt, CreatedBy: userID},
+	})
+	c.Recalculate()
+
+	return map[string][]model.EventWrapper{
+		"order.created/v1.json": {
+			model.NewEvent(order.OrderCreatedEventType, order.NewOrderCreatedEvent(o)),
+		},
+		"order.status_changed/v1.json": {
+			model.NewEvent(order.OrderStatusChangedEventType, order.NewOrderStatusChangedEvent(shipped, shippedHistory)),
+			model.NewEvent(order.OrderStatusChangedEventType, order.NewOrderStatusChangedEvent(cancelled, cancelledHistory)),
+		},
+		"order.cancelled/v1.json": {
+			model.NewEvent(order.OrderCancelledEventType, order.OrderCancelledEvent{
+				Version:     order.OrderCancelledEventVersion,
+				Order:       cancelled.ToResponseFormat(),
+				CancelledAt: cancelledHistory.CreatedAt,
+				CancelledBy: cancelledHistory.CreatedBy,
+			}),
+		},
+		"product.changed/v1.json": {
+			model.NewEvent(product.ProductCreatedEventType, product.ProductChangedEvent{
+				Version: product.ProductChangedEventVersion,
+				Product: p.ToResponseFormat(),
+			}),
+			model.NewEvent(product.ProductDeletedEventType, product.ProductChangedEvent{
+				Version: product.ProductChangedEventVersion,
+				Product: deleted.ToResponseFormat(),
+			}),
+		},
+		"product.stock_changed/v1.json": {
+			model.NewEvent(product.ProductStockChangedEventType, product.ProductStockChangedEvent{
+				Version:   product.ProductStockChangedEventVersion,
+				ProductID: productID,
+				Change:    -2,
+				Stock:     8,
+				Reason:    product.StockChangeReserved,
+				ChangedAt: time.Now(),
+			}),
+		},
+		"cart.abandoned/v1.json": {
+			model.NewEvent(cart.CartAbandonedEventType, cart.NewCartAbandonedEvent(c, time.Now())),
+		},
+	}
+}
+
+func TestPayloadsMatchSchemas(t *testing.T) {
+	for path, events := range samples(t) {
+		s := loadSchema(t, path)
+		for _, event := range events {
+			var payload interface{}
+			require.NoError(t, json.Unmarshal(event.Data.Value, &payload))
+
+			errs := validate(s, payload, event.EventType)
+			assert.Empty(t, errs, "%s does not match %s:\n%s", event.EventType, path, strings.Join(errs, "\n"))
+		}
+	}
+}
+
+func TestEverySchemaHasSamples(t *testing.T) {
+	sampled := samples(t)
+
+	err := filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".json" {
+			return err
+		}
+
+		assert.Contains(t, sampled, filepath.ToSlash(path), "%s has no sample payload", path)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestSchemaVersionsMatchPayloads(t *testing.T) {
+	for path, events := range samples(t) {
+		for _, event := range events {
+			var payload struct {
+				Version int `json:"version"`
+			}
+			require.NoError(t, json.Unmarshal(event.Data.Value, &payload))
+			assert.True(t, strings.HasSuffix(path, fmt.Sprintf("/v%d.json", payload.Version)),
+				"%s is version %d but is checked against %s", event.EventType, payload.Version, path)
+		}
+	}
+}
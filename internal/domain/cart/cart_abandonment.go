@@ -0,0 +1,172 @@
+package cart
+
+import (
+	"time"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/event/model"
+	"github.com/evermos/boilerplate-go/event/outbox"
+	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/jmoiron/sqlx"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultAbandonmentBatchSize is the number of carts the detector marks per
+// query when none is configured.
+const defaultAbandonmentBatchSize = 100
+
+// AbandonmentDetector periodically marks carts with items that have not been
+// changed for a while as abandoned, and writes a cart.abandoned event for
+// each of them to the outbox.
+type AbandonmentDetector struct {
+	cartRepository CartRepository
+	outbox         outbox.Store
+	topic          string
+	interval       time.Duration
+	after          time.Duration
+	batchSize      int
+	now            func() time.Time
+	stop           chan struct{}
+	done           chan struct{}
+}
+
+// NewAbandonmentDetector creates an AbandonmentDetector running every interval
+// and publishing to topic the carts unchanged for longer than after. A non
+// positive interval disables it.
+func NewAbandonmentDetector(cartRepository CartRepository, outbox outbox.Store, topic string, interval, after time.Duration, batchSize int) *AbandonmentDetector {
+	if batchSize <= 0 {
+		batchSize = defaultAbandonmentBatchSize
+	}
+
+	return &AbandonmentDetector{
+		cartRepository: cartRepository,
+		outbox:         outbox,
+		topic:          topic,
+		interval:       interval,
+		after:          after,
+		batchSize:      batchSize,
+		now:            time.Now,
+	}
+}
+
+// ProvideAbandonmentDetector creates an AbandonmentDetector from
+// EVENT.CART_ABANDONMENT.* configuration. It is disabled along with the
+// CART_ABANDONED topic.
+func ProvideAbandonmentDetector(cartRepository CartRepository, outbox outbox.Store, conf *configs.Config) *AbandonmentDetector {
+	abandonment := conf.Event.CartAbandonment
+	topic := conf.Event.Producer.SNS.Topics.CartAbandoned
+
+	interval := time.Duration(abandonment.IntervalSeconds) * time.Second
+	if !topic.Enabled {
+		interval = 0
+	}
+
+	return NewAbandonmentDetector(
+		cartRepository,
+		outbox,
+		topic.ARN,
+		interval,
+		time.Duration(abandonment.AfterSeconds)*time.Second,
+		abandonment.BatchSize)
+}
+
+// Start detects abandoned carts in the background until Stop is called.
+func (d *AbandonmentDetector) Start() {
+	if d.interval <= 0 {
+		log.Info().Msg("Cart abandonment detector is disabled.")
+		return
+	}
+
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	log.Info().Str("interval", d.interval.String()).Str("after", d.after.String()).Msg("Cart abandonment detector started.")
+
+	go func() {
+		defer close(d.done)
+
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				abandoned, err := d.Detect()
+				if err != nil {
+					logger.ErrorWithStack(err)
+				}
+				log.Debug().Int("abandoned", abandoned).Msg("Cart abandonment detector run completed.")
+			}
+		}
+	}()
+}
+
+// Stop stops detecting and waits for a running detection to complete.
+func (d *AbandonmentDetector) Stop() {
+	if d.stop == nil {
+		return
+	}
+
+	close(d.stop)
+	<-d.done
+	d.stop = nil
+}
+
+// Detect marks every cart unchanged for longer than the configured duration as
+// abandoned, one batch at a time, and returns how many carts were marked.
+func (d *AbandonmentDetector) Detect() (abandoned int, err error) {
+	now := d.now()
+	before := now.Add(-d.after)
+	for {
+		carts, err := d.cartRepository.ResolveAbandonedCarts(before, d.batchSize)
+		if err != nil || len(carts) == 0 {
+			return abandoned, err
+		}
+
+		batchAbandoned := 0
+		for _, cart := range carts {
+			marked, err := d.abandon(cart, before, now)
+			if err != nil {
+				return abandoned, err
+			}
+			if marked {
+				batchAbandoned++
+			}
+		}
+		abandoned += batchAbandoned
+
+		// A batch marking nothing would be resolved again, so the rest is
+		// left to the next run.
+		if len(carts) < d.batchSize || batchAbandoned == 0 {
+			return abandoned, nil
+		}
+	}
+}
+
+// abandon marks cart abandoned and writes its cart.abandoned event in one
+// transaction. A cart changed since it was resolved is left alone.
+func (d *AbandonmentDetector) abandon(cart Cart, before, now time.Time) (marked bool, err error) {
+	items, err := d.cartRepository.ResolveCartItemsJoinProduct(cart.ID)
+	if err != nil {
+		return
+	}
+
+	cart.AttachItems(items)
+	cart.Recalculate()
+
+	err = d.cartRepository.WithTransaction(func(tx *sqlx.Tx, e chan error) {
+		ok, err := d.cartRepository.TxMarkCartAbandoned(tx, cart.ID, before, now)
+		if err != nil || !ok || len(cart.Items) == 0 {
+			e <- err
+			return
+		}
+
+		marked = true
+		e <- d.outbox.TxCreate(tx, outbox.NewMessage(CartAggregateType, cart.ID.String(), model.PublishRequest{
+			Event: model.NewEvent(CartAbandonedEventType, NewCartAbandonedEvent(cart, now)),
+			Topic: d.topic,
+		}))
+	})
+	return
+}
@@ -0,0 +1,151 @@
+package cart_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/evermos/boilerplate-go/event/outbox"
+	"github.com/evermos/boilerplate-go/infras"
+	"github.com/evermos/boilerplate-go/internal/domain/cart"
+	"github.com/gofrs/uuid"
+	"github.com/guregu/null"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+// abandonedCartRepository keeps carts and their items in memory, applying the
+// same conditions as the MySQL queries used to detect abandoned carts.
+type abandonedCartRepository struct {
+	cart.CartRepository
+	carts   []cart.Cart
+	items   map[uuid.UUID][]cart.CartItem
+	changed map[uuid.UUID]bool
+	// stale resolves carts already marked abandoned, like a lagging replica.
+	stale bool
+}
+
+func (r *abandonedCartRepository) ResolveAbandonedCarts(before time.Time, limit int) (carts []cart.Cart, err error) {
+	for _, c := range r.carts {
+		if (!c.AbandonedAt.Valid || r.stale) && c.LastActiveAt().Before(before) && len(r.items[c.ID]) > 0 {
+			carts = append(carts, c)
+		}
+		if len(carts) == limit {
+			break
+		}
+	}
+	return
+}
+
+func (r *abandonedCartRepository) ResolveCartItemsJoinProduct(cartID uuid.UUID) ([]cart.CartItem, error) {
+	return r.items[cartID], nil
+}
+
+func (r *abandonedCartRepository) WithTransaction(block infras.Block) error {
+	e := make(chan error)
+	go block(nil, e)
+	return <-e
+}
+
+func (r *abandonedCartRepository) TxMarkCartAbandoned(tx *sqlx.Tx, id uuid.UUID, before time.Time, at time.Time) (bool, error) {
+	for i, c := range r.carts {
+		if c.ID != id || c.AbandonedAt.Valid {
+			continue
+		}
+		// The cart was changed after it was resolved, so it is no longer inactive.
+		if r.changed[id] {
+			r.carts[i].UpdatedAt = null.TimeFrom(at)
+			return false, nil
+		}
+		r.carts[i].AbandonedAt = null.TimeFrom(at)
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *abandonedCartRepository) addCart(lastActiveAt time.Time, quantity int) cart.Cart {
+	c, _ := cart.Cart{}.NewCart(getRandomUUID())
+	c.CreatedAt = lastActiveAt
+	r.carts = append(r.carts, c)
+	if quantity > 0 {
+		r.items[c.ID] = []cart.CartItem{{CartID: c.ID, ProductID: getRandomUUID(), Quantity: quantity, UnitPrice: 10000, TotalPrice: float64(quantity) * 10000}}
+	}
+	return c
+}
+
+type recordingOutbox struct {
+	outbox.Store
+	messages []outbox.Message
+}
+
+func (o *recordingOutbox) TxCreate(tx *sqlx.Tx, message outbox.Message) error {
+	o.messages = append(o.messages, message)
+	return nil
+}
+
+func TestAbandonmentDetectorDetect(t *testing.T) {
+	now := time.Now()
+	repo := &abandonedCartRepository{items: make(map[uuid.UUID][]cart.CartItem), changed: make(map[uuid.UUID]bool)}
+	abandoned := repo.addCart(now.Add(-48*time.Hour), 2)
+	repo.addCart(now.Add(-time.Hour), 1)
+	repo.addCart(now.Add(-48*time.Hour), 0)
+	changed := repo.addCart(now.Add(-48*time.Hour), 1)
+	repo.changed[changed.ID] = true
+	box := &recordingOutbox{}
+
+	d := cart.NewAbandonmentDetector(repo, box, "arn:cart-abandoned", time.Minute, 24*time.Hour, 1)
+
+	marked, err := d.Detect()
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, marked)
+	assert.Len(t, box.messages, 1)
+	assert.Equal(t, "arn:cart-abandoned", box.messages[0].Topic)
+	assert.Equal(t, cart.CartAbandonedEventType, box.messages[0].EventType)
+	assert.Equal(t, cart.CartAggregateType, box.messages[0].AggregateType)
+	assert.Equal(t, abandoned.ID.String(), box.messages[0].AggregateID)
+
+	var payload cart.CartAbandonedEvent
+	assert.NoError(t, json.Unmarshal(box.messages[0].Payload, &payload))
+	assert.Equal(t, cart.CartAbandonedEventVersion, payload.Version)
+	assert.Len(t, payload.Items, 1)
+	assert.Equal(t, float64(20000), payload.TotalPrice)
+
+	t.Run("abandoned carts are reported once", func(t *testing.T) {
+		marked, err := d.Detect()
+
+		assert.NoError(t, err)
+		assert.Equal(t, 0, marked)
+		assert.Len(t, box.messages, 1)
+	})
+}
+
+func TestAbandonmentDetectorDetectStale(t *testing.T) {
+	now := time.Now()
+	repo := &abandonedCartRepository{items: make(map[uuid.UUID][]cart.CartItem), stale: true}
+	repo.addCart(now.Add(-48*time.Hour), 1)
+	repo.addCart(now.Add(-48*time.Hour), 1)
+	d := cart.NewAbandonmentDetector(repo, &recordingOutbox{}, "arn:cart-abandoned", time.Minute, 24*time.Hour, 2)
+
+	marked, err := d.Detect()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, marked)
+
+	marked, err = d.Detect()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, marked, "a batch marking nothing ends the run")
+}
+
+func TestAbandonmentDetectorStartStop(t *testing.T) {
+	repo := &abandonedCartRepository{items: make(map[uuid.UUID][]cart.CartItem)}
+
+	disabled := cart.NewAbandonmentDetector(repo, &recordingOutbox{}, "", 0, time.Hour, 0)
+	disabled.Start()
+	disabled.Stop()
+
+	d := cart.NewAbandonmentDetector(repo, &recordingOutbox{}, "", time.Millisecond, time.Hour, 0)
+	d.Start()
+	time.Sleep(5 * time.Millisecond)
+	d.Stop()
+	d.Stop()
+}
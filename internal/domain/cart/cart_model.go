@@ -5,11 +5,22 @@ import (
 	"time"
 
 	"github.com/evermos/boilerplate-go/shared"
+	"github.com/evermos/boilerplate-go/shared/failure"
 	"github.com/evermos/boilerplate-go/shared/nuuid"
 	"github.com/gofrs/uuid"
 	"github.com/guregu/null"
 )
 
+var (
+	CartAbandonedEventType = "cart.abandoned"
+	// CartAggregateType identifies Carts in the event outbox.
+	CartAggregateType = "cart"
+)
+
+// CartAbandonedEventVersion is the version of the cart.abandoned payload,
+// documented in event/schemas.
+const CartAbandonedEventVersion = 1
+
 type Cart struct {
 	ID 			uuid.UUID   `db:"id" validate:"required"`
 	UserID 		uuid.UUID 	`db:"user_id" validate:"required"`
@@ -19,6 +30,7 @@ type Cart struct {
 	UpdatedBy	nuuid.NUUID `db:"updated_by"`
 	DeletedAt	null.Time   `db:"deleted_at"`
 	DeletedBy	nuuid.NUUID `db:"deleted_by"`
+	AbandonedAt	null.Time   `db:"abandoned_at"`
 	TotalPrice	float64		`db:"-"`
 	Items		[]CartItem  `db:"-"`
 }
@@ -46,6 +58,14 @@ func (c *Cart) Recalculate() {
 }
 
 
+// LastActiveAt returns when the Cart was last changed.
+func (c *Cart) LastActiveAt() time.Time {
+	if c.UpdatedAt.Valid {
+		return c.UpdatedAt.Time
+	}
+	return c.CreatedAt
+}
+
 func (c *Cart) IsDeleted() (deleted bool) {
 	return c.DeletedAt.Valid && c.DeletedBy.Valid
 }
@@ -62,6 +82,7 @@ func (c *Cart) Validate() (err error) {
 func (c *Cart) Update(userID uuid.UUID) (err error){
 	c.UpdatedAt = null.TimeFrom(time.Now())
 	c.UpdatedBy = nuuid.From(userID)
+	c.AbandonedAt = null.Time{}
 
 	c.Recalculate()
 	err = c.Validate()
@@ -193,6 +214,25 @@ func (ci *CartItem) Update(cartItem CartItem, userID uuid.UUID) (err error) {
 	return
 }
 
+// SetQuantity sets the absolute quantity of a CartItem. The quantity must be at
+// least one and no greater than the product's stock.
+func (ci *CartItem) SetQuantity(quantity int, stock int64, userID uuid.UUID) (err error) {
+	if quantity < 1 {
+		return failure.BadRequestFromString("quantity must be at least 1")
+	}
+
+	if int64(quantity) > stock {
+		return failure.BadRequestFromString("Quantity cannot be greater than stock")
+	}
+
+	ci.Quantity = quantity
+	ci.UpdatedAt = null.TimeFrom(time.Now())
+	ci.UpdatedBy = nuuid.From(userID)
+
+	err = ci.Validate()
+	return
+}
+
 func (ci *CartItem) ToResponseFormat() CartItemResponseFormat {
 	return CartItemResponseFormat{
 		CartID: 		ci.CartID,
@@ -217,6 +257,11 @@ type CartItemRequestFormat struct {
 	Quantity		int			`json:"quantity" validate:"required"`
 }
 
+// CartItemQuantityRequestFormat represents a request to set the quantity of a cart item.
+type CartItemQuantityRequestFormat struct {
+	Quantity		int			`json:"quantity" validate:"required,min=1"`
+}
+
 
 type CartItemResponseFormat struct {
 	CartID          uuid.UUID `json:"cartID" validate:"required"`
@@ -257,3 +302,47 @@ type CartItemJoin struct {
 	DeletedBy		nuuid.NUUID `db:"deleted_by"`
 }
 
+// CartAbandonedEvent is the payload of the cart.abandoned event, published
+// once a cart with items has not been changed for a while.
+type CartAbandonedEvent struct {
+	Version      int                      `json:"version"`
+	CartID       uuid.UUID                `json:"cart_id"`
+	UserID       uuid.UUID                `json:"user_id"`
+	Items        []CartAbandonedEventItem `json:"items"`
+	TotalPrice   float64                  `json:"total_price"`
+	LastActiveAt time.Time                `json:"last_active_at"`
+	AbandonedAt  time.Time                `json:"abandoned_at"`
+}
+
+// CartAbandonedEventItem is an item of an abandoned Cart.
+type CartAbandonedEventItem struct {
+	ProductID  uuid.UUID `json:"product_id"`
+	Quantity   int       `json:"quantity"`
+	UnitPrice  float64   `json:"unit_price"`
+	TotalPrice float64   `json:"total_price"`
+}
+
+// NewCartAbandonedEvent creates the cart.abandoned payload of a Cart with its
+// items attached.
+func NewCartAbandonedEvent(c Cart, abandonedAt time.Time) CartAbandonedEvent {
+	event := CartAbandonedEvent{
+		Version:      CartAbandonedEventVersion,
+		CartID:       c.ID,
+		UserID:       c.UserID,
+		Items:        make([]CartAbandonedEventItem, 0, len(c.Items)),
+		TotalPrice:   c.TotalPrice,
+		LastActiveAt: c.LastActiveAt(),
+		AbandonedAt:  abandonedAt,
+	}
+
+	for _, item := range c.Items {
+		event.Items = append(event.Items, CartAbandonedEventItem{
+			ProductID:  item.ProductID,
+			Quantity:   item.Quantity,
+			UnitPrice:  item.UnitPrice,
+			TotalPrice: item.TotalPrice,
+		})
+	}
+
+	return event
+}
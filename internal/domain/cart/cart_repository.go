@@ -2,6 +2,7 @@ package cart
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/evermos/boilerplate-go/infras"
 	"github.com/evermos/boilerplate-go/shared/failure"
@@ -18,6 +19,10 @@ var cartQueries = struct {
 	selectCartItem string
 	insertCartItem string
 	updateCartItem string
+	selectCartItemJoinProductForUpdate string
+	selectAbandonedCarts string
+	markCartAbandoned string
+	touchCart string
 }{
 	selectCart: `SELECT * FROM atc_cart`,
 	insertCart: `INSERT INTO atc_cart (
@@ -48,7 +53,8 @@ var cartQueries = struct {
 		updated_at= :updated_at,
 		updated_by= :updated_by,
 		deleted_at= :deleted_at,
-		deleted_by= :deleted_by
+		deleted_by= :deleted_by,
+		abandoned_at= :abandoned_at
 	WHERE id = :id
 	`,
 	selectCartItem: `SELECT * FROM atc_cart_item`,
@@ -85,6 +91,44 @@ var cartQueries = struct {
 		deleted_by= :deleted_by
 	WHERE cart_id =:cart_id and product_id =:product_id
 	` ,
+	selectCartItemJoinProductForUpdate: `
+	SELECT
+		aci.cart_id,
+		aci.product_id,
+		aci.quantity,
+		ap.price AS unit_price,
+		ap.stock,
+		aci.created_at,
+		aci.created_by,
+		aci.updated_at,
+		aci.updated_by,
+		aci.deleted_at,
+		aci.deleted_by
+	FROM atc_cart_item aci
+	JOIN atc_product ap ON aci.product_id = ap.id
+	WHERE aci.cart_id = ? AND aci.product_id IN (?) AND ap.deleted_at IS NULL
+	ORDER BY aci.product_id
+	FOR UPDATE
+	`,
+	selectAbandonedCarts: `
+	SELECT ac.* FROM atc_cart ac
+	WHERE ac.abandoned_at IS NULL
+		AND ac.deleted_at IS NULL
+		AND COALESCE(ac.updated_at, ac.created_at) < ?
+		AND EXISTS (SELECT 1 FROM atc_cart_item aci WHERE aci.cart_id = ac.id)
+	ORDER BY COALESCE(ac.updated_at, ac.created_at)
+	LIMIT ?
+	`,
+	markCartAbandoned: `
+	UPDATE atc_cart
+	SET abandoned_at = ?
+	WHERE id = ? AND abandoned_at IS NULL AND COALESCE(updated_at, created_at) < ?
+	`,
+	touchCart: `
+	UPDATE atc_cart
+	SET updated_at = ?, updated_by = ?, abandoned_at = NULL
+	WHERE id = ?
+	`,
 }
 
 type CartRepository interface {
@@ -102,6 +146,12 @@ type CartRepository interface {
 	ResolveCartByID(id uuid.UUID) (cart Cart, err error)
 	ResolveCartItemJoinProduct(cartID uuid.UUID, productID uuid.UUID) (cartItem CartItemJoin, err error)
 	ResolveCartItemsJoinProduct(cartID uuid.UUID) (cartItem []CartItem, err error)
+	WithTransaction(block infras.Block) (err error)
+	TxResolveCartItemsJoinProductForUpdate(tx *sqlx.Tx, cartID uuid.UUID, productIDs []uuid.UUID) (cartItems []CartItemJoin, err error)
+	TxDeleteCartItemsByProductIDs(tx *sqlx.Tx, cartID uuid.UUID, productIDs []uuid.UUID) (err error)
+	TxTouchCart(tx *sqlx.Tx, id uuid.UUID, userID uuid.UUID, at time.Time) (err error)
+	ResolveAbandonedCarts(before time.Time, limit int) (carts []Cart, err error)
+	TxMarkCartAbandoned(tx *sqlx.Tx, id uuid.UUID, before time.Time, at time.Time) (marked bool, err error)
 }
 
 type CartRepositoryMySQL struct {
@@ -295,10 +345,13 @@ func (r *CartRepositoryMySQL) ResolveCartItemJoinProduct(cartID uuid.UUID, produ
 	return
 }
 
+// ResolveCartItemsJoinProduct resolves the CartItems of a cart with the price
+// and stock of their products. Items of deleted products are left out, as
+// they cannot be checked out.
 func (r *CartRepositoryMySQL) ResolveCartItemsJoinProduct(cartID uuid.UUID) (cartItem []CartItem, err error) {
 	err = r.DB.Read.Select(
 		&cartItem,
-		"SELECT cart_id, product_id, quantity, price as unit_price, price*quantity as total_price, stock, aci.created_at , aci.created_by, aci.updated_at, aci.updated_by , aci.deleted_at , aci.deleted_by  FROM atc_cart_item aci  JOIN atc_product ap ON aci.product_id = ap.id WHERE cart_id = ? ",
+		"SELECT cart_id, product_id, quantity, price as unit_price, price*quantity as total_price, stock, aci.created_at , aci.created_by, aci.updated_at, aci.updated_by , aci.deleted_at , aci.deleted_by  FROM atc_cart_item aci  JOIN atc_product ap ON aci.product_id = ap.id WHERE cart_id = ? AND ap.deleted_at IS NULL",
 		cartID.String())
 	if err != nil {
 		logger.ErrorWithStack(err)
@@ -317,6 +370,103 @@ func (r *CartRepositoryMySQL) ResolveCartByID(id uuid.UUID) (cart Cart, err erro
 	return
 }
 
+// WithTransaction runs block inside a single write transaction, so callers can
+// combine the Tx methods of several repositories into one unit of work.
+func (r *CartRepositoryMySQL) WithTransaction(block infras.Block) (err error) {
+	return r.DB.WithTransaction(block)
+}
+
+// TxResolveCartItemsJoinProductForUpdate resolves the selected CartItems with their
+// Product's price and stock, locking both rows until the transaction ends.
+// Rows are locked in product ID order to keep concurrent checkouts from deadlocking.
+func (r *CartRepositoryMySQL) TxResolveCartItemsJoinProductForUpdate(tx *sqlx.Tx, cartID uuid.UUID, productIDs []uuid.UUID) (cartItems []CartItemJoin, err error) {
+	if len(productIDs) == 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(productIDs))
+	for _, id := range productIDs {
+		ids = append(ids, id.String())
+	}
+
+	query, args, err := sqlx.In(cartQueries.selectCartItemJoinProductForUpdate, cartID.String(), ids)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	err = tx.Select(&cartItems, tx.Rebind(query), args...)
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+	return
+}
+
+// TxDeleteCartItemsByProductIDs deletes the selected CartItems transactionally given the *sqlx.Tx param.
+func (r *CartRepositoryMySQL) TxDeleteCartItemsByProductIDs(tx *sqlx.Tx, cartID uuid.UUID, productIDs []uuid.UUID) (err error) {
+	if len(productIDs) == 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(productIDs))
+	for _, id := range productIDs {
+		ids = append(ids, id.String())
+	}
+
+	query, args, err := sqlx.In("DELETE FROM atc_cart_item WHERE cart_id = ? AND product_id IN (?)", cartID.String(), ids)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	_, err = tx.Exec(tx.Rebind(query), args...)
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+	return
+}
+
+// TxTouchCart records that the Cart was changed at the given time by userID
+// transactionally given the *sqlx.Tx param, for changes made to its items
+// only, so that it is not reported abandoned too early.
+func (r *CartRepositoryMySQL) TxTouchCart(tx *sqlx.Tx, id uuid.UUID, userID uuid.UUID, at time.Time) (err error) {
+	_, err = tx.Exec(cartQueries.touchCart, at, userID.String(), id.String())
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+	return
+}
+
+// ResolveAbandonedCarts resolves up to limit Carts with items that have not
+// been changed since before and are not marked abandoned yet, oldest first.
+// It reads from the primary, since a lagging replica would keep handing out
+// carts that have already been marked.
+func (r *CartRepositoryMySQL) ResolveAbandonedCarts(before time.Time, limit int) (carts []Cart, err error) {
+	err = r.DB.Write.Select(&carts, cartQueries.selectAbandonedCarts, before, limit)
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+	return
+}
+
+// TxMarkCartAbandoned marks the Cart abandoned at the given time transactionally
+// given the *sqlx.Tx param. It reports false when the Cart was changed since
+// before or was already marked, for example by another instance.
+func (r *CartRepositoryMySQL) TxMarkCartAbandoned(tx *sqlx.Tx, id uuid.UUID, before time.Time, at time.Time) (marked bool, err error) {
+	result, err := tx.Exec(cartQueries.markCartAbandoned, at, id.String(), before)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+	return rows == 1, nil
+}
+
 func (r *CartRepositoryMySQL) txCreate(tx *sqlx.Tx, cart Cart) (err error) {
 	stmt, err := tx.PrepareNamed(cartQueries.insertCart)
 	if err != nil {
@@ -3,20 +3,26 @@ package cart
 import (
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/evermos/boilerplate-go/configs"
 	"github.com/evermos/boilerplate-go/internal/domain/order"
 	"github.com/evermos/boilerplate-go/internal/domain/product"
 	"github.com/evermos/boilerplate-go/shared/failure"
 	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/evermos/boilerplate-go/shared/policy"
 	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
 	"github.com/rs/zerolog/log"
 )
 
 type CartService interface {
 	AddToCart(requestFormat CartItemRequestFormat, userID uuid.UUID) (cart Cart, err error)
 	ResolveCartByUserID(userID uuid.UUID) (cart Cart, err error)
-	Checkout(requestFormat CheckoutRequestFormat, userID uuid.UUID, cartID uuid.UUID, role string) (order order.Order, err error)
+	Checkout(requestFormat CheckoutRequestFormat, actor policy.Actor, cartID uuid.UUID) (order order.Order, err error)
+	UpdateCartItem(productID uuid.UUID, requestFormat CartItemQuantityRequestFormat, userID uuid.UUID) (cart Cart, err error)
+	RemoveCartItem(productID uuid.UUID, userID uuid.UUID) (cart Cart, err error)
+	ClearCart(userID uuid.UUID) (cart Cart, err error)
 }
 
 type CartServiceImpl struct {
@@ -24,13 +30,15 @@ type CartServiceImpl struct {
 	Config         *configs.Config
 	ProductService product.ProductService
 	OrderService   order.OrderService
+	Authorizer     policy.Authorizer
 }
 
-func ProvideCartServiceImpl(cartRepository CartRepository, conf *configs.Config, productService product.ProductService, orderService order.OrderService) *CartServiceImpl  {
+func ProvideCartServiceImpl(cartRepository CartRepository, conf *configs.Config, productService product.ProductService, orderService order.OrderService, authorizer policy.Authorizer) *CartServiceImpl  {
 	s := new(CartServiceImpl)
 	s.CartRepository = cartRepository
 	s.ProductService = productService
 	s.OrderService = orderService
+	s.Authorizer = authorizer
 	s.Config = conf
 
 	return s
@@ -62,7 +70,7 @@ func (s *CartServiceImpl) AddToCart(req CartItemRequestFormat, userID uuid.UUID)
 	}
 
 	// Handle cart item addition or update
-	err = s.handleCartItem(cart, req , userID)
+	err = s.handleCartItem(cart, req, product.Stock, userID)
 	if err != nil {
 		return
 	}
@@ -98,7 +106,11 @@ func (s *CartServiceImpl) ResolveCartByUserID(userID uuid.UUID) (cart Cart, err
 	return
 }
 
-func (s *CartServiceImpl) Checkout(requestFormat CheckoutRequestFormat, userID uuid.UUID, cartID uuid.UUID, role string) (newOrder order.Order, err error) {
+// Checkout turns the selected cart items into an Order. Locking the items and
+// their products, decrementing stock, creating the order and removing the
+// items from the cart all happen in one transaction, so a failure at any step
+// leaves the cart, order and product tables untouched.
+func (s *CartServiceImpl) Checkout(requestFormat CheckoutRequestFormat, actor policy.Actor, cartID uuid.UUID) (newOrder order.Order, err error) {
 	// Check if cart exists
 	if exists, err := s.CartRepository.ExistsByID(cartID); err != nil {
 		return newOrder, err
@@ -109,54 +121,157 @@ func (s *CartServiceImpl) Checkout(requestFormat CheckoutRequestFormat, userID u
 	}
 
 	// Check cart owner access
-	if isHaveAccess, err := s.checkCartOwner(cartID, userID, role); err != nil {
+	if isHaveAccess, err := s.checkCartOwner(cartID, actor); err != nil {
 		return newOrder, err
 	} else if !isHaveAccess {
 		err = failure.Unauthorized("unauthorized")
 		return newOrder, err
 	}
 
-	if err != nil {
+	productIDs := uniqueProductIDs(requestFormat.ProductIDs)
+	if len(productIDs) == 0 {
+		err = failure.BadRequestFromString("no cart items selected")
 		return newOrder, err
 	}
 
-	newOrder, err = order.Order{}.NewOrder(userID, requestFormat.Address)
+	newOrder, err = order.Order{}.NewOrder(actor.UserID, requestFormat.Address)
 	if err != nil {
 		return newOrder, err
 	}
 
-	orderItems, err := s.createOrderItems(cartID, userID, newOrder.ID, requestFormat.ProductIDs)
+	err = s.CartRepository.WithTransaction(func(tx *sqlx.Tx, e chan error) {
+		orderItems, err := s.txCreateOrderItems(tx, cartID, actor.UserID, newOrder.ID, productIDs)
+		if err != nil {
+			e <- err
+			return
+		}
+
+		newOrder.AttachItems(orderItems)
+		newOrder.Recalculate()
+
+		if err := s.OrderService.TxCreateOrder(tx, newOrder); err != nil {
+			e <- err
+			return
+		}
+
+		if err := s.CartRepository.TxDeleteCartItemsByProductIDs(tx, cartID, productIDs); err != nil {
+			e <- err
+			return
+		}
+
+		// The items left in the cart were just looked at, so they are not
+		// abandoned yet.
+		e <- s.CartRepository.TxTouchCart(tx, cartID, actor.UserID, time.Now())
+	})
 	if err != nil {
-		return newOrder, err
+		return order.Order{}, err
 	}
 
-	newOrder.AttachItems(orderItems)
-	newOrder.Recalculate()
+	return newOrder, nil
+}
 
-	if err = s.createOrderAndHandleCart(cartID, newOrder, orderItems); err != nil {
-		return newOrder, err
+
+// UpdateCartItem sets the quantity of a product already in the user's cart.
+func (s *CartServiceImpl) UpdateCartItem(productID uuid.UUID, requestFormat CartItemQuantityRequestFormat, userID uuid.UUID) (cart Cart, err error) {
+	cart, item, err := s.resolveOwnCartItem(productID, userID)
+	if err != nil {
+		return
 	}
 
-	return newOrder, nil
+	product, err := s.ProductService.ResolveProductByID(productID)
+	if err != nil {
+		return
+	}
+
+	err = item.SetQuantity(requestFormat.Quantity, product.Stock, userID)
+	if err != nil {
+		return
+	}
+
+	err = s.CartRepository.UpdateCartItem(item)
+	if err != nil {
+		return
+	}
+
+	err = s.updateCart(&cart, userID)
+	return
+}
+
+// RemoveCartItem removes a product from the user's cart.
+func (s *CartServiceImpl) RemoveCartItem(productID uuid.UUID, userID uuid.UUID) (cart Cart, err error) {
+	cart, _, err = s.resolveOwnCartItem(productID, userID)
+	if err != nil {
+		return
+	}
+
+	err = s.CartRepository.DeleteCartItem(cart.ID, productID)
+	if err != nil {
+		return
+	}
+
+	err = s.updateCart(&cart, userID)
+	return
+}
+
+// ClearCart removes every item from the user's cart.
+func (s *CartServiceImpl) ClearCart(userID uuid.UUID) (cart Cart, err error) {
+	cart, err = s.resolveOwnCart(userID)
+	if err != nil {
+		return
+	}
+
+	err = s.CartRepository.DeleteCartItems(cart.ID)
+	if err != nil {
+		return
+	}
+
+	err = s.updateCart(&cart, userID)
+	return
 }
 
 
 // internal function
 
-func (s *CartServiceImpl) checkCartOwner(cartID uuid.UUID, userID uuid.UUID, role string) (isHaveAccess bool, err error) {
-	cart, err := s.CartRepository.ResolveCartByID(cartID)
-	if err != nil{
+// resolveOwnCart resolves the user's cart, reporting a missing cart as not found.
+func (s *CartServiceImpl) resolveOwnCart(userID uuid.UUID) (cart Cart, err error) {
+	cart, err = s.CartRepository.ResolveCartByUserID(userID)
+	if err == sql.ErrNoRows {
+		err = failure.NotFound("cart")
+	}
+	return
+}
+
+// resolveOwnCartItem resolves the user's cart and its item for the given product.
+func (s *CartServiceImpl) resolveOwnCartItem(productID uuid.UUID, userID uuid.UUID) (cart Cart, item CartItem, err error) {
+	cart, err = s.resolveOwnCart(userID)
+	if err != nil {
+		return
+	}
+
+	items, err := s.CartRepository.ResolveCartItemByProductID(cart.ID, productID)
+	if err != nil {
 		return
 	}
-	if userID != cart.UserID && role != "admin" {
-		isHaveAccess = false
+
+	if len(items) == 0 {
+		err = failure.NotFound("cart item")
 		return
 	}
-	return true, err
+
+	return cart, items[0], nil
+}
+
+func (s *CartServiceImpl) checkCartOwner(cartID uuid.UUID, actor policy.Actor) (isHaveAccess bool, err error) {
+	cart, err := s.CartRepository.ResolveCartByID(cartID)
+	if err != nil{
+		return
+	}
+
+	return s.Authorizer.CanAccess(actor, cart.UserID, policy.CartCheckoutAny), nil
 }
 
 
-func (s *CartServiceImpl) handleCartItem(cart Cart, req CartItemRequestFormat, userID uuid.UUID) (err error) {
+func (s *CartServiceImpl) handleCartItem(cart Cart, req CartItemRequestFormat, stock int64, userID uuid.UUID) (err error) {
 	existingItem, err := s.CartRepository.ResolveCartItemByProductID(cart.ID, req.ProductID)
 	if err != nil {
 		logger.ErrorWithStack(err)
@@ -179,9 +294,16 @@ func (s *CartServiceImpl) handleCartItem(cart Cart, req CartItemRequestFormat, u
 	} else {
 		updateCartItem := CartItem{}.newCartItem(cart.ID, req, userID)
 		err = existingItem[0].Update(updateCartItem, cart.UserID)
-		if err == nil {
-			err = s.CartRepository.UpdateCartItem(existingItem[0])
+		if err != nil {
+			return
+		}
+
+		if int64(existingItem[0].Quantity) > stock {
+			err = failure.BadRequestFromString("Quantity cannot be greater than stock")
+			return
 		}
+
+		err = s.CartRepository.UpdateCartItem(existingItem[0])
 	}
 	return
 }
@@ -204,20 +326,28 @@ func (s *CartServiceImpl) updateCart(cart *Cart, userID uuid.UUID) (err error) {
 	return
 }
 
-func (s *CartServiceImpl) createOrderItems(cartID uuid.UUID, userID uuid.UUID, orderID uuid.UUID, productIDs []uuid.UUID) ([]order.OrderItem, error) {
-	var orderItems []order.OrderItem
+// txCreateOrderItems locks the selected cart items, reserves their stock and
+// builds the matching OrderItems within the given transaction.
+func (s *CartServiceImpl) txCreateOrderItems(tx *sqlx.Tx, cartID uuid.UUID, userID uuid.UUID, orderID uuid.UUID, productIDs []uuid.UUID) ([]order.OrderItem, error) {
+	cartItems, err := s.CartRepository.TxResolveCartItemsJoinProductForUpdate(tx, cartID, productIDs)
+	if err != nil {
+		return nil, err
+	}
 
+	if len(cartItems) != len(productIDs) {
+		err = failure.BadRequestFromString("selected product is not in cart or no longer available")
+		return nil, err
+	}
 
-	for _, item := range productIDs {
-		cartItem, err := s.CartRepository.ResolveCartItemJoinProduct(cartID, item)
-		if err != nil {
-			logger.ErrorWithStack(err)
+	orderItems := make([]order.OrderItem, 0, len(cartItems))
+	for _, cartItem := range cartItems {
+		if cartItem.Quantity > cartItem.Stock {
+			err = failure.Conflict("checkout", "product", "out of stock")
+			log.Error().Str("productID", cartItem.ProductID.String()).Msg("out of stock")
 			return nil, err
 		}
 
-		if cartItem.Quantity > cartItem.Stock {
-			err = errors.New("out of stock")
-			log.Error().Msg("out of stock")
+		if err := s.ProductService.TxDecrementStock(tx, cartItem.ProductID, cartItem.Quantity); err != nil {
 			return nil, err
 		}
 
@@ -228,23 +358,15 @@ func (s *CartServiceImpl) createOrderItems(cartID uuid.UUID, userID uuid.UUID, o
 	return orderItems, nil
 }
 
-
-func (s *CartServiceImpl) createOrderAndHandleCart(cartID uuid.UUID, newOrder order.Order, orderItems []order.OrderItem) (err error) {
-	// Create order
-	if err := s.OrderService.CreateOrder(newOrder); err != nil {
-		return err
-	}
-	// Create order items and update stock
-	for _, orderItem := range orderItems {
-		if err := s.OrderService.CreateOrderItem(orderItem); err != nil {
-			return err
-		}
-
-		if err := s.CartRepository.DeleteCartItem(cartID, orderItem.ProductID); err != nil {
-			return err
+func uniqueProductIDs(productIDs []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool)
+	unique := make([]uuid.UUID, 0, len(productIDs))
+	for _, id := range productIDs {
+		if seen[id] {
+			continue
 		}
+		seen[id] = true
+		unique = append(unique, id)
 	}
-
-	return
+	return unique
 }
-
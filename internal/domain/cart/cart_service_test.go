@@ -0,0 +1,465 @@
+package cart_test
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evermos/boilerplate-go/infras"
+	"github.com/evermos/boilerplate-go/internal/domain/cart"
+	"github.com/evermos/boilerplate-go/internal/domain/order"
+	"github.com/evermos/boilerplate-go/internal/domain/product"
+	"github.com/evermos/boilerplate-go/shared/failure"
+	"github.com/evermos/boilerplate-go/shared/policy"
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func getRandomUUID() uuid.UUID {
+	id, _ := uuid.NewV4()
+	return id
+}
+
+// store is an in-memory stand-in for the cart, order and product tables.
+// Transactions run concurrently and take no locks, so only the conditions
+// the writes check themselves keep them apart. Each transaction records how
+// to undo its writes, which are undone when the block reports an error.
+type store struct {
+	mu              sync.Mutex
+	stock           map[uuid.UUID]int
+	price           map[uuid.UUID]float64
+	carts           map[uuid.UUID]uuid.UUID
+	cartItems       map[uuid.UUID]map[uuid.UUID]int
+	orders          []order.Order
+	touched         map[uuid.UUID]uuid.UUID
+	undo            map[*sqlx.Tx][]func()
+	failCreateOrder bool
+	// reads, when set, holds every checkout after reading its cart items
+	// until all of them have, so they all see the same stock.
+	reads *sync.WaitGroup
+}
+
+func newStore() *store {
+	return &store{
+		stock:     make(map[uuid.UUID]int),
+		price:     make(map[uuid.UUID]float64),
+		carts:     make(map[uuid.UUID]uuid.UUID),
+		cartItems: make(map[uuid.UUID]map[uuid.UUID]int),
+		touched:   make(map[uuid.UUID]uuid.UUID),
+		undo:      make(map[*sqlx.Tx][]func()),
+	}
+}
+
+func (st *store) addProduct(stock int, price float64) uuid.UUID {
+	id := getRandomUUID()
+	st.stock[id] = stock
+	st.price[id] = price
+	return id
+}
+
+func (st *store) addCart(userID uuid.UUID, items map[uuid.UUID]int) uuid.UUID {
+	id := getRandomUUID()
+	st.carts[id] = userID
+	st.cartItems[id] = items
+	return id
+}
+
+// write applies a write of tx, recording how to undo it, under the store
+// lock.
+func (st *store) write(tx *sqlx.Tx, apply func() (undo func(), err error)) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	undo, err := apply()
+	if err == nil {
+		st.undo[tx] = append(st.undo[tx], undo)
+	}
+	return err
+}
+
+// end ends tx, undoing its writes in reverse order when it is rolled back.
+func (st *store) end(tx *sqlx.Tx, rollback bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	undo := st.undo[tx]
+	delete(st.undo, tx)
+	if !rollback {
+		return
+	}
+	for i := len(undo) - 1; i >= 0; i-- {
+		undo[i]()
+	}
+}
+
+type fakeCartRepository struct {
+	cart.CartRepository
+	st *store
+}
+
+func (r *fakeCartRepository) ExistsByID(id uuid.UUID) (bool, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	_, ok := r.st.carts[id]
+	return ok, nil
+}
+
+func (r *fakeCartRepository) ResolveCartByID(id uuid.UUID) (cart.Cart, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	return cart.Cart{ID: id, UserID: r.st.carts[id]}, nil
+}
+
+func (r *fakeCartRepository) WithTransaction(block infras.Block) error {
+	// The fakes never use the transaction, only tell them apart by it.
+	tx := new(sqlx.Tx)
+	e := make(chan error)
+	go block(tx, e)
+	err := <-e
+	r.st.end(tx, err != nil)
+	return err
+}
+
+func (r *fakeCartRepository) TxResolveCartItemsJoinProductForUpdate(tx *sqlx.Tx, cartID uuid.UUID, productIDs []uuid.UUID) (items []cart.CartItemJoin, err error) {
+	if r.st.reads != nil {
+		defer r.st.reads.Wait()
+		defer r.st.reads.Done()
+	}
+
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	for _, productID := range productIDs {
+		quantity, ok := r.st.cartItems[cartID][productID]
+		if !ok {
+			continue
+		}
+		items = append(items, cart.CartItemJoin{
+			CartID:    cartID,
+			ProductID: productID,
+			Quantity:  quantity,
+			UnitPrice: r.st.price[productID],
+			Stock:     r.st.stock[productID],
+		})
+	}
+	return
+}
+
+func (r *fakeCartRepository) TxDeleteCartItemsByProductIDs(tx *sqlx.Tx, cartID uuid.UUID, productIDs []uuid.UUID) error {
+	return r.st.write(tx, func() (func(), error) {
+		deleted := make(map[uuid.UUID]int)
+		for _, productID := range productIDs {
+			if quantity, ok := r.st.cartItems[cartID][productID]; ok {
+				deleted[productID] = quantity
+				delete(r.st.cartItems[cartID], productID)
+			}
+		}
+		return func() {
+			for productID, quantity := range deleted {
+				r.st.cartItems[cartID][productID] = quantity
+			}
+		}, nil
+	})
+}
+
+func (r *fakeCartRepository) TxTouchCart(tx *sqlx.Tx, id uuid.UUID, userID uuid.UUID, at time.Time) error {
+	return r.st.write(tx, func() (func(), error) {
+		previous, ok := r.st.touched[id]
+		r.st.touched[id] = userID
+		return func() {
+			if ok {
+				r.st.touched[id] = previous
+			} else {
+				delete(r.st.touched, id)
+			}
+		}, nil
+	})
+}
+
+func (r *fakeCartRepository) ResolveCartByUserID(userID uuid.UUID) (cart.Cart, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	for id, owner := range r.st.carts {
+		if owner == userID {
+			return cart.Cart{ID: id, UserID: owner}, nil
+		}
+	}
+	return cart.Cart{}, sql.ErrNoRows
+}
+
+func (r *fakeCartRepository) ResolveCartItemByProductID(cartID uuid.UUID, productID uuid.UUID) ([]cart.CartItem, error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	quantity, ok := r.st.cartItems[cartID][productID]
+	if !ok {
+		return nil, nil
+	}
+	return []cart.CartItem{{
+		CartID:    cartID,
+		ProductID: productID,
+		Quantity:  quantity,
+		CreatedAt: time.Now(),
+		CreatedBy: r.st.carts[cartID],
+	}}, nil
+}
+
+func (r *fakeCartRepository) ResolveCartItemsJoinProduct(cartID uuid.UUID) (items []cart.CartItem, err error) {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	for productID, quantity := range r.st.cartItems[cartID] {
+		items = append(items, cart.CartItem{CartID: cartID, ProductID: productID, Quantity: quantity, UnitPrice: r.st.price[productID]})
+	}
+	return
+}
+
+func (r *fakeCartRepository) UpdateCartItem(item cart.CartItem) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	r.st.cartItems[item.CartID][item.ProductID] = item.Quantity
+	return nil
+}
+
+func (r *fakeCartRepository) DeleteCartItem(cartID uuid.UUID, productID uuid.UUID) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	delete(r.st.cartItems[cartID], productID)
+	return nil
+}
+
+func (r *fakeCartRepository) DeleteCartItems(cartID uuid.UUID) error {
+	r.st.mu.Lock()
+	defer r.st.mu.Unlock()
+	r.st.cartItems[cartID] = make(map[uuid.UUID]int)
+	return nil
+}
+
+func (r *fakeCartRepository) UpdateCart(c cart.Cart) error {
+	return nil
+}
+
+type fakeProductService struct {
+	product.ProductService
+	st *store
+}
+
+func (s *fakeProductService) ResolveProductByID(id uuid.UUID) (product.Product, error) {
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+	stock, ok := s.st.stock[id]
+	if !ok {
+		return product.Product{}, failure.NotFound("product")
+	}
+	return product.Product{ID: id, Stock: int64(stock), Price: s.st.price[id]}, nil
+}
+
+// TxDecrementStock decrements the stock only while enough is left, like the
+// conditional update of the MySQL repository.
+func (s *fakeProductService) TxDecrementStock(tx *sqlx.Tx, id uuid.UUID, quantity int) error {
+	return s.st.write(tx, func() (func(), error) {
+		if s.st.stock[id] < quantity {
+			return nil, failure.Conflict("decrementStock", "product", "insufficient stock")
+		}
+		s.st.stock[id] -= quantity
+		return func() { s.st.stock[id] += quantity }, nil
+	})
+}
+
+type fakeOrderService struct {
+	order.OrderService
+	st *store
+}
+
+func (s *fakeOrderService) TxCreateOrder(tx *sqlx.Tx, o order.Order) error {
+	return s.st.write(tx, func() (func(), error) {
+		if s.st.failCreateOrder {
+			return nil, errors.New("insert failed")
+		}
+		s.st.orders = append(s.st.orders, o)
+		return func() {
+			for i := range s.st.orders {
+				if s.st.orders[i].ID == o.ID {
+					s.st.orders = append(s.st.orders[:i], s.st.orders[i+1:]...)
+					return
+				}
+			}
+		}, nil
+	})
+}
+
+func newCartService(st *store) *cart.CartServiceImpl {
+	return &cart.CartServiceImpl{
+		CartRepository: &fakeCartRepository{st: st},
+		ProductService: &fakeProductService{st: st},
+		OrderService:   &fakeOrderService{st: st},
+		Authorizer:     policy.New(policy.DefaultRoles()),
+	}
+}
+
+func TestCartServiceCheckout(t *testing.T) {
+	t.Run("concurrent checkouts never oversell", func(t *testing.T) {
+		const buyers = 10
+		const stock = 3
+
+		st := newStore()
+		productID := st.addProduct(stock, 15000)
+		userIDs := make([]uuid.UUID, buyers)
+		cartIDs := make([]uuid.UUID, buyers)
+		for i := range cartIDs {
+			userIDs[i] = getRandomUUID()
+			cartIDs[i] = st.addCart(userIDs[i], map[uuid.UUID]int{productID: 1})
+		}
+		// Every checkout sees enough stock, so only the conditional
+		// decrement keeps them from overselling.
+		st.reads = new(sync.WaitGroup)
+		st.reads.Add(buyers)
+		s := newCartService(st)
+
+		var wg sync.WaitGroup
+		errs := make([]error, buyers)
+		for i := 0; i < buyers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, errs[i] = s.Checkout(cart.CheckoutRequestFormat{
+					Address:    "Bandung",
+					ProductIDs: []uuid.UUID{productID},
+				}, policy.Actor{UserID: userIDs[i], Role: "user"}, cartIDs[i])
+			}(i)
+		}
+		wg.Wait()
+
+		succeeded := 0
+		for i, err := range errs {
+			if err == nil {
+				succeeded++
+				assert.Empty(t, st.cartItems[cartIDs[i]])
+				continue
+			}
+			assert.Equal(t, http.StatusConflict, failure.GetCode(err))
+			assert.Equal(t, 1, st.cartItems[cartIDs[i]][productID])
+		}
+
+		assert.Equal(t, stock, succeeded)
+		assert.Equal(t, 0, st.stock[productID])
+		assert.Len(t, st.orders, stock)
+	})
+
+	t.Run("failure rolls back stock and cart", func(t *testing.T) {
+		st := newStore()
+		productA := st.addProduct(5, 10000)
+		productB := st.addProduct(5, 20000)
+		userID := getRandomUUID()
+		cartID := st.addCart(userID, map[uuid.UUID]int{productA: 2, productB: 1})
+		st.failCreateOrder = true
+		s := newCartService(st)
+
+		_, err := s.Checkout(cart.CheckoutRequestFormat{
+			Address:    "Bandung",
+			ProductIDs: []uuid.UUID{productA, productB},
+		}, policy.Actor{UserID: userID, Role: "user"}, cartID)
+
+		assert.Error(t, err)
+		assert.Equal(t, 5, st.stock[productA])
+		assert.Equal(t, 5, st.stock[productB])
+		assert.Len(t, st.cartItems[cartID], 2)
+		assert.Empty(t, st.orders)
+		assert.Empty(t, st.touched)
+	})
+
+	t.Run("creates order with totals", func(t *testing.T) {
+		st := newStore()
+		productA := st.addProduct(5, 10000)
+		productB := st.addProduct(5, 20000)
+		userID := getRandomUUID()
+		cartID := st.addCart(userID, map[uuid.UUID]int{productA: 2, productB: 1})
+		s := newCartService(st)
+
+		got, err := s.Checkout(cart.CheckoutRequestFormat{
+			Address:    "Bandung",
+			ProductIDs: []uuid.UUID{productA, productA},
+		}, policy.Actor{UserID: userID, Role: "user"}, cartID)
+
+		assert.NoError(t, err)
+		assert.Equal(t, float64(20000), got.TotalPrice)
+		assert.Len(t, got.Items, 1)
+		assert.Equal(t, 3, st.stock[productA])
+		assert.Equal(t, 5, st.stock[productB])
+		assert.Equal(t, map[uuid.UUID]int{productB: 1}, st.cartItems[cartID])
+		assert.Equal(t, userID, st.touched[cartID], "the items left are not abandoned yet")
+	})
+}
+
+func TestCartServiceCartItems(t *testing.T) {
+	setup := func() (*store, *cart.CartServiceImpl, uuid.UUID, uuid.UUID, uuid.UUID, uuid.UUID) {
+		st := newStore()
+		productA := st.addProduct(5, 10000)
+		productB := st.addProduct(5, 20000)
+		userID := getRandomUUID()
+		cartID := st.addCart(userID, map[uuid.UUID]int{productA: 2, productB: 1})
+		return st, newCartService(st), userID, cartID, productA, productB
+	}
+
+	t.Run("sets an absolute quantity", func(t *testing.T) {
+		st, s, userID, cartID, productA, _ := setup()
+
+		got, err := s.UpdateCartItem(productA, cart.CartItemQuantityRequestFormat{Quantity: 4}, userID)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 4, st.cartItems[cartID][productA])
+		assert.Equal(t, float64(60000), got.TotalPrice)
+	})
+
+	t.Run("quantity cannot exceed stock", func(t *testing.T) {
+		st, s, userID, cartID, productA, _ := setup()
+
+		_, err := s.UpdateCartItem(productA, cart.CartItemQuantityRequestFormat{Quantity: 6}, userID)
+
+		assert.Equal(t, http.StatusBadRequest, failure.GetCode(err))
+		assert.Equal(t, 2, st.cartItems[cartID][productA])
+	})
+
+	t.Run("adding to an existing item cannot exceed stock", func(t *testing.T) {
+		st, s, userID, cartID, productA, _ := setup()
+
+		_, err := s.AddToCart(cart.CartItemRequestFormat{ProductID: productA, Quantity: 4}, userID)
+
+		assert.Equal(t, http.StatusBadRequest, failure.GetCode(err))
+		assert.Equal(t, 2, st.cartItems[cartID][productA])
+	})
+
+	t.Run("items not in the cart are not found", func(t *testing.T) {
+		_, s, userID, _, _, _ := setup()
+
+		_, err := s.UpdateCartItem(getRandomUUID(), cart.CartItemQuantityRequestFormat{Quantity: 1}, userID)
+		assert.Equal(t, http.StatusNotFound, failure.GetCode(err))
+
+		_, err = s.RemoveCartItem(getRandomUUID(), userID)
+		assert.Equal(t, http.StatusNotFound, failure.GetCode(err))
+
+		_, err = s.ClearCart(getRandomUUID())
+		assert.Equal(t, http.StatusNotFound, failure.GetCode(err))
+	})
+
+	t.Run("removes one item", func(t *testing.T) {
+		st, s, userID, cartID, productA, productB := setup()
+
+		got, err := s.RemoveCartItem(productA, userID)
+
+		assert.NoError(t, err)
+		assert.Equal(t, map[uuid.UUID]int{productB: 1}, st.cartItems[cartID])
+		assert.Equal(t, float64(20000), got.TotalPrice)
+	})
+
+	t.Run("clears the cart", func(t *testing.T) {
+		st, s, userID, cartID, _, _ := setup()
+
+		got, err := s.ClearCart(userID)
+
+		assert.NoError(t, err)
+		assert.Empty(t, st.cartItems[cartID])
+		assert.Empty(t, got.Items)
+	})
+}
@@ -35,6 +35,8 @@ const (
 
 var (
 	FooBarBazEventType = "evm.boilerplate-go.foo-bar-baz.fifo"
+	// FooAggregateType identifies Foos in the event outbox.
+	FooAggregateType = "foo"
 )
 
 //// Foo
@@ -136,7 +136,9 @@ type FooRepository interface {
 	ExistsByID(id uuid.UUID) (exists bool, err error)
 	ResolveByID(id uuid.UUID) (foo Foo, err error)
 	ResolveItemsByFooIDs(ids []uuid.UUID) (fooItems []FooItem, err error)
+	TxCreate(tx *sqlx.Tx, foo Foo) (err error)
 	Update(foo Foo) (err error)
+	WithTransaction(block infras.Block) (err error)
 }
 
 // FooRepositoryMySQL is the MySQL-backed implementation of FooRepository.
@@ -166,18 +168,19 @@ func (r *FooRepositoryMySQL) Create(foo Foo) (err error) {
 	}
 
 	return r.DB.WithTransaction(func(tx *sqlx.Tx, e chan error) {
-		if err := r.txCreate(tx, foo); err != nil {
-			e <- err
-			return
-		}
+		e <- r.TxCreate(tx, foo)
+	})
+}
 
-		if err := r.txCreateItems(tx, foo.Items); err != nil {
-			e <- err
-			return
-		}
+// TxCreate creates a new Foo with its items as part of a caller-owned
+// transaction.
+func (r *FooRepositoryMySQL) TxCreate(tx *sqlx.Tx, foo Foo) (err error) {
+	err = r.txCreate(tx, foo)
+	if err != nil {
+		return
+	}
 
-		e <- nil
-	})
+	return r.txCreateItems(tx, foo.Items)
 }
 
 // ExistsByID checks the existence of a Foo by its ID.
@@ -359,3 +362,9 @@ func (r *FooRepositoryMySQL) txUpdate(tx *sqlx.Tx, foo Foo) (err error) {
 
 	return
 }
+
+// WithTransaction runs block inside a single write transaction, so callers can
+// combine writes to Foos with writes to other tables.
+func (r *FooRepositoryMySQL) WithTransaction(block infras.Block) (err error) {
+	return r.DB.WithTransaction(block)
+}
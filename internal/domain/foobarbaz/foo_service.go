@@ -5,9 +5,10 @@ package foobarbaz
 import (
 	"github.com/evermos/boilerplate-go/configs"
 	"github.com/evermos/boilerplate-go/event/model"
-	"github.com/evermos/boilerplate-go/event/producer"
+	"github.com/evermos/boilerplate-go/event/outbox"
 	"github.com/evermos/boilerplate-go/shared/failure"
 	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
 )
 
 // FooService is the service interface for Foo entities.
@@ -21,21 +22,22 @@ type FooService interface {
 // FooServiceImpl is the service implementation for Foo entities.
 type FooServiceImpl struct {
 	FooRepository FooRepository
-	Producer      producer.Producer
+	Outbox        outbox.Store
 	Config        *configs.Config
 }
 
 // ProvideFooServiceImpl is the provider for this service.
-func ProvideFooServiceImpl(fooRepository FooRepository, producer producer.Producer, config *configs.Config) *FooServiceImpl {
+func ProvideFooServiceImpl(fooRepository FooRepository, outbox outbox.Store, config *configs.Config) *FooServiceImpl {
 	s := new(FooServiceImpl)
 	s.FooRepository = fooRepository
 	s.Config = config
-	s.Producer = producer
+	s.Outbox = outbox
 
 	return s
 }
 
-// Create creates a new Foo.
+// Create creates a new Foo. The FooCreated event is written to the outbox in
+// the same transaction, to be published once it has committed.
 func (s *FooServiceImpl) Create(requestFormat FooRequestFormat, userID uuid.UUID) (foo Foo, err error) {
 	foo, err = foo.NewFromRequestFormat(requestFormat, userID)
 	if err != nil {
@@ -46,19 +48,25 @@ func (s *FooServiceImpl) Create(requestFormat FooRequestFormat, userID uuid.UUID
 		return foo, failure.BadRequest(err)
 	}
 
-	err = s.FooRepository.Create(foo)
+	err = s.FooRepository.WithTransaction(func(tx *sqlx.Tx, e chan error) {
+		if err := s.FooRepository.TxCreate(tx, foo); err != nil {
+			e <- err
+			return
+		}
 
-	if err != nil {
-		return
-	}
+		if s.Config.Event.Producer.SNS.Topics.FooCreated.Enabled {
+			message := outbox.NewMessage(FooAggregateType, foo.ID.String(), model.PublishRequest{
+				Event: model.NewEvent(FooBarBazEventType, requestFormat),
+				Topic: s.Config.Event.Producer.SNS.Topics.FooCreated.ARN,
+			})
+			if err := s.Outbox.TxCreate(tx, message); err != nil {
+				e <- err
+				return
+			}
+		}
 
-	if s.Config.Event.Producer.SNS.Topics.FooCreated.Enabled {
-		e := model.NewEvent(FooBarBazEventType, requestFormat)
-		s.Producer.Publish(model.PublishRequest{
-			Event: e,
-			Topic: s.Config.Event.Producer.SNS.Topics.FooCreated.ARN,
-		})
-	}
+		e <- nil
+	})
 
 	return
 }
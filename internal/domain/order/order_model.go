@@ -2,19 +2,54 @@ package order
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/evermos/boilerplate-go/shared"
+	"github.com/evermos/boilerplate-go/shared/failure"
 	"github.com/evermos/boilerplate-go/shared/nuuid"
+	"github.com/evermos/boilerplate-go/shared/pagination"
 	"github.com/gofrs/uuid"
 	"github.com/guregu/null"
 )
 
+// OrderStatus indicates the status of an Order.
+type OrderStatus string
+
+const (
+	// OrderStatusPending indicates an Order that has been checked out but not shipped yet.
+	OrderStatusPending OrderStatus = "pending"
+	// OrderStatusShipping indicates an Order that has left our warehouse.
+	OrderStatusShipping OrderStatus = "shipping"
+	// OrderStatusDelivered indicates an Order that is in the customer's possession.
+	OrderStatusDelivered OrderStatus = "delivered"
+	// OrderStatusCompleted indicates an Order that the customer has accepted.
+	OrderStatusCompleted OrderStatus = "completed"
+	// OrderStatusCancelled indicates an Order that will not be fulfilled.
+	OrderStatusCancelled OrderStatus = "cancelled"
+)
+
+var (
+	OrderCreatedEventType       = "order.created"
+	OrderStatusChangedEventType = "order.status_changed"
+	OrderCancelledEventType     = "order.cancelled"
+	// OrderAggregateType identifies Orders in the event outbox.
+	OrderAggregateType = "order"
+)
+
+// Versions of the event payloads, documented in event/schemas. A version is
+// only bumped for changes that break consumers.
+const (
+	OrderCreatedEventVersion       = 1
+	OrderStatusChangedEventVersion = 1
+	OrderCancelledEventVersion     = 1
+)
+
 type Order struct {
 	ID     		uuid.UUID 	`db:"id" validate:"required"`
 	UserID 		uuid.UUID  	`db:"user_id" validate:"required"`
 	Address 	string		`db:"address" validate:"required"`
-	Status 		string		`db:"status" validate:"required"`
+	Status 		OrderStatus	`db:"status" validate:"required,oneof=pending shipping delivered completed cancelled"`
 	CreatedAt	time.Time   `db:"created_at" validate:"required"`
 	CreatedBy	uuid.UUID   `db:"created_by" validate:"required"`
 	UpdatedAt	null.Time   `db:"updated_at"`
@@ -51,6 +86,35 @@ func (o *Order) IsDeleted() (deleted bool) {
 	return o.DeletedAt.Valid && o.DeletedBy.Valid
 }
 
+// orderCursorSort names the only ordering of Order listings, newest first.
+const orderCursorSort = "newest"
+
+// orderKeyset orders Order listings from the most recently created.
+var orderKeyset = pagination.Keyset{KeyColumn: "created_at", KeyDesc: true, IDColumn: "id", IDDesc: true}
+
+// cursor returns a cursor pointing at the Order in a listing.
+func (o Order) cursor() pagination.Cursor {
+	return pagination.Cursor{
+		Sort: orderCursorSort,
+		Key:  o.CreatedAt.Format(time.RFC3339Nano),
+		ID:   o.ID.String(),
+	}
+}
+
+// orderCursorKey converts an Order listing cursor's sort key back to a time.
+func orderCursorKey(c pagination.Cursor) (key time.Time, err error) {
+	if c.Sort != orderCursorSort {
+		return key, pagination.ErrInvalidCursor
+	}
+
+	key, err = time.Parse(time.RFC3339Nano, c.Key)
+	if err != nil {
+		return key, pagination.ErrInvalidCursor
+	}
+
+	return
+}
+
 func (o Order) MarshalJSON() ([]byte, error) {
 	return json.Marshal(o.ToResponseFormat())
 }
@@ -70,7 +134,7 @@ func (o Order) NewOrder(userID uuid.UUID, address string) (newOrder Order, err e
 		ID: orderID,
 		UserID: userID,
 		Address:   address,
-		Status:    "pending",
+		Status:    OrderStatusPending,
 		CreatedAt: time.Now(),
 		CreatedBy: userID,
 	}
@@ -100,15 +164,177 @@ func (o Order) ToResponseFormat() OrderResponseFormat {
 	return resp
 }
 
+// UpdateStatus validates an Order's status change and records who made it.
+// Allowed state changes are:
+// 1. Pending --> Shipping, Cancelled
+// 2. Shipping --> Delivered, Cancelled
+// 3. Delivered --> Completed
+// 4. Completed --> this is a final state, no change allowed
+// 5. Cancelled --> this is a final state, no change allowed
+func (o *Order) UpdateStatus(newStatus OrderStatus, userID uuid.UUID) (history OrderStatusHistory, err error) {
+	stateChangeNotAllowedError := failure.Conflict(
+		"stateChange",
+		"order",
+		fmt.Sprintf("cannot change from %s to %s", o.Status, newStatus))
+
+	switch o.Status {
+	case OrderStatusPending:
+		if newStatus != OrderStatusShipping && newStatus != OrderStatusCancelled {
+			return history, stateChangeNotAllowedError
+		}
+	case OrderStatusShipping:
+		if newStatus != OrderStatusDelivered && newStatus != OrderStatusCancelled {
+			return history, stateChangeNotAllowedError
+		}
+	case OrderStatusDelivered:
+		if newStatus != OrderStatusCompleted {
+			return history, stateChangeNotAllowedError
+		}
+	case OrderStatusCompleted, OrderStatusCancelled:
+		return history, stateChangeNotAllowedError
+	default:
+		return history, stateChangeNotAllowedError
+	}
+
+	history, err = OrderStatusHistory{}.NewOrderStatusHistory(o.ID, o.Status, newStatus, userID)
+	if err != nil {
+		return
+	}
+
+	// passed all state change validations, actually update the status
+	o.Status = newStatus
+	o.UpdatedAt = null.TimeFrom(history.CreatedAt)
+	o.UpdatedBy = nuuid.From(userID)
+
+	err = o.Validate()
+	return
+}
+
 type OrderRequestFormat struct {
 
 }
 
+// OrderStatusRequestFormat represents an Order status change request.
+type OrderStatusRequestFormat struct {
+	Status OrderStatus `json:"status" validate:"required,oneof=pending shipping delivered completed cancelled"`
+}
+
+// OrderCancelledEvent is the payload of the order.cancelled event.
+type OrderCancelledEvent struct {
+	Version     int                 `json:"version"`
+	Order       OrderResponseFormat `json:"order"`
+	CancelledAt time.Time           `json:"cancelled_at"`
+	CancelledBy uuid.UUID           `json:"cancelled_by"`
+}
+
+// OrderCreatedEvent is the payload of the order.created event, published when
+// a cart is checked out.
+type OrderCreatedEvent struct {
+	Version       int              `json:"version"`
+	OrderID       uuid.UUID        `json:"order_id"`
+	UserID        uuid.UUID        `json:"user_id"`
+	Address       string           `json:"address"`
+	Status        OrderStatus      `json:"status"`
+	Items         []OrderEventItem `json:"items"`
+	TotalQuantity int              `json:"total_quantity"`
+	TotalPrice    float64          `json:"total_price"`
+	CreatedAt     time.Time        `json:"created_at"`
+}
+
+// OrderEventItem is an item of an Order in event payloads.
+type OrderEventItem struct {
+	ProductID  uuid.UUID `json:"product_id"`
+	Quantity   int       `json:"quantity"`
+	UnitPrice  float64   `json:"unit_price"`
+	TotalPrice float64   `json:"total_price"`
+}
+
+// NewOrderCreatedEvent creates the order.created payload of an Order with its
+// items attached.
+func NewOrderCreatedEvent(o Order) OrderCreatedEvent {
+	event := OrderCreatedEvent{
+		Version:   OrderCreatedEventVersion,
+		OrderID:   o.ID,
+		UserID:    o.UserID,
+		Address:   o.Address,
+		Status:    o.Status,
+		Items:     make([]OrderEventItem, 0, len(o.Items)),
+		CreatedAt: o.CreatedAt,
+	}
+
+	for _, item := range o.Items {
+		event.Items = append(event.Items, OrderEventItem{
+			ProductID:  item.ProductID,
+			Quantity:   item.Quantity,
+			UnitPrice:  item.UnitPrice,
+			TotalPrice: item.TotalPrice,
+		})
+		event.TotalQuantity += item.Quantity
+		event.TotalPrice += item.TotalPrice
+	}
+
+	return event
+}
+
+// OrderStatusChangedEvent is the payload of the order.status_changed event,
+// published for every status transition including cancellations.
+type OrderStatusChangedEvent struct {
+	Version    int         `json:"version"`
+	OrderID    uuid.UUID   `json:"order_id"`
+	UserID     uuid.UUID   `json:"user_id"`
+	FromStatus OrderStatus `json:"from_status"`
+	ToStatus   OrderStatus `json:"to_status"`
+	ChangedAt  time.Time   `json:"changed_at"`
+	ChangedBy  uuid.UUID   `json:"changed_by"`
+}
+
+// NewOrderStatusChangedEvent creates the order.status_changed payload of a
+// transition of o.
+func NewOrderStatusChangedEvent(o Order, history OrderStatusHistory) OrderStatusChangedEvent {
+	return OrderStatusChangedEvent{
+		Version:    OrderStatusChangedEventVersion,
+		OrderID:    o.ID,
+		UserID:     o.UserID,
+		FromStatus: history.FromStatus,
+		ToStatus:   history.ToStatus,
+		ChangedAt:  history.CreatedAt,
+		ChangedBy:  history.CreatedBy,
+	}
+}
+
+// OrderStatusHistory records a single status transition of an Order.
+type OrderStatusHistory struct {
+	ID         uuid.UUID   `db:"id" validate:"required"`
+	OrderID    uuid.UUID   `db:"order_id" validate:"required"`
+	FromStatus OrderStatus `db:"from_status" validate:"required"`
+	ToStatus   OrderStatus `db:"to_status" validate:"required"`
+	CreatedAt  time.Time   `db:"created_at" validate:"required"`
+	CreatedBy  uuid.UUID   `db:"created_by" validate:"required"`
+}
+
+// NewOrderStatusHistory creates a new OrderStatusHistory for a transition made by userID.
+func (h OrderStatusHistory) NewOrderStatusHistory(orderID uuid.UUID, from OrderStatus, to OrderStatus, userID uuid.UUID) (history OrderStatusHistory, err error) {
+	historyID, err := uuid.NewV4()
+	if err != nil {
+		return
+	}
+
+	history = OrderStatusHistory{
+		ID:         historyID,
+		OrderID:    orderID,
+		FromStatus: from,
+		ToStatus:   to,
+		CreatedAt:  time.Now(),
+		CreatedBy:  userID,
+	}
+	return
+}
+
 type OrderResponseFormat struct {
 	ID  			uuid.UUID		`json:"id" validate:"required"`
 	UserID  		uuid.UUID		`json:"user_id" validate:"required"`
 	Address			string			`json:"address"`
-	Status 			string 			`json:"status"`
+	Status 			OrderStatus		`json:"status"`
 	CreatedAt     	time.Time   	`json:"created_at" validate:"required"`
 	CreatedBy     	uuid.UUID   	`json:"created_by" validate:"required"`
 	UpdatedAt     	null.Time   	`json:"updated_at"`
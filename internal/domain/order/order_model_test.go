@@ -0,0 +1,60 @@
+package order_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/evermos/boilerplate-go/internal/domain/order"
+	"github.com/evermos/boilerplate-go/shared/failure"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func getRandomUUID() uuid.UUID {
+	id, _ := uuid.NewV4()
+	return id
+}
+
+func TestOrderUpdateStatus(t *testing.T) {
+	tests := []struct {
+		from    order.OrderStatus
+		to      order.OrderStatus
+		allowed bool
+	}{
+		{order.OrderStatusPending, order.OrderStatusShipping, true},
+		{order.OrderStatusPending, order.OrderStatusCancelled, true},
+		{order.OrderStatusPending, order.OrderStatusDelivered, false},
+		{order.OrderStatusShipping, order.OrderStatusDelivered, true},
+		{order.OrderStatusShipping, order.OrderStatusCancelled, true},
+		{order.OrderStatusShipping, order.OrderStatusPending, false},
+		{order.OrderStatusDelivered, order.OrderStatusCompleted, true},
+		{order.OrderStatusDelivered, order.OrderStatusCancelled, false},
+		{order.OrderStatusCompleted, order.OrderStatusCancelled, false},
+		{order.OrderStatusCancelled, order.OrderStatusPending, false},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.from)+"->"+string(test.to), func(t *testing.T) {
+			userID := getRandomUUID()
+			o, _ := order.Order{}.NewOrder(getRandomUUID(), "Bandung")
+			o.Status = test.from
+
+			history, err := o.UpdateStatus(test.to, userID)
+
+			if !test.allowed {
+				assert.Equal(t, http.StatusConflict, failure.GetCode(err))
+				assert.Equal(t, test.from, o.Status)
+				assert.False(t, o.UpdatedAt.Valid)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.to, o.Status)
+			assert.Equal(t, userID, o.UpdatedBy.UUID)
+			assert.Equal(t, o.ID, history.OrderID)
+			assert.Equal(t, test.from, history.FromStatus)
+			assert.Equal(t, test.to, history.ToStatus)
+			assert.Equal(t, userID, history.CreatedBy)
+		})
+	}
+}
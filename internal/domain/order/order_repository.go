@@ -1,17 +1,30 @@
 package order
 
 import (
+	"database/sql"
+	"fmt"
+	"strings"
+
 	"github.com/evermos/boilerplate-go/infras"
 	"github.com/evermos/boilerplate-go/shared/failure"
 	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/evermos/boilerplate-go/shared/pagination"
 	"github.com/gofrs/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
 var orderQueries = struct {
+	selectOrder 	string
+	countOrder		string
+	selectOrderItem string
 	insertOrder 	string
 	insertOrderItem string
+	updateOrderStatus string
+	insertOrderStatusHistory string
 } {
+	selectOrder: `SELECT * FROM atc_order`,
+	countOrder: `SELECT COUNT(id) FROM atc_order`,
+	selectOrderItem: `SELECT * FROM atc_order_item`,
 	insertOrder: `INSERT INTO atc_order (
 		id,
 		user_id,
@@ -60,14 +73,43 @@ var orderQueries = struct {
 		:deleted_by
 	)
 	`,
+	updateOrderStatus: `
+	UPDATE atc_order
+	SET
+		status = ?,
+		updated_at = ?,
+		updated_by = ?
+	WHERE id = ? AND status = ?
+	`,
+	insertOrderStatusHistory: `
+	INSERT INTO atc_order_status_history (
+		id,
+		order_id,
+		from_status,
+		to_status,
+		created_at,
+		created_by
+	) VALUES (
+		:id,
+		:order_id,
+		:from_status,
+		:to_status,
+		:created_at,
+		:created_by
+	)
+	`,
 }
 
 type OrderRepository interface {
 	CreateOrder(order Order) (err error)
 	ExistsByID(id uuid.UUID) (exists bool, err error)
 	CreateOrderItem(oi OrderItem) (err error)
-	ResolveAllOrder(userID uuid.UUID, role string, page int,limit int) (orders []Order, err error)
-
+	ResolveAllOrder(userID uuid.NullUUID, req pagination.Request) (orders []Order, page pagination.Result, err error)
+	TxCreateOrder(tx *sqlx.Tx, order Order) (err error)
+	ResolveOrderByID(id uuid.UUID) (order Order, err error)
+	ResolveItemsByOrderIDs(ids []uuid.UUID) (orderItems []OrderItem, err error)
+	WithTransaction(block infras.Block) (err error)
+	TxUpdateOrderStatus(tx *sqlx.Tx, order Order, history OrderStatusHistory) (err error)
 }
 
 type OrderRepositoryMySQL struct {
@@ -126,23 +168,122 @@ func (r *OrderRepositoryMySQL) CreateOrderItem(oi OrderItem) (err error)   {
 	})
 }
 
-func (r *OrderRepositoryMySQL) ResolveAllOrder(userID uuid.UUID, role string, page int,limit int) (orders []Order, err error)  {
-	if role != "admin"{
-		err = r.DB.Read.Select(
-			&orders,
-			"SELECT * FROM atc_order WHERE user_id = ? ORDER BY created_at DESC LIMIT ? OFFSET ?", userID, limit, (page)*limit)
+// ResolveAllOrder resolves a page of Orders, newest first, along with the
+// metadata of the page. A valid userID restricts the page to that user's
+// orders.
+func (r *OrderRepositoryMySQL) ResolveAllOrder(userID uuid.NullUUID, req pagination.Request) (orders []Order, page pagination.Result, err error) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if userID.Valid {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, userID.UUID)
+	}
+
+	var total int
+	err = r.DB.Read.Get(&total, orderQueries.countOrder+" "+composeWhere(conditions), args...)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	if req.Cursor != nil {
+		key, err := orderCursorKey(*req.Cursor)
 		if err != nil {
-			logger.ErrorWithStack(err)
+			return orders, page, failure.BadRequest(err)
 		}
 
-		return 
+		condition, keysetArgs := orderKeyset.Condition(*req.Cursor, key)
+		conditions = append(conditions, condition)
+		args = append(args, keysetArgs...)
+	}
+
+	query := fmt.Sprintf("%s %s %s LIMIT ? OFFSET ?", orderQueries.selectOrder, composeWhere(conditions), orderKeyset.OrderBy(req.Backward()))
+	args = append(args, req.FetchLimit(), req.Offset())
+
+	err = r.DB.Read.Select(&orders, query, args...)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	fetched := len(orders)
+	orders = orders[:pagination.Trim(req, fetched)]
+	if req.Backward() {
+		for i, j := 0, len(orders)-1; i < j; i, j = i+1, j-1 {
+			orders[i], orders[j] = orders[j], orders[i]
+		}
+	}
+
+	page = pagination.NewResult(req, fetched, total)
+	if len(orders) > 0 {
+		page.SetCursors(orders[0].cursor(), orders[len(orders)-1].cursor())
+	}
+
+	return
+}
+
+// composeWhere joins conditions into a WHERE clause, or an empty clause when
+// there are none.
+func composeWhere(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conditions, " AND ")
+}
+
+// ResolveOrderByID resolves an Order by its ID.
+func (r *OrderRepositoryMySQL) ResolveOrderByID(id uuid.UUID) (order Order, err error) {
+	err = r.DB.Read.Get(
+		&order,
+		orderQueries.selectOrder+" WHERE id = ?",
+		id.String())
+	if err != nil && err == sql.ErrNoRows {
+		err = failure.NotFound("order")
+		logger.ErrorWithStack(err)
+		return
+	}
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+	return
+}
+
+// ResolveItemsByOrderIDs resolves OrderItems based on a set of OrderIDs.
+func (r *OrderRepositoryMySQL) ResolveItemsByOrderIDs(ids []uuid.UUID) (orderItems []OrderItem, err error) {
+	if len(ids) == 0 {
+		return
+	}
+
+	orderIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		orderIDs = append(orderIDs, id.String())
 	}
 
-	err = r.DB.Read.Select(
-		&orders,
-		"SELECT * FROM atc_order ORDER BY created_at DESC LIMIT ? OFFSET ?", limit, (page)*limit)
+	query, args, err := sqlx.In(orderQueries.selectOrderItem+" WHERE order_id IN (?)", orderIDs)
 	if err != nil {
 		logger.ErrorWithStack(err)
+		return
+	}
+
+	err = r.DB.Read.Select(&orderItems, query, args...)
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+
+	return
+}
+
+// TxCreateOrder creates an Order and its items transactionally given the *sqlx.Tx param.
+func (r *OrderRepositoryMySQL) TxCreateOrder(tx *sqlx.Tx, order Order) (err error) {
+	if err = r.txCreate(tx, order); err != nil {
+		return
+	}
+
+	for _, item := range order.Items {
+		if err = r.txCreateItem(tx, item); err != nil {
+			return
+		}
 	}
 
 	return
@@ -180,4 +321,50 @@ func (r *OrderRepositoryMySQL) txCreateItem(tx *sqlx.Tx, oi OrderItem) (err erro
 	return
 }
 
+// WithTransaction runs block inside a single write transaction, so callers can
+// combine the Tx methods of several repositories into one unit of work.
+func (r *OrderRepositoryMySQL) WithTransaction(block infras.Block) (err error) {
+	return r.DB.WithTransaction(block)
+}
 
+// TxUpdateOrderStatus updates an Order's status transactionally given the *sqlx.Tx param.
+// The update only applies if the Order still has the status the transition
+// started from, so two concurrent transitions cannot both win.
+func (r *OrderRepositoryMySQL) TxUpdateOrderStatus(tx *sqlx.Tx, order Order, history OrderStatusHistory) (err error) {
+	result, err := tx.Exec(
+		orderQueries.updateOrderStatus,
+		order.Status,
+		order.UpdatedAt,
+		order.UpdatedBy,
+		order.ID.String(),
+		history.FromStatus)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	if affected == 0 {
+		err = failure.Conflict("stateChange", "order", "status was changed by another request")
+		return
+	}
+
+	stmt, err := tx.PrepareNamed(orderQueries.insertOrderStatusHistory)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(history)
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+
+	return
+}
@@ -2,24 +2,40 @@ package order
 
 import (
 	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/event/model"
+	"github.com/evermos/boilerplate-go/event/outbox"
+	"github.com/evermos/boilerplate-go/internal/domain/product"
 	"github.com/evermos/boilerplate-go/shared/failure"
+	"github.com/evermos/boilerplate-go/shared/pagination"
+	"github.com/evermos/boilerplate-go/shared/policy"
 	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
 )
 
 type OrderService interface {
 	CreateOrder(order Order) (err error)
 	CreateOrderItem(order OrderItem) (err error)
-	ResolveAllOrder(userID uuid.UUID, role string, page int, limit int)(orders []Order, err error) 
+	ResolveAllOrder(actor policy.Actor, req pagination.Request) (orders []Order, page pagination.Result, err error)
+	TxCreateOrder(tx *sqlx.Tx, order Order) (err error)
+	ResolveOrderByID(id uuid.UUID, actor policy.Actor) (order Order, err error)
+	UpdateStatus(id uuid.UUID, requestFormat OrderStatusRequestFormat, userID uuid.UUID) (order Order, err error)
+	Cancel(id uuid.UUID, actor policy.Actor) (order Order, err error)
 }
 
 type OrderServiceImpl struct {
 	OrderRepository OrderRepository
+	ProductService  product.ProductService
+	Outbox          outbox.Store
+	Authorizer      policy.Authorizer
 	Config          *configs.Config
 }
 
-func ProvideOrderServiceImpl(orderRepository OrderRepository, config *configs.Config) *OrderServiceImpl {
+func ProvideOrderServiceImpl(orderRepository OrderRepository, productService product.ProductService, outbox outbox.Store, authorizer policy.Authorizer, config *configs.Config) *OrderServiceImpl {
 	s := new(OrderServiceImpl)
 	s.OrderRepository = orderRepository
+	s.ProductService = productService
+	s.Outbox = outbox
+	s.Authorizer = authorizer
 	s.Config = config
 
 	return s
@@ -43,11 +59,184 @@ func (s *OrderServiceImpl) CreateOrderItem(orderItem OrderItem) (err error)  {
 	return 
 }
 
-func (s *OrderServiceImpl) ResolveAllOrder(userID uuid.UUID, role string, page int, limit int)(orders []Order, err error) {
-	orders, err = s.OrderRepository.ResolveAllOrder(userID, role, page, limit)
+// ResolveAllOrder resolves a page of Orders. Actors without order:read:any
+// only see their own orders.
+func (s *OrderServiceImpl) ResolveAllOrder(actor policy.Actor, req pagination.Request) (orders []Order, page pagination.Result, err error) {
+	ownerID := uuid.NullUUID{UUID: actor.UserID, Valid: !s.Authorizer.Can(actor, policy.OrderReadAny)}
+	orders, page, err = s.OrderRepository.ResolveAllOrder(ownerID, req)
 	if err != nil{
 		return
 	}
 
+	err = s.attachItems(orders)
 	return 
-}
\ No newline at end of file
+}
+
+// ResolveOrderByID resolves an Order with its items. Actors without
+// order:read:any can only resolve their own orders; anyone else's order is
+// reported as not found.
+func (s *OrderServiceImpl) ResolveOrderByID(id uuid.UUID, actor policy.Actor) (order Order, err error) {
+	order, err = s.OrderRepository.ResolveOrderByID(id)
+	if err != nil {
+		return
+	}
+
+	if !s.Authorizer.CanAccess(actor, order.UserID, policy.OrderReadAny) {
+		return Order{}, failure.NotFound("order")
+	}
+
+	items, err := s.OrderRepository.ResolveItemsByOrderIDs([]uuid.UUID{order.ID})
+	if err != nil {
+		return
+	}
+
+	order.AttachItems(items)
+	order.Recalculate()
+	return
+}
+
+// TxCreateOrder creates an Order with its items as part of a caller-owned
+// transaction, and writes its order.created event to the outbox.
+func (s *OrderServiceImpl) TxCreateOrder(tx *sqlx.Tx, order Order) (err error) {
+	err = order.Validate()
+	if err != nil {
+		return failure.BadRequest(err)
+	}
+
+	err = s.OrderRepository.TxCreateOrder(tx, order)
+	if err != nil {
+		return
+	}
+
+	topic := s.Config.Event.Producer.SNS.Topics.OrderCreated
+	return s.txPublish(tx, order, topic.Enabled, topic.ARN, model.NewEvent(OrderCreatedEventType, NewOrderCreatedEvent(order)))
+}
+
+// UpdateStatus moves an Order to a new status on behalf of an admin.
+func (s *OrderServiceImpl) UpdateStatus(id uuid.UUID, requestFormat OrderStatusRequestFormat, userID uuid.UUID) (order Order, err error) {
+	order, err = s.OrderRepository.ResolveOrderByID(id)
+	if err != nil {
+		return
+	}
+
+	err = s.transition(&order, requestFormat.Status, userID)
+	return
+}
+
+// Cancel cancels an Order on behalf of its owner. Owners may only cancel
+// orders that have not been shipped yet; actors with order:transition may
+// cancel any order the state machine allows. As in ResolveOrderByID, an order
+// the actor cannot see is reported as not found.
+func (s *OrderServiceImpl) Cancel(id uuid.UUID, actor policy.Actor) (order Order, err error) {
+	order, err = s.OrderRepository.ResolveOrderByID(id)
+	if err != nil {
+		return
+	}
+
+	if !s.Authorizer.CanAccess(actor, order.UserID, policy.OrderReadAny) {
+		return Order{}, failure.NotFound("order")
+	}
+
+	if !s.Authorizer.CanAccess(actor, order.UserID, policy.OrderTransition) {
+		return Order{}, failure.Unauthorized("unauthorized")
+	}
+
+	if order.Status != OrderStatusPending && !s.Authorizer.Can(actor, policy.OrderTransition) {
+		err = failure.Conflict("cancel", "order", "only pending orders can be cancelled")
+		return
+	}
+
+	err = s.transition(&order, OrderStatusCancelled, actor.UserID)
+	return
+}
+
+// internal methods
+
+// attachItems loads the items of all given orders with a single query and
+// recalculates each order's totals.
+func (s *OrderServiceImpl) attachItems(orders []Order) (err error) {
+	ids := make([]uuid.UUID, 0, len(orders))
+	for _, o := range orders {
+		ids = append(ids, o.ID)
+	}
+
+	items, err := s.OrderRepository.ResolveItemsByOrderIDs(ids)
+	if err != nil {
+		return
+	}
+
+	for i := range orders {
+		orders[i].AttachItems(items)
+		orders[i].Recalculate()
+	}
+	return
+}
+
+// transition applies a status change and persists it together with its
+// order.status_changed event. Cancelling an Order also returns its items to
+// stock and writes an order.cancelled event, all in the same transaction.
+func (s *OrderServiceImpl) transition(order *Order, newStatus OrderStatus, userID uuid.UUID) (err error) {
+	history, err := order.UpdateStatus(newStatus, userID)
+	if err != nil {
+		return
+	}
+
+	if newStatus == OrderStatusCancelled {
+		items, err := s.OrderRepository.ResolveItemsByOrderIDs([]uuid.UUID{order.ID})
+		if err != nil {
+			return err
+		}
+
+		order.AttachItems(items)
+		order.Recalculate()
+	}
+
+	topics := s.Config.Event.Producer.SNS.Topics
+	err = s.OrderRepository.WithTransaction(func(tx *sqlx.Tx, e chan error) {
+		if err := s.OrderRepository.TxUpdateOrderStatus(tx, *order, history); err != nil {
+			e <- err
+			return
+		}
+
+		statusChanged := model.NewEvent(OrderStatusChangedEventType, NewOrderStatusChangedEvent(*order, history))
+		if err := s.txPublish(tx, *order, topics.OrderStatusChanged.Enabled, topics.OrderStatusChanged.ARN, statusChanged); err != nil {
+			e <- err
+			return
+		}
+
+		if newStatus != OrderStatusCancelled {
+			e <- nil
+			return
+		}
+
+		for _, item := range order.Items {
+			if err := s.ProductService.TxIncrementStock(tx, item.ProductID, item.Quantity); err != nil {
+				e <- err
+				return
+			}
+		}
+
+		cancelled := model.NewEvent(OrderCancelledEventType, OrderCancelledEvent{
+			Version:     OrderCancelledEventVersion,
+			Order:       order.ToResponseFormat(),
+			CancelledAt: history.CreatedAt,
+			CancelledBy: history.CreatedBy,
+		})
+		e <- s.txPublish(tx, *order, topics.OrderCancelled.Enabled, topics.OrderCancelled.ARN, cancelled)
+	})
+
+	return
+}
+
+// txPublish writes event about order to the outbox as part of a caller-owned
+// transaction, when its topic is enabled.
+func (s *OrderServiceImpl) txPublish(tx *sqlx.Tx, order Order, enabled bool, topic string, event model.EventWrapper) (err error) {
+	if !enabled {
+		return
+	}
+
+	return s.Outbox.TxCreate(tx, outbox.NewMessage(OrderAggregateType, order.ID.String(), model.PublishRequest{
+		Event: event,
+		Topic: topic,
+	}))
+}
@@ -0,0 +1,275 @@
+package order_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/event/outbox"
+	"github.com/evermos/boilerplate-go/infras"
+	"github.com/evermos/boilerplate-go/internal/domain/order"
+	"github.com/evermos/boilerplate-go/internal/domain/product"
+	"github.com/evermos/boilerplate-go/shared/failure"
+	"github.com/evermos/boilerplate-go/shared/policy"
+	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeOrderRepository struct {
+	order.OrderRepository
+	order     order.Order
+	items     []order.OrderItem
+	histories []order.OrderStatusHistory
+}
+
+func (r *fakeOrderRepository) ResolveOrderByID(id uuid.UUID) (order.Order, error) {
+	if id != r.order.ID {
+		return order.Order{}, failure.NotFound("order")
+	}
+	return r.order, nil
+}
+
+func (r *fakeOrderRepository) ResolveItemsByOrderIDs(ids []uuid.UUID) ([]order.OrderItem, error) {
+	return r.items, nil
+}
+
+func (r *fakeOrderRepository) WithTransaction(block infras.Block) error {
+	saved, savedHistories := r.order, r.histories
+	e := make(chan error)
+	go block(nil, e)
+	err := <-e
+	if err != nil {
+		r.order, r.histories = saved, savedHistories
+	}
+	return err
+}
+
+func (r *fakeOrderRepository) TxUpdateOrderStatus(tx *sqlx.Tx, o order.Order, history order.OrderStatusHistory) error {
+	r.order = o
+	r.histories = append(r.histories, history)
+	return nil
+}
+
+func (r *fakeOrderRepository) TxCreateOrder(tx *sqlx.Tx, o order.Order) error {
+	r.order = o
+	return nil
+}
+
+type fakeProductService struct {
+	product.ProductService
+	stock   map[uuid.UUID]int
+	failFor uuid.UUID
+}
+
+func (s *fakeProductService) TxIncrementStock(tx *sqlx.Tx, id uuid.UUID, quantity int) error {
+	if id == s.failFor {
+		return errors.New("update failed")
+	}
+	s.stock[id] += quantity
+	return nil
+}
+
+type fakeOutbox struct {
+	outbox.Store
+	messages []outbox.Message
+}
+
+func (o *fakeOutbox) TxCreate(tx *sqlx.Tx, message outbox.Message) error {
+	o.messages = append(o.messages, message)
+	return nil
+}
+
+func newOrderFixture(status order.OrderStatus) (*fakeOrderRepository, *fakeProductService, *fakeOutbox, *order.OrderServiceImpl) {
+	o, _ := order.Order{}.NewOrder(getRandomUUID(), "Bandung")
+	o.Status = status
+	productA, productB := getRandomUUID(), getRandomUUID()
+
+	repo := &fakeOrderRepository{
+		order: o,
+		items: []order.OrderItem{
+			order.OrderItem{}.NewOrderItem(o.ID, o.UserID, productA, 2, 10000),
+			order.OrderItem{}.NewOrderItem(o.ID, o.UserID, productB, 1, 20000),
+		},
+	}
+	products := &fakeProductService{stock: map[uuid.UUID]int{productA: 0, productB: 0}}
+	box := &fakeOutbox{}
+
+	config := &configs.Config{}
+	config.Event.Producer.SNS.Topics.OrderCancelled.Enabled = true
+	config.Event.Producer.SNS.Topics.OrderCancelled.ARN = "arn:order-cancelled"
+
+	s := order.ProvideOrderServiceImpl(repo, products, box, policy.New(policy.DefaultRoles()), config)
+	return repo, products, box, s
+}
+
+func TestOrderServiceCancel(t *testing.T) {
+	t.Run("restocks items and writes event to outbox", func(t *testing.T) {
+		repo, products, box, s := newOrderFixture(order.OrderStatusPending)
+
+		got, err := s.Cancel(repo.order.ID, policy.Actor{UserID: repo.order.UserID, Role: "user"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, order.OrderStatusCancelled, got.Status)
+		assert.Equal(t, order.OrderStatusCancelled, repo.order.Status)
+		assert.Len(t, repo.histories, 1)
+		assert.Equal(t, 2, products.stock[repo.items[0].ProductID])
+		assert.Equal(t, 1, products.stock[repo.items[1].ProductID])
+
+		assert.Len(t, box.messages, 1)
+		assert.Equal(t, "arn:order-cancelled", box.messages[0].Topic)
+		assert.Equal(t, order.OrderCancelledEventType, box.messages[0].EventType)
+		assert.Equal(t, order.OrderAggregateType, box.messages[0].AggregateType)
+		assert.Equal(t, repo.order.ID.String(), box.messages[0].AggregateID)
+		assert.Equal(t, outbox.StatusPending, box.messages[0].Status)
+
+		var payload order.OrderCancelledEvent
+		assert.NoError(t, json.Unmarshal(box.messages[0].Payload, &payload))
+		assert.Equal(t, repo.order.ID, payload.Order.ID)
+		assert.Len(t, payload.Order.Items, 2)
+	})
+
+	t.Run("rolls back when restocking fails", func(t *testing.T) {
+		repo, products, box, s := newOrderFixture(order.OrderStatusPending)
+		products.failFor = repo.items[1].ProductID
+
+		_, err := s.Cancel(repo.order.ID, policy.Actor{UserID: repo.order.UserID, Role: "user"})
+
+		assert.Error(t, err)
+		assert.Equal(t, order.OrderStatusPending, repo.order.Status)
+		assert.Empty(t, repo.histories)
+		assert.Empty(t, box.messages)
+	})
+
+	t.Run("delivered order cannot be cancelled", func(t *testing.T) {
+		repo, products, box, s := newOrderFixture(order.OrderStatusDelivered)
+
+		_, err := s.Cancel(repo.order.ID, policy.Actor{UserID: repo.order.UserID, Role: "admin"})
+
+		assert.Equal(t, http.StatusConflict, failure.GetCode(err))
+		assert.Equal(t, 0, products.stock[repo.items[0].ProductID])
+		assert.Empty(t, box.messages)
+	})
+
+	t.Run("other users cannot find the order to cancel", func(t *testing.T) {
+		repo, _, _, s := newOrderFixture(order.OrderStatusPending)
+
+		_, err := s.Cancel(repo.order.ID, policy.Actor{UserID: getRandomUUID(), Role: "user"})
+
+		assert.Equal(t, http.StatusNotFound, failure.GetCode(err))
+		assert.Equal(t, order.OrderStatusPending, repo.order.Status)
+	})
+}
+
+func TestOrderServiceUpdateStatus(t *testing.T) {
+	t.Run("writes status changed event to outbox", func(t *testing.T) {
+		repo, _, box, s := newOrderFixture(order.OrderStatusPending)
+		s.Config.Event.Producer.SNS.Topics.OrderStatusChanged.Enabled = true
+		s.Config.Event.Producer.SNS.Topics.OrderStatusChanged.ARN = "arn:order-status-changed"
+		adminID := getRandomUUID()
+
+		_, err := s.UpdateStatus(repo.order.ID, order.OrderStatusRequestFormat{Status: order.OrderStatusShipping}, adminID)
+
+		assert.NoError(t, err)
+		assert.Len(t, box.messages, 1)
+		assert.Equal(t, "arn:order-status-changed", box.messages[0].Topic)
+		assert.Equal(t, order.OrderStatusChangedEventType, box.messages[0].EventType)
+
+		var payload order.OrderStatusChangedEvent
+		assert.NoError(t, json.Unmarshal(box.messages[0].Payload, &payload))
+		assert.Equal(t, order.OrderStatusChangedEventVersion, payload.Version)
+		assert.Equal(t, order.OrderStatusPending, payload.FromStatus)
+		assert.Equal(t, order.OrderStatusShipping, payload.ToStatus)
+		assert.Equal(t, adminID, payload.ChangedBy)
+	})
+
+	t.Run("cancelling writes status changed before cancelled", func(t *testing.T) {
+		repo, _, box, s := newOrderFixture(order.OrderStatusPending)
+		s.Config.Event.Producer.SNS.Topics.OrderStatusChanged.Enabled = true
+
+		_, err := s.UpdateStatus(repo.order.ID, order.OrderStatusRequestFormat{Status: order.OrderStatusCancelled}, getRandomUUID())
+
+		assert.NoError(t, err)
+		assert.Len(t, box.messages, 2)
+		assert.Equal(t, order.OrderStatusChangedEventType, box.messages[0].EventType)
+		assert.Equal(t, order.OrderCancelledEventType, box.messages[1].EventType)
+	})
+
+	t.Run("disabled topic writes nothing", func(t *testing.T) {
+		repo, _, box, s := newOrderFixture(order.OrderStatusPending)
+
+		_, err := s.UpdateStatus(repo.order.ID, order.OrderStatusRequestFormat{Status: order.OrderStatusShipping}, getRandomUUID())
+
+		assert.NoError(t, err)
+		assert.Equal(t, order.OrderStatusShipping, repo.order.Status)
+		assert.Empty(t, box.messages)
+	})
+}
+
+func TestOrderServiceTxCreateOrder(t *testing.T) {
+	repo, _, box, s := newOrderFixture(order.OrderStatusPending)
+	s.Config.Event.Producer.SNS.Topics.OrderCreated.Enabled = true
+	s.Config.Event.Producer.SNS.Topics.OrderCreated.ARN = "arn:order-created"
+	o := repo.order
+	o.AttachItems(repo.items)
+	o.Recalculate()
+
+	err := s.TxCreateOrder(nil, o)
+
+	assert.NoError(t, err)
+	assert.Len(t, box.messages, 1)
+	assert.Equal(t, "arn:order-created", box.messages[0].Topic)
+	assert.Equal(t, order.OrderCreatedEventType, box.messages[0].EventType)
+
+	var payload order.OrderCreatedEvent
+	assert.NoError(t, json.Unmarshal(box.messages[0].Payload, &payload))
+	assert.Equal(t, o.ID, payload.OrderID)
+	assert.Len(t, payload.Items, 2)
+	assert.Equal(t, 3, payload.TotalQuantity)
+	assert.Equal(t, float64(40000), payload.TotalPrice)
+}
+
+func TestOrderServiceResolveOrderByID(t *testing.T) {
+	t.Run("owner gets items and totals", func(t *testing.T) {
+		repo, _, _, s := newOrderFixture(order.OrderStatusPending)
+
+		got, err := s.ResolveOrderByID(repo.order.ID, policy.Actor{UserID: repo.order.UserID, Role: "user"})
+
+		assert.NoError(t, err)
+		assert.Len(t, got.Items, 2)
+		assert.Equal(t, float64(40000), got.TotalPrice)
+	})
+
+	t.Run("other users cannot see the order", func(t *testing.T) {
+		repo, _, _, s := newOrderFixture(order.OrderStatusPending)
+
+		_, err := s.ResolveOrderByID(repo.order.ID, policy.Actor{UserID: getRandomUUID(), Role: "user"})
+
+		assert.Equal(t, http.StatusNotFound, failure.GetCode(err))
+	})
+
+	t.Run("admin can see any order", func(t *testing.T) {
+		repo, _, _, s := newOrderFixture(order.OrderStatusPending)
+
+		got, err := s.ResolveOrderByID(repo.order.ID, policy.Actor{UserID: getRandomUUID(), Role: "admin"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, repo.order.ID, got.ID)
+	})
+
+	t.Run("configured roles can see any order", func(t *testing.T) {
+		repo, _, _, s := newOrderFixture(order.OrderStatusPending)
+		config := &configs.Config{}
+		config.App.Policy.Roles = map[string][]string{"cs_agent": {"order:read:any"}}
+		s.Authorizer = policy.ProvidePolicy(config)
+
+		got, err := s.ResolveOrderByID(repo.order.ID, policy.Actor{UserID: getRandomUUID(), Role: "cs_agent"})
+		assert.NoError(t, err)
+		assert.Equal(t, repo.order.ID, got.ID)
+
+		_, err = s.Cancel(repo.order.ID, policy.Actor{UserID: getRandomUUID(), Role: "cs_agent"})
+		assert.Equal(t, http.StatusUnauthorized, failure.GetCode(err))
+	})
+}
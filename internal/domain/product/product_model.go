@@ -2,21 +2,72 @@ package product
 
 import (
 	"encoding/json"
+	"errors"
+	"strconv"
 	"time"
 
 	"github.com/evermos/boilerplate-go/shared"
+	"github.com/evermos/boilerplate-go/shared/failure"
 	"github.com/evermos/boilerplate-go/shared/nuuid"
+	"github.com/evermos/boilerplate-go/shared/pagination"
 	"github.com/gofrs/uuid"
 	"github.com/guregu/null"
 )
 
+var (
+	ProductCreatedEventType      = "product.created"
+	ProductUpdatedEventType      = "product.updated"
+	ProductDeletedEventType      = "product.deleted"
+	ProductRestoredEventType     = "product.restored"
+	ProductStockChangedEventType = "product.stock_changed"
+	// ProductAggregateType identifies Products in the event outbox.
+	ProductAggregateType = "product"
+)
+
+// Versions of the event payloads, documented in event/schemas. A version is
+// only bumped for changes that break consumers.
+const (
+	ProductChangedEventVersion      = 1
+	ProductStockChangedEventVersion = 1
+)
+
+// StockChangeReason tells why the stock of a Product changed.
+type StockChangeReason string
+
+const (
+	// StockChangeReserved is stock taken by a checkout.
+	StockChangeReserved StockChangeReason = "reserved"
+	// StockChangeReleased is stock returned by a cancelled order.
+	StockChangeReleased StockChangeReason = "released"
+	// StockChangeAdjusted is stock set by an admin updating the Product.
+	StockChangeAdjusted StockChangeReason = "adjusted"
+)
+
+// ProductChangedEvent is the payload of the product.created, product.updated,
+// product.deleted and product.restored events.
+type ProductChangedEvent struct {
+	Version int                   `json:"version"`
+	Product ProductResponseFormat `json:"product"`
+}
+
+// ProductStockChangedEvent is the payload of the product.stock_changed event.
+// Change is negative when stock was taken.
+type ProductStockChangedEvent struct {
+	Version   int               `json:"version"`
+	ProductID uuid.UUID         `json:"product_id"`
+	Change    int64             `json:"change"`
+	Stock     int64             `json:"stock"`
+	Reason    StockChangeReason `json:"reason"`
+	ChangedAt time.Time         `json:"changed_at"`
+}
+
 type Product struct {
 	ID            uuid.UUID   `db:"id" validate:"required"`
 	Name          string      `db:"name" validate:"required"`
 	Description	  string      `db:"description" validate:"required"`
 	Category	  string      `db:"category" validate:"required"`
 	Brand		  string      `db:"brand" validate:"required"`
-	Stock		  int64       `db:"stock" validate:"required"`
+	Stock		  int64       `db:"stock" validate:"min=0"`
 	Price		  float64     `db:"price" validate:"required"`
 	CreatedAt     time.Time   `db:"created_at" validate:"required"`
 	CreatedBy     uuid.UUID   `db:"created_by" validate:"required"`
@@ -55,6 +106,33 @@ func (p Product) NewFromRequestFormat(req ProductRequestFormat, userID uuid.UUID
 }
 
 
+// Restore clears the "deletedAt" and "deletedBy" properties of a Product.
+func (p *Product) Restore(userID uuid.UUID) (err error) {
+	if !p.IsDeleted() {
+		return failure.Conflict("restore", "product", "not marked as deleted")
+	}
+
+	p.DeletedAt = null.Time{}
+	p.DeletedBy = nuuid.NUUID{}
+	p.UpdatedAt = null.TimeFrom(time.Now())
+	p.UpdatedBy = nuuid.From(userID)
+
+	return
+}
+
+// SoftDelete marks a Product as deleted by setting the "deletedAt" and
+// "deletedBy" properties of a Product.
+func (p *Product) SoftDelete(userID uuid.UUID) (err error) {
+	if p.IsDeleted() {
+		return failure.Conflict("softDelete", "product", "already marked as deleted")
+	}
+
+	p.DeletedAt = null.TimeFrom(time.Now())
+	p.DeletedBy = nuuid.From(userID)
+
+	return
+}
+
 func (p Product) ToResponseFormat() ProductResponseFormat {
 	resp := ProductResponseFormat{
 		ID:            	p.ID,
@@ -77,6 +155,22 @@ func (p Product) ToResponseFormat() ProductResponseFormat {
 }
 
 
+// Update updates a Product.
+func (p *Product) Update(req ProductRequestFormat, userID uuid.UUID) (err error) {
+	p.Name = req.Name
+	p.Description = req.Description
+	p.Category = req.Category
+	p.Brand = req.Brand
+	p.Stock = req.Stock
+	p.Price = req.Price
+	p.UpdatedAt = null.TimeFrom(time.Now())
+	p.UpdatedBy = nuuid.From(userID)
+
+	err = p.Validate()
+
+	return
+}
+
 func (p *Product) Validate() (err error) {
 	validator := shared.GetValidator()
 	return validator.Struct(p)
@@ -88,7 +182,7 @@ type ProductRequestFormat struct {
 	Description 	string	 `json:"description" validate:"required"`
 	Category		string	 `json:"category" validate:"required"`
 	Brand 			string 	 `json:"brand" validate:"required"`
-	Stock			int64	 `json:"stock" validate:"required"`
+	Stock			int64	 `json:"stock" validate:"min=0"`
 	Price			float64  `json:"price" validate:"required"`
 }
 
@@ -109,5 +203,123 @@ type ProductResponseFormat struct {
 	DeletedBy     	*uuid.UUID `json:"deleted_by"`
 }
 
+// ProductSort indicates the ordering of a Product listing.
+type ProductSort string
+
+const (
+	// ProductSortName orders products by name, A to Z.
+	ProductSortName ProductSort = "name"
+	// ProductSortPriceAsc orders products from the cheapest.
+	ProductSortPriceAsc ProductSort = "price_asc"
+	// ProductSortPriceDesc orders products from the most expensive.
+	ProductSortPriceDesc ProductSort = "price_desc"
+	// ProductSortNewest orders products from the most recently created.
+	ProductSortNewest ProductSort = "newest"
+	// ProductSortRelevance orders products by how well they match the keyword.
+	ProductSortRelevance ProductSort = "relevance"
+)
 
+// ProductFilter narrows down and orders a Product listing. Zero values mean
+// "no constraint".
+type ProductFilter struct {
+	Keyword     string      `validate:"max=100"`
+	Category    string      `validate:"max=50"`
+	Brand       string      `validate:"max=50"`
+	MinPrice    null.Float
+	MaxPrice    null.Float
+	InStockOnly bool
+	Sort        ProductSort `validate:"omitempty,oneof=name price_asc price_desc newest relevance"`
+	Page        int         `validate:"min=0"`
+	Limit       int         `validate:"min=1"`
+	// Cursor continues the listing after a previous page instead of
+	// skipping Page*Limit rows.
+	Cursor *pagination.Cursor
+}
 
+// Validate validates the filter.
+func (f *ProductFilter) Validate() (err error) {
+	validator := shared.GetValidator()
+	err = validator.Struct(f)
+	if err != nil {
+		return
+	}
+
+	if f.MinPrice.Valid && f.MinPrice.Float64 < 0 {
+		return errors.New("min_price must be equal or greater to zero")
+	}
+
+	if f.MinPrice.Valid && f.MaxPrice.Valid && f.MinPrice.Float64 > f.MaxPrice.Float64 {
+		return errors.New("min_price must not be greater than max_price")
+	}
+
+	if f.Sort == ProductSortRelevance && f.Keyword == "" {
+		return errors.New("sort by relevance requires a keyword")
+	}
+
+	if f.Cursor != nil {
+		if f.EffectiveSort() == ProductSortRelevance {
+			return errors.New("cursor is not supported when sorting by relevance")
+		}
+
+		if f.Cursor.Sort != string(f.EffectiveSort()) {
+			return errors.New("cursor does not match the requested sort")
+		}
+
+		_, err = f.cursorKey()
+	}
+
+	return
+}
+
+// EffectiveSort returns the sort the listing is ordered by: the requested
+// one, relevance for keyword searches, or name otherwise.
+func (f ProductFilter) EffectiveSort() ProductSort {
+	if f.Sort != "" {
+		return f.Sort
+	}
+
+	if f.Keyword != "" {
+		return ProductSortRelevance
+	}
+
+	return ProductSortName
+}
+
+// PageRequest returns the pagination part of the filter.
+func (f ProductFilter) PageRequest() pagination.Request {
+	return pagination.Request{Page: f.Page, Limit: f.Limit, Cursor: f.Cursor}
+}
+
+// cursorKey converts the cursor's sort key back to the type of the sorted column.
+func (f ProductFilter) cursorKey() (key interface{}, err error) {
+	switch f.EffectiveSort() {
+	case ProductSortPriceAsc, ProductSortPriceDesc:
+		key, err = strconv.ParseFloat(f.Cursor.Key, 64)
+	case ProductSortNewest:
+		key, err = time.Parse(time.RFC3339Nano, f.Cursor.Key)
+	default:
+		key = f.Cursor.Key
+	}
+
+	if err != nil {
+		err = pagination.ErrInvalidCursor
+	}
+
+	return
+}
+
+// cursor returns a cursor pointing at the Product in a listing ordered by sort.
+func (p Product) cursor(sort ProductSort) pagination.Cursor {
+	c := pagination.Cursor{Sort: string(sort), ID: p.ID.String()}
+
+	switch sort {
+	case ProductSortPriceAsc, ProductSortPriceDesc:
+		c.Key = strconv.FormatFloat(p.Price, 'f', -1, 64)
+	case ProductSortNewest:
+		c.Key = p.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		c.Key = p.Name
+	}
+
+	return c
+}
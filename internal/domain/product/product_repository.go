@@ -1,18 +1,30 @@
 package product
 
 import (
+	"database/sql"
+	"fmt"
+	"strings"
+
 	"github.com/evermos/boilerplate-go/infras"
 	"github.com/evermos/boilerplate-go/shared/failure"
 	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/evermos/boilerplate-go/shared/pagination"
 	"github.com/gofrs/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
 var productQueries = struct {
-	selectProduct string
-	insertProduct string
+	selectProduct  string
+	countProduct   string
+	insertProduct  string
+	updateProduct  string
+	softDelete     string
+	restore        string
+	decrementStock string
+	incrementStock string
 }{
 	selectProduct: `SELECT * FROM atc_product`,
+	countProduct:  `SELECT COUNT(id) FROM atc_product`,
 	insertProduct: `INSERT INTO atc_product (
 		id,
 		name,
@@ -42,13 +54,56 @@ var productQueries = struct {
 		:deleted_at,
 		:deleted_by
 	)`,
+	updateProduct: `
+	UPDATE atc_product
+	SET
+		name = :name,
+		description = :description,
+		category = :category,
+		brand = :brand,
+		stock = :stock,
+		price = :price,
+		updated_at = :updated_at,
+		updated_by = :updated_by
+	WHERE id = :id
+	`,
+	softDelete: `
+	UPDATE atc_product
+	SET deleted_at = ?, deleted_by = ?
+	WHERE id = ? AND deleted_at IS NULL
+	`,
+	restore: `
+	UPDATE atc_product
+	SET deleted_at = NULL, deleted_by = NULL, updated_at = ?, updated_by = ?
+	WHERE id = ? AND deleted_at IS NOT NULL
+	`,
+	decrementStock: `
+	UPDATE atc_product
+	SET stock = stock - ?
+	WHERE id = ? AND stock >= ? AND deleted_at IS NULL
+	`,
+	incrementStock: `
+	UPDATE atc_product
+	SET stock = stock + ?
+	WHERE id = ?
+	`,
 }
 
 type ProductRepository interface {
 	Create(product Product) (err error)
 	ExistsByID(id uuid.UUID) (exist bool, err error)
 	ResolveProductByID(id uuid.UUID) (product Product, err error)
-	ResolveAllProducts(page int, limit int) (products []Product, err error)
+	ResolveAllProducts(filter ProductFilter) (products []Product, page pagination.Result, err error)
+	Update(product Product) (err error)
+	TxCreate(tx *sqlx.Tx, product Product) (err error)
+	TxResolveProductByIDForUpdate(tx *sqlx.Tx, id uuid.UUID) (product Product, err error)
+	TxUpdate(tx *sqlx.Tx, product Product) (err error)
+	TxSoftDelete(tx *sqlx.Tx, product Product) (err error)
+	TxRestore(tx *sqlx.Tx, product Product) (err error)
+	TxDecrementStock(tx *sqlx.Tx, id uuid.UUID, quantity int) (err error)
+	TxIncrementStock(tx *sqlx.Tx, id uuid.UUID, quantity int) (err error)
+	TxResolveStock(tx *sqlx.Tx, id uuid.UUID) (stock int64, err error)
+	WithTransaction(block infras.Block) (err error)
 }
 
 type ProductRepositoryMySQL struct {
@@ -76,7 +131,7 @@ func (r *ProductRepositoryMySQL) Create(product Product) (err error) {
 	}
 
 	return r.DB.WithTransaction(func(tx *sqlx.Tx, e chan error) {
-		if err := r.txCreate(tx, product); err != nil {
+		if err := r.TxCreate(tx, product); err != nil {
 			e <- err
 			return
 		}
@@ -100,7 +155,8 @@ func (r *ProductRepositoryMySQL) ExistsByID(id uuid.UUID) (exists bool, err erro
 }
 
 
-func (r *ProductRepositoryMySQL) txCreate(tx *sqlx.Tx, product Product) (err error) {
+// TxCreate creates a new Product transactionally, given the *sqlx.Tx param.
+func (r *ProductRepositoryMySQL) TxCreate(tx *sqlx.Tx, product Product) (err error) {
 	stmt, err := tx.PrepareNamed(productQueries.insertProduct)
 	if err != nil {
 		logger.ErrorWithStack(err)
@@ -116,12 +172,47 @@ func (r *ProductRepositoryMySQL) txCreate(tx *sqlx.Tx, product Product) (err err
 	return
 }
 
-func (r *ProductRepositoryMySQL) ResolveAllProducts(page, limit int) (products []Product, err error) {
-	err = r.DB.Read.Select(
-		&products,
-		productQueries.selectProduct+" ORDER BY name ASC LIMIT ? OFFSET ?", limit, (page)*limit)
+// ResolveAllProducts resolves a page of non-deleted Products matching the filter,
+// along with the metadata of the page.
+func (r *ProductRepositoryMySQL) ResolveAllProducts(filter ProductFilter) (products []Product, page pagination.Result, err error) {
+	req := filter.PageRequest()
+	where, args := r.composeFilterQuery(filter)
+
+	var total int
+	err = r.DB.Read.Get(&total, productQueries.countProduct+" "+where, args...)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	if condition, keysetArgs := r.composeKeysetQuery(filter); condition != "" {
+		where += " AND " + condition
+		args = append(args, keysetArgs...)
+	}
+	orderBy, orderArgs := r.composeOrderQuery(filter)
+
+	query := fmt.Sprintf("%s %s %s LIMIT ? OFFSET ?", productQueries.selectProduct, where, orderBy)
+	args = append(args, orderArgs...)
+	args = append(args, req.FetchLimit(), req.Offset())
+
+	err = r.DB.Read.Select(&products, query, args...)
 	if err != nil {
 		logger.ErrorWithStack(err)
+		return
+	}
+
+	fetched := len(products)
+	products = products[:pagination.Trim(req, fetched)]
+	if req.Backward() {
+		for i, j := 0, len(products)-1; i < j; i, j = i+1, j-1 {
+			products[i], products[j] = products[j], products[i]
+		}
+	}
+
+	page = pagination.NewResult(req, fetched, total)
+	sort := filter.EffectiveSort()
+	if len(products) > 0 && sort != ProductSortRelevance {
+		page.SetCursors(products[0].cursor(sort), products[len(products)-1].cursor(sort))
 	}
 
 	return
@@ -132,6 +223,11 @@ func (r *ProductRepositoryMySQL) ResolveProductByID(id uuid.UUID) (product Produ
 	err = r.DB.Read.Get(
 		&product,
 		productQueries.selectProduct+" WHERE id = ?", id.String())
+	if err != nil && err == sql.ErrNoRows {
+		err = failure.NotFound("product")
+		logger.ErrorWithStack(err)
+		return
+	}
 	if err != nil {
 		logger.ErrorWithStack(err)
 	}
@@ -141,5 +237,235 @@ func (r *ProductRepositoryMySQL) ResolveProductByID(id uuid.UUID) (product Produ
 	return
 }
 
+// TxResolveProductByIDForUpdate resolves a Product by its ID transactionally
+// given the *sqlx.Tx param, locking its row until the transaction ends so
+// checkouts cannot change its stock in between.
+func (r *ProductRepositoryMySQL) TxResolveProductByIDForUpdate(tx *sqlx.Tx, id uuid.UUID) (product Product, err error) {
+	err = tx.Get(&product, productQueries.selectProduct+" WHERE id = ? FOR UPDATE", id.String())
+	if err == sql.ErrNoRows {
+		err = failure.NotFound("product")
+	}
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+
+	return
+}
+
+// Update updates a Product.
+func (r *ProductRepositoryMySQL) Update(product Product) (err error) {
+	exists, err := r.ExistsByID(product.ID)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
 
+	if !exists {
+		err = failure.NotFound("product")
+		logger.ErrorWithStack(err)
+		return
+	}
 
+	return r.DB.WithTransaction(func(tx *sqlx.Tx, e chan error) {
+		if err := r.TxUpdate(tx, product); err != nil {
+			e <- err
+			return
+		}
+
+		e <- nil
+	})
+}
+
+// TxUpdate updates a Product transactionally, given the *sqlx.Tx param. It
+// leaves its creation and deletion alone, which have their own statements.
+func (r *ProductRepositoryMySQL) TxUpdate(tx *sqlx.Tx, product Product) (err error) {
+	stmt, err := tx.PrepareNamed(productQueries.updateProduct)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(product)
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+
+	return
+}
+
+// TxSoftDelete marks a Product as deleted transactionally given the *sqlx.Tx
+// param, only writing its "deletedAt" and "deletedBy" columns. It fails with a
+// conflict when the Product is already deleted.
+func (r *ProductRepositoryMySQL) TxSoftDelete(tx *sqlx.Tx, product Product) (err error) {
+	result, err := tx.Exec(productQueries.softDelete, product.DeletedAt, product.DeletedBy, product.ID.String())
+	return r.checkAffected(result, err, failure.Conflict("softDelete", "product", "already marked as deleted"))
+}
+
+// TxRestore clears a Product's deletion transactionally given the *sqlx.Tx
+// param, without writing its other columns. It fails with a conflict when the
+// Product is not deleted.
+func (r *ProductRepositoryMySQL) TxRestore(tx *sqlx.Tx, product Product) (err error) {
+	result, err := tx.Exec(productQueries.restore, product.UpdatedAt, product.UpdatedBy, product.ID.String())
+	return r.checkAffected(result, err, failure.Conflict("restore", "product", "not marked as deleted"))
+}
+
+// checkAffected returns the error of a conditional update, or notAffected
+// when its condition matched no row.
+func (r *ProductRepositoryMySQL) checkAffected(result sql.Result, err error, notAffected error) error {
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return err
+	}
+
+	if affected == 0 {
+		return notAffected
+	}
+
+	return nil
+}
+
+// TxDecrementStock decrements a Product's stock transactionally given the *sqlx.Tx param.
+// The update is conditional, so it fails with a conflict instead of letting
+// the stock go below zero when another checkout got there first.
+func (r *ProductRepositoryMySQL) TxDecrementStock(tx *sqlx.Tx, id uuid.UUID, quantity int) (err error) {
+	result, err := tx.Exec(productQueries.decrementStock, quantity, id.String(), quantity)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	if affected == 0 {
+		err = failure.Conflict("decrementStock", "product", "insufficient stock")
+	}
+
+	return
+}
+
+// TxIncrementStock returns quantity to a Product's stock transactionally given the *sqlx.Tx param.
+func (r *ProductRepositoryMySQL) TxIncrementStock(tx *sqlx.Tx, id uuid.UUID, quantity int) (err error) {
+	result, err := tx.Exec(productQueries.incrementStock, quantity, id.String())
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	if affected == 0 {
+		err = failure.NotFound("product")
+	}
+
+	return
+}
+
+// productSortKeysets maps each ProductSort to the columns it orders by. Sorting
+// is only ever done through this whitelist, never by interpolating user input.
+// The ID tie-breaker keeps pages stable when the sort column has duplicates.
+var productSortKeysets = map[ProductSort]pagination.Keyset{
+	ProductSortName:      {KeyColumn: "name", IDColumn: "id"},
+	ProductSortPriceAsc:  {KeyColumn: "price", IDColumn: "id"},
+	ProductSortPriceDesc: {KeyColumn: "price", KeyDesc: true, IDColumn: "id"},
+	ProductSortNewest:    {KeyColumn: "created_at", KeyDesc: true, IDColumn: "id", IDDesc: true},
+}
+
+// TxResolveStock resolves a Product's stock transactionally given the *sqlx.Tx
+// param, seeing the stock changes made earlier in the transaction.
+func (r *ProductRepositoryMySQL) TxResolveStock(tx *sqlx.Tx, id uuid.UUID) (stock int64, err error) {
+	err = tx.Get(&stock, "SELECT stock FROM atc_product WHERE id = ?", id.String())
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+
+	return
+}
+
+// WithTransaction runs block inside a single write transaction, so a Product
+// change can be written together with its outbox event.
+func (r *ProductRepositoryMySQL) WithTransaction(block infras.Block) (err error) {
+	return r.DB.WithTransaction(block)
+}
+
+// composeFilterQuery composes the WHERE clause and its arguments for a ProductFilter.
+// Every user-supplied value is passed as a bind parameter.
+func (r *ProductRepositoryMySQL) composeFilterQuery(filter ProductFilter) (where string, args []interface{}) {
+	conditions := []string{"deleted_at IS NULL"}
+
+	if filter.Keyword != "" {
+		conditions = append(conditions, "MATCH (name, description) AGAINST (? IN NATURAL LANGUAGE MODE)")
+		args = append(args, filter.Keyword)
+	}
+
+	if filter.Category != "" {
+		conditions = append(conditions, "category = ?")
+		args = append(args, filter.Category)
+	}
+
+	if filter.Brand != "" {
+		conditions = append(conditions, "brand = ?")
+		args = append(args, filter.Brand)
+	}
+
+	if filter.MinPrice.Valid {
+		conditions = append(conditions, "price >= ?")
+		args = append(args, filter.MinPrice.Float64)
+	}
+
+	if filter.MaxPrice.Valid {
+		conditions = append(conditions, "price <= ?")
+		args = append(args, filter.MaxPrice.Float64)
+	}
+
+	if filter.InStockOnly {
+		conditions = append(conditions, "stock > 0")
+	}
+
+	where = "WHERE " + strings.Join(conditions, " AND ")
+	return
+}
+
+// composeKeysetQuery composes the condition selecting the rows past the
+// filter's cursor, or an empty condition when there is no cursor.
+func (r *ProductRepositoryMySQL) composeKeysetQuery(filter ProductFilter) (condition string, args []interface{}) {
+	keyset, ok := productSortKeysets[filter.EffectiveSort()]
+	if !ok || filter.Cursor == nil {
+		return
+	}
+
+	// The cursor key has already been checked by ProductFilter.Validate.
+	key, _ := filter.cursorKey()
+	return keyset.Condition(*filter.Cursor, key)
+}
+
+// composeOrderQuery composes the ORDER BY clause for a ProductFilter. Keyword
+// searches without an explicit sort are ordered by relevance. Walking back
+// from a cursor reverses the order.
+func (r *ProductRepositoryMySQL) composeOrderQuery(filter ProductFilter) (orderBy string, args []interface{}) {
+	sort := filter.EffectiveSort()
+	if sort == ProductSortRelevance {
+		return "ORDER BY MATCH (name, description) AGAINST (? IN NATURAL LANGUAGE MODE) DESC, id ASC", []interface{}{filter.Keyword}
+	}
+
+	keyset, ok := productSortKeysets[sort]
+	if !ok {
+		keyset = productSortKeysets[ProductSortName]
+	}
+
+	return keyset.OrderBy(filter.PageRequest().Backward()), nil
+}
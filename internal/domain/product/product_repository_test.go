@@ -0,0 +1,104 @@
+package product
+
+import (
+	"testing"
+
+	"github.com/evermos/boilerplate-go/shared/pagination"
+	"github.com/guregu/null"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProductRepositoryComposeQuery(t *testing.T) {
+	r := &ProductRepositoryMySQL{}
+
+	t.Run("no filter only hides deleted products", func(t *testing.T) {
+		where, args := r.composeFilterQuery(ProductFilter{})
+		orderBy, orderArgs := r.composeOrderQuery(ProductFilter{})
+
+		condition, _ := r.composeKeysetQuery(ProductFilter{})
+
+		assert.Equal(t, "WHERE deleted_at IS NULL", where)
+		assert.Empty(t, args)
+		assert.Empty(t, condition)
+		assert.Equal(t, "ORDER BY name ASC, id ASC", orderBy)
+		assert.Empty(t, orderArgs)
+	})
+
+	t.Run("all filters are bound as parameters", func(t *testing.T) {
+		filter := ProductFilter{
+			Keyword:     "kopi'; DROP TABLE atc_product; --",
+			Category:    "food",
+			Brand:       "kapal api",
+			MinPrice:    null.FloatFrom(1000),
+			MaxPrice:    null.FloatFrom(50000),
+			InStockOnly: true,
+			Sort:        ProductSortPriceDesc,
+		}
+
+		where, args := r.composeFilterQuery(filter)
+		orderBy, orderArgs := r.composeOrderQuery(filter)
+
+		assert.Equal(t, "WHERE deleted_at IS NULL"+
+			" AND MATCH (name, description) AGAINST (? IN NATURAL LANGUAGE MODE)"+
+			" AND category = ? AND brand = ? AND price >= ? AND price <= ? AND stock > 0", where)
+		assert.Equal(t, []interface{}{filter.Keyword, "food", "kapal api", float64(1000), float64(50000)}, args)
+		assert.NotContains(t, where, "DROP")
+		assert.Equal(t, "ORDER BY price DESC, id ASC", orderBy)
+		assert.Empty(t, orderArgs)
+	})
+
+	t.Run("keyword without sort orders by relevance", func(t *testing.T) {
+		orderBy, orderArgs := r.composeOrderQuery(ProductFilter{Keyword: "kopi"})
+
+		assert.Equal(t, "ORDER BY MATCH (name, description) AGAINST (? IN NATURAL LANGUAGE MODE) DESC, id ASC", orderBy)
+		assert.Equal(t, []interface{}{"kopi"}, orderArgs)
+	})
+
+	t.Run("unknown sort falls back to name", func(t *testing.T) {
+		orderBy, _ := r.composeOrderQuery(ProductFilter{Sort: ProductSort("price; DELETE FROM atc_product")})
+
+		assert.Equal(t, "ORDER BY name ASC, id ASC", orderBy)
+	})
+
+	t.Run("cursor continues after its position", func(t *testing.T) {
+		filter := ProductFilter{
+			Category: "food",
+			Sort:     ProductSortPriceDesc,
+			Cursor:   &pagination.Cursor{Sort: "price_desc", Key: "15000", ID: "a1", Direction: pagination.DirectionNext},
+		}
+
+		where, args := r.composeFilterQuery(filter)
+		condition, keysetArgs := r.composeKeysetQuery(filter)
+		orderBy, _ := r.composeOrderQuery(filter)
+
+		assert.Equal(t, "WHERE deleted_at IS NULL AND category = ?", where)
+		assert.Equal(t, []interface{}{"food"}, args)
+		assert.Equal(t, "(price < ? OR (price = ? AND id > ?))", condition)
+		assert.Equal(t, []interface{}{float64(15000), float64(15000), "a1"}, keysetArgs)
+		assert.Equal(t, "ORDER BY price DESC, id ASC", orderBy)
+	})
+
+	t.Run("previous cursor walks back in reverse order", func(t *testing.T) {
+		filter := ProductFilter{Cursor: &pagination.Cursor{Sort: "name", Key: "kopi", ID: "a1", Direction: pagination.DirectionPrev}}
+
+		condition, _ := r.composeKeysetQuery(filter)
+		orderBy, _ := r.composeOrderQuery(filter)
+
+		assert.Equal(t, "(name < ? OR (name = ? AND id < ?))", condition)
+		assert.Equal(t, "ORDER BY name DESC, id DESC", orderBy)
+	})
+}
+
+func TestProductFilterValidate(t *testing.T) {
+	assert.NoError(t, (&ProductFilter{Limit: 10}).Validate())
+	assert.Error(t, (&ProductFilter{Limit: 0}).Validate())
+	assert.Error(t, (&ProductFilter{Limit: 10, Sort: "cheapest"}).Validate())
+	assert.Error(t, (&ProductFilter{Limit: 10, Sort: ProductSortRelevance}).Validate())
+	assert.Error(t, (&ProductFilter{Limit: 10, MinPrice: null.FloatFrom(10), MaxPrice: null.FloatFrom(5)}).Validate())
+
+	next := pagination.DirectionNext
+	assert.NoError(t, (&ProductFilter{Limit: 10, Sort: ProductSortNewest, Cursor: &pagination.Cursor{Sort: "newest", Key: "2021-01-02T03:04:05Z", ID: "a1", Direction: next}}).Validate())
+	assert.Error(t, (&ProductFilter{Limit: 10, Sort: ProductSortNewest, Cursor: &pagination.Cursor{Sort: "newest", Key: "yesterday", ID: "a1", Direction: next}}).Validate())
+	assert.Error(t, (&ProductFilter{Limit: 10, Sort: ProductSortPriceAsc, Cursor: &pagination.Cursor{Sort: "name", Key: "kopi", ID: "a1", Direction: next}}).Validate())
+	assert.Error(t, (&ProductFilter{Limit: 10, Keyword: "kopi", Cursor: &pagination.Cursor{Sort: "relevance", Key: "1", ID: "a1", Direction: next}}).Validate())
+}
@@ -1,25 +1,38 @@
 package product
 
 import (
+	"time"
+
 	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/event/model"
+	"github.com/evermos/boilerplate-go/event/outbox"
 	"github.com/evermos/boilerplate-go/shared/failure"
+	"github.com/evermos/boilerplate-go/shared/pagination"
 	"github.com/gofrs/uuid"
+	"github.com/jmoiron/sqlx"
 )
 
 type ProductService interface {
 	Create(requestFormat ProductRequestFormat, userID uuid.UUID) (product Product, err error)
-	ResolveAllProducts(page int, limit int) (products []Product, err error)
+	ResolveAllProducts(filter ProductFilter) (products []Product, page pagination.Result, err error)
 	ResolveProductByID(id uuid.UUID) (product Product, err error)
+	Update(id uuid.UUID, requestFormat ProductRequestFormat, userID uuid.UUID) (product Product, err error)
+	SoftDelete(id uuid.UUID, userID uuid.UUID) (product Product, err error)
+	Restore(id uuid.UUID, userID uuid.UUID) (product Product, err error)
+	TxDecrementStock(tx *sqlx.Tx, id uuid.UUID, quantity int) (err error)
+	TxIncrementStock(tx *sqlx.Tx, id uuid.UUID, quantity int) (err error)
 }
 
 type ProductServiceImpl struct {
 	ProductRepository 	ProductRepository
+	Outbox				outbox.Store
 	Config				*configs.Config
 }
 
-func ProvideProductServiceImpl(productRepository ProductRepository, config *configs.Config) *ProductServiceImpl {
+func ProvideProductServiceImpl(productRepository ProductRepository, outbox outbox.Store, config *configs.Config) *ProductServiceImpl {
 	s := new(ProductServiceImpl)
 	s.ProductRepository = productRepository
+	s.Outbox = outbox
 	s.Config = config
 
 	return s
@@ -35,35 +48,192 @@ func (s *ProductServiceImpl) Create(requestFormat ProductRequestFormat, userID u
 		return product, failure.BadRequest(err)
 	}
 
-	err = s.ProductRepository.Create(product)
+	err = s.create(product)
+	return
+}
+
+
+// ResolveAllProducts resolves a page of Products matching the filter.
+func (s *ProductServiceImpl) ResolveAllProducts(filter ProductFilter) (products []Product, page pagination.Result, err error) {
+	err = filter.Validate()
+	if err != nil {
+		return products, page, failure.BadRequest(err)
+	}
+
+	products, page, err = s.ProductRepository.ResolveAllProducts(filter)
+	if err != nil {
+		return
+	}
+
+	return
+}
 
+// ResolveProductByID resolves a Product by its ID. Soft-deleted products are
+// reported as not found.
+func (s *ProductServiceImpl) ResolveProductByID(id uuid.UUID) (product Product, err error)  {
+	product, err = s.ProductRepository.ResolveProductByID(id)
 	if err != nil {
 		return
 	}
 
+	if product.IsDeleted() {
+		return product, failure.NotFound("product")
+	}
 
 	return
 }
 
+// Update updates a Product.
+func (s *ProductServiceImpl) Update(id uuid.UUID, requestFormat ProductRequestFormat, userID uuid.UUID) (product Product, err error) {
+	return s.modify(id, ProductUpdatedEventType, func(tx *sqlx.Tx, product *Product) (stockChange int64, err error) {
+		if product.IsDeleted() {
+			return 0, failure.NotFound("product")
+		}
+
+		previousStock := product.Stock
+		err = product.Update(requestFormat, userID)
+		if err != nil {
+			return 0, failure.BadRequest(err)
+		}
+
+		return product.Stock - previousStock, s.ProductRepository.TxUpdate(tx, *product)
+	})
+}
+
+// SoftDelete marks a Product as deleted by setting its `deletedAt` and `deletedBy` properties.
+func (s *ProductServiceImpl) SoftDelete(id uuid.UUID, userID uuid.UUID) (product Product, err error) {
+	return s.modify(id, ProductDeletedEventType, func(tx *sqlx.Tx, product *Product) (stockChange int64, err error) {
+		err = product.SoftDelete(userID)
+		if err != nil {
+			return
+		}
+
+		return 0, s.ProductRepository.TxSoftDelete(tx, *product)
+	})
+}
+
+// Restore brings a soft-deleted Product back into the catalogue.
+func (s *ProductServiceImpl) Restore(id uuid.UUID, userID uuid.UUID) (product Product, err error) {
+	return s.modify(id, ProductRestoredEventType, func(tx *sqlx.Tx, product *Product) (stockChange int64, err error) {
+		err = product.Restore(userID)
+		if err != nil {
+			return
+		}
+
+		return 0, s.ProductRepository.TxRestore(tx, *product)
+	})
+}
 
-func (s *ProductServiceImpl) ResolveAllProducts(page int, limit int) (products []Product, err error) {
-	products, err = s.ProductRepository.ResolveAllProducts(page, limit)
+// TxDecrementStock decrements a Product's stock as part of a caller-owned
+// transaction, and writes the product.stock_changed event of the checkout.
+func (s *ProductServiceImpl) TxDecrementStock(tx *sqlx.Tx, id uuid.UUID, quantity int) (err error) {
+	err = s.ProductRepository.TxDecrementStock(tx, id, quantity)
 	if err != nil {
 		return
 	}
 
-	return
+	return s.txPublishStockChanged(tx, id, -int64(quantity), StockChangeReserved)
 }
 
-func (s *ProductServiceImpl) ResolveProductByID(id uuid.UUID) (product Product, err error)  {
-	product, err = s.ProductRepository.ResolveProductByID(id)
+// TxIncrementStock returns quantity to a Product's stock as part of a
+// caller-owned transaction, and writes the product.stock_changed event of the
+// cancellation.
+func (s *ProductServiceImpl) TxIncrementStock(tx *sqlx.Tx, id uuid.UUID, quantity int) (err error) {
+	err = s.ProductRepository.TxIncrementStock(tx, id, quantity)
 	if err != nil {
 		return
 	}
 
+	return s.txPublishStockChanged(tx, id, int64(quantity), StockChangeReleased)
+}
+
+// create writes product together with its product.created event.
+func (s *ProductServiceImpl) create(product Product) (err error) {
+	return s.ProductRepository.WithTransaction(func(tx *sqlx.Tx, e chan error) {
+		if err := s.ProductRepository.TxCreate(tx, product); err != nil {
+			e <- err
+			return
+		}
+
+		e <- s.txPublishChanged(tx, product, ProductCreatedEventType, 0)
+	})
+}
+
+// modify applies change to the Product id inside a transaction, and writes
+// its eventType event together with the change. The Product is read with its
+// row locked, so checkouts and other changes cannot interleave with change,
+// and the stock change it reports is measured against the stock it actually
+// replaces.
+func (s *ProductServiceImpl) modify(id uuid.UUID, eventType string, change func(tx *sqlx.Tx, product *Product) (stockChange int64, err error)) (product Product, err error) {
+	err = s.ProductRepository.WithTransaction(func(tx *sqlx.Tx, e chan error) {
+		var err error
+		product, err = s.ProductRepository.TxResolveProductByIDForUpdate(tx, id)
+		if err != nil {
+			e <- err
+			return
+		}
+
+		stockChange, err := change(tx, &product)
+		if err != nil {
+			e <- err
+			return
+		}
+
+		e <- s.txPublishChanged(tx, product, eventType, stockChange)
+	})
 	return
 }
 
+// txPublishChanged writes the eventType event of product and, when an admin
+// changed its stock by stockChange, its product.stock_changed event, as part
+// of a caller-owned transaction.
+func (s *ProductServiceImpl) txPublishChanged(tx *sqlx.Tx, product Product, eventType string, stockChange int64) (err error) {
+	topic := s.Config.Event.Producer.SNS.Topics.ProductChanged
+	event := model.NewEvent(eventType, ProductChangedEvent{
+		Version: ProductChangedEventVersion,
+		Product: product.ToResponseFormat(),
+	})
+	err = s.txPublish(tx, product.ID, topic.Enabled, topic.ARN, event)
+	if err != nil || stockChange == 0 {
+		return
+	}
 
+	return s.txPublishStockChanged(tx, product.ID, stockChange, StockChangeAdjusted)
+}
 
+// txPublishStockChanged writes the product.stock_changed event of the Product
+// id, whose stock changed by change, as part of a caller-owned transaction.
+func (s *ProductServiceImpl) txPublishStockChanged(tx *sqlx.Tx, id uuid.UUID, change int64, reason StockChangeReason) (err error) {
+	topic := s.Config.Event.Producer.SNS.Topics.ProductStockChanged
+	if !topic.Enabled {
+		return
+	}
+
+	stock, err := s.ProductRepository.TxResolveStock(tx, id)
+	if err != nil {
+		return
+	}
 
+	event := model.NewEvent(ProductStockChangedEventType, ProductStockChangedEvent{
+		Version:   ProductStockChangedEventVersion,
+		ProductID: id,
+		Change:    change,
+		Stock:     stock,
+		Reason:    reason,
+		ChangedAt: time.Now(),
+	})
+	return s.txPublish(tx, id, topic.Enabled, topic.ARN, event)
+}
+
+// txPublish writes event about the Product id to the outbox as part of a
+// caller-owned transaction, when its topic is enabled.
+func (s *ProductServiceImpl) txPublish(tx *sqlx.Tx, id uuid.UUID, enabled bool, topic string, event model.EventWrapper) (err error) {
+	if !enabled {
+		return
+	}
+
+	return s.Outbox.TxCreate(tx, outbox.NewMessage(ProductAggregateType, id.String(), model.PublishRequest{
+		Event: event,
+		Topic: topic,
+	}))
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/evermos/boilerplate-go/shared"
+	"github.com/evermos/boilerplate-go/shared/failure"
+	"github.com/evermos/boilerplate-go/shared/policy"
+	"github.com/evermos/boilerplate-go/transport/http/middleware"
+	"github.com/evermos/boilerplate-go/transport/http/response"
+	"github.com/gofrs/uuid"
+)
+
+// resolveActor resolves the caller and their role from the JWT claims in the
+// request context, or writes an error response and returns false.
+func resolveActor(w http.ResponseWriter, r *http.Request) (actor policy.Actor, ok bool) {
+	claims, ok := r.Context().Value(middleware.ClaimsKey("claims")).(shared.Claims)
+	if !ok {
+		response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	userID, err := uuid.FromString(claims.UserId)
+	if err != nil {
+		response.WithError(w, failure.BadRequest(err))
+		return actor, false
+	}
+
+	return policy.Actor{UserID: userID, Role: claims.Role}, true
+}
@@ -16,12 +16,16 @@ import (
 type CartHandler struct {
 	CartService cart.CartService
 	AuthMiddleware *middleware.Authentication
+	Idempotency *middleware.Idempotency
+	RateLimit *middleware.RateLimit
 }
 
-func ProvideCartHandler(cartService cart.CartService, authmiddleware *middleware.Authentication) CartHandler {
+func ProvideCartHandler(cartService cart.CartService, authmiddleware *middleware.Authentication, idempotency *middleware.Idempotency, rateLimit *middleware.RateLimit) CartHandler {
 	return CartHandler{
 		CartService: cartService,
 		AuthMiddleware: authmiddleware,
+		Idempotency: idempotency,
+		RateLimit: rateLimit,
 	}
 }
 
@@ -30,9 +34,13 @@ func (h *CartHandler) Router(r chi.Router) {
 	r.Route("/carts", func(r chi.Router) {
 		r.Group(func(r chi.Router) {
 			r.Use(h.AuthMiddleware.ValidateJWT)
+			r.Use(h.RateLimit.Limit("carts"))
 			r.Post("/", h.AddToCart)
 			r.Get("/", h.ResolveCartByUserID)
-			r.Post("/{cart_id}/checkout", h.Checkout)
+			r.With(h.RateLimit.Limit("checkout"), h.Idempotency.Handle).Post("/{cart_id}/checkout", h.Checkout)
+			r.Put("/items/{product_id}", h.UpdateCartItem)
+			r.Delete("/items/{product_id}", h.RemoveCartItem)
+			r.Delete("/items", h.ClearCart)
 		})
 
 
@@ -48,6 +56,7 @@ func (h *CartHandler) Router(r chi.Router) {
 // @Success 201 {object} response.Base{data=cart.CartResponseFormat}
 // @Failure 400 {object} response.Base
 // @Failure 409 {object} response.Base
+// @Failure 429 {object} response.Base
 // @Failure 500 {object} response.Base
 // @Router /v1/carts [post]
 func (h *CartHandler) AddToCart(w http.ResponseWriter, r *http.Request) {
@@ -65,18 +74,12 @@ func (h *CartHandler) AddToCart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims, ok := r.Context().Value(middleware.ClaimsKey("claims")).(shared.Claims)
+	actor, ok := resolveActor(w, r)
 	if !ok {
-		response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
-	}
-
-	id, err := uuid.FromString(claims.UserId)
-	if err != nil {
-		response.WithError(w, failure.BadRequest(err))
 		return
 	}
 
-	cart,err := h.CartService.AddToCart(requestFormat, id)
+	cart,err := h.CartService.AddToCart(requestFormat, actor.UserID)
 	if err != nil {
 		response.WithError(w, err)
 		return
@@ -93,22 +96,16 @@ func (h *CartHandler) AddToCart(w http.ResponseWriter, r *http.Request) {
 // @Success 200 {object} response.Base{data=cart.CartResponseFormat}
 // @Failure 400 {object} response.Base
 // @Failure 404 {object} response.Base
+// @Failure 429 {object} response.Base
 // @Failure 500 {object} response.Base
 // @Router /v1/carts [get]
 func (h *CartHandler) ResolveCartByUserID(w http.ResponseWriter, r *http.Request) {
-	claims, ok := r.Context().Value(middleware.ClaimsKey("claims")).(shared.Claims)
+	actor, ok := resolveActor(w, r)
 	if !ok {
-		response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
-	}
-
-	id, err := uuid.FromString(claims.UserId)
-	if err != nil {
-		response.WithError(w, failure.BadRequest(err))
 		return
 	}
 
-
-	cart,err := h.CartService.ResolveCartByUserID(id)
+	cart,err := h.CartService.ResolveCartByUserID(actor.UserID)
 	if err != nil {
 		response.WithError(w, err)
 		return
@@ -125,10 +122,13 @@ func (h *CartHandler) ResolveCartByUserID(w http.ResponseWriter, r *http.Request
 // @Security JWTToken
 // @Param cart_id path string true "cartID"
 // @Param Order body cart.CheckoutRequestFormat true "make order request"
+// @Param Idempotency-Key header string false "retries with the same key replay the first response"
 // @Produce json
 // @Success 201 {object} response.Base{data=order.OrderResponseFormat}
 // @Failure 400 {object} response.Base
 // @Failure 409 {object} response.Base
+// @Failure 422 {object} response.Base
+// @Failure 429 {object} response.Base
 // @Failure 500 {object} response.Base
 // @Router /v1/carts/{cart_id}/checkout [post]
 func (h *CartHandler) Checkout(w http.ResponseWriter, r *http.Request)  {
@@ -147,22 +147,133 @@ func (h *CartHandler) Checkout(w http.ResponseWriter, r *http.Request)  {
 		return
 	}
 
-	claims, ok := r.Context().Value(middleware.ClaimsKey("claims")).(shared.Claims)
+	err = shared.GetValidator().Struct(requestFormat)
+	if err != nil {
+		response.WithError(w, failure.BadRequest(err))
+		return
+	}
+
+	actor, ok := resolveActor(w, r)
+	if !ok {
+		return
+	}
+
+	order, err := h.CartService.Checkout(requestFormat, actor, cartID)
+	if err != nil {
+		response.WithError(w, err)
+		return
+	}
+
+	response.WithJSON(w, http.StatusCreated, order)
+}
+
+// UpdateCartItem sets the quantity of a product in the caller's cart.
+// @Summary Set the quantity of a cart item.
+// @Description This endpoint sets the absolute quantity of a product already in the cart.
+// @Description The quantity must be at least one and no greater than the product's stock.
+// @Tags v1/Carts
+// @Security JWTToken
+// @Param product_id path string true "The Product's identifier."
+// @Param CartItem body cart.CartItemQuantityRequestFormat true "The new quantity."
+// @Produce json
+// @Success 200 {object} response.Base{data=cart.CartResponseFormat}
+// @Failure 400 {object} response.Base
+// @Failure 404 {object} response.Base
+// @Failure 429 {object} response.Base
+// @Failure 500 {object} response.Base
+// @Router /v1/carts/items/{product_id} [put]
+func (h *CartHandler) UpdateCartItem(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.FromString(chi.URLParam(r, "product_id"))
+	if err != nil {
+		response.WithError(w, failure.BadRequest(err))
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	var requestFormat cart.CartItemQuantityRequestFormat
+	err = decoder.Decode(&requestFormat)
+	if err != nil {
+		response.WithError(w, failure.BadRequest(err))
+		return
+	}
+
+	err = shared.GetValidator().Struct(requestFormat)
+	if err != nil {
+		response.WithError(w, failure.BadRequest(err))
+		return
+	}
+
+	actor, ok := resolveActor(w, r)
 	if !ok {
-		response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
+		return
 	}
 
-	id, err := uuid.FromString(claims.UserId)
+	cart, err := h.CartService.UpdateCartItem(productID, requestFormat, actor.UserID)
+	if err != nil {
+		response.WithError(w, err)
+		return
+	}
+
+	response.WithJSON(w, http.StatusOK, cart)
+}
+
+// RemoveCartItem removes a product from the caller's cart.
+// @Summary Remove a cart item.
+// @Description This endpoint removes a product from the cart.
+// @Tags v1/Carts
+// @Security JWTToken
+// @Param product_id path string true "The Product's identifier."
+// @Produce json
+// @Success 200 {object} response.Base{data=cart.CartResponseFormat}
+// @Failure 400 {object} response.Base
+// @Failure 404 {object} response.Base
+// @Failure 429 {object} response.Base
+// @Failure 500 {object} response.Base
+// @Router /v1/carts/items/{product_id} [delete]
+func (h *CartHandler) RemoveCartItem(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.FromString(chi.URLParam(r, "product_id"))
 	if err != nil {
 		response.WithError(w, failure.BadRequest(err))
 		return
 	}
 
-	order, err := h.CartService.Checkout(requestFormat, id, cartID, claims.Role )
+	actor, ok := resolveActor(w, r)
+	if !ok {
+		return
+	}
+
+	cart, err := h.CartService.RemoveCartItem(productID, actor.UserID)
 	if err != nil {
 		response.WithError(w, err)
 		return
 	}
 
-	response.WithJSON(w, http.StatusCreated, order)
+	response.WithJSON(w, http.StatusOK, cart)
+}
+
+// ClearCart removes every item from the caller's cart.
+// @Summary Clear the cart.
+// @Description This endpoint removes every item from the cart.
+// @Tags v1/Carts
+// @Security JWTToken
+// @Produce json
+// @Success 200 {object} response.Base{data=cart.CartResponseFormat}
+// @Failure 400 {object} response.Base
+// @Failure 404 {object} response.Base
+// @Failure 429 {object} response.Base
+// @Failure 500 {object} response.Base
+// @Router /v1/carts/items [delete]
+func (h *CartHandler) ClearCart(w http.ResponseWriter, r *http.Request) {
+	actor, ok := resolveActor(w, r)
+	if !ok {
+		return
+	}
+
+	cart, err := h.CartService.ClearCart(actor.UserID)
+	if err != nil {
+		response.WithError(w, err)
+		return
+	}
+
+	response.WithJSON(w, http.StatusOK, cart)
 }
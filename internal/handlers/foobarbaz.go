@@ -32,12 +32,12 @@ func ProvideFooBarBazHandler(fooService foobarbaz.FooService, authMiddleware *mi
 func (h *FooBarBazHandler) Router(r chi.Router) {
 	r.Route("/foobarbaz", func(r chi.Router) {
 		r.Group(func(r chi.Router) {
-			r.Use(h.AuthMiddleware.ClientCredential)
+			r.Use(h.AuthMiddleware.ClientCredential, h.AuthMiddleware.RequireScope("foo:read"))
 			r.Get("/foo/{id}", h.ResolveFooByID)
 		})
 
 		r.Group(func(r chi.Router) {
-			r.Use(h.AuthMiddleware.Password)
+			r.Use(h.AuthMiddleware.Password, h.AuthMiddleware.RequireScope("foo:write"))
 			r.Post("/foo", h.CreateFoo)
 			r.Delete("/foo/{id}", h.SoftDeleteFoo)
 			r.Put("/foo/{id}", h.UpdateFoo)
@@ -55,6 +55,7 @@ func (h *FooBarBazHandler) Router(r chi.Router) {
 // @Produce json
 // @Success 201 {object} response.Base{data=foobarbaz.FooResponseFormat}
 // @Failure 400 {object} response.Base
+// @Failure 403 {object} response.Base
 // @Failure 409 {object} response.Base
 // @Failure 500 {object} response.Base
 // @Router /v1/foobarbaz/foo [post]
@@ -94,6 +95,7 @@ func (h *FooBarBazHandler) CreateFoo(w http.ResponseWriter, r *http.Request) {
 // @Produce json
 // @Success 200 {object} response.Base{data=foobarbaz.FooResponseFormat}
 // @Failure 400 {object} response.Base
+// @Failure 403 {object} response.Base
 // @Failure 404 {object} response.Base
 // @Failure 500 {object} response.Base
 // @Router /v1/foobarbaz/foo/{id} [get]
@@ -126,6 +128,7 @@ func (h *FooBarBazHandler) ResolveFooByID(w http.ResponseWriter, r *http.Request
 // @Produce json
 // @Success 200 {object} response.Base{data=foobarbaz.FooResponseFormat}
 // @Failure 400 {object} response.Base
+// @Failure 403 {object} response.Base
 // @Failure 409 {object} response.Base
 // @Failure 500 {object} response.Base
 // @Router /v1/foobarbaz/foo/{id} [delete]
@@ -158,6 +161,7 @@ func (h *FooBarBazHandler) SoftDeleteFoo(w http.ResponseWriter, r *http.Request)
 // @Produce json
 // @Success 200 {object} response.Base{data=foobarbaz.FooResponseFormat}
 // @Failure 400 {object} response.Base
+// @Failure 403 {object} response.Base
 // @Failure 409 {object} response.Base
 // @Failure 500 {object} response.Base
 // @Router /v1/foobarbaz/foo/{id} [put]
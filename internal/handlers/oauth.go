@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"github.com/evermos/boilerplate-go/infras"
+	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/evermos/boilerplate-go/shared/oauth"
+	"github.com/go-chi/chi"
+)
+
+// OAuthHandler is the HTTP handler of the OAuth 2.0 authorization server. It
+// speaks the form encoded requests and bare JSON responses of RFC 6749 rather
+// than the response.Base envelope.
+type OAuthHandler struct {
+	Token *oauth.Token
+}
+
+// ProvideOAuthHandler is the provider for this handler.
+func ProvideOAuthHandler(db *infras.MySQLConn, config oauth.Config) OAuthHandler {
+	return OAuthHandler{
+		Token: oauth.New(db.Write, config),
+	}
+}
+
+// Router sets up the router for this domain.
+func (h *OAuthHandler) Router(r chi.Router) {
+	r.Route("/oauth", func(r chi.Router) {
+		r.Post("/authorize", h.Authorize)
+		r.Post("/token", h.CreateToken)
+		r.Post("/introspect", h.IntrospectToken)
+		r.Post("/revoke", h.RevokeToken)
+	})
+}
+
+// Authorize issues an authorization code.
+// @Summary Issue an authorization code.
+// @Description This endpoint is the authorization endpoint of the authorization_code grant with
+// @Description PKCE, as defined by RFC 6749 and RFC 7636. The storefront's login form posts the
+// @Description user's credentials here, and the user agent is redirected to redirect_uri with a
+// @Description code, or with an error, and the state. Requests with an unknown client or an
+// @Description unregistered redirect_uri are answered with an error instead.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Param response_type formData string true "code"
+// @Param client_id formData string true "the client"
+// @Param redirect_uri formData string false "one of the client's redirect URIs, required when it has several"
+// @Param state formData string false "returned unchanged with the redirect"
+// @Param scope formData string false "space separated scopes, defaults to every scope of the client"
+// @Param code_challenge formData string true "BASE64URL(SHA256(code_verifier))"
+// @Param code_challenge_method formData string true "S256"
+// @Param username formData string true "the user's email or telephone"
+// @Param password formData string true "the user's password"
+// @Success 302
+// @Failure 400 {object} oauth.Error
+// @Failure 500 {object} oauth.Error
+// @Router /oauth/authorize [post]
+func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	err := parseForm(r)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+
+	redirect, err := h.Token.Authorize(oauth.AuthorizationRequest{
+		ResponseType:        r.PostForm.Get("response_type"),
+		ClientID:            r.PostForm.Get("client_id"),
+		RedirectURI:         r.PostForm.Get("redirect_uri"),
+		State:               r.PostForm.Get("state"),
+		CodeChallenge:       r.PostForm.Get("code_challenge"),
+		CodeChallengeMethod: r.PostForm.Get("code_challenge_method"),
+		Scope:               r.PostForm.Get("scope"),
+		Username:            r.PostForm.Get("username"),
+		Password:            r.PostForm.Get("password"),
+	})
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// CreateToken issues an access token.
+// @Summary Issue an access token.
+// @Description This endpoint issues an access token for the client_credentials, password,
+// @Description authorization_code and refresh_token grants, as defined by RFC 6749. The client
+// @Description authenticates with HTTP Basic authentication or with client_id and client_secret
+// @Description in the form; public clients send only client_id. Refresh tokens are rotated:
+// @Description each one can be used once, and using one again revokes every token derived from
+// @Description the same login.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Param grant_type formData string true "client_credentials, password, authorization_code or refresh_token"
+// @Param client_id formData string false "required without HTTP Basic authentication"
+// @Param client_secret formData string false "required without HTTP Basic authentication"
+// @Param username formData string false "required for the password grant"
+// @Param password formData string false "required for the password grant"
+// @Param refresh_token formData string false "required for the refresh_token grant"
+// @Param code formData string false "required for the authorization_code grant"
+// @Param redirect_uri formData string false "required for the authorization_code grant if sent to /oauth/authorize"
+// @Param code_verifier formData string false "required for the authorization_code grant"
+// @Param scope formData string false "space separated scopes, narrowed to those of the client or of the refresh token"
+// @Produce json
+// @Success 200 {object} oauth.TokenResponse
+// @Failure 400 {object} oauth.Error
+// @Failure 401 {object} oauth.Error
+// @Failure 500 {object} oauth.Error
+// @Router /oauth/token [post]
+func (h *OAuthHandler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	credential, err := parseClientCredential(r)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+
+	if r.PostForm.Get("grant_type") == "" {
+		writeOAuthError(w, r, oauth.NewError(oauth.ErrCodeInvalidRequest, "grant_type is required"))
+		return
+	}
+
+	credential.GrantType = oauth.GrantType(r.PostForm.Get("grant_type"))
+	credential.Username = r.PostForm.Get("username")
+	credential.Password = r.PostForm.Get("password")
+	credential.RefreshToken = r.PostForm.Get("refresh_token")
+	credential.Code = r.PostForm.Get("code")
+	credential.RedirectURI = r.PostForm.Get("redirect_uri")
+	credential.CodeVerifier = r.PostForm.Get("code_verifier")
+	credential.Scope = r.PostForm.Get("scope")
+
+	token, err := h.Token.Create(credential)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+
+	writeOAuthJSON(w, http.StatusOK, token)
+}
+
+// IntrospectToken reports whether an access token is active.
+// @Summary Introspect an access token.
+// @Description This endpoint reports whether an access token is active and, if so, who it
+// @Description was issued to, as defined by RFC 7662. The caller authenticates as a client.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Param token formData string true "the access token"
+// @Param token_type_hint formData string false "access_token"
+// @Produce json
+// @Success 200 {object} oauth.Introspection
+// @Failure 400 {object} oauth.Error
+// @Failure 401 {object} oauth.Error
+// @Failure 500 {object} oauth.Error
+// @Router /oauth/introspect [post]
+func (h *OAuthHandler) IntrospectToken(w http.ResponseWriter, r *http.Request) {
+	credential, err := parseClientCredential(r)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, r, oauth.NewError(oauth.ErrCodeInvalidRequest, "token is required"))
+		return
+	}
+
+	introspection, err := h.Token.Introspect(credential, token)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+
+	writeOAuthJSON(w, http.StatusOK, introspection)
+}
+
+// RevokeToken revokes an access token or a refresh token.
+// @Summary Revoke an access token or a refresh token.
+// @Description This endpoint revokes a token issued to the calling client, as defined by
+// @Description RFC 7009. Revoking a refresh token also revokes the tokens issued with it.
+// @Description Revoking an unknown or already revoked token succeeds.
+// @Tags oauth
+// @Accept x-www-form-urlencoded
+// @Param token formData string true "the access token or refresh token"
+// @Param token_type_hint formData string false "access_token or refresh_token"
+// @Success 200
+// @Failure 400 {object} oauth.Error
+// @Failure 401 {object} oauth.Error
+// @Failure 500 {object} oauth.Error
+// @Router /oauth/revoke [post]
+func (h *OAuthHandler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	credential, err := parseClientCredential(r)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	if token == "" {
+		writeOAuthError(w, r, oauth.NewError(oauth.ErrCodeInvalidRequest, "token is required"))
+		return
+	}
+
+	err = h.Token.Revoke(credential, token)
+	if err != nil {
+		writeOAuthError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseClientCredential parses the form encoded body and reads the client
+// credentials from HTTP Basic authentication, or else from the body. Using
+// both at once is rejected, as RFC 6749 section 2.3 requires.
+func parseClientCredential(r *http.Request) (credential oauth.Credential, err error) {
+	err = parseForm(r)
+	if err != nil {
+		return
+	}
+
+	clientID, clientSecret, basic := r.BasicAuth()
+	if !basic {
+		credential.ClientID = r.PostForm.Get("client_id")
+		credential.ClientSecret = r.PostForm.Get("client_secret")
+		return
+	}
+
+	if r.PostForm.Get("client_secret") != "" {
+		err = oauth.NewError(oauth.ErrCodeInvalidRequest, "Client credentials must be sent only once")
+		return
+	}
+
+	// Basic credentials are form encoded before being base64 encoded.
+	credential.ClientID, err = url.QueryUnescape(clientID)
+	if err == nil {
+		credential.ClientSecret, err = url.QueryUnescape(clientSecret)
+	}
+	if err != nil {
+		err = oauth.NewError(oauth.ErrCodeInvalidClient, oauth.ErrorInvalidClient)
+	}
+
+	return
+}
+
+// parseForm parses the form encoded body, which the OAuth endpoints require.
+func parseForm(r *http.Request) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" {
+		return oauth.NewError(oauth.ErrCodeInvalidRequest, "Content-Type must be application/x-www-form-urlencoded")
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return oauth.NewError(oauth.ErrCodeInvalidRequest, err.Error())
+	}
+
+	return nil
+}
+
+// writeOAuthError writes an error response as defined by RFC 6749 section
+// 5.2. Errors that are not OAuth errors are logged and reported as
+// server_error.
+func writeOAuthError(w http.ResponseWriter, r *http.Request, err error) {
+	oauthErr, ok := err.(*oauth.Error)
+	if !ok {
+		logger.ErrorWithStack(err)
+		oauthErr = oauth.NewError(oauth.ErrCodeServerError, "")
+	}
+
+	status := http.StatusBadRequest
+	switch oauthErr.Code {
+	case oauth.ErrCodeInvalidClient:
+		status = http.StatusUnauthorized
+		if _, _, basic := r.BasicAuth(); basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+	case oauth.ErrCodeServerError:
+		status = http.StatusInternalServerError
+	}
+
+	writeOAuthJSON(w, status, oauthErr)
+}
+
+func writeOAuthJSON(w http.ResponseWriter, code int, payload interface{}) {
+	body, _ := json.Marshal(payload)
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(code)
+	_, err := w.Write(body)
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+}
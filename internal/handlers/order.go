@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
-	"strconv"
 
 	"github.com/evermos/boilerplate-go/internal/domain/order"
 	"github.com/evermos/boilerplate-go/shared"
 	"github.com/evermos/boilerplate-go/shared/failure"
+	"github.com/evermos/boilerplate-go/shared/pagination"
+	"github.com/evermos/boilerplate-go/shared/policy"
 	"github.com/evermos/boilerplate-go/transport/http/middleware"
 	"github.com/evermos/boilerplate-go/transport/http/response"
 	"github.com/go-chi/chi"
@@ -31,59 +33,173 @@ func (h *OrderHandler) Router(r chi.Router) {
 		r.Group(func(r chi.Router) {
 			r.Use(h.AuthMiddleware.ValidateJWT)
 			r.Get("/", h.ResolveAllOrder)
+			r.Get("/{id}", h.ResolveOrderByID)
+			r.Post("/{id}/cancel", h.CancelOrder)
+		})
+
+		r.Group(func(r chi.Router) {
+			r.Use(h.AuthMiddleware.ValidateJWT)
+			r.Use(h.AuthMiddleware.Require(policy.OrderTransition))
+			r.Patch("/{id}/status", h.UpdateOrderStatus)
 		})
 
 	})
 }
 
 // @Summary Resolve All Order align with role
-// @Description This endpoint resolves All order which align with role.
+// @Description This endpoint resolves All order which align with role. Callers without
+// @Description the order:read:any permission only see their own orders.
+// @Description The meta block carries page, limit, total_items, total_pages and has_next,
+// @Description plus next_cursor and prev_cursor for walking the listing with cursors.
 // @Tags v1/Orders
 // @Security JWTToken
-// @Param page query int true "must greater or equeal to zero"
+// @Param page query int false "must greater or equeal to zero, required without cursor"
 // @Param limit query int true "must greater than zero"
+// @Param cursor query string false "next_cursor or prev_cursor of a previous page"
 // @Produce json
-// @Success 200 {object} response.Base{data=product.ProductResponseFormat}
+// @Success 200 {object} response.Base{data=[]order.OrderResponseFormat,meta=response.Meta}
 // @Failure 400 {object} response.Base
 // @Failure 404 {object} response.Base
 // @Failure 500 {object} response.Base
 // @Router /v1/orders [get]
 func (h *OrderHandler) ResolveAllOrder(w http.ResponseWriter, r *http.Request)  {
-	pageStr := r.URL.Query().Get("page")
-	pageInt, err := strconv.Atoi(pageStr)
+	pageRequest, err := pagination.RequestFromQuery(r.URL.Query())
 	if err != nil {
-		response.WithMessage(w, http.StatusBadRequest, "Must have page queryparam")
-		return	
+		response.WithMessage(w, http.StatusBadRequest, err.Error())
+		return
 	}
-	if pageInt < 0 {
-		response.WithMessage(w, http.StatusBadRequest, "page must be equal or greater to zero")
+
+	actor, ok := resolveActor(w, r)
+	if !ok {
 		return
 	}
-	limitStr := r.URL.Query().Get("limit")
-	limitInt, err := strconv.Atoi(limitStr)
+
+	orders, page, err := h.OrderService.ResolveAllOrder(actor, pageRequest)
 	if err != nil {
-		response.WithMessage(w, http.StatusBadRequest, "Must have limit queryparam")
-		return	
+		response.WithError(w, failure.BadRequest(err))
+		return
 	}
-	
-	claims, ok := r.Context().Value(middleware.ClaimsKey("claims")).(shared.Claims)
+
+	response.WithPaginatedJSON(w, http.StatusOK, orders, page)
+}
+
+// @Summary Resolve Order by ID
+// @Description This endpoint resolves an Order with its items by its ID.
+// @Description Callers without the order:read:any permission can only resolve their own orders.
+// @Tags v1/Orders
+// @Security JWTToken
+// @Param id path string true "The Order's identifier."
+// @Produce json
+// @Success 200 {object} response.Base{data=order.OrderResponseFormat}
+// @Failure 400 {object} response.Base
+// @Failure 404 {object} response.Base
+// @Failure 500 {object} response.Base
+// @Router /v1/orders/{id} [get]
+func (h *OrderHandler) ResolveOrderByID(w http.ResponseWriter, r *http.Request) {
+	idString := chi.URLParam(r, "id")
+	id, err := uuid.FromString(idString)
+	if err != nil {
+		response.WithError(w, failure.BadRequest(err))
+		return
+	}
+
+	actor, ok := resolveActor(w, r)
 	if !ok {
-		response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
+		return
 	}
 
-	id, err := uuid.FromString(claims.UserId)
+	order, err := h.OrderService.ResolveOrderByID(id, actor)
+	if err != nil {
+		response.WithError(w, err)
+		return
+	}
+
+	response.WithJSON(w, http.StatusOK, order)
+}
+
+// @Summary Update an Order's status.
+// @Description This endpoint moves an Order to a new status. Only transitions
+// @Description allowed by the Order's state machine are accepted. Requires the
+// @Description order:transition permission.
+// @Tags v1/Orders
+// @Security JWTToken
+// @Param id path string true "The Order's identifier."
+// @Param status body order.OrderStatusRequestFormat true "The new status."
+// @Produce json
+// @Success 200 {object} response.Base{data=order.OrderResponseFormat}
+// @Failure 400 {object} response.Base
+// @Failure 403 {object} response.Base
+// @Failure 404 {object} response.Base
+// @Failure 409 {object} response.Base
+// @Failure 500 {object} response.Base
+// @Router /v1/orders/{id}/status [patch]
+func (h *OrderHandler) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	idString := chi.URLParam(r, "id")
+	id, err := uuid.FromString(idString)
 	if err != nil {
 		response.WithError(w, failure.BadRequest(err))
 		return
 	}
 
-	orders, err := h.OrderService.ResolveAllOrder(id, claims.Role, pageInt, limitInt)
+	decoder := json.NewDecoder(r.Body)
+	var requestFormat order.OrderStatusRequestFormat
+	err = decoder.Decode(&requestFormat)
 	if err != nil {
 		response.WithError(w, failure.BadRequest(err))
 		return
 	}
 
-	response.WithJSON(w, http.StatusOK, orders)
+	err = shared.GetValidator().Struct(requestFormat)
+	if err != nil {
+		response.WithError(w, failure.BadRequest(err))
+		return
+	}
+
+	actor, ok := resolveActor(w, r)
+	if !ok {
+		return
+	}
+
+	order, err := h.OrderService.UpdateStatus(id, requestFormat, actor.UserID)
+	if err != nil {
+		response.WithError(w, err)
+		return
+	}
+
+	response.WithJSON(w, http.StatusOK, order)
 }
 
+// @Summary Cancel an Order.
+// @Description This endpoint cancels a pending Order owned by the caller.
+// @Tags v1/Orders
+// @Security JWTToken
+// @Param id path string true "The Order's identifier."
+// @Produce json
+// @Success 200 {object} response.Base{data=order.OrderResponseFormat}
+// @Failure 400 {object} response.Base
+// @Failure 401 {object} response.Base
+// @Failure 404 {object} response.Base
+// @Failure 409 {object} response.Base
+// @Failure 500 {object} response.Base
+// @Router /v1/orders/{id}/cancel [post]
+func (h *OrderHandler) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	idString := chi.URLParam(r, "id")
+	id, err := uuid.FromString(idString)
+	if err != nil {
+		response.WithError(w, failure.BadRequest(err))
+		return
+	}
+
+	actor, ok := resolveActor(w, r)
+	if !ok {
+		return
+	}
+
+	order, err := h.OrderService.Cancel(id, actor)
+	if err != nil {
+		response.WithError(w, err)
+		return
+	}
 
+	response.WithJSON(w, http.StatusOK, order)
+}
@@ -4,25 +4,31 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/evermos/boilerplate-go/internal/domain/product"
 	"github.com/evermos/boilerplate-go/shared"
 	"github.com/evermos/boilerplate-go/shared/failure"
+	"github.com/evermos/boilerplate-go/shared/pagination"
+	"github.com/evermos/boilerplate-go/shared/policy"
 	"github.com/evermos/boilerplate-go/transport/http/middleware"
 	"github.com/evermos/boilerplate-go/transport/http/response"
 	"github.com/go-chi/chi"
 	"github.com/gofrs/uuid"
+	"github.com/guregu/null"
 )
 
 type ProductHandler struct {
 	ProductService product.ProductService
 	AuthMiddleware *middleware.Authentication
+	Idempotency    *middleware.Idempotency
 }
 
-func ProvideProductHandler(productService product.ProductService, authMiddleware *middleware.Authentication) ProductHandler {
+func ProvideProductHandler(productService product.ProductService, authMiddleware *middleware.Authentication, idempotency *middleware.Idempotency) ProductHandler {
 	return ProductHandler{
 		ProductService: productService,
 		AuthMiddleware: authMiddleware,
+		Idempotency:    idempotency,
 	}
 }
 
@@ -34,8 +40,11 @@ func (h *ProductHandler) Router(r chi.Router) {
 
 		r.Group(func(r chi.Router) {
 			r.Use(h.AuthMiddleware.ValidateJWT)
-			r.Use(h.AuthMiddleware.RoleAdminCheck)
-			r.Post("/", h.CreateProduct)
+			r.Use(h.AuthMiddleware.Require(policy.ProductWrite))
+			r.With(h.Idempotency.Handle).Post("/", h.CreateProduct)
+			r.Put("/{id}", h.UpdateProduct)
+			r.Delete("/{id}", h.SoftDeleteProduct)
+			r.Post("/{id}/restore", h.RestoreProduct)
 		})
 
 	})
@@ -47,10 +56,13 @@ func (h *ProductHandler) Router(r chi.Router) {
 // @Tags v1/Products
 // @Security JWTToken
 // @Param foo body product.ProductRequestFormat true "The Product to be created."
+// @Param Idempotency-Key header string false "retries with the same key replay the first response"
 // @Produce json
 // @Success 201 {object} response.Base{data=product.ProductResponseFormat}
 // @Failure 400 {object} response.Base
+// @Failure 403 {object} response.Base
 // @Failure 409 {object} response.Base
+// @Failure 422 {object} response.Base
 // @Failure 500 {object} response.Base
 // @Router /v1/products [post]
 func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
@@ -62,18 +74,12 @@ func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	claims, ok := r.Context().Value(middleware.ClaimsKey("claims")).(shared.Claims)
+	actor, ok := resolveActor(w, r)
 	if !ok {
-		response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
-	}
-
-	id, err := uuid.FromString(claims.UserId)
-	if err != nil {
-		response.WithError(w, failure.BadRequest(err))
 		return
 	}
 
-	product, err := h.ProductService.Create(requestFormat, id )
+	product, err := h.ProductService.Create(requestFormat, actor.UserID)
 	if err != nil {
 		response.WithError(w, err)
 		return
@@ -84,45 +90,207 @@ func (h *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 
 
 // @Summary Resolve All Products
-// @Description This endpoint resolves All products with pagination page and limit.
+// @Description This endpoint resolves All products with pagination page and limit,
+// @Description optionally filtered, sorted and searched by keyword.
+// @Description The meta block carries page, limit, total_items, total_pages and has_next,
+// @Description plus next_cursor and prev_cursor for walking the listing with cursors.
 // @Tags v1/Products
-// @Param page query int true "must greater or equeal to zero"
+// @Param page query int false "must greater or equeal to zero, required without cursor"
 // @Param limit query int true "must greater than zero"
+// @Param cursor query string false "next_cursor or prev_cursor of a previous page, not supported for relevance sort"
+// @Param q query string false "keyword searched across name and description"
+// @Param category query string false "exact category"
+// @Param brand query string false "exact brand"
+// @Param min_price query number false "minimum price, inclusive"
+// @Param max_price query number false "maximum price, inclusive"
+// @Param in_stock query bool false "only products with stock left"
+// @Param sort query string false "name (default), price_asc, price_desc, newest or relevance"
 // @Produce json
-// @Success 200 {object} response.Base{data=product.ProductResponseFormat}
+// @Success 200 {object} response.Base{data=[]product.ProductResponseFormat,meta=response.Meta}
 // @Failure 400 {object} response.Base
 // @Failure 404 {object} response.Base
 // @Failure 500 {object} response.Base
 // @Router /v1/products [get]
 func (h *ProductHandler) ResolveAllProducts(w http.ResponseWriter, r *http.Request) {
-	pageStr := r.URL.Query().Get("page")
-	pageInt, err := strconv.Atoi(pageStr)
+	pageRequest, err := pagination.RequestFromQuery(r.URL.Query())
+	if err != nil {
+		response.WithMessage(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter, err := productFilterFromQuery(r)
+	if err != nil {
+		response.WithError(w, failure.BadRequest(err))
+		return
+	}
+	filter.Page = pageRequest.Page
+	filter.Limit = pageRequest.Limit
+	filter.Cursor = pageRequest.Cursor
+
+	products, page, err := h.ProductService.ResolveAllProducts(filter)
+	if err != nil {
+		response.WithError(w, err)
+		return
+	}
+
+	response.WithPaginatedJSON(w, http.StatusOK, products, page)
+}
+
+// UpdateProduct updates a Product.
+// @Summary Update a Product.
+// @Description This endpoint updates an existing Product.
+// @Tags v1/Products
+// @Security JWTToken
+// @Param id path string true "The Product's identifier."
+// @Param product body product.ProductRequestFormat true "The Product to be updated."
+// @Produce json
+// @Success 200 {object} response.Base{data=product.ProductResponseFormat}
+// @Failure 400 {object} response.Base
+// @Failure 403 {object} response.Base
+// @Failure 404 {object} response.Base
+// @Failure 500 {object} response.Base
+// @Router /v1/products/{id} [put]
+func (h *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	idString := chi.URLParam(r, "id")
+	id, err := uuid.FromString(idString)
 	if err != nil {
-		response.WithMessage(w, http.StatusBadRequest, "Must have page queryparam")
-		return	
+		response.WithError(w, failure.BadRequest(err))
+		return
 	}
-	if pageInt < 0 {
-		response.WithMessage(w, http.StatusBadRequest, "page must be equal or greater to zero")
+
+	decoder := json.NewDecoder(r.Body)
+	var requestFormat product.ProductRequestFormat
+	err = decoder.Decode(&requestFormat)
+	if err != nil {
+		response.WithError(w, failure.BadRequest(err))
 		return
 	}
-	limitStr := r.URL.Query().Get("limit")
-	limitInt, err := strconv.Atoi(limitStr)
+
+	err = shared.GetValidator().Struct(requestFormat)
 	if err != nil {
-		response.WithMessage(w, http.StatusBadRequest, "Must have limit queryparam")
-		return	
+		response.WithError(w, failure.BadRequest(err))
+		return
 	}
-	if limitInt <= 0 {
-		response.WithMessage(w, http.StatusBadRequest, "limit must be greater to zero")
+
+	actor, ok := resolveActor(w, r)
+	if !ok {
 		return
 	}
-	products, err := h.ProductService.ResolveAllProducts(pageInt, limitInt)
+
+	product, err := h.ProductService.Update(id, requestFormat, actor.UserID)
 	if err != nil {
 		response.WithError(w, err)
 		return
 	}
 
-	response.WithJSON(w, http.StatusCreated, products)
+	response.WithJSON(w, http.StatusOK, product)
 }
 
+// SoftDeleteProduct marks a Product as deleted.
+// @Summary Marks a Product as deleted.
+// @Description This endpoint marks an existing Product as deleted. This is done by
+// @Description setting the "deleted_at" and "deleted_by" properties of the Product.
+// @Tags v1/Products
+// @Security JWTToken
+// @Param id path string true "The Product's identifier."
+// @Produce json
+// @Success 200 {object} response.Base{data=product.ProductResponseFormat}
+// @Failure 400 {object} response.Base
+// @Failure 403 {object} response.Base
+// @Failure 404 {object} response.Base
+// @Failure 409 {object} response.Base
+// @Failure 500 {object} response.Base
+// @Router /v1/products/{id} [delete]
+func (h *ProductHandler) SoftDeleteProduct(w http.ResponseWriter, r *http.Request) {
+	idString := chi.URLParam(r, "id")
+	id, err := uuid.FromString(idString)
+	if err != nil {
+		response.WithError(w, failure.BadRequest(err))
+		return
+	}
+
+	actor, ok := resolveActor(w, r)
+	if !ok {
+		return
+	}
+
+	product, err := h.ProductService.SoftDelete(id, actor.UserID)
+	if err != nil {
+		response.WithError(w, err)
+		return
+	}
 
+	response.WithJSON(w, http.StatusOK, product)
+}
 
+// RestoreProduct restores a soft-deleted Product.
+// @Summary Restore a deleted Product.
+// @Description This endpoint clears the "deleted_at" and "deleted_by" properties
+// @Description of a Product, making it available again.
+// @Tags v1/Products
+// @Security JWTToken
+// @Param id path string true "The Product's identifier."
+// @Produce json
+// @Success 200 {object} response.Base{data=product.ProductResponseFormat}
+// @Failure 400 {object} response.Base
+// @Failure 403 {object} response.Base
+// @Failure 404 {object} response.Base
+// @Failure 409 {object} response.Base
+// @Failure 500 {object} response.Base
+// @Router /v1/products/{id}/restore [post]
+func (h *ProductHandler) RestoreProduct(w http.ResponseWriter, r *http.Request) {
+	idString := chi.URLParam(r, "id")
+	id, err := uuid.FromString(idString)
+	if err != nil {
+		response.WithError(w, failure.BadRequest(err))
+		return
+	}
+
+	actor, ok := resolveActor(w, r)
+	if !ok {
+		return
+	}
+
+	product, err := h.ProductService.Restore(id, actor.UserID)
+	if err != nil {
+		response.WithError(w, err)
+		return
+	}
+
+	response.WithJSON(w, http.StatusOK, product)
+}
+
+// productFilterFromQuery reads the optional catalogue filters from the query string.
+func productFilterFromQuery(r *http.Request) (filter product.ProductFilter, err error) {
+	query := r.URL.Query()
+	filter = product.ProductFilter{
+		Keyword:  strings.TrimSpace(query.Get("q")),
+		Category: query.Get("category"),
+		Brand:    query.Get("brand"),
+		Sort:     product.ProductSort(query.Get("sort")),
+	}
+
+	if v := query.Get("min_price"); v != "" {
+		minPrice, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return filter, err
+		}
+		filter.MinPrice = null.FloatFrom(minPrice)
+	}
+
+	if v := query.Get("max_price"); v != "" {
+		maxPrice, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return filter, err
+		}
+		filter.MaxPrice = null.FloatFrom(maxPrice)
+	}
+
+	if v := query.Get("in_stock"); v != "" {
+		filter.InStockOnly, err = strconv.ParseBool(v)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/evermos/boilerplate-go/infras"
+	"github.com/evermos/boilerplate-go/shared/failure"
+	"github.com/evermos/boilerplate-go/shared/oauth"
+	"github.com/evermos/boilerplate-go/transport/http/middleware"
+	"github.com/evermos/boilerplate-go/transport/http/response"
+	"github.com/go-chi/chi"
+)
+
+// SessionHandler is the HTTP handler for the OAuth sessions of the current
+// user.
+type SessionHandler struct {
+	Token          *oauth.Token
+	AuthMiddleware *middleware.Authentication
+}
+
+// ProvideSessionHandler is the provider for this handler.
+func ProvideSessionHandler(db *infras.MySQLConn, config oauth.Config, authMiddleware *middleware.Authentication) SessionHandler {
+	return SessionHandler{
+		Token:          oauth.New(db.Write, config),
+		AuthMiddleware: authMiddleware,
+	}
+}
+
+// Router sets up the router for this domain.
+func (h *SessionHandler) Router(r chi.Router) {
+	r.Route("/me/sessions", func(r chi.Router) {
+		r.Use(h.AuthMiddleware.Password, h.AuthMiddleware.RequireScope("sessions"))
+		r.Get("/", h.ResolveSessions)
+		r.Delete("/{id}", h.RevokeSession)
+	})
+}
+
+// ResolveSessions lists the active sessions of the current user.
+// @Summary List the current user's sessions.
+// @Description This endpoint lists the active OAuth access tokens of the user the access
+// @Description token was issued to. The session of the access token itself is marked current.
+// @Tags v1/Sessions
+// @Security EVMOauthToken
+// @Param client_id query string false "only the sessions of this client"
+// @Produce json
+// @Success 200 {object} response.Base{data=[]oauth.Session}
+// @Failure 401 {object} response.Base
+// @Failure 403 {object} response.Base
+// @Failure 500 {object} response.Base
+// @Router /v1/me/sessions [get]
+func (h *SessionHandler) ResolveSessions(w http.ResponseWriter, r *http.Request) {
+	current, ok := r.Context().Value(middleware.ClaimsKey("token")).(oauth.OauthAccessToken)
+	if !ok {
+		response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	sessions, err := h.Token.Sessions(current.UserID.String, r.URL.Query().Get("client_id"))
+	if err != nil {
+		response.WithError(w, failure.InternalError(err))
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current.AccessToken
+	}
+
+	response.WithJSON(w, http.StatusOK, sessions)
+}
+
+// RevokeSession revokes a session of the current user.
+// @Summary Revoke one of the current user's sessions.
+// @Description This endpoint revokes an OAuth access token of the current user, along with the
+// @Description refresh token issued with it, so that the client has to log in again.
+// @Tags v1/Sessions
+// @Security EVMOauthToken
+// @Param id path string true "The session's identifier."
+// @Produce json
+// @Success 200 {object} response.Base
+// @Failure 401 {object} response.Base
+// @Failure 403 {object} response.Base
+// @Failure 404 {object} response.Base
+// @Failure 500 {object} response.Base
+// @Router /v1/me/sessions/{id} [delete]
+func (h *SessionHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	current, ok := r.Context().Value(middleware.ClaimsKey("token")).(oauth.OauthAccessToken)
+	if !ok {
+		response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	err := h.Token.RevokeSession(current.UserID.String, chi.URLParam(r, "id"))
+	if err != nil {
+		if err.Error() == oauth.ErrorSessionNotFound {
+			response.WithError(w, failure.NotFound("session"))
+			return
+		}
+		response.WithError(w, failure.InternalError(err))
+		return
+	}
+
+	response.WithMessage(w, http.StatusOK, "Session revoked")
+}
@@ -26,10 +26,11 @@ func main() {
 	// Wire everything up
 	http := InitializeService()
 
-	// consumers := InitializeEvent()
+	consumers := InitializeEvent()
 
-	// // Start consumers
-	// consumers.Start()
+	// Start consumers, and drain them on shutdown
+	consumers.Start()
+	http.OnCleanup(consumers.Stop)
 
 	// Run server
 	http.SetupAndServe()
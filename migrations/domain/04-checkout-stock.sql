@@ -0,0 +1,4 @@
+-- Checkout decrements stock with a conditional update on atc_product; the
+-- check keeps any other write from taking it below zero.
+ALTER TABLE `atc_product`
+  ADD CONSTRAINT `chk_atc_product_stock` CHECK (`stock` >= 0);
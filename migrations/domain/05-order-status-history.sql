@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `atc_order_status_history` (
+  `id` varchar(36) NOT NULL,
+  `order_id` varchar(36) NOT NULL,
+  `from_status` enum('pending','shipping','delivered','completed','cancelled') NOT NULL,
+  `to_status` enum('pending','shipping','delivered','completed','cancelled') NOT NULL,
+  `created_at` datetime NOT NULL,
+  `created_by` varchar(36) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_atc_order_status_history_1` (`order_id`, `created_at`),
+  CONSTRAINT `fk_atc_order_status_history_order_id` FOREIGN KEY (`order_id`) REFERENCES `atc_order` (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
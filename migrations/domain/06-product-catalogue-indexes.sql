@@ -0,0 +1,6 @@
+ALTER TABLE `atc_product`
+  ADD FULLTEXT INDEX `ft_atc_product_name_description` (`name`, `description`),
+  ADD INDEX `idx_atc_product_category` (`category`),
+  ADD INDEX `idx_atc_product_brand` (`brand`),
+  ADD INDEX `idx_atc_product_price` (`price`),
+  ADD INDEX `idx_atc_product_created_at` (`created_at`);
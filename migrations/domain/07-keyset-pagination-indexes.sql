@@ -0,0 +1,7 @@
+ALTER TABLE `atc_order`
+  ADD INDEX `idx_atc_order_created_at_id` (`created_at`, `id`),
+  ADD INDEX `idx_atc_order_user_id_created_at_id` (`user_id`, `created_at`, `id`);
+
+ALTER TABLE `atc_product`
+  ADD INDEX `idx_atc_product_name_id` (`name`, `id`),
+  ADD INDEX `idx_atc_product_price_id` (`price`, `id`);
@@ -0,0 +1,13 @@
+CREATE TABLE `idempotency_key` (
+  `user_id` varchar(36) NOT NULL,
+  `idempotency_key` varchar(255) NOT NULL,
+  `fingerprint` char(64) NOT NULL,
+  `completed` tinyint(1) NOT NULL DEFAULT 0,
+  `status_code` int NOT NULL DEFAULT 0,
+  `content_type` varchar(255) NOT NULL DEFAULT '',
+  `body` mediumblob,
+  `expires_at` datetime(6) NOT NULL,
+  `created_at` datetime(6) NOT NULL,
+  PRIMARY KEY (`user_id`, `idempotency_key`),
+  KEY `idx_idempotency_key_expires_at` (`expires_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS `oauth_refresh_tokens` (
+    `refresh_token` VARCHAR(40) NOT NULL,
+    `family_id` VARCHAR(40) NOT NULL,
+    `access_token` VARCHAR(40) NOT NULL,
+    `client_id` VARCHAR(32) NOT NULL,
+    `user_id` VARCHAR(20) NULL,
+    `scope` VARCHAR(2000) NULL,
+    `expires` TIMESTAMP NOT NULL,
+    `rotated_at` TIMESTAMP NULL,
+    `revoked_at` TIMESTAMP NULL,
+    `created_at` TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (`refresh_token`),
+    KEY `idx_oauth_refresh_tokens_family_id` (`family_id`)
+) ENGINE=InnoDB
+DEFAULT CHARSET=utf8;
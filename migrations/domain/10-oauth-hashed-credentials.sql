@@ -0,0 +1,12 @@
+-- Client secrets become bcrypt hashes and tokens become SHA-256 digests. Run
+-- `go run ./cmd/oauth-migrate` after this migration to convert existing rows.
+ALTER TABLE `oauth_clients`
+    MODIFY `client_secret` VARCHAR(100) NOT NULL;
+
+ALTER TABLE `oauth_access_tokens`
+    MODIFY `access_token` VARCHAR(64) NOT NULL;
+
+ALTER TABLE `oauth_refresh_tokens`
+    MODIFY `refresh_token` VARCHAR(64) NOT NULL,
+    MODIFY `family_id` VARCHAR(64) NOT NULL,
+    MODIFY `access_token` VARCHAR(64) NOT NULL;
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS `oauth_authorization_codes` (
+    `code` VARCHAR(64) NOT NULL,
+    `client_id` VARCHAR(32) NOT NULL,
+    `user_id` VARCHAR(20) NOT NULL,
+    `redirect_uri` VARCHAR(1000) NOT NULL DEFAULT '',
+    `code_challenge` VARCHAR(128) NOT NULL,
+    `code_challenge_method` VARCHAR(10) NOT NULL,
+    `expires` TIMESTAMP NOT NULL,
+    `used_at` TIMESTAMP NULL,
+    PRIMARY KEY (`code`)
+) ENGINE=InnoDB
+DEFAULT CHARSET=utf8;
+
+-- redirect_uri holds the space separated redirect URIs registered for a
+-- client. Public clients, such as the mobile storefront, have no secret.
+UPDATE `oauth_clients`
+SET `grant_types` = CONCAT(`grant_types`, ' authorization_code')
+WHERE `client_id` = 'client_web' AND `grant_types` NOT LIKE '%authorization_code%';
+
+INSERT INTO `oauth_clients`
+(`client_id`, `client_secret`, `redirect_uri`, `grant_types`, `scope`, `user_id`)
+VALUES
+('client_mobile', '', 'com.evermos.app:/oauth/callback', 'authorization_code refresh_token', NULL, NULL);
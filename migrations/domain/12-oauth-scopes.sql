@@ -0,0 +1,14 @@
+-- oauth_clients.scope is the space separated list of scopes a client may
+-- request, and the scopes it gets when it requests none.
+ALTER TABLE `oauth_authorization_codes`
+    ADD `scope` VARCHAR(2000) NULL AFTER `code_challenge_method`;
+
+-- The scopes checked by the routes: foo:read and foo:write for
+-- /v1/foobarbaz, sessions for /v1/me/sessions.
+UPDATE `oauth_clients`
+SET `scope` = 'user foo:read foo:write sessions'
+WHERE `client_id` = 'client_web';
+
+UPDATE `oauth_clients`
+SET `scope` = 'user sessions'
+WHERE `client_id` = 'client_mobile';
@@ -0,0 +1,11 @@
+-- Indexes for purging expired tokens and for listing the sessions of a user.
+ALTER TABLE `oauth_access_tokens`
+    ADD KEY `idx_oauth_access_tokens_expires` (`expires`),
+    ADD KEY `idx_oauth_access_tokens_user_id_expires` (`user_id`, `expires`);
+
+ALTER TABLE `oauth_refresh_tokens`
+    ADD KEY `idx_oauth_refresh_tokens_expires` (`expires`),
+    ADD KEY `idx_oauth_refresh_tokens_access_token` (`access_token`);
+
+ALTER TABLE `oauth_authorization_codes`
+    ADD KEY `idx_oauth_authorization_codes_expires` (`expires`);
@@ -0,0 +1,23 @@
+-- Domain events waiting to be published by the outbox relay. Rows are written
+-- in the transaction of the domain change and published in id order per
+-- aggregate.
+CREATE TABLE `event_outbox` (
+  `id` bigint unsigned NOT NULL AUTO_INCREMENT,
+  `entity_id` char(36) NOT NULL,
+  `aggregate_type` varchar(64) NOT NULL,
+  `aggregate_id` varchar(64) NOT NULL,
+  `topic` varchar(255) NOT NULL,
+  `event_type` varchar(64) NOT NULL,
+  `payload` mediumblob NOT NULL,
+  `message_group_id` varchar(128) DEFAULT NULL,
+  `status` enum('pending','published','failed') NOT NULL DEFAULT 'pending',
+  `attempts` int NOT NULL DEFAULT 0,
+  `last_error` text,
+  `occurred_at` datetime(6) NOT NULL,
+  `available_at` datetime(6) NOT NULL,
+  `published_at` datetime(6) DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uq_event_outbox_entity_id` (`entity_id`),
+  KEY `idx_event_outbox_status_available_at` (`status`, `available_at`),
+  KEY `idx_event_outbox_aggregate` (`aggregate_type`, `aggregate_id`, `status`, `id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_0900_ai_ci;
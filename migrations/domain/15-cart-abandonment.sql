@@ -0,0 +1,5 @@
+-- Marks carts reported as abandoned, so each period of inactivity is only
+-- reported once. Any change to the cart clears it.
+ALTER TABLE `atc_cart`
+    ADD COLUMN `abandoned_at` datetime DEFAULT NULL,
+    ADD KEY `idx_atc_cart_abandoned_at_updated_at` (`abandoned_at`, `updated_at`);
@@ -0,0 +1,63 @@
+package authservice
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker is a circuit breaker. It opens after threshold consecutive failures
+// and rejects calls until cooldown has passed, then lets a single trial call
+// through: a success closes it again, a failure keeps it open for another
+// cooldown.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+// allow reports whether a call may be made.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+
+	if b.trial || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+
+	b.trial = true
+	return true
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.trial = false
+}
+
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.trial = false
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
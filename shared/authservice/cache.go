@@ -0,0 +1,137 @@
+package authservice
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/evermos/boilerplate-go/shared"
+	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/go-redis/redis"
+)
+
+const (
+	CacheMemory = "memory"
+	CacheRedis  = "redis"
+
+	// redisKeyPrefix namespaces cached claims in Redis.
+	redisKeyPrefix = "authservice:claims:"
+)
+
+// ClaimsCache caches the claims of validated tokens. Keys are token digests,
+// never the tokens themselves.
+type ClaimsCache interface {
+	Get(key string) (claims shared.Claims, ok bool)
+	Set(key string, claims shared.Claims, ttl time.Duration)
+}
+
+// MemoryCache is a ClaimsCache holding up to size entries in memory, evicting
+// the least recently used one when full.
+type MemoryCache struct {
+	size int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key       string
+	claims    shared.Claims
+	expiresAt time.Time
+}
+
+// NewMemoryCache creates a new MemoryCache.
+func NewMemoryCache(size int) *MemoryCache {
+	return &MemoryCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the claims cached for key.
+func (c *MemoryCache) Get(key string) (claims shared.Claims, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	entry := element.Value.(*memoryEntry)
+	if !time.Now().Before(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return claims, false
+	}
+
+	c.order.MoveToFront(element)
+	return entry.claims, true
+}
+
+// Set caches claims for key for ttl.
+func (c *MemoryCache) Set(key string, claims shared.Claims, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &memoryEntry{key: key, claims: claims, expiresAt: time.Now().Add(ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+// RedisCache is a ClaimsCache backed by Redis, shared by every instance of the
+// service. Failures are logged and treated as cache misses.
+type RedisCache struct {
+	Client *redis.Client
+}
+
+// NewRedisCache creates a new RedisCache.
+func NewRedisCache(client *redis.Client) *RedisCache {
+	return &RedisCache{Client: client}
+}
+
+// Get returns the claims cached for key.
+func (c *RedisCache) Get(key string) (claims shared.Claims, ok bool) {
+	value, err := c.Client.Get(redisKeyPrefix + key).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			logger.ErrorWithStack(err)
+		}
+		return
+	}
+
+	err = json.Unmarshal(value, &claims)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return claims, false
+	}
+
+	return claims, true
+}
+
+// Set caches claims for key for ttl.
+func (c *RedisCache) Set(key string, claims shared.Claims, ttl time.Duration) {
+	value, err := json.Marshal(claims)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	err = c.Client.Set(redisKeyPrefix+key, value, ttl).Err()
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+}
@@ -0,0 +1,205 @@
+package authservice
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/infras"
+	"github.com/evermos/boilerplate-go/shared"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultTimeout          = 2 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+	defaultCacheSize        = 10000
+	defaultCacheTTL         = time.Minute
+)
+
+var (
+	// ErrUnauthorized is returned when the auth service rejects a token.
+	ErrUnauthorized = errors.New("token rejected by auth service")
+	// ErrUnavailable is returned when the auth service cannot be reached, or
+	// is not called because its circuit breaker is open.
+	ErrUnavailable = errors.New("auth service unavailable")
+)
+
+// ValidateResponse is the response of the auth service's validate endpoint.
+type ValidateResponse struct {
+	Data shared.Claims `json:"data"`
+}
+
+// Options configures a Client. Zero values fall back to defaults, except for
+// MaxRetries where zero disables retries.
+type Options struct {
+	BaseURL      string
+	ValidatePath string
+	// Timeout bounds each attempt.
+	Timeout time.Duration
+	// MaxRetries is the number of retries after a failed attempt.
+	MaxRetries int
+	// BreakerThreshold is the number of consecutive failed validations that
+	// opens the circuit breaker.
+	BreakerThreshold int
+	// BreakerCooldown is how long the circuit breaker stays open.
+	BreakerCooldown time.Duration
+	// CacheTTL is how long claims without exp are cached.
+	CacheTTL time.Duration
+	// RetryInterval is the initial wait between attempts.
+	RetryInterval time.Duration
+}
+
+// Client validates tokens with the auth service. Validated claims are cached
+// until the token expires, failed calls are retried with exponential backoff,
+// and a circuit breaker stops calls to the auth service while it is down.
+type Client struct {
+	options Options
+	http    *http.Client
+	cache   ClaimsCache
+	breaker *breaker
+}
+
+// NewClient creates a new Client caching claims in cache.
+func NewClient(options Options, cache ClaimsCache) *Client {
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+	if options.MaxRetries < 0 {
+		options.MaxRetries = 0
+	}
+	if options.BreakerThreshold <= 0 {
+		options.BreakerThreshold = defaultBreakerThreshold
+	}
+	if options.BreakerCooldown <= 0 {
+		options.BreakerCooldown = defaultBreakerCooldown
+	}
+	if options.CacheTTL <= 0 {
+		options.CacheTTL = defaultCacheTTL
+	}
+	if options.RetryInterval <= 0 {
+		options.RetryInterval = backoff.DefaultInitialInterval
+	}
+
+	return &Client{
+		options: options,
+		http:    &http.Client{Timeout: options.Timeout},
+		cache:   cache,
+		breaker: newBreaker(options.BreakerThreshold, options.BreakerCooldown),
+	}
+}
+
+// ProvideClient creates a Client from configuration. The claims cache is
+// selected by APP.AUTH_SERVICE.CACHE_STORE and defaults to memory.
+func ProvideClient(config *configs.Config) *Client {
+	conf := config.App.AuthService
+
+	var cache ClaimsCache
+	switch conf.CacheStore {
+	case CacheRedis:
+		cache = NewRedisCache(infras.RedisNewClient(*config))
+	default:
+		size := conf.CacheSize
+		if size <= 0 {
+			size = defaultCacheSize
+		}
+		cache = NewMemoryCache(size)
+	}
+
+	return NewClient(Options{
+		BaseURL:          config.App.AuthServiceBaseURL,
+		ValidatePath:     config.App.AuthServiceValidatePath,
+		Timeout:          time.Duration(conf.TimeoutMillis) * time.Millisecond,
+		MaxRetries:       conf.MaxRetries,
+		BreakerThreshold: conf.BreakerThreshold,
+		BreakerCooldown:  time.Duration(conf.BreakerCooldownSeconds) * time.Second,
+		CacheTTL:         time.Duration(conf.CacheTTLSeconds) * time.Second,
+	}, cache)
+}
+
+// Validate returns the claims of a token, from the cache when it was validated
+// before. It returns ErrUnauthorized for rejected tokens and ErrUnavailable
+// when the auth service is down.
+func (c *Client) Validate(accessToken string) (claims shared.Claims, err error) {
+	key := digest(accessToken)
+	if claims, ok := c.cache.Get(key); ok {
+		return claims, nil
+	}
+
+	if !c.breaker.allow() {
+		return claims, ErrUnavailable
+	}
+
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = c.options.RetryInterval
+	err = backoff.Retry(func() (err error) {
+		claims, err = c.validate(accessToken)
+		return
+	}, backoff.WithMaxRetries(b, uint64(c.options.MaxRetries)))
+
+	if err != nil && err != ErrUnauthorized {
+		c.breaker.failure()
+		log.Warn().Err(err).Msg("Failed validating token with auth service")
+		return shared.Claims{}, ErrUnavailable
+	}
+
+	c.breaker.success()
+	if err != nil {
+		return shared.Claims{}, err
+	}
+
+	ttl := c.options.CacheTTL
+	if claims.ExpiresAt != 0 {
+		ttl = time.Until(time.Unix(claims.ExpiresAt, 0))
+	}
+	if ttl > 0 {
+		c.cache.Set(key, claims, ttl)
+	}
+
+	return claims, nil
+}
+
+// validate makes a single call to the auth service. Errors worth retrying are
+// returned as is, the others wrapped with backoff.Permanent.
+func (c *Client) validate(accessToken string) (claims shared.Claims, err error) {
+	req, err := http.NewRequest(http.MethodGet, c.options.BaseURL+c.options.ValidatePath, nil)
+	if err != nil {
+		return claims, backoff.Permanent(err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode >= http.StatusInternalServerError:
+		return claims, fmt.Errorf("auth service responded with status %d", res.StatusCode)
+	case res.StatusCode != http.StatusOK:
+		return claims, backoff.Permanent(ErrUnauthorized)
+	}
+
+	var response ValidateResponse
+	err = json.NewDecoder(res.Body).Decode(&response)
+	if err != nil {
+		return claims, backoff.Permanent(ErrUnauthorized)
+	}
+
+	return response.Data, nil
+}
+
+// digest returns the SHA-256 hex digest of a token, used as its cache key.
+func digest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
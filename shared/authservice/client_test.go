@@ -0,0 +1,164 @@
+package authservice_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/evermos/boilerplate-go/shared"
+	"github.com/evermos/boilerplate-go/shared/authservice"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+// authService is an httptest stand-in for the auth service. It accepts
+// "valid-token", answers 401 to other tokens, and fails with status while
+// status is set.
+type authService struct {
+	*httptest.Server
+	calls  int32
+	status int32
+	delay  time.Duration
+}
+
+func newAuthService() *authService {
+	s := &authService{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&s.calls, 1)
+		time.Sleep(s.delay)
+
+		if status := atomic.LoadInt32(&s.status); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer valid-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(authservice.ValidateResponse{Data: shared.Claims{
+			UserId: "u1",
+			StandardClaims: jwt.StandardClaims{
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			},
+		}})
+	}))
+	return s
+}
+
+func (s *authService) callCount() int {
+	return int(atomic.LoadInt32(&s.calls))
+}
+
+func newClient(s *authService, options authservice.Options) *authservice.Client {
+	options.BaseURL = s.URL
+	options.ValidatePath = "/v1/users/validate"
+	options.RetryInterval = time.Millisecond
+	return authservice.NewClient(options, authservice.NewMemoryCache(10))
+}
+
+func TestClientValidate(t *testing.T) {
+	t.Run("validated claims are cached", func(t *testing.T) {
+		s := newAuthService()
+		defer s.Close()
+		client := newClient(s, authservice.Options{})
+
+		for i := 0; i < 3; i++ {
+			claims, err := client.Validate("valid-token")
+			assert.NoError(t, err)
+			assert.Equal(t, "u1", claims.UserId)
+		}
+
+		assert.Equal(t, 1, s.callCount())
+	})
+
+	t.Run("rejected tokens are neither retried nor cached", func(t *testing.T) {
+		s := newAuthService()
+		defer s.Close()
+		client := newClient(s, authservice.Options{MaxRetries: 3})
+
+		_, err := client.Validate("forged-token")
+		assert.Equal(t, authservice.ErrUnauthorized, err)
+		_, err = client.Validate("forged-token")
+		assert.Equal(t, authservice.ErrUnauthorized, err)
+
+		assert.Equal(t, 2, s.callCount())
+	})
+
+	t.Run("server errors are retried", func(t *testing.T) {
+		s := newAuthService()
+		defer s.Close()
+		s.status = http.StatusBadGateway
+		client := newClient(s, authservice.Options{MaxRetries: 2})
+
+		_, err := client.Validate("valid-token")
+
+		assert.Equal(t, authservice.ErrUnavailable, err)
+		assert.Equal(t, 3, s.callCount())
+	})
+
+	t.Run("slow responses time out", func(t *testing.T) {
+		s := newAuthService()
+		defer s.Close()
+		s.delay = 200 * time.Millisecond
+		client := newClient(s, authservice.Options{Timeout: 20 * time.Millisecond})
+
+		start := time.Now()
+		_, err := client.Validate("valid-token")
+
+		assert.Equal(t, authservice.ErrUnavailable, err)
+		assert.True(t, time.Since(start) < 200*time.Millisecond)
+	})
+
+	t.Run("circuit breaker opens and recovers", func(t *testing.T) {
+		s := newAuthService()
+		defer s.Close()
+		s.status = http.StatusServiceUnavailable
+		client := newClient(s, authservice.Options{
+			BreakerThreshold: 2,
+			BreakerCooldown:  50 * time.Millisecond,
+		})
+
+		for i := 0; i < 2; i++ {
+			_, err := client.Validate("valid-token")
+			assert.Equal(t, authservice.ErrUnavailable, err)
+		}
+		assert.Equal(t, 2, s.callCount())
+
+		_, err := client.Validate("valid-token")
+		assert.Equal(t, authservice.ErrUnavailable, err)
+		assert.Equal(t, 2, s.callCount(), "an open breaker must not call the auth service")
+
+		atomic.StoreInt32(&s.status, 0)
+		time.Sleep(60 * time.Millisecond)
+
+		claims, err := client.Validate("valid-token")
+		assert.NoError(t, err)
+		assert.Equal(t, "u1", claims.UserId)
+		assert.Equal(t, 3, s.callCount())
+	})
+}
+
+func TestMemoryCache(t *testing.T) {
+	cache := authservice.NewMemoryCache(2)
+
+	cache.Set("a", shared.Claims{UserId: "a"}, time.Hour)
+	cache.Set("b", shared.Claims{UserId: "b"}, time.Hour)
+	_, _ = cache.Get("a")
+	cache.Set("c", shared.Claims{UserId: "c"}, time.Hour)
+
+	_, ok := cache.Get("b")
+	assert.False(t, ok, "least recently used entry is evicted")
+	claims, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "a", claims.UserId)
+
+	cache.Set("d", shared.Claims{UserId: "d"}, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	_, ok = cache.Get("d")
+	assert.False(t, ok, "expired entries are not returned")
+}
@@ -8,5 +8,6 @@ type Claims struct {
 	UserId   string    `json:"userId"`
 	Username string    `json:"username"`
 	Role     string    `json:"role"`
+	Scope    string    `json:"scope,omitempty"`
 	jwt.StandardClaims
 }
\ No newline at end of file
@@ -0,0 +1,58 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	// StoreMySQL keeps idempotency records in the idempotency_key table.
+	StoreMySQL = "mysql"
+	// StoreRedis keeps idempotency records in Redis.
+	StoreRedis = "redis"
+)
+
+// Key identifies an idempotent request: the client-supplied key, scoped to the
+// user that sent it.
+type Key struct {
+	UserID string
+	Key    string
+}
+
+// Record is what is remembered about an idempotent request. A record is
+// created when the request starts and completed with its response.
+type Record struct {
+	Fingerprint string    `db:"fingerprint" json:"fingerprint"`
+	Completed   bool      `db:"completed" json:"completed"`
+	StatusCode  int       `db:"status_code" json:"status_code"`
+	ContentType string    `db:"content_type" json:"content_type"`
+	Body        []byte    `db:"body" json:"body"`
+	ExpiresAt   time.Time `db:"expires_at" json:"expires_at"`
+}
+
+// Store persists idempotency records.
+type Store interface {
+	// Reserve claims key for a new request with the given fingerprint and
+	// returns the reservation. When the key is already taken, reserved is
+	// false and the existing record is returned instead. The reservation
+	// expires after lease, so a key whose request never completes does not
+	// stay taken.
+	Reserve(key Key, fingerprint string, lease time.Duration) (record Record, reserved bool, err error)
+	// Complete saves the response of the request holding reservation,
+	// keeping it until record.ExpiresAt. Nothing is saved when the
+	// reservation has expired and key has been reserved again since.
+	Complete(key Key, reservation Record, record Record) (err error)
+	// Release frees key so that the request can be retried, unless the
+	// reservation has expired and key has been reserved again since.
+	Release(key Key, reservation Record) (err error)
+}
+
+// Fingerprint returns a digest of a request, used to tell a retry from a
+// different request that reuses the same key.
+func Fingerprint(method string, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
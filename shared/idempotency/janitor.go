@@ -0,0 +1,108 @@
+package idempotency
+
+import (
+	"time"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/infras"
+	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultJanitorBatchSize is the number of rows the janitor deletes per
+// statement when none is configured.
+const defaultJanitorBatchSize = 1000
+
+// Janitor periodically purges expired records from the idempotency_key table,
+// in batches so as not to hold long locks. Keys are rarely reused, so records
+// are seldom deleted when their key is reserved again. Redis expires records
+// by itself and needs no janitor.
+type Janitor struct {
+	store     *MySQLStore
+	interval  time.Duration
+	batchSize int
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewJanitor creates a Janitor purging every interval. A non positive
+// interval disables it.
+func NewJanitor(store *MySQLStore, interval time.Duration, batchSize int) *Janitor {
+	if batchSize <= 0 {
+		batchSize = defaultJanitorBatchSize
+	}
+
+	return &Janitor{
+		store:     store,
+		interval:  interval,
+		batchSize: batchSize,
+	}
+}
+
+// ProvideJanitor creates a Janitor from APP.IDEMPOTENCY.JANITOR_*
+// configuration. It is disabled when records are kept in Redis.
+func ProvideJanitor(db *infras.MySQLConn, conf *configs.Config) *Janitor {
+	interval := time.Duration(conf.App.Idempotency.JanitorIntervalSeconds) * time.Second
+	if conf.App.Idempotency.Store == StoreRedis {
+		interval = 0
+	}
+
+	return NewJanitor(NewMySQLStore(db), interval, conf.App.Idempotency.JanitorBatchSize)
+}
+
+// Start purges in the background until Stop is called.
+func (j *Janitor) Start() {
+	if j.interval <= 0 {
+		log.Info().Msg("Idempotency key janitor is disabled.")
+		return
+	}
+
+	j.stop = make(chan struct{})
+	j.done = make(chan struct{})
+	log.Info().Str("interval", j.interval.String()).Msg("Idempotency key janitor started.")
+
+	go func() {
+		defer close(j.done)
+
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-ticker.C:
+				purged, err := j.Purge()
+				if err != nil {
+					logger.ErrorWithStack(err)
+				}
+				log.Debug().Int64("purged", purged).Msg("Idempotency key janitor run completed.")
+			}
+		}
+	}()
+}
+
+// Stop stops purging and waits for a running purge to complete.
+func (j *Janitor) Stop() {
+	if j == nil || j.stop == nil {
+		return
+	}
+
+	close(j.stop)
+	<-j.done
+	j.stop = nil
+}
+
+// Purge deletes every expired record, one batch at a time, and returns how
+// many were deleted.
+func (j *Janitor) Purge() (int64, error) {
+	var purged int64
+	now := time.Now()
+	for {
+		rows, err := j.store.PurgeExpired(now, j.batchSize)
+		purged += rows
+		if err != nil || rows == 0 {
+			return purged, err
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package idempotency
+
+import (
+	"time"
+
+	"github.com/evermos/boilerplate-go/infras"
+	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/go-sql-driver/mysql"
+	"github.com/rs/zerolog/log"
+)
+
+// mysqlDuplicateEntry is the MySQL error number for a duplicate key.
+const mysqlDuplicateEntry = 1062
+
+var idempotencyQueries = struct {
+	selectRecord   string
+	insertRecord   string
+	completeRecord string
+	deleteRecord   string
+	deleteExpired  string
+	purgeExpired   string
+}{
+	selectRecord: `
+	SELECT fingerprint, completed, status_code, content_type, body, expires_at
+	FROM idempotency_key
+	WHERE user_id = ? AND idempotency_key = ?
+	`,
+	insertRecord: `
+	INSERT INTO idempotency_key (
+		user_id,
+		idempotency_key,
+		fingerprint,
+		completed,
+		status_code,
+		content_type,
+		body,
+		expires_at,
+		created_at
+	) VALUES (?, ?, ?, 0, 0, '', NULL, ?, ?)
+	`,
+	completeRecord: `
+	UPDATE idempotency_key
+	SET
+		completed = 1,
+		status_code = ?,
+		content_type = ?,
+		body = ?,
+		expires_at = ?
+	WHERE user_id = ? AND idempotency_key = ? AND fingerprint = ? AND completed = 0 AND expires_at = ?
+	`,
+	deleteRecord: `
+	DELETE FROM idempotency_key
+	WHERE user_id = ? AND idempotency_key = ? AND fingerprint = ? AND completed = 0 AND expires_at = ?
+	`,
+	deleteExpired: `DELETE FROM idempotency_key WHERE user_id = ? AND idempotency_key = ? AND expires_at <= ?`,
+	purgeExpired:  `DELETE FROM idempotency_key WHERE expires_at <= ? LIMIT ?`,
+}
+
+// MySQLStore is a Store backed by the idempotency_key table.
+type MySQLStore struct {
+	DB *infras.MySQLConn
+}
+
+// NewMySQLStore creates a new MySQLStore.
+func NewMySQLStore(db *infras.MySQLConn) *MySQLStore {
+	return &MySQLStore{DB: db}
+}
+
+// Reserve claims key by inserting its record. The primary key on (user_id,
+// idempotency_key) makes concurrent reservations of the same key fail for all
+// but one request. The expiry of the reservation identifies it afterwards, so
+// it is kept to the microsecond precision of the expires_at column.
+func (s *MySQLStore) Reserve(key Key, fingerprint string, lease time.Duration) (record Record, reserved bool, err error) {
+	now := time.Now()
+	reservation := Record{Fingerprint: fingerprint, ExpiresAt: now.Add(lease).Truncate(time.Microsecond)}
+
+	// An expired record no longer protects its key, so it can be reused.
+	_, err = s.DB.Write.Exec(idempotencyQueries.deleteExpired, key.UserID, key.Key, now)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	_, err = s.DB.Write.Exec(idempotencyQueries.insertRecord, key.UserID, key.Key, fingerprint, reservation.ExpiresAt, now)
+	if err == nil {
+		return reservation, true, nil
+	}
+
+	if mysqlErr, ok := err.(*mysql.MySQLError); !ok || mysqlErr.Number != mysqlDuplicateEntry {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	// Read from the primary, the replica may not have the record yet.
+	err = s.DB.Write.Get(&record, idempotencyQueries.selectRecord, key.UserID, key.Key)
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+
+	return
+}
+
+// Complete saves the response of the request holding reservation and extends
+// its expiry to record.ExpiresAt.
+func (s *MySQLStore) Complete(key Key, reservation Record, record Record) (err error) {
+	result, err := s.DB.Write.Exec(
+		idempotencyQueries.completeRecord,
+		record.StatusCode,
+		record.ContentType,
+		record.Body,
+		record.ExpiresAt,
+		key.UserID,
+		key.Key,
+		reservation.Fingerprint,
+		reservation.ExpiresAt)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		log.Warn().Str("key", key.Key).Msg("Idempotency key reservation was lost before its response was saved")
+	}
+
+	return
+}
+
+// Release deletes the record of key while reservation still holds it.
+func (s *MySQLStore) Release(key Key, reservation Record) (err error) {
+	_, err = s.DB.Write.Exec(idempotencyQueries.deleteRecord, key.UserID, key.Key, reservation.Fingerprint, reservation.ExpiresAt)
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+
+	return
+}
+
+// PurgeExpired deletes at most limit records that expired by before, and
+// returns how many were deleted.
+func (s *MySQLStore) PurgeExpired(before time.Time, limit int) (purged int64, err error) {
+	result, err := s.DB.Write.Exec(idempotencyQueries.purgeExpired, before, limit)
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	return result.RowsAffected()
+}
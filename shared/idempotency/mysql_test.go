@@ -0,0 +1,117 @@
+package idempotency
+
+import (
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/evermos/boilerplate-go/infras"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// before matches a time argument that is not after t.
+type before time.Time
+
+func (b before) Match(v driver.Value) bool {
+	at, ok := v.(time.Time)
+	return ok && !at.After(time.Time(b))
+}
+
+func newMockMySQLStore(t *testing.T) (*MySQLStore, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, mock.ExpectationsWereMet())
+		db.Close()
+	})
+
+	conn := sqlx.NewDb(db, "mysql")
+	return NewMySQLStore(&infras.MySQLConn{Read: conn, Write: conn}), mock
+}
+
+func TestMySQLStore(t *testing.T) {
+	key := Key{UserID: "u1", Key: "k1"}
+
+	t.Run("reserves a free key for the lease", func(t *testing.T) {
+		store, mock := newMockMySQLStore(t)
+		mock.ExpectExec("DELETE FROM idempotency_key WHERE user_id = \\? AND idempotency_key = \\? AND expires_at <= \\?").
+			WithArgs("u1", "k1", before(time.Now().Add(time.Second))).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO idempotency_key").
+			WithArgs("u1", "k1", "fp", sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		record, reserved, err := store.Reserve(key, "fp", time.Minute)
+
+		require.NoError(t, err)
+		assert.True(t, reserved)
+		assert.Equal(t, "fp", record.Fingerprint)
+		assert.False(t, record.Completed)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), record.ExpiresAt, time.Second)
+		assert.Equal(t, record.ExpiresAt, record.ExpiresAt.Truncate(time.Microsecond))
+	})
+
+	t.Run("returns the record of a taken key", func(t *testing.T) {
+		store, mock := newMockMySQLStore(t)
+		expiresAt := time.Now().Add(time.Hour)
+		mock.ExpectExec("DELETE FROM idempotency_key").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO idempotency_key").WillReturnError(&mysql.MySQLError{Number: mysqlDuplicateEntry})
+		mock.ExpectQuery("SELECT (.+) FROM idempotency_key").
+			WithArgs("u1", "k1").
+			WillReturnRows(sqlmock.NewRows([]string{"fingerprint", "completed", "status_code", "content_type", "body", "expires_at"}).
+				AddRow("fp", true, 201, "application/json", []byte(`{}`), expiresAt))
+
+		record, reserved, err := store.Reserve(key, "fp", time.Minute)
+
+		require.NoError(t, err)
+		assert.False(t, reserved)
+		assert.Equal(t, Record{Fingerprint: "fp", Completed: true, StatusCode: 201, ContentType: "application/json", Body: []byte(`{}`), ExpiresAt: expiresAt}, record)
+	})
+
+	t.Run("reuses an expired key", func(t *testing.T) {
+		store, mock := newMockMySQLStore(t)
+		mock.ExpectExec("DELETE FROM idempotency_key WHERE user_id = \\? AND idempotency_key = \\? AND expires_at <= \\?").
+			WithArgs("u1", "k1", before(time.Now().Add(time.Second))).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO idempotency_key").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		_, reserved, err := store.Reserve(key, "fp", time.Minute)
+
+		require.NoError(t, err)
+		assert.True(t, reserved)
+	})
+
+	t.Run("completes and releases only the reservation it holds", func(t *testing.T) {
+		store, mock := newMockMySQLStore(t)
+		reservation := Record{Fingerprint: "fp", ExpiresAt: time.Now().Truncate(time.Microsecond)}
+		record := Record{Fingerprint: "fp", Completed: true, StatusCode: 201, ContentType: "application/json", Body: []byte(`{}`), ExpiresAt: time.Now().Add(time.Hour)}
+		// The lease was lost, so neither statement matches a row.
+		mock.ExpectExec("UPDATE idempotency_key (.+) WHERE user_id = \\? AND idempotency_key = \\? AND fingerprint = \\? AND completed = 0 AND expires_at = \\?").
+			WithArgs(201, "application/json", []byte(`{}`), record.ExpiresAt, "u1", "k1", "fp", reservation.ExpiresAt).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DELETE FROM idempotency_key WHERE user_id = \\? AND idempotency_key = \\? AND fingerprint = \\? AND completed = 0 AND expires_at = \\?").
+			WithArgs("u1", "k1", "fp", reservation.ExpiresAt).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		assert.NoError(t, store.Complete(key, reservation, record))
+		assert.NoError(t, store.Release(key, reservation))
+	})
+}
+
+func TestJanitorPurge(t *testing.T) {
+	store, mock := newMockMySQLStore(t)
+	for _, rows := range []int64{2, 1, 0} {
+		mock.ExpectExec("DELETE FROM idempotency_key WHERE expires_at <= \\? LIMIT \\?").
+			WithArgs(before(time.Now().Add(time.Second)), 2).
+			WillReturnResult(sqlmock.NewResult(0, rows))
+	}
+
+	purged, err := NewJanitor(store, time.Minute, 2).Purge()
+
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+}
@@ -0,0 +1,149 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/go-redis/redis"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// redisKeyPrefix namespaces idempotency records in Redis.
+	redisKeyPrefix = "idempotency:"
+	// maxReserveAttempts bounds how many times Reserve retries when the
+	// record it found is gone before it could be read.
+	maxReserveAttempts = 3
+)
+
+// ErrReserveContended is returned by RedisStore.Reserve when a key keeps
+// disappearing between being found taken and being read.
+var ErrReserveContended = errors.New("idempotency key is contended")
+
+// redisCompareAndSet replaces a record with ARGV[2] for ARGV[3] milliseconds
+// only while it still holds ARGV[1].
+var redisCompareAndSet = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+end
+return false
+`)
+
+// redisCompareAndDelete deletes a record only while it still holds ARGV[1].
+var redisCompareAndDelete = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// RedisStore is a Store backed by Redis. Records expire with their keys.
+type RedisStore struct {
+	Client *redis.Client
+}
+
+// NewRedisStore creates a new RedisStore.
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{Client: client}
+}
+
+// Reserve claims key with SETNX, so concurrent reservations of the same key
+// fail for all but one request.
+func (s *RedisStore) Reserve(key Key, fingerprint string, lease time.Duration) (record Record, reserved bool, err error) {
+	for attempt := 0; attempt < maxReserveAttempts; attempt++ {
+		var found bool
+		record, reserved, found, err = s.reserve(key, fingerprint, lease)
+		if err != nil || reserved || found {
+			return
+		}
+		// The record expired or was released in between, try again.
+	}
+
+	err = ErrReserveContended
+	logger.ErrorWithStack(err)
+	return
+}
+
+// reserve makes one attempt at claiming key. When the key is taken, found
+// tells whether its record could still be read.
+func (s *RedisStore) reserve(key Key, fingerprint string, lease time.Duration) (existing Record, reserved bool, found bool, err error) {
+	reservation := Record{Fingerprint: fingerprint, ExpiresAt: time.Now().Add(lease)}
+	value, err := json.Marshal(reservation)
+	if err != nil {
+		return
+	}
+
+	reserved, err = s.Client.SetNX(s.redisKey(key), value, lease).Result()
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+	if reserved {
+		return reservation, true, false, nil
+	}
+
+	value, err = s.Client.Get(s.redisKey(key)).Bytes()
+	if err == redis.Nil {
+		return existing, false, false, nil
+	}
+	if err != nil {
+		logger.ErrorWithStack(err)
+		return
+	}
+
+	err = json.Unmarshal(value, &existing)
+	return existing, false, err == nil, err
+}
+
+// Complete saves the response of the request holding reservation, expiring it
+// at record.ExpiresAt. The stored reservation is compared and replaced in a
+// script, so a newer reservation of key is never overwritten.
+func (s *RedisStore) Complete(key Key, reservation Record, record Record) (err error) {
+	ttl := time.Until(record.ExpiresAt)
+	if ttl <= 0 {
+		return
+	}
+
+	reserved, err := json.Marshal(reservation)
+	if err != nil {
+		return
+	}
+
+	record.Completed = true
+	value, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	err = redisCompareAndSet.Run(s.Client, []string{s.redisKey(key)}, reserved, value, ttl.Milliseconds()).Err()
+	if err == redis.Nil {
+		log.Warn().Str("key", key.Key).Msg("Idempotency key reservation was lost before its response was saved")
+		return nil
+	}
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+
+	return
+}
+
+// Release deletes the record of key while reservation still holds it.
+func (s *RedisStore) Release(key Key, reservation Record) (err error) {
+	reserved, err := json.Marshal(reservation)
+	if err != nil {
+		return
+	}
+
+	err = redisCompareAndDelete.Run(s.Client, []string{s.redisKey(key)}, reserved).Err()
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+
+	return
+}
+
+func (s *RedisStore) redisKey(key Key) string {
+	return redisKeyPrefix + key.UserID + ":" + key.Key
+}
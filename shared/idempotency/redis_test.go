@@ -0,0 +1,110 @@
+package idempotency
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMiniRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	server, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(server.Close)
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedisStore(client), server
+}
+
+func storedRecord(t *testing.T, server *miniredis.Miniredis, key Key) Record {
+	value, err := server.Get(redisKeyPrefix + key.UserID + ":" + key.Key)
+	require.NoError(t, err)
+
+	var record Record
+	require.NoError(t, json.Unmarshal([]byte(value), &record))
+	return record
+}
+
+func TestRedisStore(t *testing.T) {
+	key := Key{UserID: "u1", Key: "k1"}
+
+	t.Run("reserves a free key for the lease", func(t *testing.T) {
+		store, server := newMiniRedisStore(t)
+
+		record, reserved, err := store.Reserve(key, "fp", time.Minute)
+
+		require.NoError(t, err)
+		assert.True(t, reserved)
+		assert.Equal(t, "fp", record.Fingerprint)
+		assert.Equal(t, time.Minute, server.TTL(store.redisKey(key)))
+	})
+
+	t.Run("returns the record of a taken key", func(t *testing.T) {
+		store, _ := newMiniRedisStore(t)
+		reservation, _, err := store.Reserve(key, "fp", time.Minute)
+		require.NoError(t, err)
+
+		record, reserved, err := store.Reserve(key, "other", time.Minute)
+
+		require.NoError(t, err)
+		assert.False(t, reserved)
+		assert.Equal(t, "fp", record.Fingerprint)
+		assert.True(t, reservation.ExpiresAt.Equal(record.ExpiresAt))
+	})
+
+	t.Run("reuses an expired key", func(t *testing.T) {
+		store, server := newMiniRedisStore(t)
+		_, _, err := store.Reserve(key, "fp", time.Minute)
+		require.NoError(t, err)
+		server.FastForward(time.Minute)
+
+		_, reserved, err := store.Reserve(key, "fp", time.Minute)
+
+		require.NoError(t, err)
+		assert.True(t, reserved)
+	})
+
+	t.Run("completes a reservation for the TTL", func(t *testing.T) {
+		store, server := newMiniRedisStore(t)
+		reservation, _, err := store.Reserve(key, "fp", time.Minute)
+		require.NoError(t, err)
+
+		err = store.Complete(key, reservation, Record{Fingerprint: "fp", StatusCode: 201, ExpiresAt: time.Now().Add(time.Hour)})
+
+		require.NoError(t, err)
+		assert.True(t, storedRecord(t, server, key).Completed)
+		assert.InDelta(t, time.Hour.Seconds(), server.TTL(store.redisKey(key)).Seconds(), 1)
+	})
+
+	t.Run("does not complete or release a lost reservation", func(t *testing.T) {
+		store, server := newMiniRedisStore(t)
+		lost, _, err := store.Reserve(key, "fp", time.Minute)
+		require.NoError(t, err)
+		server.FastForward(time.Minute)
+		_, reserved, err := store.Reserve(key, "fp", time.Minute)
+		require.NoError(t, err)
+		require.True(t, reserved)
+
+		require.NoError(t, store.Complete(key, lost, Record{Fingerprint: "fp", StatusCode: 201, ExpiresAt: time.Now().Add(time.Hour)}))
+		require.NoError(t, store.Release(key, lost))
+
+		record := storedRecord(t, server, key)
+		assert.False(t, record.Completed)
+		assert.False(t, lost.ExpiresAt.Equal(record.ExpiresAt))
+	})
+
+	t.Run("releases the reservation it holds", func(t *testing.T) {
+		store, server := newMiniRedisStore(t)
+		reservation, _, err := store.Reserve(key, "fp", time.Minute)
+		require.NoError(t, err)
+
+		require.NoError(t, store.Release(key, reservation))
+
+		assert.False(t, server.Exists(store.redisKey(key)))
+	})
+}
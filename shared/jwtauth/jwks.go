@@ -0,0 +1,228 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultJWKSRefresh = 15 * time.Minute
+	// minJWKSRefresh throttles reloads triggered by unknown key IDs, so a
+	// flood of tokens with made-up key IDs cannot hammer the JWKS source.
+	minJWKSRefresh = time.Minute
+)
+
+// jwk is a single JSON Web Key as defined by RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// KeySet is a set of verification keys loaded from a JWKS file or URL. It is
+// reloaded periodically and whenever a token names a key ID it does not know,
+// which picks up keys rotated in at the source.
+type KeySet struct {
+	source  string
+	client  *http.Client
+	refresh time.Duration
+
+	mu       sync.RWMutex
+	keys     map[string]interface{}
+	loadedAt time.Time
+}
+
+// NewKeySet creates a KeySet reading from source, which is either an http(s)
+// URL or a file path, and loads it once.
+func NewKeySet(source string, refresh time.Duration) (*KeySet, error) {
+	if refresh <= 0 {
+		refresh = defaultJWKSRefresh
+	}
+
+	s := &KeySet{
+		source:  source,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		refresh: refresh,
+	}
+
+	err := s.load()
+	return s, err
+}
+
+// Key returns the key with the given key ID.
+func (s *KeySet) Key(kid string) (key interface{}, ok bool) {
+	s.reloadIfOlderThan(s.refresh)
+
+	s.mu.RLock()
+	key, ok = s.keys[kid]
+	s.mu.RUnlock()
+	if ok {
+		return
+	}
+
+	if !s.reloadIfOlderThan(minJWKSRefresh) {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok = s.keys[kid]
+	return
+}
+
+// Keys returns every key in the set.
+func (s *KeySet) Keys() (keys []interface{}) {
+	s.reloadIfOlderThan(s.refresh)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	return
+}
+
+// reloadIfOlderThan reloads the set when it was last loaded longer than age
+// ago, and reports whether it did.
+func (s *KeySet) reloadIfOlderThan(age time.Duration) bool {
+	s.mu.RLock()
+	stale := time.Since(s.loadedAt) >= age
+	s.mu.RUnlock()
+	if !stale {
+		return false
+	}
+
+	err := s.load()
+	if err != nil {
+		log.Warn().Err(err).Str("source", s.source).Msg("Failed reloading JWKS, keeping previous keys")
+	}
+	return err == nil
+}
+
+func (s *KeySet) load() error {
+	// Whatever the outcome, wait for the next period before trying again.
+	s.mu.Lock()
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+
+	data, err := s.read()
+	if err != nil {
+		return err
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *KeySet) read() ([]byte, error) {
+	if !strings.HasPrefix(s.source, "http://") && !strings.HasPrefix(s.source, "https://") {
+		return ioutil.ReadFile(s.source)
+	}
+
+	res, err := s.client.Get(s.source)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: unexpected status %d", res.StatusCode)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// parseJWKS parses a JWKS document into keys by key ID. Keys not meant for
+// signatures and key types that are not supported are skipped.
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err := json.Unmarshal(data, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			log.Warn().Err(err).Str("kid", k.Kid).Msg("Skipping JWKS key")
+			continue
+		}
+		keys[k.Kid] = key
+	}
+
+	return keys, nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	default:
+		return nil, errors.New("unsupported key type " + k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,233 @@
+package jwtauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"time"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/shared"
+	"github.com/golang-jwt/jwt"
+)
+
+var (
+	// ErrUnknownKey is returned when no configured key can verify a token, so
+	// the token may still be valid for another verifier.
+	ErrUnknownKey = errors.New("no key to verify token")
+	// ErrInvalidToken is returned for malformed tokens and bad signatures.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpired is returned for tokens past their exp.
+	ErrExpired = errors.New("token is expired")
+	// ErrNotYetValid is returned for tokens before their nbf.
+	ErrNotYetValid = errors.New("token is not valid yet")
+	// ErrInvalidIssuer is returned for tokens from an unexpected issuer.
+	ErrInvalidIssuer = errors.New("token has an invalid issuer")
+	// ErrInvalidAudience is returned for tokens meant for another audience.
+	ErrInvalidAudience = errors.New("token has an invalid audience")
+)
+
+// Verifier verifies JWTs locally, using HS256 shared secrets and RS256/ES256
+// public keys from a JWKS.
+type Verifier struct {
+	secrets  [][]byte
+	keySet   *KeySet
+	issuer   string
+	audience string
+	leeway   time.Duration
+	parser   *jwt.Parser
+}
+
+// Option configures a Verifier.
+type Option func(v *Verifier)
+
+// WithSecrets adds HS256 secrets. Several secrets can be configured while one
+// is being rotated out; each is tried in turn.
+func WithSecrets(secrets ...string) Option {
+	return func(v *Verifier) {
+		for _, secret := range secrets {
+			if secret != "" {
+				v.secrets = append(v.secrets, []byte(secret))
+			}
+		}
+	}
+}
+
+// WithKeySet verifies tokens with the keys of a JWKS.
+func WithKeySet(keySet *KeySet) Option {
+	return func(v *Verifier) {
+		v.keySet = keySet
+	}
+}
+
+// WithIssuer requires the iss claim to match.
+func WithIssuer(issuer string) Option {
+	return func(v *Verifier) {
+		v.issuer = issuer
+	}
+}
+
+// WithAudience requires the aud claim to match.
+func WithAudience(audience string) Option {
+	return func(v *Verifier) {
+		v.audience = audience
+	}
+}
+
+// WithLeeway tolerates clock skew when checking exp and nbf.
+func WithLeeway(leeway time.Duration) Option {
+	return func(v *Verifier) {
+		v.leeway = leeway
+	}
+}
+
+// NewVerifier creates a Verifier.
+func NewVerifier(opts ...Option) *Verifier {
+	v := &Verifier{
+		parser: &jwt.Parser{
+			ValidMethods:         []string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()},
+			SkipClaimsValidation: true,
+		},
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	return v
+}
+
+// ProvideVerifier creates a Verifier from configuration. It returns nil when
+// no secret or JWKS is configured, meaning tokens cannot be verified locally.
+func ProvideVerifier(config *configs.Config) (*Verifier, error) {
+	conf := config.App.JWT
+	opts := []Option{
+		WithSecrets(conf.HS256Secrets...),
+		WithIssuer(conf.Issuer),
+		WithAudience(conf.Audience),
+		WithLeeway(time.Duration(conf.LeewaySeconds) * time.Second),
+	}
+
+	source := conf.JWKSURL
+	if source == "" {
+		source = conf.JWKSFile
+	}
+	if source != "" {
+		keySet, err := NewKeySet(source, time.Duration(conf.JWKSRefreshSeconds)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithKeySet(keySet))
+	}
+
+	v := NewVerifier(opts...)
+	if len(v.secrets) == 0 && v.keySet == nil {
+		return nil, nil
+	}
+
+	return v, nil
+}
+
+// Verify verifies a token's signature and its exp, nbf, iss and aud claims.
+func (v *Verifier) Verify(tokenString string) (claims shared.Claims, err error) {
+	unverified, _, err := v.parser.ParseUnverified(tokenString, &shared.Claims{})
+	if err != nil || !v.supports(unverified.Method.Alg()) {
+		return claims, ErrInvalidToken
+	}
+
+	keys := v.candidateKeys(unverified)
+	if len(keys) == 0 {
+		return claims, ErrUnknownKey
+	}
+
+	for _, key := range keys {
+		claims = shared.Claims{}
+		_, err = v.parser.ParseWithClaims(tokenString, &claims, func(*jwt.Token) (interface{}, error) {
+			return key, nil
+		})
+		if err != nil {
+			continue
+		}
+
+		err = v.validate(&claims)
+		if err != nil {
+			return shared.Claims{}, err
+		}
+		return claims, nil
+	}
+
+	return shared.Claims{}, ErrInvalidToken
+}
+
+func (v *Verifier) supports(alg string) bool {
+	for _, method := range v.parser.ValidMethods {
+		if method == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// candidateKeys returns the keys that may have signed the token: the key named
+// by its kid header, or else every key usable with its algorithm.
+func (v *Verifier) candidateKeys(token *jwt.Token) (keys []interface{}) {
+	alg := token.Method.Alg()
+
+	if kid, _ := token.Header["kid"].(string); kid != "" && v.keySet != nil {
+		if key, ok := v.keySet.Key(kid); ok && usableWith(key, alg) {
+			return []interface{}{key}
+		}
+	}
+
+	if alg == jwt.SigningMethodHS256.Alg() {
+		for _, secret := range v.secrets {
+			keys = append(keys, secret)
+		}
+	}
+
+	if v.keySet != nil {
+		for _, key := range v.keySet.Keys() {
+			if usableWith(key, alg) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return
+}
+
+func usableWith(key interface{}, alg string) bool {
+	switch key.(type) {
+	case []byte:
+		return alg == jwt.SigningMethodHS256.Alg()
+	case *rsa.PublicKey:
+		return alg == jwt.SigningMethodRS256.Alg()
+	case *ecdsa.PublicKey:
+		return alg == jwt.SigningMethodES256.Alg()
+	default:
+		return false
+	}
+}
+
+// validate checks the registered claims. exp is required.
+func (v *Verifier) validate(claims *shared.Claims) error {
+	now := time.Now()
+
+	if !claims.VerifyExpiresAt(now.Add(-v.leeway).Unix(), true) {
+		return ErrExpired
+	}
+
+	if !claims.VerifyNotBefore(now.Add(v.leeway).Unix(), false) {
+		return ErrNotYetValid
+	}
+
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return ErrInvalidIssuer
+	}
+
+	if v.audience != "" && !claims.VerifyAudience(v.audience, true) {
+		return ErrInvalidAudience
+	}
+
+	return nil
+}
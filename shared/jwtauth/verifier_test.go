@@ -0,0 +1,267 @@
+package jwtauth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evermos/boilerplate-go/shared"
+	"github.com/evermos/boilerplate-go/shared/jwtauth"
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newClaims(mutate func(c *shared.Claims)) shared.Claims {
+	c := shared.Claims{
+		UserId:   "u1",
+		Username: "jane",
+		Role:     "user",
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    "https://auth.example.com",
+			Audience:  "boilerplate-go",
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+	if mutate != nil {
+		mutate(&c)
+	}
+	return c
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims shared.Claims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   encodeBigInt(key.N),
+		"e":   encodeBigInt(big.NewInt(int64(key.E))),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PrivateKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": "P-256",
+		"x":   encodeBigInt(key.X),
+		"y":   encodeBigInt(key.Y),
+	}
+}
+
+func jwks(t *testing.T, keys ...map[string]string) []byte {
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	require.NoError(t, err)
+	return data
+}
+
+func TestVerifierHS256(t *testing.T) {
+	verifier := jwtauth.NewVerifier(
+		jwtauth.WithSecrets("current", "previous"),
+		jwtauth.WithIssuer("https://auth.example.com"),
+		jwtauth.WithAudience("boilerplate-go"),
+		jwtauth.WithLeeway(30*time.Second),
+	)
+
+	t.Run("valid token", func(t *testing.T) {
+		claims, err := verifier.Verify(sign(t, jwt.SigningMethodHS256, "", []byte("current"), newClaims(nil)))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "u1", claims.UserId)
+		assert.Equal(t, "user", claims.Role)
+	})
+
+	t.Run("token signed with a rotated out secret", func(t *testing.T) {
+		_, err := verifier.Verify(sign(t, jwt.SigningMethodHS256, "", []byte("previous"), newClaims(nil)))
+
+		assert.NoError(t, err)
+	})
+
+	cases := []struct {
+		name   string
+		secret string
+		claims shared.Claims
+		err    error
+	}{
+		{
+			name:   "wrong secret",
+			secret: "forged",
+			claims: newClaims(nil),
+			err:    jwtauth.ErrInvalidToken,
+		},
+		{
+			name:   "expired",
+			secret: "current",
+			claims: newClaims(func(c *shared.Claims) { c.ExpiresAt = time.Now().Add(-time.Minute).Unix() }),
+			err:    jwtauth.ErrExpired,
+		},
+		{
+			name:   "without exp",
+			secret: "current",
+			claims: newClaims(func(c *shared.Claims) { c.ExpiresAt = 0 }),
+			err:    jwtauth.ErrExpired,
+		},
+		{
+			name:   "not valid yet",
+			secret: "current",
+			claims: newClaims(func(c *shared.Claims) { c.NotBefore = time.Now().Add(time.Minute).Unix() }),
+			err:    jwtauth.ErrNotYetValid,
+		},
+		{
+			name:   "wrong issuer",
+			secret: "current",
+			claims: newClaims(func(c *shared.Claims) { c.Issuer = "https://evil.example.com" }),
+			err:    jwtauth.ErrInvalidIssuer,
+		},
+		{
+			name:   "wrong audience",
+			secret: "current",
+			claims: newClaims(func(c *shared.Claims) { c.Audience = "another-service" }),
+			err:    jwtauth.ErrInvalidAudience,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			claims, err := verifier.Verify(sign(t, jwt.SigningMethodHS256, "", []byte(c.secret), c.claims))
+
+			assert.Equal(t, c.err, err)
+			assert.Empty(t, claims.UserId)
+		})
+	}
+
+	t.Run("within leeway", func(t *testing.T) {
+		claims := newClaims(func(c *shared.Claims) { c.ExpiresAt = time.Now().Add(-10 * time.Second).Unix() })
+
+		_, err := verifier.Verify(sign(t, jwt.SigningMethodHS256, "", []byte("current"), claims))
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("malformed token", func(t *testing.T) {
+		_, err := verifier.Verify("not-a-jwt")
+
+		assert.Equal(t, jwtauth.ErrInvalidToken, err)
+	})
+
+	t.Run("unsigned token", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodNone, newClaims(nil)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		require.NoError(t, err)
+
+		_, err = verifier.Verify(token)
+
+		assert.Equal(t, jwtauth.ErrInvalidToken, err)
+	})
+
+	t.Run("no key for the algorithm", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		_, err = verifier.Verify(sign(t, jwt.SigningMethodRS256, "", key, newClaims(nil)))
+
+		assert.Equal(t, jwtauth.ErrUnknownKey, err)
+	})
+}
+
+func TestVerifierJWKSFile(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "jwks")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	require.NoError(t, ioutil.WriteFile(path, jwks(t, rsaJWK("old", oldKey)), 0600))
+
+	keySet, err := jwtauth.NewKeySet(path, time.Hour)
+	require.NoError(t, err)
+	verifier := jwtauth.NewVerifier(jwtauth.WithKeySet(keySet))
+
+	_, err = verifier.Verify(sign(t, jwt.SigningMethodRS256, "old", oldKey, newClaims(nil)))
+	assert.NoError(t, err)
+
+	_, err = verifier.Verify(sign(t, jwt.SigningMethodRS256, "old", newKey, newClaims(nil)))
+	assert.Equal(t, jwtauth.ErrInvalidToken, err)
+
+	// A key rotated in is only picked up once the unknown key ID throttle has
+	// passed, so until then tokens signed with it are unknown.
+	require.NoError(t, ioutil.WriteFile(path, jwks(t, rsaJWK("old", oldKey), rsaJWK("new", newKey)), 0600))
+	_, err = verifier.Verify(sign(t, jwt.SigningMethodRS256, "new", newKey, newClaims(nil)))
+	assert.Equal(t, jwtauth.ErrInvalidToken, err)
+
+	// Once the refresh period has passed, the rotated key is loaded.
+	refreshing, err := jwtauth.NewKeySet(path, time.Nanosecond)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, jwks(t, rsaJWK("new", newKey)), 0600))
+	time.Sleep(time.Millisecond)
+
+	_, err = jwtauth.NewVerifier(jwtauth.WithKeySet(refreshing)).Verify(sign(t, jwt.SigningMethodRS256, "new", newKey, newClaims(nil)))
+	assert.NoError(t, err)
+}
+
+func TestVerifierJWKSURL(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rotated, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	keys := []map[string]string{ecJWK("k1", key)}
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(jwks(t, keys...))
+	}))
+	defer server.Close()
+
+	keySet, err := jwtauth.NewKeySet(server.URL, time.Hour)
+	require.NoError(t, err)
+	verifier := jwtauth.NewVerifier(jwtauth.WithKeySet(keySet), jwtauth.WithAudience("boilerplate-go"))
+
+	claims, err := verifier.Verify(sign(t, jwt.SigningMethodES256, "k1", key, newClaims(nil)))
+	assert.NoError(t, err)
+	assert.Equal(t, "u1", claims.UserId)
+	assert.Equal(t, 1, fetches)
+
+	_, err = verifier.Verify(sign(t, jwt.SigningMethodES256, "", key, newClaims(nil)))
+	assert.NoError(t, err, "tokens without a kid are tried against every key")
+
+	keys = append(keys, ecJWK("k2", rotated))
+	_, err = verifier.Verify(sign(t, jwt.SigningMethodES256, "k2", rotated, newClaims(nil)))
+	assert.Equal(t, jwtauth.ErrInvalidToken, err)
+	assert.Equal(t, 1, fetches, "unknown key IDs must not trigger a reload within the throttle")
+}
+
+func TestNewKeySetRejectsUnreadableSource(t *testing.T) {
+	_, err := jwtauth.NewKeySet(filepath.Join(os.TempDir(), "does-not-exist.json"), time.Hour)
+
+	assert.Error(t, err)
+}
@@ -1,14 +1,31 @@
 package oauth
 
 import (
+	"time"
+
+	"github.com/evermos/boilerplate-go/configs"
 	"github.com/jmoiron/sqlx"
 )
 
+const (
+	// defaultExpiration is the lifetime of access tokens, in seconds, when
+	// none is configured.
+	defaultExpiration = 3600
+	// defaultRefreshExpiration is the lifetime of refresh tokens, in seconds,
+	// when none is configured.
+	defaultRefreshExpiration = 30 * 24 * 3600
+	// defaultCodeExpiration is the lifetime of authorization codes, in
+	// seconds, when none is configured.
+	defaultCodeExpiration = 60
+)
+
 type GrantType string
 
 const (
 	ClientCredentials GrantType = "client_credentials"
 	Password          GrantType = "password"
+	RefreshToken      GrantType = "refresh_token"
+	AuthorizationCode GrantType = "authorization_code"
 )
 
 type Token struct {
@@ -23,13 +40,52 @@ func New(db *sqlx.DB, config Config) *Token {
 	}
 }
 
+// NewWithStore creates a Token on the given TokenStore.
+func NewWithStore(tokenStore TokenStore, config Config) *Token {
+	return &Token{
+		config:          config,
+		tokenRepository: tokenStore,
+	}
+}
+
 type Config struct {
-	Expiration  int64
-	ClientScope []string
+	Expiration        int64
+	RefreshExpiration int64
+	CodeExpiration    int64
+	ClientScope       []string
+}
+
+// ProvideConfig creates a Config from OAUTH.* configuration.
+func ProvideConfig(conf *configs.Config) Config {
+	expiration := conf.OAuth.ExpirationSeconds
+	if expiration <= 0 {
+		expiration = defaultExpiration
+	}
+
+	refreshExpiration := conf.OAuth.RefreshExpirationSeconds
+	if refreshExpiration <= 0 {
+		refreshExpiration = defaultRefreshExpiration
+	}
+
+	codeExpiration := conf.OAuth.CodeExpirationSeconds
+	if codeExpiration <= 0 {
+		codeExpiration = defaultCodeExpiration
+	}
+
+	return Config{
+		Expiration:        expiration,
+		RefreshExpiration: refreshExpiration,
+		CodeExpiration:    codeExpiration,
+		ClientScope:       conf.OAuth.ClientScope,
+	}
 }
 
 // Create is function to store NewToken into database
 func (t *Token) Create(credential Credential) (*TokenResponse, error) {
+	if !t.ClientScopeAllowed(credential.ClientID) {
+		return &TokenResponse{}, NewError(ErrCodeUnauthorizedClient, ErrorGrantNotAllowed)
+	}
+
 	grant, err := NewGrant(t.tokenRepository, t.config).Create(credential)
 	if err != nil {
 		return &TokenResponse{}, err
@@ -65,3 +121,67 @@ func (t *Token) ClientScopeAllowed(clientID string) bool {
 
 	return false
 }
+
+// Introspect returns the state of an access token on behalf of an
+// authenticated confidential client, as defined by RFC 7662. Unknown and
+// expired tokens are reported as inactive rather than as errors.
+func (t *Token) Introspect(credential Credential, accessToken string) (Introspection, error) {
+	client, err := authenticateClient(t.tokenRepository, credential, "")
+	if err != nil {
+		return Introspection{}, err
+	}
+
+	if client.Public() {
+		return Introspection{}, NewError(ErrCodeUnauthorizedClient, ErrorPublicClient)
+	}
+
+	token, err := t.tokenRepository.resolveAccessTokenByAccessToken(digestToken(accessToken))
+	if err != nil {
+		if err.Error() == ErrorClientNotFound {
+			return Introspection{}, nil
+		}
+		return Introspection{}, err
+	}
+
+	if !token.VerifyExpireIn() {
+		return Introspection{}, nil
+	}
+
+	return token.toIntrospection(), nil
+}
+
+// Revoke revokes an access token or a refresh token on behalf of the client
+// it was issued to, as defined by RFC 7009. Revoking a refresh token revokes
+// its whole family along with the access tokens issued with it. Revoking an
+// unknown token succeeds.
+func (t *Token) Revoke(credential Credential, token string) error {
+	client, err := authenticateClient(t.tokenRepository, credential, "")
+	if err != nil {
+		return err
+	}
+
+	accessToken, err := t.tokenRepository.resolveAccessTokenByAccessToken(digestToken(token))
+	switch {
+	case err == nil:
+		if accessToken.ClientID != client.ClientID {
+			return NewError(ErrCodeInvalidGrant, ErrorTokenNotOwned)
+		}
+		return t.tokenRepository.deleteAccessToken(accessToken.AccessToken)
+	case err.Error() != ErrorClientNotFound:
+		return err
+	}
+
+	refreshToken, err := t.tokenRepository.resolveRefreshToken(digestToken(token))
+	if err != nil {
+		if err.Error() == ErrorClientNotFound {
+			return nil
+		}
+		return err
+	}
+
+	if refreshToken.ClientID != client.ClientID {
+		return NewError(ErrCodeInvalidGrant, ErrorTokenNotOwned)
+	}
+
+	return t.tokenRepository.revokeRefreshTokenFamily(refreshToken.FamilyID, time.Now())
+}
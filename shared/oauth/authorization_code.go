@@ -0,0 +1,215 @@
+package oauth
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CodeChallengeS256 is the PKCE code challenge method of RFC 7636. The plain
+// method is not supported.
+const CodeChallengeS256 = "S256"
+
+// codeChallengeLength is the length of a base64url encoded SHA-256 digest.
+const codeChallengeLength = 43
+
+// AuthorizationRequest is a request of the authorization endpoint, made by the
+// user agent of the user whose credentials it carries.
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Scope               string
+	Username            string
+	Password            string
+}
+
+// Authorize handles an authorization request of the authorization_code grant,
+// as defined by RFC 6749 section 4.1.1 and RFC 7636. It returns the redirect
+// URI to send the user agent back to, carrying either an authorization code or
+// an error. An error is returned instead when the client or the redirect URI
+// is invalid, since the user agent must not be redirected then.
+func (t *Token) Authorize(request AuthorizationRequest) (*url.URL, error) {
+	client, err := t.tokenRepository.resolveClientByClientID(request.ClientID)
+	if err != nil {
+		if err.Error() == ErrorClientNotFound {
+			err = NewError(ErrCodeInvalidRequest, ErrorInvalidClient)
+		}
+		return nil, err
+	}
+
+	redirectURI, ok := client.resolveRedirectURI(request.RedirectURI)
+	if !ok {
+		return nil, NewError(ErrCodeInvalidRequest, ErrorInvalidRedirectURI)
+	}
+
+	code, err := t.authorize(client, request)
+	if err != nil {
+		oauthErr, ok := err.(*Error)
+		if !ok {
+			return nil, err
+		}
+
+		return redirectWith(redirectURI, request.State, url.Values{
+			"error":             {oauthErr.Code},
+			"error_description": {oauthErr.Description},
+		})
+	}
+
+	return redirectWith(redirectURI, request.State, url.Values{"code": {code}})
+}
+
+func (t *Token) authorize(client OauthClient, request AuthorizationRequest) (string, error) {
+	if request.ResponseType != "code" {
+		return "", NewError(ErrCodeUnsupportedResponseType, "response_type must be code")
+	}
+
+	if !client.AllowsGrant(AuthorizationCode) || !t.ClientScopeAllowed(client.ClientID) {
+		return "", NewError(ErrCodeUnauthorizedClient, ErrorGrantNotAllowed)
+	}
+
+	if request.CodeChallengeMethod != CodeChallengeS256 {
+		return "", NewError(ErrCodeInvalidRequest, "code_challenge_method must be S256")
+	}
+
+	if len(request.CodeChallenge) != codeChallengeLength {
+		return "", NewError(ErrCodeInvalidRequest, "code_challenge is invalid")
+	}
+
+	scope, err := narrowScope(request.Scope, client.Scope.String)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := authenticateUser(t.tokenRepository, Credential{Username: request.Username, Password: request.Password})
+	if err != nil {
+		if _, ok := err.(*Error); ok {
+			err = NewError(ErrCodeAccessDenied, ErrorInvalidPassword)
+		}
+		return "", err
+	}
+
+	code, err := generateAccessToken()
+	if err != nil {
+		return "", NewError(ErrCodeServerError, ErrorGenerateAccessToken)
+	}
+
+	authorizationCode := new(OauthAuthorizationCode).Generate(code, client.ClientID, user.ID, scope, request, t.config)
+	err = t.tokenRepository.createAuthorizationCode(authorizationCode)
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+type AuthorizationCodeAuth struct {
+	tokenStore TokenStore
+	config     Config
+}
+
+// Create exchanges an authorization code for an access token. The redirect
+// URI must be the one of the authorization request, and the code verifier
+// must match its code challenge.
+func (c *AuthorizationCodeAuth) Create(credential Credential) (oauthAccessToken OauthAccessToken, err error) {
+	client, err := authenticateClient(c.tokenStore, credential, AuthorizationCode)
+	if err != nil {
+		return
+	}
+
+	if credential.Code == "" || credential.CodeVerifier == "" {
+		err = NewError(ErrCodeInvalidRequest, "code and code_verifier are required")
+		return
+	}
+
+	code, err := c.tokenStore.resolveAuthorizationCode(digestToken(credential.Code))
+	if err != nil {
+		if err.Error() == ErrorClientNotFound {
+			err = NewError(ErrCodeInvalidGrant, ErrorInvalidCode)
+		}
+		return
+	}
+
+	if code.ClientID != credential.ClientID || code.UsedAt.Valid || !code.VerifyExpireIn() || code.RedirectURI != credential.RedirectURI {
+		err = NewError(ErrCodeInvalidGrant, ErrorInvalidCode)
+		return
+	}
+
+	if !code.VerifyCodeVerifier(credential.CodeVerifier) {
+		err = NewError(ErrCodeInvalidGrant, ErrorInvalidCodeVerifier)
+		return
+	}
+
+	consumed, err := c.tokenStore.consumeAuthorizationCode(code.Code, time.Now())
+	if err != nil {
+		return
+	}
+	if !consumed {
+		err = NewError(ErrCodeInvalidGrant, ErrorInvalidCode)
+		return
+	}
+
+	accessToken, err := generateAccessToken()
+	if err != nil {
+		err = NewError(ErrCodeServerError, ErrorGenerateAccessToken)
+		return
+	}
+
+	oauthAccessToken = new(OauthAccessToken).Generate(accessToken, credential.ClientID, nil, "", c.config)
+	oauthAccessToken.UserID = code.UserID
+	oauthAccessToken.Scope = code.Scope
+
+	err = c.tokenStore.createAccessToken(oauthAccessToken)
+	if err != nil {
+		return
+	}
+
+	if client.AllowsGrant(RefreshToken) {
+		err = issueRefreshToken(c.tokenStore, c.config, &oauthAccessToken, "", code.Scope)
+	}
+
+	return
+}
+
+// resolveRedirectURI returns the registered redirect URI exactly matching
+// redirectURI, or the registered redirect URI when redirectURI is empty and
+// there is only one. RedirectURI is a space separated list.
+func (o *OauthClient) resolveRedirectURI(redirectURI string) (string, bool) {
+	registered := strings.Fields(o.RedirectURI)
+	if redirectURI == "" {
+		if len(registered) == 1 {
+			return registered[0], true
+		}
+		return "", false
+	}
+
+	for _, uri := range registered {
+		if uri == redirectURI {
+			return uri, true
+		}
+	}
+
+	return "", false
+}
+
+// redirectWith adds params and state to the query of a redirect URI.
+func redirectWith(redirectURI string, state string, params url.Values) (*url.URL, error) {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return nil, err
+	}
+
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	u.RawQuery = query.Encode()
+
+	return u, nil
+}
@@ -0,0 +1,291 @@
+package oauth
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The code verifier and S256 code challenge of RFC 7636 appendix B.
+const (
+	codeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	codeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func authorizationRequest(clientID string) AuthorizationRequest {
+	return AuthorizationRequest{
+		ResponseType:        "code",
+		ClientID:            clientID,
+		RedirectURI:         "https://evermos.com/callback",
+		State:               "xyz",
+		CodeChallenge:       codeChallenge,
+		CodeChallengeMethod: CodeChallengeS256,
+		Username:            "jane@example.com",
+		Password:            "s3cret",
+	}
+}
+
+func TestTokenAuthorize(t *testing.T) {
+	config := Config{Expiration: 3600, CodeExpiration: 60}
+
+	t.Run("redirects with a code", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+
+		redirect, err := NewWithStore(store, config).Authorize(authorizationRequest("client_web"))
+
+		require.NoError(t, err)
+		assert.Equal(t, "https://evermos.com/callback", redirect.Scheme+"://"+redirect.Host+redirect.Path)
+		assert.Equal(t, "xyz", redirect.Query().Get("state"))
+		code := store.codes[digestToken(redirect.Query().Get("code"))]
+		assert.Equal(t, "client_web", code.ClientID)
+		assert.Equal(t, "10001", code.UserID.String)
+	})
+
+	t.Run("keeps the query of the redirect URI", func(t *testing.T) {
+		request := authorizationRequest("client_web")
+		request.RedirectURI = "https://evermos.com/login?next=cart"
+
+		redirect, err := NewWithStore(newFakeTokenStore(t), config).Authorize(request)
+
+		require.NoError(t, err)
+		assert.Equal(t, "cart", redirect.Query().Get("next"))
+		assert.NotEmpty(t, redirect.Query().Get("code"))
+	})
+
+	t.Run("defaults to the only redirect URI", func(t *testing.T) {
+		request := authorizationRequest("client_mobile")
+		request.RedirectURI = ""
+
+		redirect, err := NewWithStore(newFakeTokenStore(t), config).Authorize(request)
+
+		require.NoError(t, err)
+		assert.Equal(t, "com.evermos.app", redirect.Scheme)
+		assert.NotEmpty(t, redirect.Query().Get("code"))
+	})
+
+	t.Run("does not redirect to unregistered URIs", func(t *testing.T) {
+		for _, redirectURI := range []string{"https://evermos.com/callback/", "https://evil.example/callback", ""} {
+			request := authorizationRequest("client_web")
+			request.RedirectURI = redirectURI
+
+			redirect, err := NewWithStore(newFakeTokenStore(t), config).Authorize(request)
+
+			assert.Nil(t, redirect)
+			assertOAuthError(t, ErrCodeInvalidRequest, err)
+		}
+	})
+
+	t.Run("does not redirect for unknown clients", func(t *testing.T) {
+		redirect, err := NewWithStore(newFakeTokenStore(t), config).Authorize(authorizationRequest("nobody"))
+
+		assert.Nil(t, redirect)
+		assertOAuthError(t, ErrCodeInvalidRequest, err)
+	})
+
+	cases := []struct {
+		name   string
+		modify func(request *AuthorizationRequest)
+		code   string
+	}{
+		{
+			name:   "unsupported response type",
+			modify: func(request *AuthorizationRequest) { request.ResponseType = "token" },
+			code:   ErrCodeUnsupportedResponseType,
+		},
+		{
+			name:   "plain code challenge",
+			modify: func(request *AuthorizationRequest) { request.CodeChallengeMethod = "plain" },
+			code:   ErrCodeInvalidRequest,
+		},
+		{
+			name:   "missing code challenge",
+			modify: func(request *AuthorizationRequest) { request.CodeChallenge = "" },
+			code:   ErrCodeInvalidRequest,
+		},
+		{
+			name:   "wrong password",
+			modify: func(request *AuthorizationRequest) { request.Password = "wrong" },
+			code:   ErrCodeAccessDenied,
+		},
+		{
+			name:   "scope the client does not have",
+			modify: func(request *AuthorizationRequest) { request.Scope = "admin" },
+			code:   ErrCodeInvalidScope,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store := newFakeTokenStore(t)
+			request := authorizationRequest("client_web")
+			c.modify(&request)
+
+			redirect, err := NewWithStore(store, config).Authorize(request)
+
+			require.NoError(t, err)
+			assert.Equal(t, c.code, redirect.Query().Get("error"))
+			assert.Equal(t, "xyz", redirect.Query().Get("state"))
+			assert.Empty(t, redirect.Query().Get("code"))
+			assert.Empty(t, store.codes)
+		})
+	}
+}
+
+func TestTokenAuthorizationCode(t *testing.T) {
+	config := Config{Expiration: 3600, RefreshExpiration: 7200, CodeExpiration: 60}
+
+	authorize := func(t *testing.T, token *Token, clientID string) string {
+		redirect, err := token.Authorize(authorizationRequest(clientID))
+		require.NoError(t, err)
+		code := redirect.Query().Get("code")
+		require.NotEmpty(t, code)
+		return code
+	}
+
+	exchange := func(clientID string, clientSecret string, code string) Credential {
+		return Credential{
+			GrantType:    AuthorizationCode,
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Code:         code,
+			RedirectURI:  "https://evermos.com/callback",
+			CodeVerifier: codeVerifier,
+		}
+	}
+
+	t.Run("exchanges a code once", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+		token := NewWithStore(store, config)
+		code := authorize(t, token, "client_web")
+
+		res, err := token.Create(exchange("client_web", "3v3rm0s", code))
+
+		require.NoError(t, err)
+		assert.Equal(t, "10001", store.tokens[digestToken(res.AccessToken)].UserID.String)
+		assert.NotEmpty(t, res.RefreshToken)
+		assert.Equal(t, "user orders:read orders:write", res.Scope)
+
+		_, err = token.Create(exchange("client_web", "3v3rm0s", code))
+		assertOAuthError(t, ErrCodeInvalidGrant, err)
+	})
+
+	t.Run("tokens get the scope of the authorization request", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+		token := NewWithStore(store, config)
+		request := authorizationRequest("client_web")
+		request.Scope = "orders:read"
+		redirect, err := token.Authorize(request)
+		require.NoError(t, err)
+
+		credential := exchange("client_web", "3v3rm0s", redirect.Query().Get("code"))
+		credential.Scope = "orders:write"
+		res, err := token.Create(credential)
+
+		assert.NoError(t, err)
+		assert.Equal(t, "orders:read", res.Scope)
+	})
+
+	t.Run("public clients authenticate without a secret", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+		token := NewWithStore(store, config)
+		redirect, err := token.Authorize(AuthorizationRequest{
+			ResponseType:        "code",
+			ClientID:            "client_mobile",
+			CodeChallenge:       codeChallenge,
+			CodeChallengeMethod: CodeChallengeS256,
+			Username:            "jane@example.com",
+			Password:            "s3cret",
+		})
+		require.NoError(t, err)
+
+		res, err := token.Create(Credential{
+			GrantType:    AuthorizationCode,
+			ClientID:     "client_mobile",
+			Code:         redirect.Query().Get("code"),
+			CodeVerifier: codeVerifier,
+		})
+
+		assert.NoError(t, err)
+		assert.NotEmpty(t, res.AccessToken)
+
+		_, err = token.Introspect(Credential{ClientID: "client_mobile"}, res.AccessToken)
+		assertOAuthError(t, ErrCodeUnauthorizedClient, err)
+	})
+
+	cases := []struct {
+		name   string
+		modify func(credential *Credential)
+		code   string
+	}{
+		{
+			name:   "wrong code verifier",
+			modify: func(credential *Credential) { credential.CodeVerifier = "wrong-verifier-wrong-verifier-wrong-verifier" },
+			code:   ErrCodeInvalidGrant,
+		},
+		{
+			name:   "missing code verifier",
+			modify: func(credential *Credential) { credential.CodeVerifier = "" },
+			code:   ErrCodeInvalidRequest,
+		},
+		{
+			name:   "different redirect URI",
+			modify: func(credential *Credential) { credential.RedirectURI = "https://evermos.com/login?next=cart" },
+			code:   ErrCodeInvalidGrant,
+		},
+		{
+			name:   "unknown code",
+			modify: func(credential *Credential) { credential.Code = "unknown" },
+			code:   ErrCodeInvalidGrant,
+		},
+		{
+			name: "code of another client",
+			modify: func(credential *Credential) {
+				credential.ClientID = "client_mobile"
+				credential.ClientSecret = ""
+			},
+			code: ErrCodeInvalidGrant,
+		},
+		{
+			name:   "wrong client secret",
+			modify: func(credential *Credential) { credential.ClientSecret = "wrong" },
+			code:   ErrCodeInvalidClient,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store := newFakeTokenStore(t)
+			token := NewWithStore(store, config)
+			credential := exchange("client_web", "3v3rm0s", authorize(t, token, "client_web"))
+			c.modify(&credential)
+
+			_, err := token.Create(credential)
+
+			assertOAuthError(t, c.code, err)
+			assert.Empty(t, store.tokens)
+		})
+	}
+
+	t.Run("expired code", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+		token := NewWithStore(store, config)
+		code := authorize(t, token, "client_web")
+		expired := store.codes[digestToken(code)]
+		expired.Expires = time.Now().Add(-time.Second)
+		store.codes[digestToken(code)] = expired
+
+		_, err := token.Create(exchange("client_web", "3v3rm0s", code))
+
+		assertOAuthError(t, ErrCodeInvalidGrant, err)
+	})
+}
+
+func TestRedirectWith(t *testing.T) {
+	redirect, err := redirectWith("https://evermos.com/cb?a=1", "s", url.Values{"code": {"c"}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://evermos.com/cb?a=1&code=c&state=s", redirect.String())
+}
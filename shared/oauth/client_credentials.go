@@ -1,32 +1,28 @@
 package oauth
 
-import (
-	"errors"
-)
-
 type ClientCredentialsAuth struct {
 	tokenStore TokenStore
 	config     Config
 }
 
 func (c *ClientCredentialsAuth) Create(credential Credential) (oauthAccessToken OauthAccessToken, err error) {
-	client, err := c.tokenStore.resolveClientByClientID(credential.ClientID)
+	client, err := authenticateClient(c.tokenStore, credential, ClientCredentials)
 	if err != nil {
 		return
 	}
 
-	if !client.VerifyClient(credential) {
-		err = errors.New(ErrorInvalidClient)
+	scope, err := narrowScope(credential.Scope, client.Scope.String)
+	if err != nil {
 		return
 	}
 
 	accessToken, err := generateAccessToken()
 	if err != nil {
-		err = errors.New(ErrorGenerateAccessToken)
+		err = NewError(ErrCodeServerError, ErrorGenerateAccessToken)
 		return
 	}
 
-	oauthAccessToken = new(OauthAccessToken).Generate(accessToken, credential.ClientID, nil, true, c.config)
+	oauthAccessToken = new(OauthAccessToken).Generate(accessToken, credential.ClientID, nil, scope, c.config)
 	err = c.tokenStore.createAccessToken(oauthAccessToken)
 	if err != nil {
 		return
@@ -1,11 +1,54 @@
 package oauth
 
 const (
-	ErrorEmptyCredential     string = "Credential can't be empty"
-	ErrorClientNotFound      string = "Client does not exist"
-	ErrorInvalidPassword     string = "Invalid password credential"
-	ErrorInvalidClient       string = "Invalid client credentials"
-	ErrorInvalidToken        string = "Invalid Token"
-	ErrorTokenTypeMismatch   string = "Token type mismatch"
-	ErrorGenerateAccessToken string = "Error generating access token"
+	ErrorEmptyCredential      string = "Credential can't be empty"
+	ErrorClientNotFound       string = "Client does not exist"
+	ErrorInvalidPassword      string = "Invalid password credential"
+	ErrorInvalidClient        string = "Invalid client credentials"
+	ErrorInvalidToken         string = "Invalid Token"
+	ErrorTokenTypeMismatch    string = "Token type mismatch"
+	ErrorGenerateAccessToken  string = "Error generating access token"
+	ErrorUnsupportedGrantType string = "Grant type is not supported"
+	ErrorGrantNotAllowed      string = "Client is not allowed to use this grant type"
+	ErrorTokenNotOwned        string = "Token was not issued to this client"
+	ErrorInvalidRefreshToken  string = "Invalid refresh token"
+	ErrorRefreshTokenReused   string = "Refresh token was already used"
+	ErrorInvalidCode          string = "Invalid authorization code"
+	ErrorInvalidRedirectURI   string = "Redirect URI is not registered for this client"
+	ErrorInvalidCodeVerifier  string = "Code verifier does not match the code challenge"
+	ErrorPublicClient         string = "Public clients are not allowed to use this endpoint"
+	ErrorScopeNotAllowed      string = "Requested scope is not allowed"
+	ErrorSessionNotFound      string = "Session not found"
 )
+
+// Error codes of RFC 6749 section 5.2.
+const (
+	ErrCodeInvalidRequest       string = "invalid_request"
+	ErrCodeInvalidClient        string = "invalid_client"
+	ErrCodeInvalidGrant         string = "invalid_grant"
+	ErrCodeUnauthorizedClient   string = "unauthorized_client"
+	ErrCodeUnsupportedGrantType string = "unsupported_grant_type"
+	ErrCodeInvalidScope         string = "invalid_scope"
+	ErrCodeServerError          string = "server_error"
+)
+
+// Error codes of the authorization endpoint, RFC 6749 section 4.1.2.1.
+const (
+	ErrCodeAccessDenied            string = "access_denied"
+	ErrCodeUnsupportedResponseType string = "unsupported_response_type"
+)
+
+// Error is an OAuth error response as defined by RFC 6749 section 5.2.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// NewError creates an Error.
+func NewError(code string, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
+
+func (e *Error) Error() string {
+	return e.Description
+}
@@ -1,5 +1,9 @@
 package oauth
 
+import (
+	"strings"
+)
+
 type AuthorizationMethod interface {
 	Create(credential Credential) (OauthAccessToken, error)
 }
@@ -20,6 +24,55 @@ func (g *Grant) Create(credential Credential) (OauthAccessToken, error) {
 	authMap := make(map[GrantType]AuthorizationMethod)
 	authMap[ClientCredentials] = &ClientCredentialsAuth{tokenStore: g.TokenStore, config: g.Config}
 	authMap[Password] = &PasswordAuth{tokenStore: g.TokenStore, config: g.Config}
+	authMap[RefreshToken] = &RefreshTokenAuth{tokenStore: g.TokenStore, config: g.Config}
+	authMap[AuthorizationCode] = &AuthorizationCodeAuth{tokenStore: g.TokenStore, config: g.Config}
+
+	auth, ok := authMap[credential.GrantType]
+	if !ok {
+		return OauthAccessToken{}, NewError(ErrCodeUnsupportedGrantType, ErrorUnsupportedGrantType)
+	}
+
+	return auth.Create(credential)
+}
+
+// authenticateClient resolves the client of a credential and verifies its
+// secret. When grantType is not empty, the client must also be allowed to use
+// it.
+func authenticateClient(tokenStore TokenStore, credential Credential, grantType GrantType) (client OauthClient, err error) {
+	if credential.ClientID == "" {
+		err = NewError(ErrCodeInvalidClient, ErrorInvalidClient)
+		return
+	}
+
+	client, err = tokenStore.resolveClientByClientID(credential.ClientID)
+	if err != nil {
+		if err.Error() == ErrorClientNotFound {
+			err = NewError(ErrCodeInvalidClient, ErrorInvalidClient)
+		}
+		return
+	}
+
+	if !client.VerifyClient(credential) {
+		err = NewError(ErrCodeInvalidClient, ErrorInvalidClient)
+		return
+	}
+
+	if grantType != "" && !client.AllowsGrant(grantType) {
+		err = NewError(ErrCodeUnauthorizedClient, ErrorGrantNotAllowed)
+		return
+	}
+
+	return
+}
+
+// AllowsGrant reports whether the client may use grantType. GrantTypes is a
+// space separated list.
+func (o *OauthClient) AllowsGrant(grantType GrantType) bool {
+	for _, allowed := range strings.Fields(o.GrantTypes) {
+		if allowed == string(grantType) {
+			return true
+		}
+	}
 
-	return authMap[credential.GrantType].Create(credential)
+	return false
 }
@@ -0,0 +1,103 @@
+package oauth
+
+import (
+	"time"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/infras"
+	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/rs/zerolog/log"
+)
+
+// defaultJanitorBatchSize is the number of rows the janitor deletes per
+// statement when none is configured.
+const defaultJanitorBatchSize = 1000
+
+// Janitor periodically purges expired access tokens, refresh tokens and
+// authorization codes, in batches so as not to hold long locks.
+type Janitor struct {
+	tokenStore TokenStore
+	interval   time.Duration
+	batchSize  int
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewJanitor creates a Janitor purging every interval. A non positive
+// interval disables it.
+func NewJanitor(tokenStore TokenStore, interval time.Duration, batchSize int) *Janitor {
+	if batchSize <= 0 {
+		batchSize = defaultJanitorBatchSize
+	}
+
+	return &Janitor{
+		tokenStore: tokenStore,
+		interval:   interval,
+		batchSize:  batchSize,
+	}
+}
+
+// ProvideJanitor creates a Janitor from OAUTH.JANITOR_* configuration.
+func ProvideJanitor(db *infras.MySQLConn, conf *configs.Config) *Janitor {
+	return NewJanitor(
+		NewTokenStore(db.Write),
+		time.Duration(conf.OAuth.JanitorIntervalSeconds)*time.Second,
+		conf.OAuth.JanitorBatchSize)
+}
+
+// Start purges in the background until Stop is called.
+func (j *Janitor) Start() {
+	if j.interval <= 0 {
+		log.Info().Msg("OAuth token janitor is disabled.")
+		return
+	}
+
+	j.stop = make(chan struct{})
+	j.done = make(chan struct{})
+	log.Info().Str("interval", j.interval.String()).Msg("OAuth token janitor started.")
+
+	go func() {
+		defer close(j.done)
+
+		ticker := time.NewTicker(j.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-j.stop:
+				return
+			case <-ticker.C:
+				purged, err := j.Purge()
+				if err != nil {
+					logger.ErrorWithStack(err)
+				}
+				log.Debug().Int64("purged", purged).Msg("OAuth token janitor run completed.")
+			}
+		}
+	}()
+}
+
+// Stop stops purging and waits for a running purge to complete.
+func (j *Janitor) Stop() {
+	if j.stop == nil {
+		return
+	}
+
+	close(j.stop)
+	<-j.done
+	j.stop = nil
+}
+
+// Purge deletes everything that has expired, one batch at a time, and returns
+// how many rows were deleted.
+func (j *Janitor) Purge() (int64, error) {
+	var purged int64
+	now := time.Now()
+	for {
+		rows, err := j.tokenStore.purgeExpired(now, j.batchSize)
+		purged += rows
+		if err != nil || rows == 0 {
+			return purged, err
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package oauth
+
+import (
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	queryUpdateClientSecret = `UPDATE oauth_clients SET client_secret = ? WHERE client_id = ?`
+
+	queryDigestAccessTokens = `UPDATE oauth_access_tokens
+		SET access_token = SHA2(access_token, 256)
+		WHERE CHAR_LENGTH(access_token) <> 64`
+
+	queryDigestRefreshTokens = `UPDATE oauth_refresh_tokens
+		SET
+			refresh_token = SHA2(refresh_token, 256),
+			family_id = SHA2(family_id, 256),
+			access_token = SHA2(access_token, 256)
+		WHERE CHAR_LENGTH(refresh_token) <> 64`
+)
+
+// MigrationResult counts the rows converted by MigrateCredentials.
+type MigrationResult struct {
+	ClientSecrets int64
+	AccessTokens  int64
+	RefreshTokens int64
+}
+
+// MigrateCredentials converts the credentials stored in plaintext: client
+// secrets are replaced by their bcrypt hash and tokens by their SHA-256
+// digest. Rows already converted are left alone, so it is safe to run more
+// than once.
+func MigrateCredentials(db *sqlx.DB) (result MigrationResult, err error) {
+	var clients []OauthClient
+	err = db.Select(&clients, querySelectClients)
+	if err != nil {
+		return
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			result = MigrationResult{}
+		}
+	}()
+
+	for _, client := range clients {
+		if client.Public() {
+			continue
+		}
+
+		if _, costErr := bcrypt.Cost([]byte(client.ClientSecret)); costErr == nil {
+			continue
+		}
+
+		var secret string
+		secret, err = HashClientSecret(client.ClientSecret)
+		if err != nil {
+			return
+		}
+
+		_, err = tx.Exec(queryUpdateClientSecret, secret, client.ClientID)
+		if err != nil {
+			return
+		}
+		result.ClientSecrets++
+	}
+
+	result.AccessTokens, err = execRowsAffected(tx, queryDigestAccessTokens)
+	if err != nil {
+		return
+	}
+
+	result.RefreshTokens, err = execRowsAffected(tx, queryDigestRefreshTokens)
+	if err != nil {
+		return
+	}
+
+	err = tx.Commit()
+	return
+}
+
+func execRowsAffected(tx *sqlx.Tx, query string) (int64, error) {
+	res, err := tx.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
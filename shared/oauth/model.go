@@ -1,7 +1,11 @@
 package oauth
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"golang.org/x/crypto/bcrypt"
+	"math"
 	"strconv"
 	"time"
 
@@ -14,12 +18,6 @@ const (
 	Bearer TokenType = "Bearer"
 )
 
-var scope = struct {
-	User string
-}{
-	"user",
-}
-
 // Credential is
 type Credential struct {
 	GrantType    GrantType
@@ -27,27 +25,38 @@ type Credential struct {
 	ClientSecret string
 	Username     string
 	Password     string
+	RefreshToken string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	Scope        string
 }
 
+// OauthAccessToken is an access token. Only the SHA-256 digest of a token is
+// stored, so Token and RefreshToken, the tokens handed to the client, are only
+// known when the token is issued.
 type OauthAccessToken struct {
-	AccessToken string      `json:"accessToken" db:"access_token"`
-	ClientID    string      `json:"clientId" db:"client_id"`
-	UserID      null.String `json:"userId" db:"user_id"`
-	Expires     time.Time   `json:"expires" db:"expires"`
-	Scope       null.String `json:"scope" db:"scope"`
+	AccessToken  string      `json:"accessToken" db:"access_token"`
+	ClientID     string      `json:"clientId" db:"client_id"`
+	UserID       null.String `json:"userId" db:"user_id"`
+	Expires      time.Time   `json:"expires" db:"expires"`
+	Scope        null.String `json:"scope" db:"scope"`
+	Token        string      `json:"-" db:"-"`
+	RefreshToken string      `json:"-" db:"-"`
 }
 
-func (o *OauthAccessToken) Generate(accessToken string, clientID string, userID *int, withScope bool, config Config) OauthAccessToken {
+func (o *OauthAccessToken) Generate(accessToken string, clientID string, userID *int, scope string, config Config) OauthAccessToken {
 	if userID != nil {
 		o.UserID = null.StringFrom(strconv.Itoa(*userID))
 	}
 
-	if withScope {
-		o.Scope = null.StringFrom(scope.User)
+	if scope != "" {
+		o.Scope = null.StringFrom(scope)
 	}
 
 	o.ClientID = clientID
-	o.AccessToken = accessToken
+	o.Token = accessToken
+	o.AccessToken = digestToken(accessToken)
 	o.Expires = time.Now().Add(time.Second * time.Duration(config.Expiration))
 
 	return *o
@@ -63,19 +72,117 @@ func (o *OauthAccessToken) VerifyExpireIn() bool {
 }
 
 func (o *OauthAccessToken) VerifyUserLoggedIn() bool {
-	if o.UserID.Valid && !o.Scope.Valid {
-		return true
-	}
-	return false
+	return o.UserID.Valid
 }
 
 func (o *OauthAccessToken) toCreateTokenResponse() *TokenResponse {
 	return &TokenResponse{
-		AccessToken: o.AccessToken,
-		ExpiresIn:   o.Expires,
-		TokenType:   string(Bearer),
-		Scope:       scope.User,
+		AccessToken:  o.Token,
+		TokenType:    string(Bearer),
+		ExpiresIn:    int64(math.Round(time.Until(o.Expires).Seconds())),
+		Scope:        o.Scope.String,
+		RefreshToken: o.RefreshToken,
+	}
+}
+
+func (o *OauthAccessToken) toIntrospection() Introspection {
+	return Introspection{
+		Active:    true,
+		ClientID:  o.ClientID,
+		Subject:   o.UserID.String,
+		Scope:     o.Scope.String,
+		TokenType: string(Bearer),
+		ExpiresAt: o.Expires.Unix(),
+	}
+}
+
+// OauthRefreshToken is a refresh token. Refresh tokens are rotated on use: the
+// token used is marked as rotated and a new one is issued in the same family.
+// A family starts with the refresh token issued by the password grant, and
+// FamilyID is that first token. Like access tokens, refresh tokens are stored
+// as SHA-256 digests.
+type OauthRefreshToken struct {
+	RefreshToken string      `db:"refresh_token"`
+	FamilyID     string      `db:"family_id"`
+	AccessToken  string      `db:"access_token"`
+	ClientID     string      `db:"client_id"`
+	UserID       null.String `db:"user_id"`
+	Scope        null.String `db:"scope"`
+	Expires      time.Time   `db:"expires"`
+	RotatedAt    null.Time   `db:"rotated_at"`
+	RevokedAt    null.Time   `db:"revoked_at"`
+	CreatedAt    time.Time   `db:"created_at"`
+}
+
+// Generate fills a refresh token issued along with accessToken. An empty
+// familyID starts a new family. The scope of a refresh token may be broader
+// than that of the access token, which the client may have narrowed.
+func (o *OauthRefreshToken) Generate(refreshToken string, familyID string, accessToken OauthAccessToken, scope null.String, config Config) OauthRefreshToken {
+	if familyID == "" {
+		familyID = digestToken(refreshToken)
+	}
+
+	o.RefreshToken = digestToken(refreshToken)
+	o.FamilyID = familyID
+	o.AccessToken = accessToken.AccessToken
+	o.ClientID = accessToken.ClientID
+	o.UserID = accessToken.UserID
+	o.Scope = scope
+	o.CreatedAt = time.Now()
+	o.Expires = o.CreatedAt.Add(time.Second * time.Duration(config.RefreshExpiration))
+
+	return *o
+}
+
+func (o *OauthRefreshToken) VerifyExpireIn() bool {
+	return time.Now().Before(o.Expires)
+}
+
+// OauthAuthorizationCode is an authorization code of the authorization_code
+// grant, stored as a SHA-256 digest. Codes are short lived and can be
+// exchanged only once, by the client they were issued to and with the PKCE
+// code verifier matching CodeChallenge.
+type OauthAuthorizationCode struct {
+	Code                string      `db:"code"`
+	ClientID            string      `db:"client_id"`
+	UserID              null.String `db:"user_id"`
+	RedirectURI         string      `db:"redirect_uri"`
+	CodeChallenge       string      `db:"code_challenge"`
+	CodeChallengeMethod string      `db:"code_challenge_method"`
+	Scope               null.String `db:"scope"`
+	Expires             time.Time   `db:"expires"`
+	UsedAt              null.Time   `db:"used_at"`
+}
+
+func (o *OauthAuthorizationCode) Generate(code string, clientID string, userID int, scope string, request AuthorizationRequest, config Config) OauthAuthorizationCode {
+	o.Code = digestToken(code)
+	o.ClientID = clientID
+	o.UserID = null.StringFrom(strconv.Itoa(userID))
+	o.RedirectURI = request.RedirectURI
+	o.CodeChallenge = request.CodeChallenge
+	o.CodeChallengeMethod = request.CodeChallengeMethod
+	if scope != "" {
+		o.Scope = null.StringFrom(scope)
 	}
+	o.Expires = time.Now().Add(time.Second * time.Duration(config.CodeExpiration))
+
+	return *o
+}
+
+func (o *OauthAuthorizationCode) VerifyExpireIn() bool {
+	return time.Now().Before(o.Expires)
+}
+
+// VerifyCodeVerifier reports whether verifier matches the S256 code challenge,
+// as defined by RFC 7636 section 4.6.
+func (o *OauthAuthorizationCode) VerifyCodeVerifier(verifier string) bool {
+	if o.CodeChallengeMethod != CodeChallengeS256 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(o.CodeChallenge)) == 1
 }
 
 type OauthClient struct {
@@ -83,25 +190,54 @@ type OauthClient struct {
 	ClientSecret string `json:"clientSecret" db:"client_secret"`
 	RedirectURI  string `json:"redirectUri" db:"redirect_uri"`
 	GrantTypes   string `json:"grantTypes" db:"grant_types"`
+	// Scope is the space separated list of scopes the client may request.
+	Scope null.String `json:"scope" db:"scope"`
 }
 
+// Public reports whether the client is a public client, such as a mobile app,
+// which cannot keep a secret. Public clients have no client secret.
+func (o *OauthClient) Public() bool {
+	return o.ClientSecret == ""
+}
+
+// VerifyClient verifies the client credentials. ClientSecret is a bcrypt hash,
+// see HashClientSecret, or empty for public clients.
 func (o *OauthClient) VerifyClient(credential Credential) bool {
 	if o.ClientID != credential.ClientID {
 		return false
 	}
 
-	if o.ClientSecret != credential.ClientSecret {
+	if o.Public() {
+		return credential.ClientSecret == ""
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(o.ClientSecret), []byte(credential.ClientSecret))
+	if err != nil {
 		return false
 	}
 
 	return true
 }
 
+// TokenResponse is the successful response of the token endpoint, as defined
+// by RFC 6749 section 5.1.
 type TokenResponse struct {
-	AccessToken string
-	ExpiresIn   time.Time
-	TokenType   string
-	Scope       string
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// Introspection is the response of the introspection endpoint, as defined by
+// RFC 7662 section 2.2. Only Active is set for inactive tokens.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Scope     string `json:"scope,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
 }
 
 type User struct {
@@ -29,7 +29,7 @@ func (p *Parser) Parse(accessToken string) (accessTokenClient OauthAccessToken,
 		return
 	}
 
-	accessTokenClient, err = p.TokenStore.resolveAccessTokenByAccessToken(token[1])
+	accessTokenClient, err = p.TokenStore.resolveAccessTokenByAccessToken(digestToken(token[1]))
 	if err != nil {
 		return
 	}
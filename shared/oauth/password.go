@@ -1,45 +1,59 @@
 package oauth
 
-import (
-	"errors"
-)
-
 type PasswordAuth struct {
 	tokenStore TokenStore
 	config     Config
 }
 
 func (c *PasswordAuth) Create(credential Credential) (oauthAccessToken OauthAccessToken, err error) {
-	client, err := c.tokenStore.resolveClientByClientID(credential.ClientID)
+	client, err := authenticateClient(c.tokenStore, credential, Password)
 	if err != nil {
 		return
 	}
 
-	if !client.VerifyClient(credential) {
-		err = errors.New(ErrorInvalidClient)
+	scope, err := narrowScope(credential.Scope, client.Scope.String)
+	if err != nil {
 		return
 	}
 
-	user, err := c.tokenStore.resolveByTelephoneOrEmail(credential.Username)
+	user, err := authenticateUser(c.tokenStore, credential)
 	if err != nil {
 		return
 	}
 
-	if !user.ValidCredential(credential) {
-		err = errors.New(ErrorInvalidPassword)
+	accessToken, err := generateAccessToken()
+	if err != nil {
+		err = NewError(ErrCodeServerError, ErrorGenerateAccessToken)
 		return
 	}
 
-	accessToken, err := generateAccessToken()
+	oauthAccessToken = new(OauthAccessToken).Generate(accessToken, credential.ClientID, &user.ID, scope, c.config)
+
+	err = c.tokenStore.createAccessToken(oauthAccessToken)
 	if err != nil {
-		err = errors.New(ErrorGenerateAccessToken)
 		return
 	}
 
-	oauthAccessToken = new(OauthAccessToken).Generate(accessToken, credential.ClientID, &user.ID, false, c.config)
+	if client.AllowsGrant(RefreshToken) {
+		err = issueRefreshToken(c.tokenStore, c.config, &oauthAccessToken, "", oauthAccessToken.Scope)
+	}
 
-	err = c.tokenStore.createAccessToken(oauthAccessToken)
+	return
+}
+
+// authenticateUser resolves the user of a credential and verifies their
+// password.
+func authenticateUser(tokenStore TokenStore, credential Credential) (user User, err error) {
+	user, err = tokenStore.resolveByTelephoneOrEmail(credential.Username)
 	if err != nil {
+		if err.Error() == ErrorClientNotFound {
+			err = NewError(ErrCodeInvalidGrant, ErrorInvalidPassword)
+		}
+		return
+	}
+
+	if !user.ValidCredential(credential) {
+		err = NewError(ErrCodeInvalidGrant, ErrorInvalidPassword)
 		return
 	}
 
@@ -0,0 +1,112 @@
+package oauth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/guregu/null"
+)
+
+type RefreshTokenAuth struct {
+	tokenStore TokenStore
+	config     Config
+}
+
+// Create exchanges a refresh token for a new access token and a new refresh
+// token. The refresh token used is rotated and cannot be used again: using it
+// again revokes its whole family, since either its holder or someone who
+// stole it is replaying it. The access token may be narrowed to some of the
+// scopes of the refresh token, while the new refresh token keeps them all.
+func (c *RefreshTokenAuth) Create(credential Credential) (oauthAccessToken OauthAccessToken, err error) {
+	_, err = authenticateClient(c.tokenStore, credential, RefreshToken)
+	if err != nil {
+		return
+	}
+
+	if credential.RefreshToken == "" {
+		err = NewError(ErrCodeInvalidRequest, "refresh_token is required")
+		return
+	}
+
+	refreshToken, err := c.tokenStore.resolveRefreshToken(digestToken(credential.RefreshToken))
+	if err != nil {
+		if err.Error() == ErrorClientNotFound {
+			err = NewError(ErrCodeInvalidGrant, ErrorInvalidRefreshToken)
+		}
+		return
+	}
+
+	if refreshToken.ClientID != credential.ClientID || refreshToken.RevokedAt.Valid || !refreshToken.VerifyExpireIn() {
+		err = NewError(ErrCodeInvalidGrant, ErrorInvalidRefreshToken)
+		return
+	}
+
+	scope := refreshToken.Scope.String
+	if credential.Scope != "" {
+		if !HasScope(scope, strings.Fields(credential.Scope)...) {
+			err = NewError(ErrCodeInvalidScope, ErrorScopeNotAllowed)
+			return
+		}
+		scope = credential.Scope
+	}
+
+	accessToken, err := generateAccessToken()
+	if err != nil {
+		err = NewError(ErrCodeServerError, ErrorGenerateAccessToken)
+		return
+	}
+
+	oauthAccessToken = new(OauthAccessToken).Generate(accessToken, credential.ClientID, nil, scope, c.config)
+	oauthAccessToken.UserID = refreshToken.UserID
+
+	next, err := newRefreshToken(c.config, &oauthAccessToken, refreshToken.FamilyID, refreshToken.Scope)
+	if err != nil {
+		return
+	}
+
+	// The rotation and the new tokens are stored together, so a failure
+	// leaves the refresh token usable for the client's retry instead of
+	// making the retry look like reuse.
+	now := time.Now()
+	rotated := false
+	if !refreshToken.RotatedAt.Valid {
+		rotated, err = c.tokenStore.rotateRefreshToken(refreshToken.RefreshToken, now, oauthAccessToken, next)
+		if err != nil {
+			return
+		}
+	}
+
+	if !rotated {
+		err = c.tokenStore.revokeRefreshTokenFamily(refreshToken.FamilyID, now)
+		if err != nil {
+			return
+		}
+
+		err = NewError(ErrCodeInvalidGrant, ErrorRefreshTokenReused)
+	}
+
+	return
+}
+
+// issueRefreshToken issues a refresh token for scope along with accessToken,
+// in the family familyID or in a new family when familyID is empty.
+func issueRefreshToken(tokenStore TokenStore, config Config, accessToken *OauthAccessToken, familyID string, scope null.String) error {
+	refreshToken, err := newRefreshToken(config, accessToken, familyID, scope)
+	if err != nil {
+		return err
+	}
+
+	return tokenStore.createRefreshToken(refreshToken)
+}
+
+// newRefreshToken generates a refresh token for scope along with accessToken,
+// in the family familyID or in a new family when familyID is empty.
+func newRefreshToken(config Config, accessToken *OauthAccessToken, familyID string, scope null.String) (OauthRefreshToken, error) {
+	token, err := generateAccessToken()
+	if err != nil {
+		return OauthRefreshToken{}, NewError(ErrCodeServerError, ErrorGenerateAccessToken)
+	}
+
+	accessToken.RefreshToken = token
+	return new(OauthRefreshToken).Generate(token, familyID, *accessToken, scope, config), nil
+}
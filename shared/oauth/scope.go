@@ -0,0 +1,48 @@
+package oauth
+
+import (
+	"strings"
+)
+
+// HasScope reports whether scope, a space separated list of scopes as defined
+// by RFC 6749 section 3.3, includes all of required.
+func HasScope(scope string, required ...string) bool {
+	granted := make(map[string]bool)
+	for _, s := range strings.Fields(scope) {
+		granted[s] = true
+	}
+
+	for _, s := range required {
+		if !granted[s] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// narrowScope returns the scopes of requested that are also in allowed, in
+// the order they were requested. When nothing is requested, all of allowed is
+// granted. An invalid_scope error is returned when none of the requested
+// scopes is allowed.
+func narrowScope(requested string, allowed string) (string, error) {
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(strings.Fields(allowed), " "), nil
+	}
+
+	granted := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, s := range strings.Fields(requested) {
+		if seen[s] || !HasScope(allowed, s) {
+			continue
+		}
+		seen[s] = true
+		granted = append(granted, s)
+	}
+
+	if len(granted) == 0 {
+		return "", NewError(ErrCodeInvalidScope, ErrorScopeNotAllowed)
+	}
+
+	return strings.Join(granted, " "), nil
+}
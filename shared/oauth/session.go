@@ -0,0 +1,64 @@
+package oauth
+
+import (
+	"errors"
+	"time"
+)
+
+// Session is an active access token of a user. Its ID is the digest of the
+// token, which cannot be used as a token itself.
+type Session struct {
+	ID        string    `json:"id"`
+	ClientID  string    `json:"client_id"`
+	Scope     string    `json:"scope,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Current   bool      `json:"current"`
+}
+
+// Sessions returns the active access tokens of a user, only those issued to
+// clientID unless it is empty.
+func (t *Token) Sessions(userID string, clientID string) ([]Session, error) {
+	accessTokens, err := t.tokenRepository.resolveAccessTokensByUserID(userID, clientID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(accessTokens))
+	for _, accessToken := range accessTokens {
+		sessions = append(sessions, Session{
+			ID:        accessToken.AccessToken,
+			ClientID:  accessToken.ClientID,
+			Scope:     accessToken.Scope.String,
+			ExpiresAt: accessToken.Expires,
+		})
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes an active access token of a user. When the token was
+// issued with a refresh token, the refresh token family is revoked as well so
+// that the client cannot get a new access token.
+func (t *Token) RevokeSession(userID string, sessionID string) error {
+	accessToken, err := t.tokenRepository.resolveAccessTokenByAccessToken(sessionID)
+	if err != nil {
+		if err.Error() == ErrorClientNotFound {
+			return errors.New(ErrorSessionNotFound)
+		}
+		return err
+	}
+
+	if accessToken.UserID.String != userID || !accessToken.VerifyExpireIn() {
+		return errors.New(ErrorSessionNotFound)
+	}
+
+	refreshToken, err := t.tokenRepository.resolveRefreshTokenByAccessToken(accessToken.AccessToken)
+	switch {
+	case err == nil:
+		return t.tokenRepository.revokeRefreshTokenFamily(refreshToken.FamilyID, time.Now())
+	case err.Error() != ErrorClientNotFound:
+		return err
+	}
+
+	return t.tokenRepository.deleteAccessToken(accessToken.AccessToken)
+}
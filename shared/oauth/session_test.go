@@ -0,0 +1,128 @@
+package oauth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenSessions(t *testing.T) {
+	config := Config{Expiration: 3600, RefreshExpiration: 7200}
+	login := func(t *testing.T, token *Token) *TokenResponse {
+		res, err := token.Create(Credential{
+			GrantType:    Password,
+			ClientID:     "client_web",
+			ClientSecret: "3v3rm0s",
+			Username:     "jane@example.com",
+			Password:     "s3cret",
+		})
+		require.NoError(t, err)
+		return res
+	}
+
+	t.Run("lists active sessions of the user", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+		token := NewWithStore(store, config)
+		res := login(t, token)
+		login(t, token)
+		_, err := token.Create(Credential{GrantType: ClientCredentials, ClientID: "client_web", ClientSecret: "3v3rm0s"})
+		require.NoError(t, err)
+
+		expired := login(t, token)
+		stale := store.tokens[digestToken(expired.AccessToken)]
+		stale.Expires = time.Now().Add(-time.Minute)
+		store.tokens[digestToken(expired.AccessToken)] = stale
+
+		sessions, err := token.Sessions("10001", "")
+		assert.NoError(t, err)
+		assert.Len(t, sessions, 2)
+
+		sessions, err = token.Sessions("10001", "client_mobile")
+		assert.NoError(t, err)
+		assert.Empty(t, sessions)
+
+		ids := make([]string, 0)
+		sessions, _ = token.Sessions("10001", "client_web")
+		for _, session := range sessions {
+			ids = append(ids, session.ID)
+			assert.Equal(t, "client_web", session.ClientID)
+		}
+		assert.Contains(t, ids, digestToken(res.AccessToken))
+	})
+
+	t.Run("revoking a session revokes its refresh token", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+		token := NewWithStore(store, config)
+		res := login(t, token)
+		other := login(t, token)
+
+		assert.NoError(t, token.RevokeSession("10001", digestToken(res.AccessToken)))
+
+		assert.NotContains(t, store.tokens, digestToken(res.AccessToken))
+		assert.Contains(t, store.tokens, digestToken(other.AccessToken))
+		_, err := token.Create(Credential{
+			GrantType:    RefreshToken,
+			ClientID:     "client_web",
+			ClientSecret: "3v3rm0s",
+			RefreshToken: res.RefreshToken,
+		})
+		assertOAuthError(t, ErrCodeInvalidGrant, err)
+	})
+
+	t.Run("sessions of other users are not found", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+		token := NewWithStore(store, config)
+		res := login(t, token)
+
+		err := token.RevokeSession("10002", digestToken(res.AccessToken))
+		assert.EqualError(t, err, ErrorSessionNotFound)
+
+		err = token.RevokeSession("10001", res.AccessToken)
+		assert.EqualError(t, err, ErrorSessionNotFound, "sessions are identified by digest")
+
+		assert.Contains(t, store.tokens, digestToken(res.AccessToken))
+	})
+}
+
+func TestJanitorPurge(t *testing.T) {
+	store := newFakeTokenStore(t)
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	for _, token := range []string{"a", "b", "c", "d", "e"} {
+		store.tokens["expired-"+token] = OauthAccessToken{AccessToken: "expired-" + token, Expires: past}
+	}
+	store.tokens["live"] = OauthAccessToken{AccessToken: "live", Expires: future}
+	store.refresh["expired"] = OauthRefreshToken{RefreshToken: "expired", Expires: past}
+	store.codes["expired"] = OauthAuthorizationCode{Code: "expired", Expires: past}
+
+	purged, err := NewJanitor(store, time.Minute, 2).Purge()
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), purged)
+	assert.Equal(t, 4, store.purges, "batches of 2 and a final empty batch")
+	assert.Len(t, store.tokens, 1)
+	assert.Contains(t, store.tokens, "live")
+	assert.Empty(t, store.refresh)
+	assert.Empty(t, store.codes)
+}
+
+func TestJanitorStartStop(t *testing.T) {
+	store := newFakeTokenStore(t)
+	store.tokens["expired"] = OauthAccessToken{AccessToken: "expired", Expires: time.Now().Add(-time.Hour)}
+	janitor := NewJanitor(store, 5*time.Millisecond, 10)
+
+	janitor.Start()
+	time.Sleep(50 * time.Millisecond)
+	janitor.Stop()
+	purges := store.purges
+
+	assert.Empty(t, store.tokens)
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, purges, store.purges, "no purges after Stop")
+
+	disabled := NewJanitor(store, 0, 10)
+	disabled.Start()
+	disabled.Stop()
+}
@@ -2,11 +2,15 @@ package oauth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/sha512"
+	"encoding/hex"
 	"fmt"
 	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 // Create Access Token is method to generate unique access_token
@@ -32,3 +36,22 @@ func generateAccessToken() (string, error) {
 
 	return string(accessToken[0:40]), nil
 }
+
+// digestToken returns the hex encoded SHA-256 digest of a token, which is
+// what is stored instead of the token. Tokens are random, so unlike passwords
+// they need neither salt nor a slow hash.
+func digestToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashClientSecret returns the bcrypt hash of a client secret, to be stored in
+// oauth_clients.client_secret.
+func HashClientSecret(secret string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
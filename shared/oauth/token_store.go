@@ -3,11 +3,45 @@ package oauth
 import (
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
-type TokenStore struct {
+// TokenStore persists OAuth clients, users, access tokens and refresh tokens.
+// Tokens are stored and looked up by their digest, see digestToken.
+type TokenStore interface {
+	createAccessToken(accessToken OauthAccessToken) error
+	resolveAccessTokenByAccessToken(accessToken string) (OauthAccessToken, error)
+	// resolveAccessTokensByUserID returns the access tokens of a user that
+	// expire after now, only those of clientID unless it is empty.
+	resolveAccessTokensByUserID(userID string, clientID string, now time.Time) ([]OauthAccessToken, error)
+	deleteAccessToken(accessToken string) error
+	createRefreshToken(refreshToken OauthRefreshToken) error
+	resolveRefreshToken(refreshToken string) (OauthRefreshToken, error)
+	resolveRefreshTokenByAccessToken(accessToken string) (OauthRefreshToken, error)
+	// rotateRefreshToken marks a refresh token as rotated and stores the
+	// access token and refresh token issued in its place, all or nothing. It
+	// reports false, storing nothing, if it already was rotated or revoked.
+	rotateRefreshToken(refreshToken string, rotatedAt time.Time, accessToken OauthAccessToken, next OauthRefreshToken) (bool, error)
+	// revokeRefreshTokenFamily revokes every refresh token of a family and
+	// deletes the access tokens issued along with them.
+	revokeRefreshTokenFamily(familyID string, revokedAt time.Time) error
+	createAuthorizationCode(code OauthAuthorizationCode) error
+	resolveAuthorizationCode(code string) (OauthAuthorizationCode, error)
+	// consumeAuthorizationCode marks an authorization code as used, and
+	// reports false if it already was used.
+	consumeAuthorizationCode(code string, usedAt time.Time) (bool, error)
+	// purgeExpired deletes up to limit access tokens, refresh tokens and
+	// authorization codes each that expired before, and returns how many
+	// rows were deleted.
+	purgeExpired(before time.Time, limit int) (int64, error)
+	resolveClientByClientID(clientID string) (OauthClient, error)
+	resolveByTelephoneOrEmail(username string) (User, error)
+}
+
+// TokenStoreMySQL is the TokenStore backed by the oauth tables.
+type TokenStoreMySQL struct {
 	db *sqlx.DB
 }
 
@@ -35,11 +69,104 @@ const (
 		FROM
 			oauth_access_tokens`
 
+	queryDeleteAccessToken = `DELETE FROM oauth_access_tokens WHERE access_token = ?`
+
+	queryPurgeAccessTokens = `DELETE FROM oauth_access_tokens WHERE expires < ? LIMIT ?`
+
+	queryPurgeRefreshTokens = `DELETE FROM oauth_refresh_tokens WHERE expires < ? LIMIT ?`
+
+	queryPurgeAuthorizationCodes = `DELETE FROM oauth_authorization_codes WHERE expires < ? LIMIT ?`
+
+	queryInsertRefreshToken = `INSERT INTO oauth_refresh_tokens (
+			refresh_token,
+			family_id,
+			access_token,
+			client_id,
+			user_id,
+			scope,
+			expires,
+			created_at
+		) VALUES (
+			:refresh_token,
+			:family_id,
+			:access_token,
+			:client_id,
+			:user_id,
+			:scope,
+			:expires,
+			:created_at
+		)`
+
+	querySelectRefreshToken = `SELECT
+			refresh_token,
+			family_id,
+			access_token,
+			client_id,
+			user_id,
+			scope,
+			expires,
+			rotated_at,
+			revoked_at,
+			created_at
+		FROM
+			oauth_refresh_tokens`
+
+	queryRotateRefreshToken = `UPDATE oauth_refresh_tokens
+		SET rotated_at = ?
+		WHERE refresh_token = ? AND rotated_at IS NULL AND revoked_at IS NULL`
+
+	queryDeleteFamilyAccessTokens = `DELETE FROM oauth_access_tokens
+		WHERE access_token IN (
+			SELECT access_token FROM oauth_refresh_tokens WHERE family_id = ?
+		)`
+
+	queryRevokeRefreshTokenFamily = `UPDATE oauth_refresh_tokens
+		SET revoked_at = ?
+		WHERE family_id = ? AND revoked_at IS NULL`
+
+	queryInsertAuthorizationCode = `INSERT INTO oauth_authorization_codes (
+			code,
+			client_id,
+			user_id,
+			redirect_uri,
+			code_challenge,
+			code_challenge_method,
+			scope,
+			expires
+		) VALUES (
+			:code,
+			:client_id,
+			:user_id,
+			:redirect_uri,
+			:code_challenge,
+			:code_challenge_method,
+			:scope,
+			:expires
+		)`
+
+	querySelectAuthorizationCode = `SELECT
+			code,
+			client_id,
+			user_id,
+			redirect_uri,
+			code_challenge,
+			code_challenge_method,
+			scope,
+			expires,
+			used_at
+		FROM
+			oauth_authorization_codes`
+
+	queryConsumeAuthorizationCode = `UPDATE oauth_authorization_codes
+		SET used_at = ?
+		WHERE code = ? AND used_at IS NULL`
+
 	querySelectClients = `SELECT
 			client_id,
 			client_secret,
 			redirect_uri,
-			grant_types
+			grant_types,
+			scope
 		FROM 
 			oauth_clients`
 
@@ -53,12 +180,12 @@ const (
 )
 
 func NewTokenStore(db *sqlx.DB) TokenStore {
-	return TokenStore{
+	return &TokenStoreMySQL{
 		db: db,
 	}
 }
 
-func (a *TokenStore) createAccessToken(accessToken OauthAccessToken) error {
+func (a *TokenStoreMySQL) createAccessToken(accessToken OauthAccessToken) error {
 	stmt, err := a.db.PrepareNamed(queryInsertAccessToken)
 	if err != nil {
 		return err
@@ -73,7 +200,7 @@ func (a *TokenStore) createAccessToken(accessToken OauthAccessToken) error {
 	return nil
 }
 
-func (a *TokenStore) resolveAccessTokenByAccessToken(accessToken string) (oauthAccessToken OauthAccessToken, err error) {
+func (a *TokenStoreMySQL) resolveAccessTokenByAccessToken(accessToken string) (oauthAccessToken OauthAccessToken, err error) {
 	err = a.db.Get(&oauthAccessToken, querySelectAccessToken+" WHERE access_token = ?", accessToken)
 	switch {
 	case err == sql.ErrNoRows:
@@ -86,7 +213,165 @@ func (a *TokenStore) resolveAccessTokenByAccessToken(accessToken string) (oauthA
 	return
 }
 
-func (a *TokenStore) resolveAllClients(db *sqlx.DB) ([]OauthClient, error) {
+func (a *TokenStoreMySQL) resolveAccessTokensByUserID(userID string, clientID string, now time.Time) ([]OauthAccessToken, error) {
+	query := querySelectAccessToken + " WHERE user_id = ? AND expires > ?"
+	args := []interface{}{userID, now}
+	if clientID != "" {
+		query += " AND client_id = ?"
+		args = append(args, clientID)
+	}
+
+	accessTokens := make([]OauthAccessToken, 0)
+	err := a.db.Select(&accessTokens, query+" ORDER BY expires DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return accessTokens, nil
+}
+
+func (a *TokenStoreMySQL) deleteAccessToken(accessToken string) error {
+	_, err := a.db.Exec(queryDeleteAccessToken, accessToken)
+	return err
+}
+
+func (a *TokenStoreMySQL) createRefreshToken(refreshToken OauthRefreshToken) error {
+	stmt, err := a.db.PrepareNamed(queryInsertRefreshToken)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(refreshToken)
+	return err
+}
+
+func (a *TokenStoreMySQL) resolveRefreshToken(refreshToken string) (oauthRefreshToken OauthRefreshToken, err error) {
+	err = a.db.Get(&oauthRefreshToken, querySelectRefreshToken+" WHERE refresh_token = ?", refreshToken)
+	if err == sql.ErrNoRows {
+		err = errors.New(ErrorClientNotFound)
+	}
+
+	return
+}
+
+func (a *TokenStoreMySQL) resolveRefreshTokenByAccessToken(accessToken string) (oauthRefreshToken OauthRefreshToken, err error) {
+	err = a.db.Get(&oauthRefreshToken, querySelectRefreshToken+" WHERE access_token = ?", accessToken)
+	if err == sql.ErrNoRows {
+		err = errors.New(ErrorClientNotFound)
+	}
+
+	return
+}
+
+func (a *TokenStoreMySQL) rotateRefreshToken(refreshToken string, rotatedAt time.Time, accessToken OauthAccessToken, next OauthRefreshToken) (bool, error) {
+	tx, err := a.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+
+	rotated, err := rotateRefreshTokenTx(tx, refreshToken, rotatedAt, accessToken, next)
+	if err != nil || !rotated {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+func rotateRefreshTokenTx(tx *sqlx.Tx, refreshToken string, rotatedAt time.Time, accessToken OauthAccessToken, next OauthRefreshToken) (bool, error) {
+	res, err := tx.Exec(queryRotateRefreshToken, rotatedAt, refreshToken)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil || rows != 1 {
+		return false, err
+	}
+
+	if _, err = tx.NamedExec(queryInsertAccessToken, accessToken); err != nil {
+		return false, err
+	}
+
+	if _, err = tx.NamedExec(queryInsertRefreshToken, next); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (a *TokenStoreMySQL) revokeRefreshTokenFamily(familyID string, revokedAt time.Time) error {
+	tx, err := a.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(queryDeleteFamilyAccessTokens, familyID)
+	if err == nil {
+		_, err = tx.Exec(queryRevokeRefreshTokenFamily, revokedAt, familyID)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (a *TokenStoreMySQL) createAuthorizationCode(code OauthAuthorizationCode) error {
+	stmt, err := a.db.PrepareNamed(queryInsertAuthorizationCode)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(code)
+	return err
+}
+
+func (a *TokenStoreMySQL) resolveAuthorizationCode(code string) (authorizationCode OauthAuthorizationCode, err error) {
+	err = a.db.Get(&authorizationCode, querySelectAuthorizationCode+" WHERE code = ?", code)
+	if err == sql.ErrNoRows {
+		err = errors.New(ErrorClientNotFound)
+	}
+
+	return
+}
+
+func (a *TokenStoreMySQL) consumeAuthorizationCode(code string, usedAt time.Time) (bool, error) {
+	res, err := a.db.Exec(queryConsumeAuthorizationCode, usedAt, code)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+func (a *TokenStoreMySQL) purgeExpired(before time.Time, limit int) (int64, error) {
+	var purged int64
+	for _, query := range []string{queryPurgeAccessTokens, queryPurgeRefreshTokens, queryPurgeAuthorizationCodes} {
+		res, err := a.db.Exec(query, before, limit)
+		if err != nil {
+			return purged, err
+		}
+
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return purged, err
+		}
+		purged += rows
+	}
+
+	return purged, nil
+}
+
+func (a *TokenStoreMySQL) resolveAllClients(db *sqlx.DB) ([]OauthClient, error) {
 	var clients []OauthClient
 
 	err := a.db.Get(&clients, querySelectClients)
@@ -97,7 +382,7 @@ func (a *TokenStore) resolveAllClients(db *sqlx.DB) ([]OauthClient, error) {
 	return clients, nil
 }
 
-func (a *TokenStore) resolveClientByClientID(clientID string) (client OauthClient, err error) {
+func (a *TokenStoreMySQL) resolveClientByClientID(clientID string) (client OauthClient, err error) {
 	err = a.db.Get(&client, querySelectClients+" WHERE client_id = ?", clientID)
 	switch {
 	case err == sql.ErrNoRows:
@@ -110,7 +395,7 @@ func (a *TokenStore) resolveClientByClientID(clientID string) (client OauthClien
 	return
 }
 
-func (a *TokenStore) resolveByTelephoneOrEmail(username string) (User, error) {
+func (a *TokenStoreMySQL) resolveByTelephoneOrEmail(username string) (User, error) {
 	var user User
 
 	err := a.db.Get(&user, querySelectUser+" WHERE telephone = ? OR  email = ?", username, username)
@@ -0,0 +1,566 @@
+package oauth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/guregu/null"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fakeTokenStore is an in-memory TokenStore.
+type fakeTokenStore struct {
+	clients map[string]OauthClient
+	users   map[string]User
+	tokens  map[string]OauthAccessToken
+	refresh map[string]OauthRefreshToken
+	codes   map[string]OauthAuthorizationCode
+	purges  int
+	// failRotation fails rotateRefreshToken, which then stores nothing.
+	failRotation error
+}
+
+func hash(t *testing.T, secret string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.MinCost)
+	require.NoError(t, err)
+	return string(hash)
+}
+
+func newFakeTokenStore(t *testing.T) *fakeTokenStore {
+	return &fakeTokenStore{
+		clients: map[string]OauthClient{
+			"client_web":     {ClientID: "client_web", ClientSecret: hash(t, "3v3rm0s"), RedirectURI: "https://evermos.com/callback https://evermos.com/login?next=cart", GrantTypes: "client_credentials password authorization_code refresh_token", Scope: null.StringFrom("user orders:read orders:write")},
+			"client_mobile":  {ClientID: "client_mobile", RedirectURI: "com.evermos.app:/oauth/callback", GrantTypes: "authorization_code refresh_token"},
+			"client_service": {ClientID: "client_service", ClientSecret: hash(t, "s3rv1c3"), GrantTypes: "client_credentials"},
+		},
+		users: map[string]User{
+			"jane@example.com": {ID: 10001, Username: "jane", Password: hash(t, "s3cret")},
+		},
+		tokens:  make(map[string]OauthAccessToken),
+		refresh: make(map[string]OauthRefreshToken),
+		codes:   make(map[string]OauthAuthorizationCode),
+	}
+}
+
+func (s *fakeTokenStore) createAccessToken(accessToken OauthAccessToken) error {
+	s.tokens[accessToken.AccessToken] = accessToken
+	return nil
+}
+
+func (s *fakeTokenStore) resolveAccessTokenByAccessToken(accessToken string) (OauthAccessToken, error) {
+	token, ok := s.tokens[accessToken]
+	if !ok {
+		return OauthAccessToken{}, errors.New(ErrorClientNotFound)
+	}
+	return token, nil
+}
+
+func (s *fakeTokenStore) resolveAccessTokensByUserID(userID string, clientID string, now time.Time) ([]OauthAccessToken, error) {
+	accessTokens := make([]OauthAccessToken, 0)
+	for _, token := range s.tokens {
+		if token.UserID.String == userID && token.Expires.After(now) && (clientID == "" || token.ClientID == clientID) {
+			accessTokens = append(accessTokens, token)
+		}
+	}
+	return accessTokens, nil
+}
+
+func (s *fakeTokenStore) deleteAccessToken(accessToken string) error {
+	delete(s.tokens, accessToken)
+	return nil
+}
+
+func (s *fakeTokenStore) createRefreshToken(refreshToken OauthRefreshToken) error {
+	s.refresh[refreshToken.RefreshToken] = refreshToken
+	return nil
+}
+
+func (s *fakeTokenStore) resolveRefreshToken(refreshToken string) (OauthRefreshToken, error) {
+	token, ok := s.refresh[refreshToken]
+	if !ok {
+		return OauthRefreshToken{}, errors.New(ErrorClientNotFound)
+	}
+	return token, nil
+}
+
+func (s *fakeTokenStore) resolveRefreshTokenByAccessToken(accessToken string) (OauthRefreshToken, error) {
+	for _, token := range s.refresh {
+		if token.AccessToken == accessToken {
+			return token, nil
+		}
+	}
+	return OauthRefreshToken{}, errors.New(ErrorClientNotFound)
+}
+
+func (s *fakeTokenStore) rotateRefreshToken(refreshToken string, rotatedAt time.Time, accessToken OauthAccessToken, next OauthRefreshToken) (bool, error) {
+	if s.failRotation != nil {
+		return false, s.failRotation
+	}
+
+	token, ok := s.refresh[refreshToken]
+	if !ok || token.RotatedAt.Valid || token.RevokedAt.Valid {
+		return false, nil
+	}
+	token.RotatedAt = null.TimeFrom(rotatedAt)
+	s.refresh[refreshToken] = token
+	s.tokens[accessToken.AccessToken] = accessToken
+	s.refresh[next.RefreshToken] = next
+	return true, nil
+}
+
+func (s *fakeTokenStore) revokeRefreshTokenFamily(familyID string, revokedAt time.Time) error {
+	for key, token := range s.refresh {
+		if token.FamilyID != familyID {
+			continue
+		}
+		delete(s.tokens, token.AccessToken)
+		if !token.RevokedAt.Valid {
+			token.RevokedAt = null.TimeFrom(revokedAt)
+			s.refresh[key] = token
+		}
+	}
+	return nil
+}
+
+func (s *fakeTokenStore) createAuthorizationCode(code OauthAuthorizationCode) error {
+	s.codes[code.Code] = code
+	return nil
+}
+
+func (s *fakeTokenStore) resolveAuthorizationCode(code string) (OauthAuthorizationCode, error) {
+	authorizationCode, ok := s.codes[code]
+	if !ok {
+		return OauthAuthorizationCode{}, errors.New(ErrorClientNotFound)
+	}
+	return authorizationCode, nil
+}
+
+func (s *fakeTokenStore) consumeAuthorizationCode(code string, usedAt time.Time) (bool, error) {
+	authorizationCode, ok := s.codes[code]
+	if !ok || authorizationCode.UsedAt.Valid {
+		return false, nil
+	}
+	authorizationCode.UsedAt = null.TimeFrom(usedAt)
+	s.codes[code] = authorizationCode
+	return true, nil
+}
+
+func (s *fakeTokenStore) purgeExpired(before time.Time, limit int) (int64, error) {
+	var purged int64
+	deleted := 0
+	for key, token := range s.tokens {
+		if deleted < limit && token.Expires.Before(before) {
+			delete(s.tokens, key)
+			deleted++
+		}
+	}
+	purged += int64(deleted)
+
+	deleted = 0
+	for key, token := range s.refresh {
+		if deleted < limit && token.Expires.Before(before) {
+			delete(s.refresh, key)
+			deleted++
+		}
+	}
+	purged += int64(deleted)
+
+	deleted = 0
+	for key, code := range s.codes {
+		if deleted < limit && code.Expires.Before(before) {
+			delete(s.codes, key)
+			deleted++
+		}
+	}
+	purged += int64(deleted)
+
+	s.purges++
+	return purged, nil
+}
+
+func (s *fakeTokenStore) resolveClientByClientID(clientID string) (OauthClient, error) {
+	client, ok := s.clients[clientID]
+	if !ok {
+		return OauthClient{}, errors.New(ErrorClientNotFound)
+	}
+	return client, nil
+}
+
+func (s *fakeTokenStore) resolveByTelephoneOrEmail(username string) (User, error) {
+	user, ok := s.users[username]
+	if !ok {
+		return User{}, errors.New(ErrorClientNotFound)
+	}
+	return user, nil
+}
+
+func assertOAuthError(t *testing.T, code string, err error) {
+	oauthErr, ok := err.(*Error)
+	if assert.True(t, ok, "expected an OAuth error, got %v", err) {
+		assert.Equal(t, code, oauthErr.Code)
+	}
+}
+
+func TestTokenCreate(t *testing.T) {
+	config := Config{Expiration: 3600}
+
+	t.Run("client credentials", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+
+		res, err := NewWithStore(store, config).Create(Credential{
+			GrantType:    ClientCredentials,
+			ClientID:     "client_web",
+			ClientSecret: "3v3rm0s",
+		})
+
+		assert.NoError(t, err)
+		assert.Len(t, res.AccessToken, 40)
+		assert.Equal(t, "Bearer", res.TokenType)
+		assert.InDelta(t, 3600, res.ExpiresIn, 1)
+		assert.Equal(t, "user orders:read orders:write", res.Scope, "clients requesting no scope get all of theirs")
+		assert.Contains(t, store.tokens, digestToken(res.AccessToken))
+		assert.NotContains(t, store.tokens, res.AccessToken, "tokens are stored as digests")
+	})
+
+	t.Run("plaintext client secrets are rejected", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+		store.clients["client_web"] = OauthClient{ClientID: "client_web", ClientSecret: "3v3rm0s", GrantTypes: "client_credentials"}
+
+		_, err := NewWithStore(store, config).Create(Credential{
+			GrantType:    ClientCredentials,
+			ClientID:     "client_web",
+			ClientSecret: "3v3rm0s",
+		})
+
+		assertOAuthError(t, ErrCodeInvalidClient, err)
+	})
+
+	t.Run("password", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+
+		res, err := NewWithStore(store, config).Create(Credential{
+			GrantType:    Password,
+			ClientID:     "client_web",
+			ClientSecret: "3v3rm0s",
+			Username:     "jane@example.com",
+			Password:     "s3cret",
+		})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "10001", store.tokens[digestToken(res.AccessToken)].UserID.String)
+	})
+
+	cases := []struct {
+		name       string
+		credential Credential
+		config     Config
+		code       string
+	}{
+		{
+			name:       "unknown client",
+			credential: Credential{GrantType: ClientCredentials, ClientID: "nobody", ClientSecret: "x"},
+			code:       ErrCodeInvalidClient,
+		},
+		{
+			name:       "wrong client secret",
+			credential: Credential{GrantType: ClientCredentials, ClientID: "client_web", ClientSecret: "wrong"},
+			code:       ErrCodeInvalidClient,
+		},
+		{
+			name:       "grant not allowed for the client",
+			credential: Credential{GrantType: Password, ClientID: "client_service", ClientSecret: "s3rv1c3", Username: "jane@example.com", Password: "s3cret"},
+			code:       ErrCodeUnauthorizedClient,
+		},
+		{
+			name:       "client outside the client scope",
+			credential: Credential{GrantType: ClientCredentials, ClientID: "client_service", ClientSecret: "s3rv1c3"},
+			config:     Config{ClientScope: []string{"client_web"}},
+			code:       ErrCodeUnauthorizedClient,
+		},
+		{
+			name:       "unsupported grant type",
+			credential: Credential{GrantType: "implicit", ClientID: "client_web", ClientSecret: "3v3rm0s"},
+			code:       ErrCodeUnsupportedGrantType,
+		},
+		{
+			name:       "wrong password",
+			credential: Credential{GrantType: Password, ClientID: "client_web", ClientSecret: "3v3rm0s", Username: "jane@example.com", Password: "wrong"},
+			code:       ErrCodeInvalidGrant,
+		},
+		{
+			name:       "unknown user",
+			credential: Credential{GrantType: Password, ClientID: "client_web", ClientSecret: "3v3rm0s", Username: "john@example.com", Password: "s3cret"},
+			code:       ErrCodeInvalidGrant,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			store := newFakeTokenStore(t)
+
+			_, err := NewWithStore(store, c.config).Create(c.credential)
+
+			assertOAuthError(t, c.code, err)
+			assert.Empty(t, store.tokens)
+		})
+	}
+}
+
+func TestTokenIntrospect(t *testing.T) {
+	store := newFakeTokenStore(t)
+	token := NewWithStore(store, Config{Expiration: 3600})
+	web := Credential{ClientID: "client_web", ClientSecret: "3v3rm0s"}
+	service := Credential{ClientID: "client_service", ClientSecret: "s3rv1c3"}
+
+	res, err := token.Create(Credential{GrantType: ClientCredentials, ClientID: "client_web", ClientSecret: "3v3rm0s"})
+	require.NoError(t, err)
+
+	introspection, err := token.Introspect(service, res.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, introspection.Active)
+	assert.Equal(t, "client_web", introspection.ClientID)
+	assert.Equal(t, "Bearer", introspection.TokenType)
+
+	introspection, err = token.Introspect(service, "unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, Introspection{}, introspection)
+
+	expired := store.tokens[digestToken(res.AccessToken)]
+	expired.Expires = time.Now().Add(-time.Minute)
+	store.tokens[digestToken(res.AccessToken)] = expired
+	introspection, err = token.Introspect(web, res.AccessToken)
+	assert.NoError(t, err)
+	assert.False(t, introspection.Active)
+
+	_, err = token.Introspect(Credential{ClientID: "client_web", ClientSecret: "wrong"}, res.AccessToken)
+	assertOAuthError(t, ErrCodeInvalidClient, err)
+}
+
+func TestTokenRevoke(t *testing.T) {
+	store := newFakeTokenStore(t)
+	token := NewWithStore(store, Config{Expiration: 3600})
+	web := Credential{ClientID: "client_web", ClientSecret: "3v3rm0s"}
+	service := Credential{ClientID: "client_service", ClientSecret: "s3rv1c3"}
+
+	res, err := token.Create(Credential{GrantType: ClientCredentials, ClientID: "client_web", ClientSecret: "3v3rm0s"})
+	require.NoError(t, err)
+
+	err = token.Revoke(service, res.AccessToken)
+	assertOAuthError(t, ErrCodeInvalidGrant, err)
+	assert.Contains(t, store.tokens, digestToken(res.AccessToken))
+
+	assert.NoError(t, token.Revoke(web, res.AccessToken))
+	assert.NotContains(t, store.tokens, digestToken(res.AccessToken))
+
+	assert.NoError(t, token.Revoke(web, res.AccessToken), "revoking twice succeeds")
+}
+
+func TestTokenRefresh(t *testing.T) {
+	config := Config{Expiration: 3600, RefreshExpiration: 7200}
+	web := Credential{ClientID: "client_web", ClientSecret: "3v3rm0s"}
+
+	login := func(t *testing.T, token *Token) *TokenResponse {
+		res, err := token.Create(Credential{
+			GrantType:    Password,
+			ClientID:     "client_web",
+			ClientSecret: "3v3rm0s",
+			Username:     "jane@example.com",
+			Password:     "s3cret",
+		})
+		require.NoError(t, err)
+		require.NotEmpty(t, res.RefreshToken)
+		return res
+	}
+
+	refresh := func(token *Token, refreshToken string) (*TokenResponse, error) {
+		return token.Create(Credential{
+			GrantType:    RefreshToken,
+			ClientID:     "client_web",
+			ClientSecret: "3v3rm0s",
+			RefreshToken: refreshToken,
+		})
+	}
+
+	t.Run("client credentials do not get refresh tokens", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+
+		res, err := NewWithStore(store, config).Create(Credential{GrantType: ClientCredentials, ClientID: "client_web", ClientSecret: "3v3rm0s"})
+
+		assert.NoError(t, err)
+		assert.Empty(t, res.RefreshToken)
+		assert.Empty(t, store.refresh)
+	})
+
+	t.Run("refresh tokens are rotated", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+		token := NewWithStore(store, config)
+		first := login(t, token)
+
+		second, err := refresh(token, first.RefreshToken)
+
+		assert.NoError(t, err)
+		assert.NotEqual(t, first.AccessToken, second.AccessToken)
+		assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+		assert.Equal(t, "10001", store.tokens[digestToken(second.AccessToken)].UserID.String)
+		assert.Equal(t, first.Scope, store.tokens[digestToken(second.AccessToken)].Scope.String)
+		assert.True(t, store.refresh[digestToken(first.RefreshToken)].RotatedAt.Valid)
+		assert.Equal(t, digestToken(first.RefreshToken), store.refresh[digestToken(second.RefreshToken)].FamilyID)
+	})
+
+	t.Run("reusing a rotated refresh token revokes the family", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+		token := NewWithStore(store, config)
+		first := login(t, token)
+		second, err := refresh(token, first.RefreshToken)
+		require.NoError(t, err)
+
+		_, err = refresh(token, first.RefreshToken)
+		assertOAuthError(t, ErrCodeInvalidGrant, err)
+		assert.NotContains(t, store.tokens, digestToken(first.AccessToken))
+		assert.NotContains(t, store.tokens, digestToken(second.AccessToken))
+
+		_, err = refresh(token, second.RefreshToken)
+		assertOAuthError(t, ErrCodeInvalidGrant, err)
+	})
+
+	t.Run("a failed rotation can be retried", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+		token := NewWithStore(store, config)
+		first := login(t, token)
+
+		store.failRotation = errors.New("connection reset")
+		_, err := refresh(token, first.RefreshToken)
+		require.Error(t, err)
+		assert.Len(t, store.refresh, 1)
+
+		store.failRotation = nil
+		second, err := refresh(token, first.RefreshToken)
+		assert.NoError(t, err)
+		assert.Contains(t, store.tokens, digestToken(first.AccessToken))
+		assert.Contains(t, store.tokens, digestToken(second.AccessToken))
+	})
+
+	t.Run("revoking a refresh token revokes the family", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+		token := NewWithStore(store, config)
+		res := login(t, token)
+
+		assert.NoError(t, token.Revoke(web, res.RefreshToken))
+		assert.NotContains(t, store.tokens, digestToken(res.AccessToken))
+
+		_, err := refresh(token, res.RefreshToken)
+		assertOAuthError(t, ErrCodeInvalidGrant, err)
+	})
+
+	t.Run("invalid refresh tokens", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+		token := NewWithStore(store, config)
+		res := login(t, token)
+
+		_, err := refresh(token, "unknown")
+		assertOAuthError(t, ErrCodeInvalidGrant, err)
+
+		_, err = refresh(token, "")
+		assertOAuthError(t, ErrCodeInvalidRequest, err)
+
+		store.clients["client_other"] = OauthClient{ClientID: "client_other", ClientSecret: hash(t, "0th3r"), GrantTypes: "refresh_token"}
+		_, err = token.Create(Credential{GrantType: RefreshToken, ClientID: "client_other", ClientSecret: "0th3r", RefreshToken: res.RefreshToken})
+		assertOAuthError(t, ErrCodeInvalidGrant, err)
+
+		expired := store.refresh[digestToken(res.RefreshToken)]
+		expired.Expires = time.Now().Add(-time.Minute)
+		store.refresh[digestToken(res.RefreshToken)] = expired
+		_, err = refresh(token, res.RefreshToken)
+		assertOAuthError(t, ErrCodeInvalidGrant, err)
+		assert.False(t, store.refresh[digestToken(res.RefreshToken)].RotatedAt.Valid)
+	})
+}
+
+func TestTokenScope(t *testing.T) {
+	config := Config{Expiration: 3600, RefreshExpiration: 7200}
+	password := func(scope string) Credential {
+		return Credential{
+			GrantType:    Password,
+			ClientID:     "client_web",
+			ClientSecret: "3v3rm0s",
+			Username:     "jane@example.com",
+			Password:     "s3cret",
+			Scope:        scope,
+		}
+	}
+
+	t.Run("requested scopes are narrowed to the client's", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+
+		res, err := NewWithStore(store, config).Create(password("orders:read admin orders:read"))
+
+		assert.NoError(t, err)
+		assert.Equal(t, "orders:read", res.Scope)
+		assert.Equal(t, "orders:read", store.tokens[digestToken(res.AccessToken)].Scope.String)
+	})
+
+	t.Run("scopes the client does not have", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+
+		_, err := NewWithStore(store, config).Create(password("admin"))
+
+		assertOAuthError(t, ErrCodeInvalidScope, err)
+		assert.Empty(t, store.tokens)
+	})
+
+	t.Run("clients without scopes get unscoped tokens", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+
+		res, err := NewWithStore(store, config).Create(Credential{GrantType: ClientCredentials, ClientID: "client_service", ClientSecret: "s3rv1c3"})
+
+		assert.NoError(t, err)
+		assert.Empty(t, res.Scope)
+		assert.False(t, store.tokens[digestToken(res.AccessToken)].Scope.Valid)
+	})
+
+	t.Run("refreshing narrows the access token only", func(t *testing.T) {
+		store := newFakeTokenStore(t)
+		token := NewWithStore(store, config)
+		first, err := token.Create(password("orders:read orders:write"))
+		require.NoError(t, err)
+
+		refresh := func(refreshToken string, scope string) (*TokenResponse, error) {
+			return token.Create(Credential{
+				GrantType:    RefreshToken,
+				ClientID:     "client_web",
+				ClientSecret: "3v3rm0s",
+				RefreshToken: refreshToken,
+				Scope:        scope,
+			})
+		}
+
+		_, err = refresh(first.RefreshToken, "user")
+		assertOAuthError(t, ErrCodeInvalidScope, err)
+
+		second, err := refresh(first.RefreshToken, "orders:read")
+		require.NoError(t, err)
+		assert.Equal(t, "orders:read", second.Scope)
+
+		third, err := refresh(second.RefreshToken, "")
+		require.NoError(t, err)
+		assert.Equal(t, "orders:read orders:write", third.Scope)
+	})
+}
+
+func TestHasScope(t *testing.T) {
+	assert.True(t, HasScope("orders:read orders:write", "orders:write"))
+	assert.True(t, HasScope("orders:read", "orders:read", "orders:read"))
+	assert.True(t, HasScope(""))
+	assert.False(t, HasScope("orders:read", "orders:read", "orders:write"))
+	assert.False(t, HasScope("orders:readable", "orders:read"))
+	assert.False(t, HasScope("", "orders:read"))
+}
+
+func TestDigestToken(t *testing.T) {
+	// The digest must match MySQL's SHA2(token, 256), which MigrateCredentials
+	// uses to convert stored tokens.
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", digestToken("hello"))
+}
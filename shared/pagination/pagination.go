@@ -0,0 +1,235 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Direction indicates which way a cursor walks through a listing.
+type Direction string
+
+const (
+	// DirectionNext walks towards the end of the listing.
+	DirectionNext Direction = "next"
+	// DirectionPrev walks towards the start of the listing.
+	DirectionPrev Direction = "prev"
+)
+
+// ErrInvalidCursor is returned when a cursor cannot be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is a position in a keyset-paginated listing: the sort key and ID of
+// the row the next page starts after. Clients only ever see it encoded.
+type Cursor struct {
+	Sort      string    `json:"s,omitempty"`
+	Key       string    `json:"k"`
+	ID        string    `json:"i"`
+	Direction Direction `json:"d"`
+}
+
+// Encode encodes the cursor into an opaque, URL-safe string.
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor decodes a cursor produced by Cursor.Encode.
+func DecodeCursor(s string) (c Cursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	err = json.Unmarshal(b, &c)
+	if err != nil || c.ID == "" {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	if c.Direction != DirectionNext && c.Direction != DirectionPrev {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	return
+}
+
+// Request describes the page a client asked for. When Cursor is set, Page is
+// ignored and the listing continues from the cursor position instead of
+// skipping Page*Limit rows.
+type Request struct {
+	Page   int
+	Limit  int
+	Cursor *Cursor
+}
+
+// RequestFromQuery reads the page, limit and cursor query parameters. page may
+// be omitted when a cursor is given.
+func RequestFromQuery(query url.Values) (req Request, err error) {
+	if v := query.Get("cursor"); v != "" {
+		cursor, err := DecodeCursor(v)
+		if err != nil {
+			return req, err
+		}
+		req.Cursor = &cursor
+	}
+
+	if v := query.Get("page"); v != "" || req.Cursor == nil {
+		req.Page, err = strconv.Atoi(v)
+		if err != nil {
+			return req, errors.New("Must have page queryparam")
+		}
+		if req.Page < 0 {
+			return req, errors.New("page must be equal or greater to zero")
+		}
+	}
+
+	req.Limit, err = strconv.Atoi(query.Get("limit"))
+	if err != nil {
+		return req, errors.New("Must have limit queryparam")
+	}
+	if req.Limit <= 0 {
+		return req, errors.New("limit must be greater to zero")
+	}
+
+	return
+}
+
+// Offset returns the number of rows to skip for offset-based paging.
+func (r Request) Offset() int {
+	if r.Cursor != nil {
+		return 0
+	}
+	return r.Page * r.Limit
+}
+
+// Backward reports whether the request walks towards the start of the listing.
+func (r Request) Backward() bool {
+	return r.Cursor != nil && r.Cursor.Direction == DirectionPrev
+}
+
+// FetchLimit returns the number of rows to fetch: one more than the page
+// size, so the repository can tell whether another page follows.
+func (r Request) FetchLimit() int {
+	return r.Limit + 1
+}
+
+// Keyset describes the ordering of a keyset-paginated listing: a sort key
+// column followed by a unique ID column as tie-breaker.
+type Keyset struct {
+	KeyColumn string
+	KeyDesc   bool
+	IDColumn  string
+	IDDesc    bool
+}
+
+// Condition returns the WHERE fragment selecting the rows past the cursor in
+// its direction, with its arguments. key is the cursor's sort key converted
+// back to the column's type.
+func (k Keyset) Condition(c Cursor, key interface{}) (condition string, args []interface{}) {
+	backward := c.Direction == DirectionPrev
+	condition = fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))",
+		k.KeyColumn, comparator(k.KeyDesc, backward),
+		k.KeyColumn,
+		k.IDColumn, comparator(k.IDDesc, backward))
+	args = []interface{}{key, key, c.ID}
+	return
+}
+
+// OrderBy returns the ORDER BY clause for walking the listing. Walking
+// backward reverses the order, so the fetched rows must be reversed again
+// before they are returned.
+func (k Keyset) OrderBy(backward bool) string {
+	return fmt.Sprintf("ORDER BY %s %s, %s %s",
+		k.KeyColumn, order(k.KeyDesc, backward),
+		k.IDColumn, order(k.IDDesc, backward))
+}
+
+func comparator(desc bool, backward bool) string {
+	if desc != backward {
+		return "<"
+	}
+	return ">"
+}
+
+func order(desc bool, backward bool) string {
+	if desc != backward {
+		return "DESC"
+	}
+	return "ASC"
+}
+
+// Result carries the metadata of a fetched page: its position, the size of
+// the whole listing and the cursors of the neighbouring pages.
+type Result struct {
+	Page       *int
+	Limit      int
+	TotalItems int
+	TotalPages int
+	HasNext    bool
+	NextCursor *string
+	PrevCursor *string
+
+	req     Request
+	fetched int
+}
+
+// NewResult works out the metadata of a page. fetched is the number of rows
+// fetched with Request.FetchLimit and totalItems the number of rows in the
+// whole listing. Page is only set for offset-based requests.
+func NewResult(req Request, fetched int, totalItems int) (result Result) {
+	result = Result{
+		Limit:      req.Limit,
+		TotalItems: totalItems,
+		TotalPages: (totalItems + req.Limit - 1) / req.Limit,
+		req:        req,
+		fetched:    fetched,
+	}
+
+	if req.Cursor == nil {
+		page := req.Page
+		result.Page = &page
+	}
+
+	// Walking back from a cursor always leaves the cursor's own row ahead.
+	result.HasNext = fetched > req.Limit || (req.Backward() && fetched > 0)
+	return
+}
+
+// SetCursors sets the cursors of the neighbouring pages. first and last point
+// at the first and last row of the page in listing order; their direction is
+// set by SetCursors.
+func (r *Result) SetCursors(first Cursor, last Cursor) {
+	if r.fetched == 0 {
+		return
+	}
+
+	if r.HasNext {
+		r.NextCursor = encode(last, DirectionNext)
+	}
+
+	hasPrev := r.fetched > r.req.Limit
+	if !r.req.Backward() {
+		hasPrev = r.req.Cursor != nil || r.req.Page > 0
+	}
+	if hasPrev {
+		r.PrevCursor = encode(first, DirectionPrev)
+	}
+}
+
+// Trim returns the number of rows that belong to the page, dropping the
+// look-ahead row fetched by Request.FetchLimit.
+func Trim(req Request, fetched int) int {
+	if fetched > req.Limit {
+		return req.Limit
+	}
+	return fetched
+}
+
+func encode(c Cursor, d Direction) *string {
+	c.Direction = d
+	s := c.Encode()
+	return &s
+}
@@ -0,0 +1,121 @@
+package pagination_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/evermos/boilerplate-go/shared/pagination"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursorEncodeDecode(t *testing.T) {
+	c := pagination.Cursor{Sort: "newest", Key: "2021-01-02T03:04:05.123Z", ID: "a1", Direction: pagination.DirectionNext}
+
+	got, err := pagination.DecodeCursor(c.Encode())
+
+	assert.NoError(t, err)
+	assert.Equal(t, c, got)
+
+	for _, invalid := range []string{"", "not base64!", "e30", pagination.Cursor{ID: "a1", Direction: "up"}.Encode()} {
+		_, err = pagination.DecodeCursor(invalid)
+		assert.Equal(t, pagination.ErrInvalidCursor, err, invalid)
+	}
+}
+
+func TestRequestFromQuery(t *testing.T) {
+	cursor := pagination.Cursor{Key: "kopi", ID: "a1", Direction: pagination.DirectionNext}
+
+	req, err := pagination.RequestFromQuery(url.Values{"page": {"2"}, "limit": {"10"}})
+	assert.NoError(t, err)
+	assert.Equal(t, pagination.Request{Page: 2, Limit: 10}, req)
+	assert.Equal(t, 20, req.Offset())
+
+	req, err = pagination.RequestFromQuery(url.Values{"cursor": {cursor.Encode()}, "limit": {"10"}})
+	assert.NoError(t, err)
+	assert.Equal(t, &cursor, req.Cursor)
+	assert.Equal(t, 0, req.Offset())
+
+	_, err = pagination.RequestFromQuery(url.Values{"limit": {"10"}})
+	assert.Error(t, err)
+	_, err = pagination.RequestFromQuery(url.Values{"page": {"0"}})
+	assert.Error(t, err)
+	_, err = pagination.RequestFromQuery(url.Values{"cursor": {"garbage"}, "limit": {"10"}})
+	assert.Equal(t, pagination.ErrInvalidCursor, err)
+}
+
+func TestKeyset(t *testing.T) {
+	newest := pagination.Keyset{KeyColumn: "created_at", KeyDesc: true, IDColumn: "id", IDDesc: true}
+	priceDesc := pagination.Keyset{KeyColumn: "price", KeyDesc: true, IDColumn: "id"}
+
+	condition, args := newest.Condition(pagination.Cursor{ID: "a1", Direction: pagination.DirectionNext}, "t")
+	assert.Equal(t, "(created_at < ? OR (created_at = ? AND id < ?))", condition)
+	assert.Equal(t, []interface{}{"t", "t", "a1"}, args)
+	assert.Equal(t, "ORDER BY created_at DESC, id DESC", newest.OrderBy(false))
+
+	condition, _ = priceDesc.Condition(pagination.Cursor{ID: "a1", Direction: pagination.DirectionPrev}, 10.5)
+	assert.Equal(t, "(price > ? OR (price = ? AND id < ?))", condition)
+	assert.Equal(t, "ORDER BY price ASC, id DESC", priceDesc.OrderBy(true))
+}
+
+func TestNewResult(t *testing.T) {
+	first := pagination.Cursor{Key: "a", ID: "1"}
+	last := pagination.Cursor{Key: "c", ID: "3"}
+	decode := func(s *string) pagination.Cursor {
+		c, err := pagination.DecodeCursor(*s)
+		assert.NoError(t, err)
+		return c
+	}
+
+	t.Run("first page with more rows", func(t *testing.T) {
+		result := pagination.NewResult(pagination.Request{Limit: 3}, 4, 7)
+		result.SetCursors(first, last)
+
+		assert.Equal(t, 0, *result.Page)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, 7, result.TotalItems)
+		assert.Equal(t, 3, result.TotalPages)
+		assert.True(t, result.HasNext)
+		assert.Nil(t, result.PrevCursor)
+		next := decode(result.NextCursor)
+		assert.Equal(t, "3", next.ID)
+		assert.Equal(t, pagination.DirectionNext, next.Direction)
+	})
+
+	t.Run("last page reached from a cursor", func(t *testing.T) {
+		req := pagination.Request{Limit: 3, Cursor: &pagination.Cursor{ID: "0", Direction: pagination.DirectionNext}}
+		result := pagination.NewResult(req, 2, 5)
+		result.SetCursors(first, last)
+
+		assert.Nil(t, result.Page)
+		assert.False(t, result.HasNext)
+		assert.Nil(t, result.NextCursor)
+		prev := decode(result.PrevCursor)
+		assert.Equal(t, "1", prev.ID)
+		assert.Equal(t, pagination.DirectionPrev, prev.Direction)
+	})
+
+	t.Run("walking back always leaves a next page", func(t *testing.T) {
+		req := pagination.Request{Limit: 3, Cursor: &pagination.Cursor{ID: "4", Direction: pagination.DirectionPrev}}
+
+		result := pagination.NewResult(req, 3, 6)
+		result.SetCursors(first, last)
+		assert.True(t, result.HasNext)
+		assert.Nil(t, result.PrevCursor)
+		assert.Equal(t, "3", decode(result.NextCursor).ID)
+
+		result = pagination.NewResult(req, 4, 7)
+		result.SetCursors(first, last)
+		assert.Equal(t, "1", decode(result.PrevCursor).ID)
+	})
+
+	t.Run("page past the end has totals but no cursors", func(t *testing.T) {
+		result := pagination.NewResult(pagination.Request{Limit: 3, Page: 5}, 0, 4)
+		result.SetCursors(first, last)
+
+		assert.Equal(t, 5, *result.Page)
+		assert.Equal(t, 2, result.TotalPages)
+		assert.False(t, result.HasNext)
+		assert.Nil(t, result.NextCursor)
+		assert.Nil(t, result.PrevCursor)
+	})
+}
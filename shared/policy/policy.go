@@ -0,0 +1,109 @@
+package policy
+
+import (
+	"strings"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/gofrs/uuid"
+)
+
+// Permission is something a role may be allowed to do.
+type Permission string
+
+const (
+	// ProductWrite allows creating, updating, deleting and restoring products.
+	ProductWrite Permission = "product:write"
+	// OrderReadAny allows reading the orders of every user.
+	OrderReadAny Permission = "order:read:any"
+	// OrderTransition allows moving any order to another status, including
+	// cancelling orders that are no longer pending.
+	OrderTransition Permission = "order:transition"
+	// CartCheckoutAny allows checking out the carts of every user.
+	CartCheckoutAny Permission = "cart:checkout:any"
+	// All grants every permission.
+	All Permission = "*"
+)
+
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// DefaultRoles are the roles known without any configuration.
+func DefaultRoles() map[string][]Permission {
+	return map[string][]Permission{
+		RoleAdmin: {All},
+		RoleUser:  {},
+	}
+}
+
+// Actor is the user on whose behalf an action is taken.
+type Actor struct {
+	UserID uuid.UUID
+	Role   string
+}
+
+// Authorizer decides what actors are allowed to do.
+type Authorizer interface {
+	// Can reports whether actor has permission.
+	Can(actor Actor, permission Permission) bool
+	// CanAccess reports whether actor may act on a resource owned by ownerID:
+	// owners always may, anyone else needs permission.
+	CanAccess(actor Actor, ownerID uuid.UUID, permission Permission) bool
+}
+
+// Policy is an Authorizer mapping roles to permissions. Role names are case
+// insensitive, and unknown roles have no permissions.
+type Policy struct {
+	roles map[string]map[Permission]bool
+}
+
+// New creates a Policy from roles and their permissions.
+func New(roles map[string][]Permission) *Policy {
+	p := &Policy{roles: make(map[string]map[Permission]bool)}
+	for role, permissions := range roles {
+		p.SetRole(role, permissions...)
+	}
+
+	return p
+}
+
+// ProvidePolicy creates a Policy with the default roles, extended or
+// overridden by the roles in APP.POLICY.ROLES, for example
+// APP.POLICY.ROLES.WAREHOUSE=order:read:any,order:transition.
+func ProvidePolicy(config *configs.Config) *Policy {
+	p := New(DefaultRoles())
+	for role, permissions := range config.App.Policy.Roles {
+		granted := make([]Permission, 0, len(permissions))
+		for _, permission := range permissions {
+			if permission = strings.TrimSpace(permission); permission != "" {
+				granted = append(granted, Permission(permission))
+			}
+		}
+		p.SetRole(role, granted...)
+	}
+
+	return p
+}
+
+// SetRole grants role exactly the given permissions.
+func (p *Policy) SetRole(role string, permissions ...Permission) {
+	granted := make(map[Permission]bool, len(permissions))
+	for _, permission := range permissions {
+		granted[permission] = true
+	}
+
+	p.roles[strings.ToLower(role)] = granted
+}
+
+// Can reports whether actor has permission.
+func (p *Policy) Can(actor Actor, permission Permission) bool {
+	granted := p.roles[strings.ToLower(actor.Role)]
+	return granted[All] || granted[permission]
+}
+
+// CanAccess reports whether actor owns the resource or has permission to act
+// on anyone's.
+func (p *Policy) CanAccess(actor Actor, ownerID uuid.UUID, permission Permission) bool {
+	return actor.UserID == ownerID || p.Can(actor, permission)
+}
@@ -0,0 +1,35 @@
+package policy_test
+
+import (
+	"testing"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/shared/policy"
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProvidePolicy(t *testing.T) {
+	config := &configs.Config{}
+	config.App.Policy.Roles = map[string][]string{
+		"warehouse": {"order:read:any", " order:transition "},
+		"user":      {"cart:checkout:any"},
+	}
+	p := policy.ProvidePolicy(config)
+
+	owner := uuid.Must(uuid.NewV4())
+	admin := policy.Actor{UserID: uuid.Must(uuid.NewV4()), Role: "Admin"}
+	warehouse := policy.Actor{UserID: uuid.Must(uuid.NewV4()), Role: "WAREHOUSE"}
+	user := policy.Actor{UserID: uuid.Must(uuid.NewV4()), Role: "user"}
+	guest := policy.Actor{UserID: owner, Role: "guest"}
+
+	assert.True(t, p.Can(admin, policy.ProductWrite), "admin keeps every permission")
+	assert.True(t, p.Can(warehouse, policy.OrderTransition), "permissions are trimmed, roles case insensitive")
+	assert.False(t, p.Can(warehouse, policy.ProductWrite))
+	assert.True(t, p.Can(user, policy.CartCheckoutAny), "configured roles override the defaults")
+	assert.False(t, p.Can(guest, policy.OrderReadAny), "unknown roles have no permissions")
+
+	assert.True(t, p.CanAccess(guest, owner, policy.OrderReadAny), "owners can always access")
+	assert.True(t, p.CanAccess(warehouse, owner, policy.OrderReadAny))
+	assert.False(t, p.CanAccess(user, owner, policy.OrderReadAny))
+}
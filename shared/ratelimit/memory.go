@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store keeping buckets in memory. Buckets that have been
+// full for a while are swept so that idle keys do not pile up.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	window  time.Duration
+}
+
+// NewMemoryStore creates a new MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Take takes a token from the bucket of key.
+func (s *MemoryStore) Take(key string, limit Limit) (result Result, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now, limit.Window)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Requests), updated: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = refill(limit, b.tokens, now.Sub(b.updated))
+	b.updated = now
+	b.window = limit.Window
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+
+	return newResult(limit, b.tokens, allowed), nil
+}
+
+// sweep drops the buckets untouched for longer than their window, which are
+// full again, at most once per window.
+func (s *MemoryStore) sweep(now time.Time, window time.Duration) {
+	if now.Sub(s.lastSweep) < window {
+		return
+	}
+	s.lastSweep = now
+
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) >= b.window {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStoreTake(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Requests: 3, Window: time.Minute}
+
+	for i := 2; i >= 0; i-- {
+		res, err := store.Take("user:1", limit)
+		assert.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 3, res.Limit)
+		assert.Equal(t, i, res.Remaining)
+	}
+
+	res, _ := store.Take("user:1", limit)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 20*time.Second, res.RetryAfter)
+	assert.Equal(t, time.Minute, res.Reset)
+
+	res, _ = store.Take("user:2", limit)
+	assert.True(t, res.Allowed, "keys have their own bucket")
+
+	now = now.Add(20 * time.Second)
+	res, _ = store.Take("user:1", limit)
+	assert.True(t, res.Allowed, "a token is added every 20 seconds")
+	assert.Equal(t, 0, res.Remaining)
+
+	now = now.Add(time.Hour)
+	res, _ = store.Take("user:1", limit)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 2, res.Remaining, "buckets hold at most Requests tokens")
+}
+
+func TestMemoryStoreSweep(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Requests: 1, Window: time.Minute}
+
+	_, _ = store.Take("user:1", limit)
+	now = now.Add(30 * time.Second)
+	_, _ = store.Take("user:2", limit)
+	now = now.Add(45 * time.Second)
+	_, _ = store.Take("user:3", limit)
+
+	assert.NotContains(t, store.buckets, "user:1")
+	assert.Contains(t, store.buckets, "user:2")
+	assert.Contains(t, store.buckets, "user:3")
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// StoreMemory keeps buckets in memory, so every instance of the service
+	// enforces its own limits.
+	StoreMemory = "memory"
+	// StoreRedis keeps buckets in Redis, shared by every instance of the
+	// service.
+	StoreRedis = "redis"
+)
+
+// Limit allows Requests requests per Window. Requests are limited with a token
+// bucket holding up to Requests tokens and refilled at Requests per Window, so
+// bursts of up to Requests requests are allowed.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long to wait until a request is allowed again. It
+	// is zero for allowed requests.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store keeps the buckets of rate limited keys.
+type Store interface {
+	// Take takes a token from the bucket of key, which is allowed when the
+	// bucket is not empty.
+	Take(key string, limit Limit) (result Result, err error)
+}
+
+// refill returns the tokens in a bucket of limit holding tokens elapsed ago.
+func refill(limit Limit, tokens float64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return tokens
+	}
+	return math.Min(float64(limit.Requests), tokens+float64(elapsed)*rate(limit))
+}
+
+// newResult describes a bucket of limit left holding tokens.
+func newResult(limit Limit, tokens float64, allowed bool) Result {
+	res := Result{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration(math.Ceil((float64(limit.Requests) - tokens) / rate(limit))),
+	}
+	if !allowed {
+		res.RetryAfter = time.Duration(math.Ceil((1 - tokens) / rate(limit)))
+	}
+	return res
+}
+
+// rate is the number of tokens added to a bucket of limit per nanosecond.
+func rate(limit Limit) float64 {
+	return float64(limit.Requests) / float64(limit.Window)
+}
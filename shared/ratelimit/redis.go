@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// redisKeyPrefix namespaces buckets in Redis.
+const redisKeyPrefix = "ratelimit:"
+
+// takeScript refills and takes a token from the bucket in KEYS[1] atomically.
+// ARGV holds the capacity, the window and the current time in milliseconds. It
+// returns whether the token was taken and the tokens left, as a string since
+// Redis truncates Lua numbers to integers.
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(bucket[1]) or capacity
+local updated = tonumber(bucket[2]) or now
+if now > updated then
+	tokens = math.min(capacity, tokens + (now - updated) * capacity / window)
+end
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "updated", math.max(now, updated))
+redis.call("PEXPIRE", KEYS[1], window)
+return {allowed, tostring(tokens)}
+`)
+
+// RedisStore is a Store keeping buckets in Redis. Buckets expire once they
+// are full again.
+type RedisStore struct {
+	Client *redis.Client
+}
+
+// NewRedisStore creates a new RedisStore.
+func NewRedisStore(client *redis.Client) *RedisStore {
+	return &RedisStore{Client: client}
+}
+
+// Take takes a token from the bucket of key with a Lua script, so concurrent
+// requests from every instance of the service share the bucket.
+func (s *RedisStore) Take(key string, limit Limit) (result Result, err error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	window := limit.Window.Milliseconds()
+
+	reply, err := takeScript.Run(s.Client, []string{redisKeyPrefix + key}, limit.Requests, window, now).Result()
+	if err != nil {
+		return
+	}
+
+	values := reply.([]interface{})
+	tokens, err := strconv.ParseFloat(values[1].(string), 64)
+	if err != nil {
+		return
+	}
+
+	return newResult(limit, tokens, values[0].(int64) == 1), nil
+}
@@ -11,8 +11,12 @@ import (
 
 	"github.com/evermos/boilerplate-go/configs"
 	"github.com/evermos/boilerplate-go/docs"
+	"github.com/evermos/boilerplate-go/event/outbox"
 	"github.com/evermos/boilerplate-go/infras"
+	"github.com/evermos/boilerplate-go/internal/domain/cart"
+	"github.com/evermos/boilerplate-go/shared/idempotency"
 	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/evermos/boilerplate-go/shared/oauth"
 	"github.com/evermos/boilerplate-go/transport/http/response"
 	"github.com/evermos/boilerplate-go/transport/http/router"
 	"github.com/go-chi/chi"
@@ -39,22 +43,39 @@ const (
 
 // HTTP is the HTTP server.
 type HTTP struct {
-	Config *configs.Config
-	DB     *infras.MySQLConn
-	Router router.Router
-	State  ServerState
-	mux    *chi.Mux
+	Config             *configs.Config
+	DB                 *infras.MySQLConn
+	Router             router.Router
+	Janitor            *oauth.Janitor
+	IdempotencyJanitor *idempotency.Janitor
+	Relay              *outbox.Relay
+	OutboxJanitor      *outbox.Janitor
+	Abandonment        *cart.AbandonmentDetector
+	State              ServerState
+	mux                *chi.Mux
+	cleanups           []func()
 }
 
 // ProvideHTTP is the provider for HTTP.
-func ProvideHTTP(db *infras.MySQLConn, config *configs.Config, router router.Router) *HTTP {
+func ProvideHTTP(db *infras.MySQLConn, config *configs.Config, router router.Router, janitor *oauth.Janitor, idempotencyJanitor *idempotency.Janitor, relay *outbox.Relay, outboxJanitor *outbox.Janitor, abandonment *cart.AbandonmentDetector) *HTTP {
 	return &HTTP{
-		DB:     db,
-		Config: config,
-		Router: router,
+		DB:                 db,
+		Config:             config,
+		Router:             router,
+		Janitor:            janitor,
+		IdempotencyJanitor: idempotencyJanitor,
+		Relay:              relay,
+		OutboxJanitor:      outboxJanitor,
+		Abandonment:        abandonment,
 	}
 }
 
+// OnCleanup registers fn to be run when the server enters its cleanup period,
+// after its own background workers are stopped.
+func (h *HTTP) OnCleanup(fn func()) {
+	h.cleanups = append(h.cleanups, fn)
+}
+
 // SetupAndServe sets up the server and gets it up and running.
 func (h *HTTP) SetupAndServe() {
 	h.mux = chi.NewRouter()
@@ -62,6 +83,11 @@ func (h *HTTP) SetupAndServe() {
 	h.setupSwaggerDocs()
 	h.setupRoutes()
 	h.setupGracefulShutdown()
+	h.Janitor.Start()
+	h.IdempotencyJanitor.Start()
+	h.Relay.Start()
+	h.OutboxJanitor.Start()
+	h.Abandonment.Start()
 	h.State = ServerStateReady
 
 	h.logServerInfo()
@@ -108,6 +134,16 @@ func (h *HTTP) respondToSigterm(done chan os.Signal) {
 
 	log.Info().Int64("seconds", shutdownConfig.CleanupPeriodSeconds).Msg("Entering cleanup period.")
 	h.State = ServerStateInCleanupPeriod
+	// Consumers are stopped last, so the events still being relayed reach
+	// the consumers of the memory driver instead of being dropped.
+	h.Janitor.Stop()
+	h.IdempotencyJanitor.Stop()
+	h.Abandonment.Stop()
+	h.Relay.Stop()
+	h.OutboxJanitor.Stop()
+	for _, cleanup := range h.cleanups {
+		cleanup()
+	}
 	time.Sleep(time.Duration(shutdownConfig.CleanupPeriodSeconds) * time.Second)
 
 	log.Info().Msg("Cleaning up completed. Shutting down now.")
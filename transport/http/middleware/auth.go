@@ -2,42 +2,66 @@ package middleware
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/evermos/boilerplate-go/configs"
 	"github.com/evermos/boilerplate-go/infras"
 	"github.com/evermos/boilerplate-go/shared"
-	"github.com/evermos/boilerplate-go/shared/failure"
+	"github.com/evermos/boilerplate-go/shared/authservice"
+	"github.com/evermos/boilerplate-go/shared/jwtauth"
 	"github.com/evermos/boilerplate-go/shared/oauth"
+	"github.com/evermos/boilerplate-go/shared/policy"
 	"github.com/evermos/boilerplate-go/transport/http/response"
+	"github.com/gofrs/uuid"
+	"github.com/rs/zerolog/log"
 )
 
-type ResponseValidate struct {
-    Data shared.Claims `json:"data"`
-}
-
 type ClaimsKey string
 type Authentication struct {
-	db *infras.MySQLConn
-	config *configs.Config
+	db         *infras.MySQLConn
+	config     *configs.Config
+	verifier   *jwtauth.Verifier
+	remote     *authservice.Client
+	authorizer policy.Authorizer
 }
 
 const (
 	HeaderAuthorization = "Authorization"
 )
 
-func ProvideAuthentication(db *infras.MySQLConn, conf *configs.Config) *Authentication {
+func ProvideAuthentication(db *infras.MySQLConn, conf *configs.Config, authorizer policy.Authorizer) *Authentication {
+	verifier, err := jwtauth.ProvideVerifier(conf)
+	if err != nil {
+		log.
+			Fatal().
+			Err(err).
+			Msg("Failed loading JWT verification keys")
+	}
+
+	if verifier == nil && !conf.App.JWT.RemoteFallback {
+		log.Warn().Msg("No JWT verification keys configured and remote fallback is disabled, all tokens will be rejected")
+	}
+
+	var remote *authservice.Client
+	if conf.App.JWT.RemoteFallback {
+		remote = authservice.ProvideClient(conf)
+	}
+
 	return &Authentication{
-		db: db,
-		config: conf,
+		db:         db,
+		config:     conf,
+		verifier:   verifier,
+		remote:     remote,
+		authorizer: authorizer,
 	}
 }
 
 func (a *Authentication) ClientCredential(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		accessToken := r.Header.Get(HeaderAuthorization)
-		token := oauth.New(a.db.Read, oauth.Config{})
+		token := oauth.New(a.db.Read, oauth.ProvideConfig(a.config))
 
 		parseToken, err := token.ParseWithAccessToken(accessToken)
 		if err != nil {
@@ -46,11 +70,12 @@ func (a *Authentication) ClientCredential(next http.Handler) http.Handler {
 		}
 
 		if !parseToken.VerifyExpireIn() {
-			response.WithMessage(w, http.StatusUnauthorized, err.Error())
+			response.WithMessage(w, http.StatusUnauthorized, oauth.ErrorInvalidToken)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), ClaimsKey("token"), parseToken)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
@@ -61,7 +86,7 @@ func (a *Authentication) ClientCredentialWithQueryParameter(next http.Handler) h
 		tokenType := params.Get("token_type")
 		accessToken := tokenType + " " + token
 
-		auth := oauth.New(a.db.Read, oauth.Config{})
+		auth := oauth.New(a.db.Read, oauth.ProvideConfig(a.config))
 		parseToken, err := auth.ParseWithAccessToken(accessToken)
 		if err != nil {
 			response.WithMessage(w, http.StatusUnauthorized, err.Error())
@@ -69,18 +94,19 @@ func (a *Authentication) ClientCredentialWithQueryParameter(next http.Handler) h
 		}
 
 		if !parseToken.VerifyExpireIn() {
-			response.WithMessage(w, http.StatusUnauthorized, err.Error())
+			response.WithMessage(w, http.StatusUnauthorized, oauth.ErrorInvalidToken)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), ClaimsKey("token"), parseToken)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
 func (a *Authentication) Password(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		accessToken := r.Header.Get(HeaderAuthorization)
-		token := oauth.New(a.db.Read, oauth.Config{})
+		token := oauth.New(a.db.Read, oauth.ProvideConfig(a.config))
 
 		parseToken, err := token.ParseWithAccessToken(accessToken)
 		if err != nil {
@@ -89,7 +115,7 @@ func (a *Authentication) Password(next http.Handler) http.Handler {
 		}
 
 		if !parseToken.VerifyExpireIn() {
-			response.WithMessage(w, http.StatusUnauthorized, err.Error())
+			response.WithMessage(w, http.StatusUnauthorized, oauth.ErrorInvalidToken)
 			return
 		}
 
@@ -98,12 +124,16 @@ func (a *Authentication) Password(next http.Handler) http.Handler {
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), ClaimsKey("token"), parseToken)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-
-
+// ValidateJWT verifies the bearer token and puts its claims in the request
+// context. Tokens are verified locally with the configured secrets and JWKS;
+// the auth service is only asked about tokens no local key can verify, and
+// only when APP.JWT.REMOTE_FALLBACK is enabled. Requests are answered with 503
+// while the auth service is unavailable.
 func (a *Authentication) ValidateJWT(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		accessToken := r.Header.Get(HeaderAuthorization)
@@ -112,59 +142,97 @@ func (a *Authentication) ValidateJWT(next http.Handler) http.Handler {
 			return
 		}
 
-		accessToken = accessToken[(len("Bearer ")):]
-		client := http.Client{}
-		req , err := http.NewRequest("GET",configs.Get().App.AuthServiceBaseURL + configs.Get().App.AuthServiceValidatePath, nil)
-		if err != nil {
-			response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
-			return
-		}
-
-		req.Header.Set("Authorization", "Bearer " +  accessToken)
-
-		res , err := client.Do(req)
-		if err != nil {
-			response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
-			return
-		}
+		accessToken = strings.TrimPrefix(accessToken, "Bearer ")
 
-		if res.StatusCode != 200 {
-			response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
+		claims, err := a.validate(accessToken)
+		if err == authservice.ErrUnavailable {
+			response.WithMessage(w, http.StatusServiceUnavailable, "Auth service unavailable")
 			return
 		}
-
-		decoder := json.NewDecoder(res.Body)
-		var requestFormat ResponseValidate
-		err = decoder.Decode(&requestFormat)
 		if err != nil {
-			response.WithError(w, failure.BadRequest(err))
+			response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), ClaimsKey("claims"), requestFormat.Data)
+		ctx := context.WithValue(r.Context(), ClaimsKey("claims"), claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
-
 	})
 }
 
+func (a *Authentication) validate(accessToken string) (claims shared.Claims, err error) {
+	err = jwtauth.ErrUnknownKey
+	if a.verifier != nil {
+		claims, err = a.verifier.Verify(accessToken)
+	}
 
-func (a *Authentication) RoleAdminCheck(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		claims, ok := r.Context().Value(ClaimsKey("claims")).(shared.Claims)
-		if !ok {
-			response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
-			return
+	if err == jwtauth.ErrUnknownKey && a.remote != nil {
+		return a.remote.Validate(accessToken)
+	}
 
-		}
+	return
+}
 
-		if claims.Role != "admin" {
-			response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
-			return
-		}
-		
-		ctx := context.WithValue(r.Context(), ClaimsKey("claims"), claims)
-		next.ServeHTTP(w, r.WithContext(ctx))
-	})
+// Require lets requests through only when the role in the JWT claims has all
+// of the given permissions, so ValidateJWT must run first.
+func (a *Authentication) Require(permissions ...policy.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := r.Context().Value(ClaimsKey("claims")).(shared.Claims)
+			if !ok {
+				response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+
+			actor := policy.Actor{UserID: uuid.FromStringOrNil(claims.UserId), Role: claims.Role}
+			for _, permission := range permissions {
+				if !a.authorizer.Can(actor, permission) {
+					response.WithMessage(w, http.StatusForbidden, "Forbidden")
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
+// RequireScope lets requests through only when the access token has all of
+// the given scopes. The scopes are those of the OAuth access token put in the
+// context by ClientCredential or Password, or else the scope claim of the JWT
+// put there by ValidateJWT. Requests lacking a scope are answered with 403 and
+// an insufficient_scope challenge, as defined by RFC 6750 section 3.1.
+func (a *Authentication) RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	challenge := fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " "))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			scope, ok := tokenScope(r)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				response.WithMessage(w, http.StatusUnauthorized, "Unauthorized")
+				return
+			}
+
+			if !oauth.HasScope(scope, scopes...) {
+				w.Header().Set("WWW-Authenticate", challenge)
+				response.WithMessage(w, http.StatusForbidden, "Insufficient scope")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// tokenScope returns the scope of the access token of the request.
+func tokenScope(r *http.Request) (string, bool) {
+	if token, ok := r.Context().Value(ClaimsKey("token")).(oauth.OauthAccessToken); ok {
+		return token.Scope.String, true
+	}
 
+	if claims, ok := r.Context().Value(ClaimsKey("claims")).(shared.Claims); ok {
+		return claims.Scope, true
+	}
+
+	return "", false
+}
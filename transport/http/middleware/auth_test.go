@@ -0,0 +1,206 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/shared"
+	"github.com/evermos/boilerplate-go/shared/authservice"
+	"github.com/evermos/boilerplate-go/shared/oauth"
+	"github.com/evermos/boilerplate-go/shared/policy"
+	"github.com/evermos/boilerplate-go/transport/http/middleware"
+	"github.com/golang-jwt/jwt"
+	"github.com/guregu/null"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// claimsHandler answers with the user ID of the claims in the context.
+func claimsHandler(w http.ResponseWriter, r *http.Request) {
+	claims, _ := r.Context().Value(middleware.ClaimsKey("claims")).(shared.Claims)
+	_, _ = w.Write([]byte(claims.UserId))
+}
+
+func signHS256(t *testing.T, secret string, userID string) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, shared.Claims{
+		UserId: userID,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	}).SignedString([]byte(secret))
+	require.NoError(t, err)
+	return token
+}
+
+func newAuthorizedRequest(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/v1/orders", nil)
+	if token != "" {
+		r.Header.Set(middleware.HeaderAuthorization, "Bearer "+token)
+	}
+	return r
+}
+
+func TestValidateJWT(t *testing.T) {
+	remoteCalls := 0
+	authService := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteCalls++
+		if r.Header.Get(middleware.HeaderAuthorization) != "Bearer remote-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(authservice.ValidateResponse{Data: shared.Claims{UserId: "remote-user"}})
+	}))
+	defer authService.Close()
+
+	setup := func(secret string, remoteFallback bool) http.Handler {
+		conf := &configs.Config{}
+		if secret != "" {
+			conf.App.JWT.HS256Secrets = []string{secret}
+		}
+		conf.App.JWT.RemoteFallback = remoteFallback
+		conf.App.AuthServiceBaseURL = authService.URL
+		conf.App.AuthServiceValidatePath = "/v1/validate"
+		remoteCalls = 0
+		return middleware.ProvideAuthentication(nil, conf, policy.ProvidePolicy(conf)).ValidateJWT(http.HandlerFunc(claimsHandler))
+	}
+
+	t.Run("missing header", func(t *testing.T) {
+		w := serve(setup("secret", false), newAuthorizedRequest(""))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("verifies locally", func(t *testing.T) {
+		w := serve(setup("secret", true), newAuthorizedRequest(signHS256(t, "secret", "u1")))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "u1", w.Body.String())
+		assert.Equal(t, 0, remoteCalls)
+	})
+
+	t.Run("bad signature is not sent to the auth service", func(t *testing.T) {
+		w := serve(setup("secret", true), newAuthorizedRequest(signHS256(t, "forged", "u1")))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, 0, remoteCalls)
+	})
+
+	t.Run("falls back to the auth service when enabled", func(t *testing.T) {
+		w := serve(setup("", true), newAuthorizedRequest("remote-token"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "remote-user", w.Body.String())
+		assert.Equal(t, 1, remoteCalls)
+	})
+
+	t.Run("auth service rejection", func(t *testing.T) {
+		w := serve(setup("", true), newAuthorizedRequest("other-token"))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, 1, remoteCalls)
+	})
+
+	t.Run("auth service outage", func(t *testing.T) {
+		down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		down.Close()
+		conf := &configs.Config{}
+		conf.App.JWT.RemoteFallback = true
+		conf.App.AuthServiceBaseURL = down.URL
+
+		h := middleware.ProvideAuthentication(nil, conf, policy.ProvidePolicy(conf)).ValidateJWT(http.HandlerFunc(claimsHandler))
+		w := serve(h, newAuthorizedRequest("remote-token"))
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("no fallback unless enabled", func(t *testing.T) {
+		w := serve(setup("", false), newAuthorizedRequest("remote-token"))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, 0, remoteCalls)
+	})
+}
+
+func TestRequire(t *testing.T) {
+	conf := &configs.Config{}
+	conf.App.Policy.Roles = map[string][]string{"warehouse": {"order:read:any", "order:transition"}}
+	auth := middleware.ProvideAuthentication(nil, conf, policy.ProvidePolicy(conf))
+
+	cases := []struct {
+		name        string
+		role        string
+		permissions []policy.Permission
+		want        int
+	}{
+		{name: "admin has every permission", role: "admin", permissions: []policy.Permission{policy.ProductWrite}, want: http.StatusOK},
+		{name: "user cannot write products", role: "user", permissions: []policy.Permission{policy.ProductWrite}, want: http.StatusForbidden},
+		{name: "configured role", role: "warehouse", permissions: []policy.Permission{policy.OrderTransition}, want: http.StatusOK},
+		{name: "configured role needs all permissions", role: "warehouse", permissions: []policy.Permission{policy.OrderTransition, policy.ProductWrite}, want: http.StatusForbidden},
+		{name: "unknown role", role: "guest", permissions: []policy.Permission{policy.OrderReadAny}, want: http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/v1/orders/1/status", nil)
+			r = r.WithContext(context.WithValue(r.Context(), middleware.ClaimsKey("claims"), shared.Claims{UserId: "u1", Role: c.role}))
+
+			w := serve(auth.Require(c.permissions...)(http.HandlerFunc(claimsHandler)), r)
+
+			assert.Equal(t, c.want, w.Code)
+		})
+	}
+
+	t.Run("without claims", func(t *testing.T) {
+		w := serve(auth.Require(policy.ProductWrite)(http.HandlerFunc(claimsHandler)), httptest.NewRequest(http.MethodPost, "/v1/products", nil))
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestRequireScope(t *testing.T) {
+	auth := middleware.ProvideAuthentication(nil, &configs.Config{}, policy.ProvidePolicy(&configs.Config{}))
+	handler := auth.RequireScope("orders:read", "orders:write")(http.HandlerFunc(claimsHandler))
+
+	withToken := func(scope string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/v1/orders", nil)
+		token := oauth.OauthAccessToken{}
+		if scope != "" {
+			token.Scope = null.StringFrom(scope)
+		}
+		return r.WithContext(context.WithValue(r.Context(), middleware.ClaimsKey("token"), token))
+	}
+
+	withClaims := func(scope string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/v1/orders", nil)
+		return r.WithContext(context.WithValue(r.Context(), middleware.ClaimsKey("claims"), shared.Claims{UserId: "u1", Scope: scope}))
+	}
+
+	cases := []struct {
+		name string
+		r    *http.Request
+		want int
+	}{
+		{name: "OAuth token with every scope", r: withToken("orders:write user orders:read"), want: http.StatusOK},
+		{name: "OAuth token lacking a scope", r: withToken("orders:read"), want: http.StatusForbidden},
+		{name: "OAuth token without scopes", r: withToken(""), want: http.StatusForbidden},
+		{name: "JWT with every scope", r: withClaims("orders:read orders:write"), want: http.StatusOK},
+		{name: "JWT without scopes", r: withClaims(""), want: http.StatusForbidden},
+		{name: "no token", r: httptest.NewRequest(http.MethodGet, "/v1/orders", nil), want: http.StatusUnauthorized},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := serve(handler, c.r)
+
+			assert.Equal(t, c.want, w.Code)
+			if c.want == http.StatusForbidden {
+				assert.Equal(t, `Bearer error="insufficient_scope", scope="orders:read orders:write"`, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
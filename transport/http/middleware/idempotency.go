@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/infras"
+	"github.com/evermos/boilerplate-go/shared"
+	"github.com/evermos/boilerplate-go/shared/idempotency"
+	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/evermos/boilerplate-go/transport/http/response"
+)
+
+const (
+	HeaderIdempotencyKey        = "Idempotency-Key"
+	HeaderIdempotentReplayed    = "Idempotent-Replayed"
+	maxIdempotencyKeyLength     = 255
+	defaultIdempotencyTTLSecs   = 24 * 60 * 60
+	defaultIdempotencyLeaseSecs = 60
+)
+
+// Idempotency makes unsafe requests safe to retry. The first response sent for
+// an Idempotency-Key is saved and replayed to retries with the same key.
+type Idempotency struct {
+	store idempotency.Store
+	ttl   time.Duration
+	// lease is how long a key stays reserved while its first request is
+	// processed. It bounds how long retries are locked out when the process
+	// dies before the response is saved, so it should be longer than any
+	// request but much shorter than ttl.
+	lease time.Duration
+}
+
+// ProvideIdempotency is the provider for Idempotency. The store is selected by
+// configuration and defaults to MySQL.
+func ProvideIdempotency(db *infras.MySQLConn, conf *configs.Config) *Idempotency {
+	var store idempotency.Store
+	switch conf.App.Idempotency.Store {
+	case idempotency.StoreRedis:
+		store = idempotency.NewRedisStore(infras.RedisNewClient(*conf))
+	default:
+		store = idempotency.NewMySQLStore(db)
+	}
+
+	ttlSeconds := conf.App.Idempotency.TTLSeconds
+	if ttlSeconds <= 0 {
+		ttlSeconds = defaultIdempotencyTTLSecs
+	}
+
+	leaseSeconds := conf.App.Idempotency.LeaseSeconds
+	if leaseSeconds <= 0 {
+		leaseSeconds = defaultIdempotencyLeaseSecs
+	}
+
+	return NewIdempotency(store, time.Duration(ttlSeconds)*time.Second, time.Duration(leaseSeconds)*time.Second)
+}
+
+// NewIdempotency creates an Idempotency middleware on the given store. Keys are
+// reserved for lease while their first request is processed, and responses are
+// kept for ttl once saved.
+func NewIdempotency(store idempotency.Store, ttl time.Duration, lease time.Duration) *Idempotency {
+	return &Idempotency{
+		store: store,
+		ttl:   ttl,
+		lease: lease,
+	}
+}
+
+// Handle applies idempotency to requests carrying an Idempotency-Key header;
+// requests without one pass through untouched. Keys are scoped to the user in
+// the JWT claims, so ValidateJWT must run first. A key reused with a different
+// payload is rejected with 422, and a key whose first request is still being
+// processed with 409. Server errors are not saved, so they can be retried.
+// A key whose first request never completed is freed once its lease expires.
+func (m *Idempotency) Handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(HeaderIdempotencyKey)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			response.WithMessage(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			response.WithMessage(w, http.StatusBadRequest, "Unable to read request body")
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		claims, _ := r.Context().Value(ClaimsKey("claims")).(shared.Claims)
+		idempotencyKey := idempotency.Key{UserID: claims.UserId, Key: key}
+		fingerprint := idempotency.Fingerprint(r.Method, r.URL.Path, body)
+
+		record, reserved, err := m.store.Reserve(idempotencyKey, fingerprint, m.lease)
+		if err != nil {
+			response.WithMessage(w, http.StatusInternalServerError, "Unable to process Idempotency-Key")
+			return
+		}
+
+		if !reserved {
+			m.replay(w, record, fingerprint)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		completed := false
+		defer func() {
+			if !completed {
+				m.release(idempotencyKey, record)
+			}
+		}()
+
+		next.ServeHTTP(recorder, r)
+
+		if recorder.statusCode() >= http.StatusInternalServerError {
+			return
+		}
+
+		err = m.store.Complete(idempotencyKey, record, idempotency.Record{
+			Fingerprint: fingerprint,
+			Completed:   true,
+			StatusCode:  recorder.statusCode(),
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+			ExpiresAt:   time.Now().Add(m.ttl),
+		})
+		completed = err == nil
+	})
+}
+
+// replay answers a request whose key has already been used.
+func (m *Idempotency) replay(w http.ResponseWriter, existing idempotency.Record, fingerprint string) {
+	if existing.Fingerprint != fingerprint {
+		response.WithMessage(w, http.StatusUnprocessableEntity, "Idempotency-Key has already been used with a different request")
+		return
+	}
+
+	if !existing.Completed {
+		response.WithMessage(w, http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+		return
+	}
+
+	if existing.ContentType != "" {
+		w.Header().Set("Content-Type", existing.ContentType)
+	}
+	w.Header().Set(HeaderIdempotentReplayed, "true")
+	w.WriteHeader(existing.StatusCode)
+	_, err := w.Write(existing.Body)
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+}
+
+func (m *Idempotency) release(key idempotency.Key, reservation idempotency.Record) {
+	err := m.store.Release(key, reservation)
+	if err != nil {
+		logger.ErrorWithStack(err)
+	}
+}
+
+// responseRecorder passes a response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
@@ -0,0 +1,216 @@
+package middleware_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evermos/boilerplate-go/shared"
+	"github.com/evermos/boilerplate-go/shared/idempotency"
+	"github.com/evermos/boilerplate-go/transport/http/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[idempotency.Key]idempotency.Record
+}
+
+func newFakeIdempotencyStore() *fakeIdempotencyStore {
+	return &fakeIdempotencyStore{records: make(map[idempotency.Key]idempotency.Record)}
+}
+
+func (s *fakeIdempotencyStore) Reserve(key idempotency.Key, fingerprint string, lease time.Duration) (idempotency.Record, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.records[key]; ok && existing.ExpiresAt.After(time.Now()) {
+		return existing, false, nil
+	}
+	reservation := idempotency.Record{Fingerprint: fingerprint, ExpiresAt: time.Now().Add(lease)}
+	s.records[key] = reservation
+	return reservation, true, nil
+}
+
+func (s *fakeIdempotencyStore) Complete(key idempotency.Key, reservation idempotency.Record, record idempotency.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.holds(key, reservation) {
+		s.records[key] = record
+	}
+	return nil
+}
+
+func (s *fakeIdempotencyStore) Release(key idempotency.Key, reservation idempotency.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.holds(key, reservation) {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+// holds tells whether key is still reserved by reservation.
+func (s *fakeIdempotencyStore) holds(key idempotency.Key, reservation idempotency.Record) bool {
+	record := s.records[key]
+	return !record.Completed && record.Fingerprint == reservation.Fingerprint && record.ExpiresAt.Equal(reservation.ExpiresAt)
+}
+
+// countingHandler answers with the given status and counts its calls.
+type countingHandler struct {
+	calls  int
+	status int
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.calls++
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(h.status)
+	_, _ = fmt.Fprintf(w, `{"data":{"call":%d}}`, h.calls)
+}
+
+func newIdempotentRequest(userID string, key string, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/v1/carts/1/checkout", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(middleware.HeaderIdempotencyKey, key)
+	}
+	ctx := context.WithValue(r.Context(), middleware.ClaimsKey("claims"), shared.Claims{UserId: userID})
+	return r.WithContext(ctx)
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotency(t *testing.T) {
+	setup := func(status int) (*countingHandler, http.Handler, *fakeIdempotencyStore) {
+		next := &countingHandler{status: status}
+		store := newFakeIdempotencyStore()
+		return next, middleware.NewIdempotency(store, time.Hour, time.Minute).Handle(next), store
+	}
+
+	t.Run("requests without a key pass through", func(t *testing.T) {
+		next, h, _ := setup(http.StatusCreated)
+
+		serve(h, newIdempotentRequest("u1", "", `{}`))
+		serve(h, newIdempotentRequest("u1", "", `{}`))
+
+		assert.Equal(t, 2, next.calls)
+	})
+
+	t.Run("retry replays the first response", func(t *testing.T) {
+		next, h, _ := setup(http.StatusCreated)
+
+		first := serve(h, newIdempotentRequest("u1", "k1", `{"address":"Bandung"}`))
+		retry := serve(h, newIdempotentRequest("u1", "k1", `{"address":"Bandung"}`))
+
+		assert.Equal(t, 1, next.calls)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.Equal(t, first.Body.String(), retry.Body.String())
+		assert.Equal(t, "application/json", retry.Header().Get("Content-Type"))
+		assert.Equal(t, "true", retry.Header().Get(middleware.HeaderIdempotentReplayed))
+		assert.Empty(t, first.Header().Get(middleware.HeaderIdempotentReplayed))
+	})
+
+	t.Run("same key with a different payload is rejected", func(t *testing.T) {
+		next, h, _ := setup(http.StatusCreated)
+
+		serve(h, newIdempotentRequest("u1", "k1", `{"address":"Bandung"}`))
+		w := serve(h, newIdempotentRequest("u1", "k1", `{"address":"Jakarta"}`))
+
+		assert.Equal(t, 1, next.calls)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	})
+
+	t.Run("keys are scoped per user", func(t *testing.T) {
+		next, h, _ := setup(http.StatusCreated)
+
+		serve(h, newIdempotentRequest("u1", "k1", `{}`))
+		serve(h, newIdempotentRequest("u2", "k1", `{}`))
+
+		assert.Equal(t, 2, next.calls)
+	})
+
+	t.Run("server errors are not saved", func(t *testing.T) {
+		next, h, store := setup(http.StatusInternalServerError)
+
+		serve(h, newIdempotentRequest("u1", "k1", `{}`))
+		serve(h, newIdempotentRequest("u1", "k1", `{}`))
+
+		assert.Equal(t, 2, next.calls)
+		assert.Empty(t, store.records)
+	})
+
+	t.Run("request still in progress conflicts", func(t *testing.T) {
+		next, h, store := setup(http.StatusCreated)
+		body := `{}`
+		store.records[idempotency.Key{UserID: "u1", Key: "k1"}] = idempotency.Record{
+			Fingerprint: idempotency.Fingerprint(http.MethodPost, "/v1/carts/1/checkout", []byte(body)),
+			ExpiresAt:   time.Now().Add(time.Hour),
+		}
+
+		w := serve(h, newIdempotentRequest("u1", "k1", body))
+
+		assert.Equal(t, 0, next.calls)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("keys are leased until the response is saved", func(t *testing.T) {
+		store := newFakeIdempotencyStore()
+		key := idempotency.Key{UserID: "u1", Key: "k1"}
+		var leased time.Time
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			store.mu.Lock()
+			leased = store.records[key].ExpiresAt
+			store.mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		})
+		h := middleware.NewIdempotency(store, time.Hour, time.Minute).Handle(next)
+
+		serve(h, newIdempotentRequest("u1", "k1", `{}`))
+
+		assert.WithinDuration(t, time.Now().Add(time.Minute), leased, 5*time.Second)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), store.records[key].ExpiresAt, 5*time.Second)
+	})
+
+	t.Run("a request that lost its lease keeps the new reservation", func(t *testing.T) {
+		for _, status := range []int{http.StatusCreated, http.StatusInternalServerError} {
+			store := newFakeIdempotencyStore()
+			key := idempotency.Key{UserID: "u1", Key: "k1"}
+			newer := idempotency.Record{Fingerprint: "newer", ExpiresAt: time.Now().Add(time.Minute)}
+			status := status
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// The lease expired and another request reserved the key.
+				store.mu.Lock()
+				store.records[key] = newer
+				store.mu.Unlock()
+				w.WriteHeader(status)
+			})
+			h := middleware.NewIdempotency(store, time.Hour, time.Minute).Handle(next)
+
+			serve(h, newIdempotentRequest("u1", "k1", `{}`))
+
+			assert.Equal(t, newer, store.records[key])
+		}
+	})
+
+	t.Run("an abandoned request frees its key once its lease expires", func(t *testing.T) {
+		next, h, store := setup(http.StatusCreated)
+		body := `{}`
+		store.records[idempotency.Key{UserID: "u1", Key: "k1"}] = idempotency.Record{
+			Fingerprint: idempotency.Fingerprint(http.MethodPost, "/v1/carts/1/checkout", []byte(body)),
+			ExpiresAt:   time.Now().Add(-time.Second),
+		}
+
+		w := serve(h, newIdempotentRequest("u1", "k1", body))
+
+		assert.Equal(t, 1, next.calls)
+		assert.Equal(t, http.StatusCreated, w.Code)
+	})
+}
@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/evermos/boilerplate-go/configs"
+	"github.com/evermos/boilerplate-go/infras"
+	"github.com/evermos/boilerplate-go/shared"
+	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/evermos/boilerplate-go/shared/oauth"
+	"github.com/evermos/boilerplate-go/shared/ratelimit"
+	"github.com/evermos/boilerplate-go/transport/http/response"
+)
+
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRateLimitPolicy    = "RateLimit-Policy"
+	HeaderRetryAfter         = "Retry-After"
+)
+
+// RateLimit limits how often each caller may call a group of routes.
+type RateLimit struct {
+	store  ratelimit.Store
+	limits map[string]ratelimit.Limit
+}
+
+// ProvideRateLimit is the provider for RateLimit. The store is selected by
+// configuration and defaults to memory, and the limits of each route group are
+// read from APP.RATE_LIMIT.GROUPS.
+func ProvideRateLimit(conf *configs.Config) *RateLimit {
+	var store ratelimit.Store
+	switch conf.App.RateLimit.Store {
+	case ratelimit.StoreRedis:
+		store = ratelimit.NewRedisStore(infras.RedisNewClient(*conf))
+	default:
+		store = ratelimit.NewMemoryStore()
+	}
+
+	limits := make(map[string]ratelimit.Limit)
+	for group, limit := range conf.App.RateLimit.Groups {
+		limits[strings.ToLower(group)] = ratelimit.Limit{
+			Requests: limit.Requests,
+			Window:   time.Duration(limit.WindowSeconds) * time.Second,
+		}
+	}
+
+	return NewRateLimit(store, limits)
+}
+
+// NewRateLimit creates a RateLimit middleware on the given store, with limits
+// keyed by route group.
+func NewRateLimit(store ratelimit.Store, limits map[string]ratelimit.Limit) *RateLimit {
+	return &RateLimit{
+		store:  store,
+		limits: limits,
+	}
+}
+
+// Limit limits the requests of each caller to the routes of group, answering
+// 429 with Retry-After once the limit is reached. Callers are identified by
+// the user in the JWT claims, then the OAuth client of the access token, then
+// their IP, so the authentication middleware should run first. Groups without
+// a limit are not limited, and requests are let through when the store fails.
+func (m *RateLimit) Limit(group string) func(http.Handler) http.Handler {
+	limit, ok := m.limits[strings.ToLower(group)]
+	return func(next http.Handler) http.Handler {
+		if !ok || limit.Requests <= 0 || limit.Window <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := m.store.Take(strings.ToLower(group)+":"+rateLimitKey(r), limit)
+			if err != nil {
+				logger.ErrorWithStack(err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set(HeaderRateLimitLimit, strconv.Itoa(res.Limit))
+			w.Header().Set(HeaderRateLimitRemaining, strconv.Itoa(res.Remaining))
+			w.Header().Set(HeaderRateLimitReset, strconv.Itoa(seconds(res.Reset)))
+			w.Header().Set(HeaderRateLimitPolicy, strconv.Itoa(limit.Requests)+";w="+strconv.Itoa(seconds(limit.Window)))
+
+			if !res.Allowed {
+				w.Header().Set(HeaderRetryAfter, strconv.Itoa(seconds(res.RetryAfter)))
+				response.WithMessage(w, http.StatusTooManyRequests, "Too many requests")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey identifies the caller of r.
+func rateLimitKey(r *http.Request) string {
+	if claims, ok := r.Context().Value(ClaimsKey("claims")).(shared.Claims); ok && claims.UserId != "" {
+		return "user:" + claims.UserId
+	}
+
+	if token, ok := r.Context().Value(ClaimsKey("token")).(oauth.OauthAccessToken); ok && token.ClientID != "" {
+		return "client:" + token.ClientID
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// seconds rounds d up to whole seconds.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
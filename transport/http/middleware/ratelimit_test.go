@@ -0,0 +1,101 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/evermos/boilerplate-go/shared"
+	"github.com/evermos/boilerplate-go/shared/oauth"
+	"github.com/evermos/boilerplate-go/shared/ratelimit"
+	"github.com/evermos/boilerplate-go/transport/http/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingRateLimitStore struct{}
+
+func (failingRateLimitStore) Take(key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("connection refused")
+}
+
+func newRateLimitedRequest(remoteAddr string, value interface{}) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/v1/carts", nil)
+	r.RemoteAddr = remoteAddr
+	switch v := value.(type) {
+	case shared.Claims:
+		r = r.WithContext(context.WithValue(r.Context(), middleware.ClaimsKey("claims"), v))
+	case oauth.OauthAccessToken:
+		r = r.WithContext(context.WithValue(r.Context(), middleware.ClaimsKey("token"), v))
+	}
+	return r
+}
+
+func TestRateLimit(t *testing.T) {
+	limits := map[string]ratelimit.Limit{"carts": {Requests: 2, Window: time.Minute}}
+
+	t.Run("answers 429 once the limit is reached", func(t *testing.T) {
+		next := &countingHandler{status: http.StatusOK}
+		h := middleware.NewRateLimit(ratelimit.NewMemoryStore(), limits).Limit("carts")(next)
+		user := shared.Claims{UserId: "user-1"}
+
+		w := serve(h, newRateLimitedRequest("10.0.0.1:1234", user))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "2", w.Header().Get(middleware.HeaderRateLimitLimit))
+		assert.Equal(t, "1", w.Header().Get(middleware.HeaderRateLimitRemaining))
+		assert.Equal(t, "30", w.Header().Get(middleware.HeaderRateLimitReset))
+		assert.Equal(t, "2;w=60", w.Header().Get(middleware.HeaderRateLimitPolicy))
+
+		serve(h, newRateLimitedRequest("10.0.0.2:1234", user))
+		w = serve(h, newRateLimitedRequest("10.0.0.3:1234", user))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "0", w.Header().Get(middleware.HeaderRateLimitRemaining))
+		assert.Equal(t, "30", w.Header().Get(middleware.HeaderRetryAfter))
+		assert.Equal(t, 2, next.calls)
+	})
+
+	t.Run("limits users, clients and IPs separately", func(t *testing.T) {
+		next := &countingHandler{status: http.StatusOK}
+		h := middleware.NewRateLimit(ratelimit.NewMemoryStore(), limits).Limit("carts")(next)
+
+		callers := []interface{}{
+			shared.Claims{UserId: "user-1"},
+			shared.Claims{UserId: "user-2"},
+			oauth.OauthAccessToken{ClientID: "client_web"},
+			nil,
+		}
+		for _, caller := range callers {
+			serve(h, newRateLimitedRequest("10.0.0.1:1234", caller))
+			w := serve(h, newRateLimitedRequest("10.0.0.1:5678", caller))
+			assert.Equal(t, http.StatusOK, w.Code)
+		}
+
+		w := serve(h, newRateLimitedRequest("10.0.0.1:9012", nil))
+		assert.Equal(t, http.StatusTooManyRequests, w.Code, "requests without credentials are limited by IP")
+
+		w = serve(h, newRateLimitedRequest("10.0.0.2:1234", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("groups without a limit are not limited", func(t *testing.T) {
+		next := &countingHandler{status: http.StatusOK}
+		h := middleware.NewRateLimit(ratelimit.NewMemoryStore(), limits).Limit("orders")(next)
+
+		for i := 0; i < 5; i++ {
+			w := serve(h, newRateLimitedRequest("10.0.0.1:1234", nil))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Empty(t, w.Header().Get(middleware.HeaderRateLimitLimit))
+		}
+	})
+
+	t.Run("lets requests through when the store fails", func(t *testing.T) {
+		next := &countingHandler{status: http.StatusOK}
+		h := middleware.NewRateLimit(failingRateLimitStore{}, limits).Limit("carts")(next)
+
+		w := serve(h, newRateLimitedRequest("10.0.0.1:1234", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, 1, next.calls)
+	})
+}
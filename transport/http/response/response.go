@@ -6,6 +6,7 @@ import (
 
 	"github.com/evermos/boilerplate-go/shared/failure"
 	"github.com/evermos/boilerplate-go/shared/logger"
+	"github.com/evermos/boilerplate-go/shared/pagination"
 )
 
 // Base is the base object of all responses
@@ -13,6 +14,19 @@ type Base struct {
 	Data    *interface{} `json:"data,omitempty"`
 	Error   *string      `json:"error,omitempty"`
 	Message *string      `json:"message,omitempty"`
+	Meta    *Meta        `json:"meta,omitempty"`
+}
+
+// Meta is the pagination metadata of list responses. Page is omitted for
+// cursor-based requests.
+type Meta struct {
+	Page       *int    `json:"page,omitempty" example:"0"`
+	Limit      int     `json:"limit" example:"10"`
+	TotalItems int     `json:"total_items" example:"42"`
+	TotalPages int     `json:"total_pages" example:"5"`
+	HasNext    bool    `json:"has_next" example:"true"`
+	NextCursor *string `json:"next_cursor,omitempty"`
+	PrevCursor *string `json:"prev_cursor,omitempty"`
 }
 
 // NoContent sends a response without any content
@@ -30,6 +44,20 @@ func WithJSON(w http.ResponseWriter, code int, jsonPayload interface{}) {
 	respond(w, code, Base{Data: &jsonPayload})
 }
 
+// WithPaginatedJSON sends a response containing a page of a JSON list and its pagination metadata
+func WithPaginatedJSON(w http.ResponseWriter, code int, jsonPayload interface{}, page pagination.Result) {
+	meta := Meta{
+		Page:       page.Page,
+		Limit:      page.Limit,
+		TotalItems: page.TotalItems,
+		TotalPages: page.TotalPages,
+		HasNext:    page.HasNext,
+		NextCursor: page.NextCursor,
+		PrevCursor: page.PrevCursor,
+	}
+	respond(w, code, Base{Data: &jsonPayload, Meta: &meta})
+}
+
 // WithError sends a response with an error message
 func WithError(w http.ResponseWriter, err error) {
 	code := failure.GetCode(err)
@@ -11,6 +11,8 @@ type DomainHandlers struct {
 	ProductHandler	 handlers.ProductHandler
 	CartHandler	 handlers.CartHandler
 	OrderHandler  handlers.OrderHandler
+	OAuthHandler  handlers.OAuthHandler
+	SessionHandler handlers.SessionHandler
 }
 
 // Router is the router struct containing handlers.
@@ -32,5 +34,8 @@ func (r *Router) SetupRoutes(mux *chi.Mux) {
 		r.DomainHandlers.ProductHandler.Router(rc)
 		r.DomainHandlers.CartHandler.Router(rc)
 		r.DomainHandlers.OrderHandler.Router(rc)
+		r.DomainHandlers.SessionHandler.Router(rc)
 	})
+
+	r.DomainHandlers.OAuthHandler.Router(mux)
 }
@@ -4,8 +4,10 @@ package main
 
 import (
 	"github.com/evermos/boilerplate-go/configs"
-	// "github.com/evermos/boilerplate-go/event"
-	// fooBarBazEvent "github.com/evermos/boilerplate-go/event/domain/foobarbaz"
+	"github.com/evermos/boilerplate-go/event"
+	fooBarBazEvent "github.com/evermos/boilerplate-go/event/domain/foobarbaz"
+	"github.com/evermos/boilerplate-go/event/local"
+	"github.com/evermos/boilerplate-go/event/outbox"
 	"github.com/evermos/boilerplate-go/event/producer"
 	"github.com/evermos/boilerplate-go/infras"
 	"github.com/evermos/boilerplate-go/internal/domain/foobarbaz"
@@ -13,6 +15,9 @@ import (
 	"github.com/evermos/boilerplate-go/internal/domain/cart"
 	"github.com/evermos/boilerplate-go/internal/domain/order"
 	"github.com/evermos/boilerplate-go/internal/handlers"
+	"github.com/evermos/boilerplate-go/shared/idempotency"
+	"github.com/evermos/boilerplate-go/shared/oauth"
+	"github.com/evermos/boilerplate-go/shared/policy"
 	"github.com/evermos/boilerplate-go/transport/http"
 	"github.com/evermos/boilerplate-go/transport/http/middleware"
 	"github.com/evermos/boilerplate-go/transport/http/router"
@@ -29,6 +34,18 @@ var persistences = wire.NewSet(
 	infras.ProvideMySQLConn,
 )
 
+// Wiring for the event outbox and the relay publishing it.
+var eventOutbox = wire.NewSet(
+	// Store interface and implementation
+	outbox.ProvideMySQLStore,
+	wire.Bind(new(outbox.Store), new(*outbox.MySQLStore)),
+	outbox.ProvideRelay,
+	outbox.ProvideJanitor,
+	// Producer of the configured driver
+	local.ProvideMemoryBroker,
+	producer.ProvideProducer,
+)
+
 // Wiring for domain FooBarBaz.
 var domainFooBarBaz = wire.NewSet(
 	// FooService interface and implementation
@@ -37,9 +54,6 @@ var domainFooBarBaz = wire.NewSet(
 	// FooRepository interface and implementation
 	foobarbaz.ProvideFooRepositoryMySQL,
 	wire.Bind(new(foobarbaz.FooRepository), new(*foobarbaz.FooRepositoryMySQL)),
-	// Producer interface and implementation
-	producer.NewSNSProducer,
-	wire.Bind(new(producer.Producer), new(*producer.SNSProducer)),
 )
 
 
@@ -58,6 +72,8 @@ var domainCart = wire.NewSet(
 
 	cart.ProvideCartRepositoryMySQL,
 	wire.Bind(new(cart.CartRepository), new(*cart.CartRepositoryMySQL)),
+
+	cart.ProvideAbandonmentDetector,
 	
 )
 
@@ -77,25 +93,38 @@ var domains = wire.NewSet(
 	domainCart,
 )
 
+// Wiring for authorization policies and the OAuth server.
+var policies = wire.NewSet(
+	oauth.ProvideConfig,
+	oauth.ProvideJanitor,
+	policy.ProvidePolicy,
+	wire.Bind(new(policy.Authorizer), new(*policy.Policy)),
+)
+
 var authMiddleware = wire.NewSet(
 	middleware.ProvideAuthentication,
+	middleware.ProvideIdempotency,
+	idempotency.ProvideJanitor,
+	middleware.ProvideRateLimit,
 )
 
 // Wiring for HTTP routing.
 var routing = wire.NewSet(
-	wire.Struct(new(router.DomainHandlers), "FooBarBazHandler", "ProductHandler", "CartHandler", "OrderHandler"),
+	wire.Struct(new(router.DomainHandlers), "FooBarBazHandler", "ProductHandler", "CartHandler", "OrderHandler", "OAuthHandler", "SessionHandler"),
 	handlers.ProvideFooBarBazHandler,
 	handlers.ProvideProductHandler,
 	handlers.ProvideCartHandler,
 	handlers.ProvideOrderHandler,
+	handlers.ProvideOAuthHandler,
+	handlers.ProvideSessionHandler,
 	router.ProvideRouter,
 )
 
-// // Wiring for all domains event consumer.
-// var evco = wire.NewSet(
-// 	wire.Struct(new(event.Consumers), "FooBarBaz"),
-// 	fooBarBazEvent.ProvideConsumerImpl,
-// )
+// Wiring for all domains event consumer.
+var evco = wire.NewSet(
+	wire.Struct(new(event.Consumers), "FooBarBaz"),
+	fooBarBazEvent.ProvideConsumerImpl,
+)
 
 // Wiring for everything.
 func InitializeService() *http.HTTP {
@@ -104,8 +133,12 @@ func InitializeService() *http.HTTP {
 		configurations,
 		// persistences
 		persistences,
+		// authorization
+		policies,
 		// middleware
 		authMiddleware,
+		// event outbox
+		eventOutbox,
 		// domains
 		domains,
 		// routing
@@ -115,17 +148,19 @@ func InitializeService() *http.HTTP {
 	return &http.HTTP{}
 }
 
-// // Wiring the event needs.
-// func InitializeEvent() event.Consumers {
-// 	wire.Build(
-// 		// configurations
-// 		configurations,
-// 		// persistences
-// 		persistences,
-// 		// domains
-// 		domains,
-// 		// event consumer
-// 		evco)
-
-// 	return event.Consumers{}
-// }
+// Wiring the event needs.
+func InitializeEvent() event.Consumers {
+	wire.Build(
+		// configurations
+		configurations,
+		// persistences
+		persistences,
+		// event outbox
+		eventOutbox,
+		// domains
+		domains,
+		// event consumer
+		evco)
+
+	return event.Consumers{}
+}